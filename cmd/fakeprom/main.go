@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"liang/internal/fakeprom"
+)
+
+var (
+	addr   = flag.String("addr", "127.0.0.1:9090", "listen address")
+	nodes  = flag.String("nodes", "node1,node2,node3", "comma separated node names, same as netbwMapKeys")
+	period = flag.Duration("period", 10*time.Minute, "period of the simulated load waves")
+	seed   = flag.Int64("seed", 1, "random seed of the phase of each node")
+)
+
+// wave 在[low, high]之间按正弦变化的负载
+func wave(low, high float64, phase float64) fakeprom.Series {
+	return func(t time.Time) float64 {
+		x := 2*math.Pi*float64(t.UnixNano())/float64(*period) + phase
+		return low + (high-low)*(1+math.Sin(x))/2
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	names := strings.Split(*nodes, ",")
+	rnd := rand.New(rand.NewSource(*seed))
+	prom := fakeprom.New()
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		phase := rnd.Float64() * 2 * math.Pi
+		// 数据单位和Liang的查询结果一致：网络Kbit/s，磁盘B/s，CPU和内存使用率0-1
+		prom.SetSeries(fakeprom.NetReceive, name, wave(50*1000, 500*1000, phase))
+		prom.SetSeries(fakeprom.NetTransmit, name, wave(20*1000, 200*1000, phase))
+		prom.SetSeries(fakeprom.DiskWritten, name, wave(1<<20, 50<<20, phase))
+		prom.SetSeries(fakeprom.DiskRead, name, wave(1<<20, 20<<20, phase))
+		prom.SetSeries(fakeprom.DiskWritesCompleted, name, wave(50, 500, phase))
+		prom.SetSeries(fakeprom.DiskReadsCompleted, name, wave(20, 200, phase))
+		// 网卡速度Kbit/s，和默认配置一致：1G/1.5G/2.5G循环
+		prom.SetSeries(fakeprom.NetSpeed, name, fakeprom.Const([]float64{1e6, 1.5e6, 2.5e6}[i%3]))
+		// 磁盘基准测试结果，节点交替使用SSD和HDD
+		if i%2 == 0 {
+			prom.SetSeries(fakeprom.DiskCapacityBytes, name, fakeprom.Const(500<<20))
+			prom.SetSeries(fakeprom.DiskCapacityIOPS, name, fakeprom.Const(80000))
+		} else {
+			prom.SetSeries(fakeprom.DiskCapacityBytes, name, fakeprom.Const(150<<20))
+			prom.SetSeries(fakeprom.DiskCapacityIOPS, name, fakeprom.Const(200))
+		}
+		prom.SetSeries(fakeprom.CPUSeconds, name, wave(0.1, 0.7, phase))
+		prom.SetSeries(fakeprom.MemAvailable, name, wave(0.2, 0.6, phase))
+	}
+
+	fmt.Fprintf(os.Stderr, "fake prometheus for %v listening on %s\n", names, *addr)
+	if err := http.ListenAndServe(*addr, prom); err != nil {
+		fmt.Fprintf(os.Stderr, "fakeprom: %v\n", err)
+		os.Exit(1)
+	}
+}
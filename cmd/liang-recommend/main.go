@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"liang/internal/dao"
+	"liang/internal/recommend"
+)
+
+var (
+	promAddr   = flag.String("prom-addr", "http://localhost:9090", "prometheus address")
+	promUser   = flag.String("prom-user", "", "prometheus basic auth user")
+	promPass   = flag.String("prom-password", "", "prometheus basic auth password")
+	window     = flag.Duration("window", 7*24*time.Hour, "history window of the usage")
+	percentile = flag.Float64("percentile", 95, "percentile of each pod's usage in the window")
+	under      = flag.Float64("under", 0.5, "flag annotations below observed usage times this ratio as under-stated")
+	over       = flag.Float64("over", 2, "flag annotations above observed usage times this ratio as over-stated")
+	namespace  = flag.String("namespace", "", "only analyse workloads in the namespace, empty means all")
+	format     = flag.String("format", "table", "report format: table/json/yaml, yaml only prints patches")
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "liang-recommend: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	d, cf, err := dao.NewWithConfig(&dao.Config{
+		PromAddr:              *promAddr,
+		PromBasicAuthUser:     *promUser,
+		PromBasicAuthPassword: *promPass,
+	})
+	if err != nil {
+		return err
+	}
+	defer cf()
+
+	opts := recommend.Options{
+		Window:     *window,
+		Percentile: *percentile,
+		UnderRatio: *under,
+		OverRatio:  *over,
+		Namespace:  *namespace,
+	}
+	report, err := recommend.Analyze(d, opts)
+	if err != nil {
+		return err
+	}
+
+	return recommend.WriteReport(os.Stdout, *format, report)
+}
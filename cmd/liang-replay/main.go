@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"liang/internal/model"
+	"liang/internal/replay"
+)
+
+var (
+	algo      = flag.String("algo", "", "replay with algorithm bnp/cmdn/ensemble, empty means the captured one")
+	topsisMin = flag.String("topsis-min", "", "replay with topsisMin true/false, empty means the captured one")
+	netbw     = flag.String("netbw", "", "replay with NIC bandwidth like node1=1000,node2=1500 in Mbps, empty means the captured one")
+	format    = flag.String("format", "table", "report format: table/json")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: liang-replay [flags] capture-file...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "liang-replay: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if flag.NArg() == 0 {
+		return fmt.Errorf("no capture file")
+	}
+	opts, err := parseOptions()
+	if err != nil {
+		return err
+	}
+
+	captures, err := replay.LoadCaptures(flag.Args()...)
+	if err != nil {
+		return err
+	}
+	report := replay.Replay(captures, opts)
+
+	return replay.WriteReport(os.Stdout, *format, report)
+}
+
+func parseOptions() (replay.Options, error) {
+	opts := replay.Options{}
+	switch *algo {
+	case "", model.AlgoBNP, model.AlgoCMDN, model.AlgoEnsemble:
+		opts.Algorithm = *algo
+	default:
+		// 录制记录只保存策略解析后的算法和参数，没有策略的配置，无法按策略名回放
+		return opts, fmt.Errorf("unknown algorithm %s, should be %s/%s/%s, policy names are not supported because captures only keep the resolved algorithm",
+			*algo, model.AlgoBNP, model.AlgoCMDN, model.AlgoEnsemble)
+	}
+
+	if *topsisMin != "" {
+		v, err := strconv.ParseBool(*topsisMin)
+		if err != nil {
+			return opts, fmt.Errorf("parse topsis-min error: %v", err)
+		}
+		opts.TopsisMin = &v
+	}
+
+	if *netbw != "" {
+		opts.NetBwMap = make(map[string]int64)
+		for _, item := range strings.Split(*netbw, ",") {
+			kv := strings.SplitN(item, "=", 2)
+			if len(kv) != 2 {
+				return opts, fmt.Errorf("netbw item %s should be node=Mbps", item)
+			}
+			v, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil || v <= 0 {
+				return opts, fmt.Errorf("netbw of node %s should be a positive number", kv[0])
+			}
+			// 和配置文件一致，转换为Kbit/s
+			opts.NetBwMap[strings.TrimSpace(kv[0])] = int64(v * model.KbitPS)
+		}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"liang/internal/sim"
+)
+
+var (
+	clusterFile = flag.String("cluster-file", "", "cluster description json file, empty to generate a synthetic cluster")
+	nodeNum     = flag.Int("nodes", 20, "num of nodes of the synthetic cluster")
+	traceFile   = flag.String("trace-file", "", "pod arrival trace json file, empty to generate a synthetic trace")
+	podNum      = flag.Int("pods", 500, "num of pods of the synthetic trace")
+	podInterval = flag.Float64("pod-interval", 10, "mean arrival interval in seconds of the synthetic trace")
+	seed        = flag.Int64("seed", 1, "random seed of the synthetic cluster and trace")
+	algos       = flag.String("algo", "all", "comma separated algorithms, or all: "+strings.Join(sim.Algorithms(), ","))
+	interval    = flag.Float64("interval", 60, "sample interval in seconds")
+	hotspot     = flag.Float64("hotspot", 80, "usage percent above which a node is a hotspot")
+	format      = flag.String("format", "table", "report format: table/csv/json")
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "liang-sim: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var (
+		cluster *sim.Cluster
+		trace   *sim.Trace
+		err     error
+	)
+	if *clusterFile != "" {
+		if cluster, err = sim.LoadCluster(*clusterFile); err != nil {
+			return err
+		}
+	} else {
+		cluster = sim.SyntheticCluster(*nodeNum, *seed)
+	}
+	if *traceFile != "" {
+		if trace, err = sim.LoadTrace(*traceFile); err != nil {
+			return err
+		}
+	} else {
+		trace = sim.SyntheticTrace(*podNum, *podInterval, *seed)
+	}
+
+	names := sim.Algorithms()
+	if *algos != "all" {
+		names = strings.Split(*algos, ",")
+	}
+	opts := sim.Options{
+		Interval:         *interval,
+		HotspotThreshold: *hotspot,
+	}
+
+	results := make([]*sim.Result, 0, len(names))
+	for _, name := range names {
+		res, err := sim.Run(cluster, trace, strings.TrimSpace(name), opts)
+		if err != nil {
+			return err
+		}
+		results = append(results, res)
+	}
+
+	return sim.WriteReport(os.Stdout, *format, results)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"liang/internal/sim"
+)
+
+var (
+	traceFormat   = flag.String("trace-format", "borg", "public trace format: borg/alibaba")
+	machineFile   = flag.String("machine-file", "", "borg machine_events or alibaba machine_meta csv file")
+	metaFile      = flag.String("container-meta-file", "", "alibaba container_meta csv file")
+	usageFile     = flag.String("usage-file", "", "borg task_usage or alibaba container_usage csv file")
+	nicCap        = flag.Float64("nic-cap", 10000, "NIC capacity of every machine in Mbps")
+	diskBandwidth = flag.Float64("disk-bandwidth", 200*1024*1024, "disk throughput in B/s when disk io is 100%")
+	maxTasks      = flag.Int("max-tasks", 0, "import at most n tasks ordered by arrival, 0 means all")
+	algos         = flag.String("algo", "all", "comma separated algorithms, or all: "+strings.Join(append(sim.Algorithms(), sim.AlgoTrace), ","))
+	interval      = flag.Float64("interval", 300, "sample interval in seconds")
+	hotspot       = flag.Float64("hotspot", 80, "usage percent above which a node is a hotspot")
+	format        = flag.String("format", "table", "report format: table/csv/json")
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "liang-trace: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	mapping := sim.TraceMapping{
+		NICCap:        *nicCap,
+		DiskBandwidth: *diskBandwidth,
+		MaxTasks:      *maxTasks,
+	}
+
+	var (
+		cluster *sim.Cluster
+		trace   *sim.Trace
+		err     error
+	)
+	switch *traceFormat {
+	case "borg":
+		cluster, trace, err = sim.LoadBorgTrace(*machineFile, *usageFile, mapping)
+	case "alibaba":
+		cluster, trace, err = sim.LoadAlibabaTrace(*machineFile, *metaFile, *usageFile, mapping)
+	default:
+		err = fmt.Errorf("unknown trace format %s", *traceFormat)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %d machines and %d tasks\n", len(cluster.Nodes), len(trace.Pods))
+
+	// 原始调度结果作为对照组排在最前面
+	names := append([]string{sim.AlgoTrace}, sim.Algorithms()...)
+	if *algos != "all" {
+		names = strings.Split(*algos, ",")
+	}
+	opts := sim.Options{
+		Interval:         *interval,
+		HotspotThreshold: *hotspot,
+	}
+
+	results := make([]*sim.Result, 0, len(names))
+	for _, name := range names {
+		res, err := sim.Run(cluster, trace, strings.TrimSpace(name), opts)
+		if err != nil {
+			return err
+		}
+		results = append(results, res)
+	}
+
+	return sim.WriteReport(os.Stdout, *format, results)
+}
@@ -13,5 +13,6 @@ require (
 	gonum.org/v1/gonum v0.9.3
 	k8s.io/api v0.21.3
 	k8s.io/apimachinery v0.21.3
+	k8s.io/client-go v0.21.3
 	k8s.io/kube-scheduler v0.21.3
 )
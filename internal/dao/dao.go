@@ -2,10 +2,14 @@ package dao
 
 import (
 	"context"
+	"sync"
 	"time"
 
+	"liang/internal/model"
+
 	"github.com/bluele/gcache"
 	"github.com/go-kratos/kratos/pkg/conf/paladin"
+	"github.com/go-kratos/kratos/pkg/log"
 	xtime "github.com/go-kratos/kratos/pkg/time"
 
 	"github.com/google/wire"
@@ -22,24 +26,57 @@ type Dao interface {
 	// prometheus related interface
 	RequestPromDemo()
 	RequestPromMaxDiskIO() (map[string]int64, error)
+	RequestPromMaxDiskIOPS() (map[string]int64, error)
+	RequestPromDiskCapacity() (map[string]model.DiskCapacity, error)
+	RequestPromNICSpeed() (map[string]int64, error)
 	RequestPromMaxNetIO() (map[string]int64, error)
 	RequestPromNetIO(bwType string) (map[string]int64, error)
 	RequestPromDiskIO(diskType string) (map[string]int64, error)
 	RequestPromCPUUsage() (map[string]int64, error)
 	RequestPromMemUsage() (map[string]int64, error)
+	RequestPromPodNetIO(bwType string, window time.Duration, quantile float64) (map[model.PodOwner]map[string]int64, error)
+	RequestPromWorkloadUsage(resource string, window time.Duration, quantile float64) (map[model.PodOwner]map[string]float64, error)
+	RequestPromWorkloadAnnotations(keys []string) (map[model.PodOwner]map[string]string, error)
+	RequestPromNamespaceLabel(key string) (map[string]string, error)
+	RequestPromNodeLabels(keys []string) (map[string]map[string]string, error)
+	RequestPromNodeAnnotations(keys []string) (map[string]map[string]string, error)
 
 	// local KV cache interface
 	SetKV(k string, v interface{}) error
+	GetKV(k string) (map[string]int64, error)
 	GetAllInfo() (map[string](map[string]int64), error)
 	SetNetIO(netload map[string]int64) error
 	GetNetIO() (map[string]int64, error)
+	GetLastKnownInfo() map[string](map[string]int64)
+	GetLastKnown(k, name string) (int64, bool)
+
+	// metric history interface
+	AppendHistory(key string, values map[string]int64, at time.Time)
+	GetHistory(key string, since time.Time) map[string][]model.Sample
+	GetHistoryStats(key string, since time.Time) map[string]model.WindowStats
+	GetHistoryKeys() []string
+
+	// decision audit log interface
+	AddDecision(decision *model.Decision) error
+	DecisionLogEnabled() bool
+	QueryDecisions(filter *model.DecisionFilter) ([]*model.Decision, error)
+
+	// prioritize capture interface
+	AddCapture(c *model.Capture) error
 }
 
 // dao dao.
 type dao struct {
-	promDao    *PromDao
-	localCache gcache.Cache
-	demoExpire int32
+	promDao       *PromDao
+	localCache    gcache.Cache
+	demoExpire    int32
+	decisionStore *decisionStore
+	captureStore  *decisionStore
+
+	lastKnownMu sync.RWMutex
+	lastKnown   map[string](map[string]int64) // 每个节点最近一次已知的指标值
+
+	history *history // 每个节点每个指标最近的历史数据，用于负载预测
 }
 
 // New new a dao and return.
@@ -47,18 +84,38 @@ func New() (d Dao, cf func(), err error) {
 	return newDao()
 }
 
+// Config dao的配置，对应application.toml中的同名配置项
+type Config struct {
+	PromAddr              string
+	PromBasicAuthUser     string
+	PromBasicAuthPassword string
+	LocalCacheExpire      int64
+	DemoExpire            xtime.Duration
+	DecisionLogPath       string
+	DecisionLogMaxSize    int64
+	DecisionLogMaxBackups int
+	CaptureFile           string
+	CaptureMaxSize        int64
+	CaptureMaxBackups     int
+	HistorySize           int
+	HistoryInterval       xtime.Duration
+}
+
+// NewWithConfig 使用给定的配置创建dao，不读取配置文件
+func NewWithConfig(cfg *Config) (d Dao, cf func(), err error) {
+	return newDaoWithConfig(cfg)
+}
+
 func newDao() (d *dao, cf func(), err error) {
-	var cfg struct {
-		PromAddr              string
-		PromBasicAuthUser     string
-		PromBasicAuthPassword string
-		LocalCacheExpire      int64
-		DemoExpire            xtime.Duration
-	}
+	var cfg Config
 	if err = paladin.Get("application.toml").UnmarshalTOML(&cfg); err != nil {
 		return
 	}
 
+	return newDaoWithConfig(&cfg)
+}
+
+func newDaoWithConfig(cfg *Config) (d *dao, cf func(), err error) {
 	// new promDao
 	promDao, err := NewPromDao(cfg.PromAddr, cfg.PromBasicAuthUser, cfg.PromBasicAuthPassword)
 	if err != nil {
@@ -69,6 +126,22 @@ func newDao() (d *dao, cf func(), err error) {
 		promDao:    promDao,
 		localCache: gcache.New(2000).LRU().Expiration(time.Duration(cfg.LocalCacheExpire) * time.Second).Build(),
 		demoExpire: int32(time.Duration(cfg.DemoExpire) / time.Second),
+		history:    newHistory(cfg.HistorySize, time.Duration(cfg.HistoryInterval)),
+	}
+	if cfg.DecisionLogPath != "" {
+		d.decisionStore, err = newDecisionStore(cfg.DecisionLogPath, cfg.DecisionLogMaxSize, cfg.DecisionLogMaxBackups)
+		if err != nil {
+			log.Error("open decision log %s error: %v", cfg.DecisionLogPath, err)
+			return
+		}
+	}
+	if cfg.CaptureFile != "" {
+		d.captureStore, err = newDecisionStore(cfg.CaptureFile, cfg.CaptureMaxSize, cfg.CaptureMaxBackups)
+		if err != nil {
+			log.Error("open capture file %s error: %v", cfg.CaptureFile, err)
+			return
+		}
+		log.Info("capture prioritize requests to %s", cfg.CaptureFile)
 	}
 	cf = d.Close
 
@@ -77,9 +150,16 @@ func newDao() (d *dao, cf func(), err error) {
 
 // Close close the resource.
 func (d *dao) Close() {
+	if d.decisionStore != nil {
+		d.decisionStore.Close()
+	}
+	if d.captureStore != nil {
+		d.captureStore.Close()
+	}
 }
 
 // Ping ping the resource.
+// 检查Prometheus是否可以正常查询
 func (d *dao) Ping(ctx context.Context) (err error) {
-	return nil
+	return d.promDao.Ping(ctx)
 }
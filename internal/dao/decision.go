@@ -0,0 +1,257 @@
+package dao
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/log"
+)
+
+const defaultDecisionQueryLimit = 100
+
+// decisionStore 按大小滚动的本地决策审计日志，每行一条JSON记录，Prioritize录制文件也使用它写入
+// 当前文件为path，历史文件依次为path.1 path.2 ...，序号越大越旧
+type decisionStore struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// newDecisionStore maxSize单位为MB
+func newDecisionStore(path string, maxSize int64, maxBackups int) (*decisionStore, error) {
+	if maxSize <= 0 {
+		maxSize = 100
+	}
+	if maxBackups < 0 {
+		maxBackups = 0
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	ds := &decisionStore{
+		path:       path,
+		maxSize:    maxSize * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+	if err := ds.open(); err != nil {
+		return nil, err
+	}
+
+	return ds, nil
+}
+
+func (ds *decisionStore) open() error {
+	f, err := os.OpenFile(ds.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	ds.file = f
+	ds.size = info.Size()
+
+	return nil
+}
+
+func (ds *decisionStore) backupName(i int) string {
+	return fmt.Sprintf("%s.%d", ds.path, i)
+}
+
+// rotate 关闭当前文件并依次后移历史文件，超过maxBackups的文件被删除
+func (ds *decisionStore) rotate() error {
+	if err := ds.file.Close(); err != nil {
+		return err
+	}
+
+	if ds.maxBackups == 0 {
+		if err := os.Remove(ds.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return ds.open()
+	}
+
+	os.Remove(ds.backupName(ds.maxBackups))
+	for i := ds.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(ds.backupName(i), ds.backupName(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(ds.path, ds.backupName(1)); err != nil {
+		return err
+	}
+
+	return ds.open()
+}
+
+// Append 追加一条记录，v需要可以序列化为JSON
+func (ds *decisionStore) Append(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.size > 0 && ds.size+int64(len(line)) > ds.maxSize {
+		if err = ds.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := ds.file.Write(line)
+	ds.size += int64(n)
+
+	return err
+}
+
+// Query 按照条件查询决策记录，结果按时间倒序
+// 只在打开文件时持有锁，读取和解析在锁外进行，避免阻塞Prioritize写入记录
+// 已经打开的文件在滚动时被改名或删除也可以继续读取，当前文件只读取打开时已经写入的部分
+func (ds *decisionStore) Query(filter *model.DecisionFilter) ([]*model.Decision, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultDecisionQueryLimit
+	}
+
+	files, err := ds.openFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.file.Close()
+		}
+	}()
+
+	// 从新到旧依次读取文件，每个文件内部也要倒序
+	res := make([]*model.Decision, 0)
+	for _, f := range files {
+		matched, err := readDecisions(f.file, f.size, filter)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(matched) - 1; i >= 0; i-- {
+			res = append(res, matched[i])
+			if len(res) >= limit {
+				return res, nil
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// snapshotFile 查询时打开的文件和当时的大小
+type snapshotFile struct {
+	file *os.File
+	size int64
+}
+
+// openFiles 持有锁打开当前文件和全部历史文件，不存在的历史文件跳过
+func (ds *decisionStore) openFiles() ([]snapshotFile, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	names := []string{ds.path}
+	for i := 1; i <= ds.maxBackups; i++ {
+		names = append(names, ds.backupName(i))
+	}
+	res := make([]snapshotFile, 0, len(names))
+	for i, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			for _, opened := range res {
+				opened.file.Close()
+			}
+			return nil, err
+		}
+		size := int64(-1)
+		if i == 0 {
+			size = ds.size
+		}
+		res = append(res, snapshotFile{file: f, size: size})
+	}
+
+	return res, nil
+}
+
+// readDecisions 读取文件中满足条件的记录，size不小于0时只读取前size字节
+func readDecisions(f *os.File, size int64, filter *model.DecisionFilter) ([]*model.Decision, error) {
+	var r io.Reader = f
+	if size >= 0 {
+		r = io.LimitReader(f, size)
+	}
+
+	res := make([]*model.Decision, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		d := new(model.Decision)
+		if err := json.Unmarshal(scanner.Bytes(), d); err != nil {
+			log.Warn("decision log %s has broken line: %v", f.Name(), err)
+			continue
+		}
+		if filter.Match(d) {
+			res = append(res, d)
+		}
+	}
+
+	return res, scanner.Err()
+}
+
+// Close 关闭当前日志文件
+func (ds *decisionStore) Close() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	return ds.file.Close()
+}
+
+// AddDecision 持久化一条评分决策
+func (d *dao) AddDecision(decision *model.Decision) error {
+	if d.decisionStore == nil {
+		return nil
+	}
+
+	return d.decisionStore.Append(decision)
+}
+
+// DecisionLogEnabled 是否配置了决策审计日志，没有配置时不需要构造记录
+func (d *dao) DecisionLogEnabled() bool {
+	return d.decisionStore != nil
+}
+
+// QueryDecisions 查询评分决策审计记录
+func (d *dao) QueryDecisions(filter *model.DecisionFilter) ([]*model.Decision, error) {
+	if d.decisionStore == nil {
+		return nil, fmt.Errorf("decision log is disabled, set decisionLogPath to enable it")
+	}
+
+	return d.decisionStore.Query(filter)
+}
+
+// AddCapture 录制一次Prioritize请求，未配置captureFile时不录制
+func (d *dao) AddCapture(c *model.Capture) error {
+	if d.captureStore == nil {
+		return nil
+	}
+
+	return d.captureStore.Append(c)
+}
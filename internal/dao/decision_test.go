@@ -0,0 +1,128 @@
+package dao
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"liang/internal/model"
+)
+
+func TestDecisionStore_Query(t *testing.T) {
+	dir := t.TempDir()
+	ds, err := newDecisionStore(filepath.Join(dir, "decision.log"), 1, 2)
+	if err != nil {
+		t.Fatalf("new decision store error: %v", err)
+	}
+	defer ds.Close()
+
+	base := time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		d := &model.Decision{
+			Time:      base.Add(time.Duration(i) * time.Minute),
+			PodName:   fmt.Sprintf("pod-%d", i),
+			Namespace: []string{"default", "kube-system"}[i%2],
+			Nodes:     []string{"node1", fmt.Sprintf("node%d", i+2)},
+			Algorithm: model.AlgoBNP,
+		}
+		if err = ds.Append(d); err != nil {
+			t.Fatalf("append decision error: %v", err)
+		}
+	}
+
+	cases := []struct {
+		Name     string
+		Filter   *model.DecisionFilter
+		Expected []string
+	}{
+		{
+			Name:     "test 0: all records newest first",
+			Filter:   &model.DecisionFilter{},
+			Expected: []string{"pod-5", "pod-4", "pod-3", "pod-2", "pod-1", "pod-0"},
+		},
+		{
+			Name:     "test 1: by namespace",
+			Filter:   &model.DecisionFilter{Namespace: "kube-system"},
+			Expected: []string{"pod-5", "pod-3", "pod-1"},
+		},
+		{
+			Name:     "test 2: by node",
+			Filter:   &model.DecisionFilter{Node: "node4"},
+			Expected: []string{"pod-2"},
+		},
+		{
+			Name: "test 3: by time and limit",
+			Filter: &model.DecisionFilter{
+				Start: base.Add(time.Minute),
+				End:   base.Add(4 * time.Minute),
+				Limit: 2,
+			},
+			Expected: []string{"pod-4", "pod-3"},
+		},
+		{
+			Name:     "test 4: by pod",
+			Filter:   &model.DecisionFilter{Pod: "pod-0"},
+			Expected: []string{"pod-0"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			res, err := ds.Query(tc.Filter)
+			if err != nil {
+				t.Fatalf("test %s error: %v", tc.Name, err)
+			}
+			if len(res) != len(tc.Expected) {
+				t.Fatalf("test %s, num of res %d and Expected %d not equal", tc.Name, len(res), len(tc.Expected))
+			}
+			for i := range res {
+				if res[i].PodName != tc.Expected[i] {
+					t.Errorf("test %s, %dth record should be %s, but get %s", tc.Name, i, tc.Expected[i], res[i].PodName)
+				}
+			}
+		})
+	}
+}
+
+func TestDecisionStore_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "decision.log")
+	ds, err := newDecisionStore(path, 1, 2)
+	if err != nil {
+		t.Fatalf("new decision store error: %v", err)
+	}
+	defer ds.Close()
+	// 缩小单个文件上限，每条记录都会触发滚动
+	ds.maxSize = 10
+
+	for i := 0; i < 5; i++ {
+		if err = ds.Append(&model.Decision{PodName: fmt.Sprintf("pod-%d", i)}); err != nil {
+			t.Fatalf("append decision error: %v", err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		if _, err = os.Stat(name); err != nil {
+			t.Errorf("file %s should exist: %v", name, err)
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("file %s should be removed", path+".3")
+	}
+
+	res, err := ds.Query(&model.DecisionFilter{})
+	if err != nil {
+		t.Fatalf("query error: %v", err)
+	}
+	expected := []string{"pod-4", "pod-3", "pod-2"}
+	if len(res) != len(expected) {
+		t.Fatalf("num of res %d should be %d", len(res), len(expected))
+	}
+	for i := range res {
+		if res[i].PodName != expected[i] {
+			t.Errorf("%dth record should be %s, but get %s", i, expected[i], res[i].PodName)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package dao
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"liang/internal/model"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+const defaultHistorySize = 720
+
+// ring 固定容量的环形缓冲区，保存一个节点一个指标按时间排列的数据，满了之后覆盖最早的数据
+type ring struct {
+	times  []int64 // unix纳秒
+	values []int64
+	next   int // 下一个写入的位置
+	count  int
+}
+
+func newRing(size int) *ring {
+	return &ring{times: make([]int64, size), values: make([]int64, size)}
+}
+
+// push 写入一个数据
+func (r *ring) push(at time.Time, v int64) {
+	r.times[r.next], r.values[r.next] = at.UnixNano(), v
+	r.next = (r.next + 1) % len(r.times)
+	if r.count < len(r.times) {
+		r.count++
+	}
+}
+
+// last 最近写入的数据的时间
+func (r *ring) last() (time.Time, bool) {
+	if r.count == 0 {
+		return time.Time{}, false
+	}
+	i := (r.next - 1 + len(r.times)) % len(r.times)
+
+	return time.Unix(0, r.times[i]), true
+}
+
+// since 按时间顺序返回不早于since的数据，since为零值时返回全部数据
+func (r *ring) since(since time.Time) []model.Sample {
+	start := (r.next - r.count + len(r.times)) % len(r.times)
+	res := make([]model.Sample, 0, r.count)
+	for k := 0; k < r.count; k++ {
+		i := (start + k) % len(r.times)
+		if !since.IsZero() && r.times[i] < since.UnixNano() {
+			continue
+		}
+		res = append(res, model.Sample{Time: time.Unix(0, r.times[i]), Value: r.values[i]})
+	}
+
+	return res
+}
+
+// history 每个节点每个指标最近的历史数据，每个序列最多保存size个数据
+// 与上一个数据的间隔小于interval时不记录，为0时记录每次同步的数据
+type history struct {
+	mu       sync.RWMutex
+	size     int
+	interval time.Duration
+	series   map[string](map[string]*ring) // 指标 -> 节点 -> 数据
+}
+
+func newHistory(size int, interval time.Duration) *history {
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+
+	return &history{
+		size:     size,
+		interval: interval,
+		series:   make(map[string](map[string]*ring)),
+	}
+}
+
+// append 记录一次同步得到的指标
+func (h *history) append(key string, values map[string]int64, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.series[key] == nil {
+		h.series[key] = make(map[string]*ring)
+	}
+	for name, v := range values {
+		r, ok := h.series[key][name]
+		if !ok {
+			r = newRing(h.size)
+			h.series[key][name] = r
+		}
+		if last, ok := r.last(); ok && at.Sub(last) < h.interval {
+			continue
+		}
+		r.push(at, v)
+	}
+}
+
+// get 返回指标每个节点不早于since的历史数据
+func (h *history) get(key string, since time.Time) map[string][]model.Sample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	res := make(map[string][]model.Sample, len(h.series[key]))
+	for name, r := range h.series[key] {
+		res[name] = r.since(since)
+	}
+
+	return res
+}
+
+// keys 有历史数据的指标
+func (h *history) keys() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	res := make([]string, 0, len(h.series))
+	for key := range h.series {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// WindowStats 计算按时间排列的数据的统计值，数据个数小于2时标准差和斜率为0
+func WindowStats(samples []model.Sample) model.WindowStats {
+	var res model.WindowStats
+	n := len(samples)
+	if n == 0 {
+		return res
+	}
+
+	res.Count, res.Start, res.End = n, samples[0].Time, samples[n-1].Time
+	xs, ys := make([]float64, n), make([]float64, n)
+	for i, sample := range samples {
+		xs[i] = sample.Time.Sub(res.Start).Seconds()
+		ys[i] = float64(sample.Value)
+	}
+	res.Mean = stat.Mean(ys, nil)
+	if n > 1 {
+		res.Stddev = stat.StdDev(ys, nil)
+		if xs[n-1] > 0 {
+			_, res.Slope = stat.LinearRegression(xs, ys, nil, false)
+		}
+	}
+
+	sort.Float64s(ys)
+	res.Min, res.Max = ys[0], ys[n-1]
+	res.P50 = stat.Quantile(0.5, stat.Empirical, ys, nil)
+	res.P90 = stat.Quantile(0.9, stat.Empirical, ys, nil)
+	res.P99 = stat.Quantile(0.99, stat.Empirical, ys, nil)
+
+	return res
+}
+
+// AppendHistory 记录一次同步得到的指标，values为每个节点的值
+func (d *dao) AppendHistory(key string, values map[string]int64, at time.Time) {
+	d.history.append(key, values, at)
+}
+
+// GetHistory 返回指标每个节点不早于since的历史数据，按时间排列，since为零值时返回全部数据
+func (d *dao) GetHistory(key string, since time.Time) map[string][]model.Sample {
+	return d.history.get(key, since)
+}
+
+// GetHistoryStats 返回指标每个节点不早于since的历史数据的统计值
+func (d *dao) GetHistoryStats(key string, since time.Time) map[string]model.WindowStats {
+	samples := d.history.get(key, since)
+	res := make(map[string]model.WindowStats, len(samples))
+	for name, s := range samples {
+		res[name] = WindowStats(s)
+	}
+
+	return res
+}
+
+// GetHistoryKeys 返回有历史数据的指标
+func (d *dao) GetHistoryKeys() []string {
+	return d.history.keys()
+}
@@ -0,0 +1,82 @@
+package dao
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"liang/internal/model"
+
+	xtime "github.com/go-kratos/kratos/pkg/time"
+)
+
+func TestDao_History(t *testing.T) {
+	d, cf, err := newDaoWithConfig(&Config{LocalCacheExpire: 300, HistorySize: 3, HistoryInterval: xtime.Duration(time.Minute)})
+	if err != nil {
+		t.Fatalf("new dao error: %v", err)
+	}
+	defer cf()
+
+	start := time.Unix(0, 0)
+	for i := 0; i < 5; i++ {
+		d.AppendHistory(model.ResourceCPUKey, map[string]int64{"node1": int64(i)}, start.Add(time.Duration(i)*time.Minute))
+		// 间隔小于historyInterval的数据不记录
+		d.AppendHistory(model.ResourceCPUKey, map[string]int64{"node1": 100}, start.Add(time.Duration(i)*time.Minute+time.Second))
+	}
+
+	// 环形缓冲区只保留最近的historySize个数据
+	samples := d.GetHistory(model.ResourceCPUKey, time.Time{})["node1"]
+	if len(samples) != 3 || samples[0].Value != 2 || samples[2].Value != 4 || !samples[2].Time.Equal(start.Add(4*time.Minute)) {
+		t.Fatalf("unexpected history %v", samples)
+	}
+
+	// 返回的是副本
+	samples[0].Value = 0
+	if v := d.GetHistory(model.ResourceCPUKey, time.Time{})["node1"][0].Value; v != 2 {
+		t.Errorf("history should not be modified by caller, but get %d", v)
+	}
+	if len(d.GetHistory(model.ResourceMemKey, time.Time{})) != 0 {
+		t.Errorf("history of metric without samples should be empty")
+	}
+
+	// 只返回窗口内的数据
+	samples = d.GetHistory(model.ResourceCPUKey, start.Add(3*time.Minute))["node1"]
+	if len(samples) != 2 || samples[0].Value != 3 {
+		t.Errorf("unexpected history in window %v", samples)
+	}
+	stats := d.GetHistoryStats(model.ResourceCPUKey, start.Add(3*time.Minute))["node1"]
+	if stats.Count != 2 || stats.Mean != 3.5 || math.Abs(stats.Slope-1.0/60) > 1e-9 {
+		t.Errorf("unexpected stats in window %+v", stats)
+	}
+	if keys := d.GetHistoryKeys(); len(keys) != 1 || keys[0] != model.ResourceCPUKey {
+		t.Errorf("history keys should be [%s], but get %v", model.ResourceCPUKey, keys)
+	}
+}
+
+func TestWindowStats(t *testing.T) {
+	start := time.Unix(0, 0)
+	samples := make([]model.Sample, 0, 100)
+	for i := 0; i < 100; i++ {
+		// 每10s上升2
+		samples = append(samples, model.Sample{Time: start.Add(time.Duration(i) * 10 * time.Second), Value: int64(2 * i)})
+	}
+
+	stats := WindowStats(samples)
+	if stats.Count != 100 || stats.Mean != 99 || stats.Min != 0 || stats.Max != 198 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.P50 != 98 || stats.P90 != 178 || stats.P99 != 196 {
+		t.Errorf("unexpected percentiles %+v", stats)
+	}
+	if math.Abs(stats.Slope-0.2) > 1e-9 || math.Abs(stats.Stddev-58.02298395176403) > 1e-9 {
+		t.Errorf("unexpected slope or stddev %+v", stats)
+	}
+
+	one := WindowStats(samples[:1])
+	if one.Count != 1 || one.Stddev != 0 || one.Slope != 0 || one.P99 != 0 {
+		t.Errorf("unexpected stats of one sample %+v", one)
+	}
+	if empty := WindowStats(nil); empty.Count != 0 {
+		t.Errorf("unexpected stats of no sample %+v", empty)
+	}
+}
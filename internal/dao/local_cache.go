@@ -36,18 +36,69 @@ func (d *dao) innerGet(key string) (map[string]int64, error) {
 }
 
 func (d *dao) SetKV(k string, v interface{}) error {
+	if m, ok := v.(map[string]int64); ok {
+		d.setLastKnown(k, m)
+	}
 	return d.localCache.Set(k, v)
 }
 
+// GetKV 读取一个指标，没有同步过或者已经过期时返回nil
+func (d *dao) GetKV(k string) (map[string]int64, error) {
+	return d.innerGet(k)
+}
+
 func (d *dao) SetNetIO(netIO map[string]int64) error {
+	d.setLastKnown(model.ResourceNetIOKey, netIO)
 	return d.localCache.Set(model.ResourceNetIOKey, netIO)
 }
 
+// setLastKnown 合并每个节点最近一次已知的指标值，不会过期
+func (d *dao) setLastKnown(k string, values map[string]int64) {
+	d.lastKnownMu.Lock()
+	defer d.lastKnownMu.Unlock()
+
+	if d.lastKnown == nil {
+		d.lastKnown = make(map[string](map[string]int64))
+	}
+	if d.lastKnown[k] == nil {
+		d.lastKnown[k] = make(map[string]int64)
+	}
+	for name, v := range values {
+		d.lastKnown[k][name] = v
+	}
+}
+
+// GetLastKnownInfo 返回每个节点最近一次已知的指标值，即使本地缓存已经过期或者最近一次同步缺少该节点
+func (d *dao) GetLastKnownInfo() map[string](map[string]int64) {
+	d.lastKnownMu.RLock()
+	defer d.lastKnownMu.RUnlock()
+
+	res := make(map[string](map[string]int64), len(d.lastKnown))
+	for k, values := range d.lastKnown {
+		res[k] = make(map[string]int64, len(values))
+		for name, v := range values {
+			res[k][name] = v
+		}
+	}
+
+	return res
+}
+
+// GetLastKnown 返回节点name的指标k最近一次已知的值，只在缺失数据使用last策略时按需查询
+func (d *dao) GetLastKnown(k, name string) (int64, bool) {
+	d.lastKnownMu.RLock()
+	defer d.lastKnownMu.RUnlock()
+
+	v, ok := d.lastKnown[k][name]
+	return v, ok
+}
+
 func (d *dao) GetNetIO() (map[string]int64, error) {
 	return d.innerGet(model.ResourceNetIOKey)
 }
 
 func (d *dao) SetDiskIO(diskIO map[string]int64) error {
+	d.setLastKnown(model.ResourceDiskIOKey, diskIO)
 	return d.localCache.Set(model.ResourceDiskIOKey, diskIO)
 }
 
@@ -55,7 +106,17 @@ func (d *dao) GetDiskIO() (map[string]int64, error) {
 	return d.innerGet(model.ResourceDiskIOKey)
 }
 
+func (d *dao) SetDiskIOPS(diskIOPS map[string]int64) error {
+	d.setLastKnown(model.ResourceDiskIOPSKey, diskIOPS)
+	return d.localCache.Set(model.ResourceDiskIOPSKey, diskIOPS)
+}
+
+func (d *dao) GetDiskIOPS() (map[string]int64, error) {
+	return d.innerGet(model.ResourceDiskIOPSKey)
+}
+
 func (d *dao) SetCPUUsage(cpuUsage map[string]int64) error {
+	d.setLastKnown(model.ResourceCPUKey, cpuUsage)
 	return d.localCache.Set(model.ResourceCPUKey, cpuUsage)
 }
 
@@ -64,6 +125,7 @@ func (d *dao) GetCPUUsage() (map[string]int64, error) {
 }
 
 func (d *dao) SetMemUsage(memUsage map[string]int64) error {
+	d.setLastKnown(model.ResourceMemKey, memUsage)
 	return d.localCache.Set(model.ResourceMemKey, memUsage)
 }
 
@@ -93,10 +155,23 @@ func (d *dao) GetAllInfo() (map[string](map[string]int64), error) {
 		return nil, err
 	}
 
-	return map[string](map[string]int64){
+	res := map[string](map[string]int64){
 		model.ResourceNetIOKey:  netIO,
 		model.ResourceDiskIOKey: diskIO,
 		model.ResourceCPUKey:    cpuUsage,
 		model.ResourceMemKey:    memUsage,
-	}, nil
+	}
+
+	// 磁盘IOPS和分方向的网络负载是可选指标，没有同步过时不返回
+	for _, key := range []string{model.ResourceDiskIOPSKey, model.ResourceNetInKey, model.ResourceNetOutKey} {
+		values, err := d.innerGet(key)
+		if err != nil {
+			return nil, err
+		}
+		if values != nil {
+			res[key] = values
+		}
+	}
+
+	return res, nil
 }
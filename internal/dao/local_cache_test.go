@@ -0,0 +1,42 @@
+package dao
+
+import (
+	"reflect"
+	"testing"
+
+	"liang/internal/model"
+)
+
+func TestDao_LastKnownInfo(t *testing.T) {
+	d, cf, err := newDaoWithConfig(&Config{LocalCacheExpire: 300})
+	if err != nil {
+		t.Fatalf("new dao error: %v", err)
+	}
+	defer cf()
+
+	if err = d.SetCPUUsage(map[string]int64{"node1": 10, "node2": 20}); err != nil {
+		t.Fatalf("set cpu usage error: %v", err)
+	}
+	// 最近一次同步缺少node2时保留node2上一次的值
+	if err = d.SetKV(model.ResourceCPUKey, map[string]int64{"node1": 30}); err != nil {
+		t.Fatalf("set cpu usage error: %v", err)
+	}
+	if err = d.SetNetIO(map[string]int64{"node1": 1000}); err != nil {
+		t.Fatalf("set net io error: %v", err)
+	}
+
+	expected := map[string](map[string]int64){
+		model.ResourceCPUKey:   {"node1": 30, "node2": 20},
+		model.ResourceNetIOKey: {"node1": 1000},
+	}
+	lastKnown := d.GetLastKnownInfo()
+	if !reflect.DeepEqual(lastKnown, expected) {
+		t.Fatalf("last known info should be %v, but get %v", expected, lastKnown)
+	}
+
+	// 返回的是副本，修改不影响dao中的数据
+	lastKnown[model.ResourceCPUKey]["node1"] = 0
+	if v := d.GetLastKnownInfo()[model.ResourceCPUKey]["node1"]; v != 30 {
+		t.Errorf("last known info should not be modified by caller, but get %d", v)
+	}
+}
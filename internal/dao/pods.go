@@ -0,0 +1,103 @@
+package dao
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/pkg/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// podSyncTimeout 等待Pod informer首次同步的最长时间，超时一般是没有list/watch pods的权限或者连不上apiserver
+const podSyncTimeout = 2 * time.Minute
+
+// PodHandler 接收Pod informer的事件，新增和更新都调用OnPodUpdate
+type PodHandler struct {
+	OnPodUpdate func(pod *v1.Pod)
+	OnPodDelete func(pod *v1.Pod)
+}
+
+// WatchPods 创建监听全部Pod的informer，kubeconfig为空时使用集群内的ServiceAccount
+// 等待首次同步完成后返回，stopCh关闭后informer停止，同步完成之前stopCh关闭时返回nil
+// podSyncTimeout内没有同步完成时停止informer并返回错误
+func WatchPods(kubeconfig string, resync time.Duration, handler PodHandler, stopCh <-chan struct{}) error {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		log.Error("build kubernetes config from %q error: %v", kubeconfig, err)
+		return err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Error("create kubernetes client error: %v", err)
+		return err
+	}
+
+	factory := informers.NewSharedInformerFactory(client, resync)
+	informer := factory.Core().V1().Pods().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*v1.Pod); ok {
+				handler.OnPodUpdate(pod)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if pod, ok := obj.(*v1.Pod); ok {
+				handler.OnPodUpdate(pod)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			// 错过删除事件时得到的是最后已知状态
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*v1.Pod); ok {
+				handler.OnPodDelete(pod)
+			}
+		},
+	})
+	// informer使用单独的stop，同步失败时也能停止
+	stop := make(chan struct{})
+	var once sync.Once
+	halt := func() { once.Do(func() { close(stop) }) }
+	go func() {
+		select {
+		case <-stopCh:
+			halt()
+		case <-stop:
+		}
+	}()
+	factory.Start(stop)
+
+	timeout := time.NewTimer(podSyncTimeout)
+	defer timeout.Stop()
+	syncCh, done := make(chan struct{}), make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(syncCh)
+		select {
+		case <-stop:
+		case <-timeout.C:
+		case <-done:
+		}
+	}()
+	if !cache.WaitForCacheSync(syncCh, informer.HasSynced) {
+		select {
+		case <-stop:
+			log.Info("pod informer stopped before cache sync")
+			return nil
+		default:
+		}
+		halt()
+		err = fmt.Errorf("pod informer cache did not sync within %v", podSyncTimeout)
+		log.Error("%v", err)
+		return err
+	}
+	log.Info("pod informer started")
+
+	return nil
+}
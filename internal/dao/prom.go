@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"strings"
 	"time"
 
 	liangModel "liang/internal/model"
@@ -54,6 +55,15 @@ func (promDao *PromDao) ExecPromQL(promQL string) (error, model.Value) {
 	return nil, result
 }
 
+// Ping 执行一次简单查询检查Prometheus是否可用
+func (promDao *PromDao) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, _, err := promDao.API.Query(ctx, "vector(1)", time.Now())
+	return err
+}
+
 func (d *dao) RequestPromDemo() {
 	// d.promDao.ExecPromQL("up")
 	// d.promDao.ExecPromQL(`increase(node_network_receive_bytes_total{device=~"eth0"}[30s])`)
@@ -127,6 +137,18 @@ func (d *dao) RequestPromMaxNetIO() (map[string]int64, error) {
 	return d.parsePromResultInt64(result, 1)
 }
 
+// RequestPromNICSpeed 查询物理网卡的速度，排除回环和容器网络等虚拟网卡，一台机器有多块网卡时取最大值
+// 单位 kbit/s，无法获取速度的网卡node_exporter返回-1
+func (d *dao) RequestPromNICSpeed() (map[string]int64, error) {
+	promQL := `max(node_network_speed_bytes{device!~"lo|veth.*|docker.*|br-.*|cni.*|flannel.*|cali.*|vxlan.*|tun.*|tap.*|virbr.*|kube-ipvs.*"}*8/1000) by (job)`
+	err, result := d.promDao.ExecPromQL(promQL)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.parsePromResultInt64(result, 1)
+}
+
 // RequestPromDiskIO 查询Prom上机器的DiskIO
 // 单位byte/s 或者 B/s
 func (d *dao) RequestPromDiskIO(diskType string) (map[string]int64, error) {
@@ -156,6 +178,271 @@ func (d *dao) RequestPromMaxDiskIO() (map[string]int64, error) {
 	return d.parsePromResultInt64(result, 1)
 }
 
+// RequestPromMaxDiskIOPS 查询读/写中最大的磁盘每秒操作次数
+func (d *dao) RequestPromMaxDiskIOPS() (map[string]int64, error) {
+	promQL := `(max(irate(node_disk_writes_completed_total[30s])) by (job)) > (max(irate(node_disk_reads_completed_total[30s])) by (job)) or (max(irate(node_disk_reads_completed_total[30s])) by (job))`
+	err, result := d.promDao.ExecPromQL(promQL)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.parsePromResultInt64(result, 1)
+}
+
+// RequestPromDiskCapacity 查询磁盘基准测试得到的磁盘IO能力
+// 指标由节点上一次性的基准测试(如fio)通过node_exporter textfile collector发布，吞吐单位B/s
+func (d *dao) RequestPromDiskCapacity() (map[string]liangModel.DiskCapacity, error) {
+	err, result := d.promDao.ExecPromQL(`max(liang_disk_capacity_bytes) by (job)`)
+	if err != nil {
+		return nil, err
+	}
+	throughput, err := d.parsePromResultInt64(result, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	err, result = d.promDao.ExecPromQL(`max(liang_disk_capacity_iops) by (job)`)
+	if err != nil {
+		return nil, err
+	}
+	iops, err := d.parsePromResultInt64(result, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]liangModel.DiskCapacity)
+	for name, v := range throughput {
+		res[name] = liangModel.DiskCapacity{Throughput: v, IOPS: iops[name]}
+	}
+	for name, v := range iops {
+		if _, ok := res[name]; !ok {
+			res[name] = liangModel.DiskCapacity{IOPS: v}
+		}
+	}
+
+	return res, nil
+}
+
+// RequestPromPodNetIO 查询Deployment、StatefulSet、ReplicaSet和Job管理的Pod在window内网络负载的quantile分位数，按控制器分组
+// Deployment的Pod按Deployment分组，滚动更新产生新的ReplicaSet后仍然使用同一个估计，不属于Deployment的ReplicaSet按自身分组
+// 容器网络指标来自cAdvisor，控制器来自kube-state-metrics的kube_pod_owner，窗口内已经删除的Pod也参与计算
+// 单位 kbit/s
+func (d *dao) RequestPromPodNetIO(bwType string, window time.Duration, quantile float64) (map[liangModel.PodOwner]map[string]int64, error) {
+	metric := "container_network_transmit_bytes_total"
+	if bwType == liangModel.NetIOTypeDown {
+		metric = "container_network_receive_bytes_total"
+	}
+	podOwnerQL := podWorkloadQL + ` or on (namespace, pod) ` +
+		`max(kube_pod_owner{owner_kind=~"ReplicaSet|Job"}) by (namespace, pod, owner_kind, owner_name)`
+	perPodQL := fmt.Sprintf(`max(rate(%s{pod!=""}[1m])) by (namespace, pod)*8/1000`, metric)
+	err, result := d.promDao.ExecPromQL(podQuantileQL(perPodQL, podOwnerQL, window, quantile))
+	if err != nil {
+		return nil, err
+	}
+	values, err := d.parsePromResultByOwner(result)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[liangModel.PodOwner]map[string]int64, len(values))
+	for owner, pods := range values {
+		res[owner] = make(map[string]int64, len(pods))
+		for pod, v := range pods {
+			res[owner][pod] = int64(math.Round(v))
+		}
+	}
+
+	return res, nil
+}
+
+// podWorkloadQL 每个Pod所属的Deployment或StatefulSet，Deployment通过ReplicaSet的控制器确定，取值为1
+const podWorkloadQL = `(max(kube_pod_owner{owner_kind="StatefulSet"}) by (namespace, pod, owner_kind, owner_name) ` +
+	`or max(max(label_replace(kube_pod_owner{owner_kind="ReplicaSet"}, "replicaset", "$1", "owner_name", "(.*)")) by (namespace, pod, replicaset) ` +
+	`* on (namespace, replicaset) group_left (owner_kind, owner_name) ` +
+	`max(kube_replicaset_owner{owner_kind="Deployment"}) by (namespace, replicaset, owner_kind, owner_name)) by (namespace, pod, owner_kind, owner_name))`
+
+// podQuantileQL 按ownerQL给perPodQL中每个Pod的负载加上控制器标签，再取window内的quantile分位数
+func podQuantileQL(perPodQL, ownerQL string, window time.Duration, quantile float64) string {
+	return fmt.Sprintf(`max(quantile_over_time(%g, ((%s) * on (namespace, pod) group_left (owner_kind, owner_name) %s)[%s:1m])) `+
+		`by (namespace, pod, owner_kind, owner_name)`, quantile, perPodQL, ownerQL, model.Duration(window))
+}
+
+// RequestPromWorkloadUsage 查询Deployment和StatefulSet的每个Pod在window内负载的quantile分位数，按工作负载分组
+// resource为LiangNetIO时取上行和下行中较大的网络负载，单位 kbit/s；为LiangDiskIO时取容器磁盘读写吞吐之和，单位 B/s
+func (d *dao) RequestPromWorkloadUsage(resource string, window time.Duration, quantile float64) (map[liangModel.PodOwner]map[string]float64, error) {
+	var perPodQL string
+	switch resource {
+	case liangModel.ResourceNetIOKey:
+		perPodQL = `(max(rate(container_network_receive_bytes_total{pod!=""}[1m])) by (namespace, pod) ` +
+			`> max(rate(container_network_transmit_bytes_total{pod!=""}[1m])) by (namespace, pod) ` +
+			`or max(rate(container_network_transmit_bytes_total{pod!=""}[1m])) by (namespace, pod))*8/1000`
+	case liangModel.ResourceDiskIOKey:
+		perPodQL = `sum(rate(container_fs_reads_bytes_total{pod!="", container!=""}[1m]) ` +
+			`+ rate(container_fs_writes_bytes_total{pod!="", container!=""}[1m])) by (namespace, pod)`
+	default:
+		return nil, fmt.Errorf("unsupported workload resource %s", resource)
+	}
+
+	err, result := d.promDao.ExecPromQL(podQuantileQL(perPodQL, podWorkloadQL, window, quantile))
+	if err != nil {
+		return nil, err
+	}
+
+	return d.parsePromResultByOwner(result)
+}
+
+// RequestPromWorkloadAnnotations 查询Deployment和StatefulSet的Pod上keys对应的注解，没有注解的key不在结果中
+// 注解来自kube-state-metrics的kube_pod_annotations，需要通过--metric-annotations-allowlist导出这些注解
+// 滚动更新时同一工作负载的Pod注解可能不同，取Pod个数最多的一组
+func (d *dao) RequestPromWorkloadAnnotations(keys []string) (map[liangModel.PodOwner]map[string]string, error) {
+	labels := make([]string, len(keys))
+	for i, key := range keys {
+		labels[i] = "annotation_" + key
+	}
+	promQL := fmt.Sprintf(`count(kube_pod_annotations * on (namespace, pod) group_left (owner_kind, owner_name) %s) `+
+		`by (namespace, owner_kind, owner_name, %s)`, podWorkloadQL, strings.Join(labels, ", "))
+	err, result := d.promDao.ExecPromQL(promQL)
+	if err != nil {
+		return nil, err
+	}
+	vectorValue, ok := result.(model.Vector)
+	if !ok {
+		return nil, fmt.Errorf("type of result not %T, get %T", model.Vector{}, result)
+	}
+
+	res := make(map[liangModel.PodOwner]map[string]string)
+	pods := make(map[liangModel.PodOwner]float64)
+	for _, sample := range vectorValue {
+		owner, ok := promOwner(sample.Metric)
+		if !ok || float64(sample.Value) <= pods[owner] {
+			continue
+		}
+		pods[owner] = float64(sample.Value)
+		res[owner] = make(map[string]string)
+		for i, key := range keys {
+			if v := string(sample.Metric[model.LabelName(labels[i])]); v != "" {
+				res[owner][key] = v
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// RequestPromNamespaceLabel 查询每个命名空间上标签key的值，没有该标签的命名空间不在结果中
+// 标签来自kube-state-metrics的kube_namespace_labels，需要通过--metric-labels-allowlist导出该标签
+func (d *dao) RequestPromNamespaceLabel(key string) (map[string]string, error) {
+	label := promLabelName("label_" + key)
+	err, result := d.promDao.ExecPromQL(fmt.Sprintf(`max(kube_namespace_labels) by (namespace, %s)`, label))
+	if err != nil {
+		return nil, err
+	}
+	vectorValue, ok := result.(model.Vector)
+	if !ok {
+		return nil, fmt.Errorf("type of result not %T, get %T", model.Vector{}, result)
+	}
+
+	res := make(map[string]string)
+	for _, sample := range vectorValue {
+		ns, v := string(sample.Metric["namespace"]), string(sample.Metric[model.LabelName(label)])
+		if ns != "" && v != "" {
+			res[ns] = v
+		}
+	}
+
+	return res, nil
+}
+
+// RequestPromNodeLabels 查询每个节点上标签keys的值，结果按节点名和标签名索引，没有这些标签的节点不在结果中
+// 标签来自kube-state-metrics的kube_node_labels，需要通过--metric-labels-allowlist导出这些标签
+func (d *dao) RequestPromNodeLabels(keys []string) (map[string]map[string]string, error) {
+	return d.requestPromNodeMeta("kube_node_labels", "label_", keys)
+}
+
+// RequestPromNodeAnnotations 查询每个节点上注解keys的值，注解来自kube-state-metrics的kube_node_annotations
+// 需要通过--metric-annotations-allowlist导出这些注解
+func (d *dao) RequestPromNodeAnnotations(keys []string) (map[string]map[string]string, error) {
+	return d.requestPromNodeMeta("kube_node_annotations", "annotation_", keys)
+}
+
+// requestPromNodeMeta 查询kube-state-metrics导出的节点标签或注解，prefix为导出时标签名的前缀
+func (d *dao) requestPromNodeMeta(metric, prefix string, keys []string) (map[string]map[string]string, error) {
+	labels := make([]string, len(keys))
+	for i, key := range keys {
+		labels[i] = promLabelName(prefix + key)
+	}
+	err, result := d.promDao.ExecPromQL(fmt.Sprintf(`max(%s) by (node, %s)`, metric, strings.Join(labels, ", ")))
+	if err != nil {
+		return nil, err
+	}
+	vectorValue, ok := result.(model.Vector)
+	if !ok {
+		return nil, fmt.Errorf("type of result not %T, get %T", model.Vector{}, result)
+	}
+
+	res := make(map[string]map[string]string)
+	for _, sample := range vectorValue {
+		node := string(sample.Metric["node"])
+		if node == "" {
+			continue
+		}
+		for i, key := range keys {
+			if v := string(sample.Metric[model.LabelName(labels[i])]); v != "" {
+				if res[node] == nil {
+					res[node] = make(map[string]string)
+				}
+				res[node][key] = v
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// promLabelName 与kube-state-metrics相同，把标签名中不合法的字符替换为下划线，如liang.io/policy为liang_io_policy
+func promLabelName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// parsePromResultByOwner 解析带有namespace、pod和控制器标签的查询结果，按控制器分组，没有控制器的Pod被忽略
+func (d *dao) parsePromResultByOwner(result model.Value) (map[liangModel.PodOwner]map[string]float64, error) {
+	vectorValue, ok := result.(model.Vector)
+	if !ok {
+		return nil, fmt.Errorf("type of result not %T, get %T", model.Vector{}, result)
+	}
+
+	res := make(map[liangModel.PodOwner]map[string]float64)
+	for _, sample := range vectorValue {
+		owner, ok := promOwner(sample.Metric)
+		pod := string(sample.Metric["pod"])
+		if !ok || pod == "" {
+			continue
+		}
+		if res[owner] == nil {
+			res[owner] = make(map[string]float64)
+		}
+		res[owner][pod] = float64(sample.Value)
+	}
+
+	return res, nil
+}
+
+// promOwner 从namespace、owner_kind和owner_name标签得到控制器
+func promOwner(metric model.Metric) (liangModel.PodOwner, bool) {
+	owner := liangModel.PodOwner{
+		Namespace: string(metric["namespace"]),
+		Kind:      string(metric["owner_kind"]),
+		Name:      string(metric["owner_name"]),
+	}
+
+	return owner, owner.Kind != "" && owner.Name != ""
+}
+
 // RequestPromCPUUsage 查询Prom上机器的CPU使用率
 // 取4位有效数字后转换成int64，相比float64满足精度的前提下提高计算速度
 // e.g.: 0.012->12 23.453453245->2345
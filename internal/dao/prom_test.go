@@ -0,0 +1,207 @@
+package dao
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"liang/internal/fakeprom"
+	"liang/internal/model"
+
+	promModel "github.com/prometheus/common/model"
+)
+
+func newFakePromDao(t *testing.T) (*fakeprom.Server, *dao) {
+	prom := fakeprom.New()
+	addr := prom.Start()
+	t.Cleanup(prom.Close)
+
+	d, cf, err := newDaoWithConfig(&Config{PromAddr: addr, LocalCacheExpire: 30})
+	if err != nil {
+		t.Fatalf("new dao error: %v", err)
+	}
+	t.Cleanup(cf)
+
+	return prom, d
+}
+
+func TestDao_RequestProm(t *testing.T) {
+	prom, d := newFakePromDao(t)
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 1024.4, "node2": 2048.6})
+	prom.SetConst(fakeprom.DiskWritten, map[string]float64{"node1": 4096})
+	prom.SetConst(fakeprom.DiskRead, map[string]float64{"node1": 1})
+	prom.SetConst(fakeprom.CPUSeconds, map[string]float64{"node1": 0.123, "node2": 0.5})
+	prom.SetConst(fakeprom.MemAvailable, map[string]float64{"node1": 0.666})
+
+	cases := []struct {
+		Name     string
+		Request  func() (map[string]int64, error)
+		Expected map[string]int64
+	}{
+		{
+			Name:     "test 0: net io rounded",
+			Request:  func() (map[string]int64, error) { return d.RequestPromNetIO(model.NetIOTypeDown) },
+			Expected: map[string]int64{"node1": 1024, "node2": 2049},
+		},
+		{
+			Name:     "test 1: max net io",
+			Request:  d.RequestPromMaxNetIO,
+			Expected: map[string]int64{"node1": 1024, "node2": 2049},
+		},
+		{
+			Name:     "test 2: max disk io",
+			Request:  d.RequestPromMaxDiskIO,
+			Expected: map[string]int64{"node1": 4096},
+		},
+		{
+			Name:     "test 3: disk read",
+			Request:  func() (map[string]int64, error) { return d.RequestPromDiskIO(model.DiskIOTypeRead) },
+			Expected: map[string]int64{"node1": 1},
+		},
+		{
+			Name:     "test 4: cpu usage in percent",
+			Request:  d.RequestPromCPUUsage,
+			Expected: map[string]int64{"node1": 12, "node2": 50},
+		},
+		{
+			Name:     "test 5: mem usage in percent",
+			Request:  d.RequestPromMemUsage,
+			Expected: map[string]int64{"node1": 67},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			res, err := c.Request()
+			if err != nil {
+				t.Fatalf("request prom error: %v", err)
+			}
+			if !reflect.DeepEqual(res, c.Expected) {
+				t.Errorf("expected %v, but get %v", c.Expected, res)
+			}
+		})
+	}
+}
+
+func TestDao_RequestPromFault(t *testing.T) {
+	prom, d := newFakePromDao(t)
+	prom.SetConst(fakeprom.CPUSeconds, map[string]float64{"node1": 0.5})
+
+	if err := d.Ping(context.Background()); err != nil {
+		t.Errorf("ping should succeed, but get %v", err)
+	}
+
+	prom.SetFault(fakeprom.CPUSeconds, fakeprom.Fault{ErrorType: "execution", Error: "query timed out"})
+	if _, err := d.RequestPromCPUUsage(); err == nil {
+		t.Errorf("request cpu usage should return error")
+	}
+
+	// 告警不影响结果
+	prom.SetFault(fakeprom.CPUSeconds, fakeprom.Fault{Warnings: []string{"partial response"}})
+	res, err := d.RequestPromCPUUsage()
+	if err != nil || res["node1"] != 50 {
+		t.Errorf("request cpu usage with warnings should succeed, but get %v %v", res, err)
+	}
+
+	prom.SetFault("", fakeprom.Fault{StatusCode: 503})
+	if err = d.Ping(context.Background()); err == nil {
+		t.Errorf("ping should fail when prometheus is unavailable")
+	}
+}
+
+func TestDao_RequestPromPodNetIO(t *testing.T) {
+	prom, d := newFakePromDao(t)
+	pod := func(name, kind, owner string) promModel.Metric {
+		return promModel.Metric{"namespace": "default", "pod": promModel.LabelValue(name),
+			"owner_kind": promModel.LabelValue(kind), "owner_name": promModel.LabelValue(owner)}
+	}
+	prom.SetLabeledSeries(fakeprom.ContainerNetReceive, pod("web-1", "ReplicaSet", "web-7d4b9"), fakeprom.Const(1000.4))
+	prom.SetLabeledSeries(fakeprom.ContainerNetReceive, pod("web-2", "ReplicaSet", "web-7d4b9"), fakeprom.Const(3000))
+	prom.SetLabeledSeries(fakeprom.ContainerNetReceive, pod("db-0", "StatefulSet", "db"), fakeprom.Const(500))
+	// 没有控制器标签的数据被忽略
+	prom.SetLabeledSeries(fakeprom.ContainerNetReceive, promModel.Metric{"namespace": "default", "pod": "debug"}, fakeprom.Const(1))
+	prom.SetLabeledSeries(fakeprom.ContainerNetTransmit, pod("web-1", "ReplicaSet", "web-7d4b9"), fakeprom.Const(200))
+
+	res, err := d.RequestPromPodNetIO(model.NetIOTypeDown, 6*time.Hour, 0.95)
+	if err != nil {
+		t.Fatalf("request pod net io error: %v", err)
+	}
+	expected := map[model.PodOwner]map[string]int64{
+		{Namespace: "default", Kind: "ReplicaSet", Name: "web-7d4b9"}: {"web-1": 1000, "web-2": 3000},
+		{Namespace: "default", Kind: "StatefulSet", Name: "db"}:       {"db-0": 500},
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected %v, but get %v", expected, res)
+	}
+
+	res, err = d.RequestPromPodNetIO(model.NetIOTypeUp, 6*time.Hour, 0.95)
+	if err != nil || len(res) != 1 {
+		t.Errorf("upload net io should only contain web, but get %v %v", res, err)
+	}
+}
+
+func TestDao_RequestPromWorkload(t *testing.T) {
+	prom, d := newFakePromDao(t)
+	web := model.PodOwner{Namespace: "default", Kind: "Deployment", Name: "web"}
+	db := model.PodOwner{Namespace: "default", Kind: "StatefulSet", Name: "db"}
+	pod := func(owner model.PodOwner, name string) promModel.Metric {
+		return promModel.Metric{"namespace": promModel.LabelValue(owner.Namespace), "pod": promModel.LabelValue(name),
+			"owner_kind": promModel.LabelValue(owner.Kind), "owner_name": promModel.LabelValue(owner.Name)}
+	}
+	prom.SetLabeledSeries(fakeprom.ContainerNetReceive, pod(web, "web-7d4b9-x"), fakeprom.Const(8000))
+	prom.SetLabeledSeries(fakeprom.ContainerFsReads, pod(db, "db-0"), fakeprom.Const(10*model.MByte))
+
+	net, err := d.RequestPromWorkloadUsage(model.ResourceNetIOKey, time.Hour, 0.95)
+	if err != nil || !reflect.DeepEqual(net, map[model.PodOwner]map[string]float64{web: {"web-7d4b9-x": 8000}}) {
+		t.Errorf("net usage of web is wrong: %v %v", net, err)
+	}
+	disk, err := d.RequestPromWorkloadUsage(model.ResourceDiskIOKey, time.Hour, 0.95)
+	if err != nil || !reflect.DeepEqual(disk, map[model.PodOwner]map[string]float64{db: {"db-0": 10 * model.MByte}}) {
+		t.Errorf("disk usage of db is wrong: %v %v", disk, err)
+	}
+	if _, err = d.RequestPromWorkloadUsage(model.ResourceCPUKey, time.Hour, 0.95); err == nil {
+		t.Errorf("cpu usage should not be supported")
+	}
+
+	// 滚动更新时新旧Pod的注解不同，使用Pod个数多的一组
+	annotated := func(owner model.PodOwner, netIO string) promModel.Metric {
+		return promModel.Metric{"namespace": promModel.LabelValue(owner.Namespace), "owner_kind": promModel.LabelValue(owner.Kind),
+			"owner_name": promModel.LabelValue(owner.Name), "annotation_LiangNetIO": promModel.LabelValue(netIO)}
+	}
+	prom.SetLabeledSeries(fakeprom.PodAnnotations, annotated(web, "10"), fakeprom.Const(1))
+	prom.SetLabeledSeries(fakeprom.PodAnnotations, annotated(web, "20"), fakeprom.Const(3))
+	prom.SetLabeledSeries(fakeprom.PodAnnotations, annotated(db, ""), fakeprom.Const(1))
+	annotations, err := d.RequestPromWorkloadAnnotations([]string{model.ResourceNetIOKey, model.ResourceDiskIOKey})
+	expected := map[model.PodOwner]map[string]string{web: {model.ResourceNetIOKey: "20"}, db: {}}
+	if err != nil || !reflect.DeepEqual(annotations, expected) {
+		t.Errorf("annotations should be %v, but get %v %v", expected, annotations, err)
+	}
+}
+
+func TestDao_RequestPromNamespaceLabel(t *testing.T) {
+	prom, d := newFakePromDao(t)
+	namespace := func(name, policy string) promModel.Metric {
+		return promModel.Metric{"namespace": promModel.LabelValue(name), "label_liang_io_policy": promModel.LabelValue(policy)}
+	}
+	prom.SetLabeledSeries(fakeprom.NamespaceLabels, namespace("batch", "compact"), fakeprom.Const(1))
+	prom.SetLabeledSeries(fakeprom.NamespaceLabels, namespace("default", ""), fakeprom.Const(1))
+
+	labels, err := d.RequestPromNamespaceLabel(model.AnnotationPolicy)
+	if err != nil || !reflect.DeepEqual(labels, map[string]string{"batch": "compact"}) {
+		t.Errorf("policy label of namespaces is wrong: %v %v", labels, err)
+	}
+}
+
+func TestDao_RequestPromNodeLabels(t *testing.T) {
+	prom, d := newFakePromDao(t)
+	prom.SetLabeledSeries(fakeprom.NodeLabels,
+		promModel.Metric{"node": "node1", "label_liang_io_nic_speed": "10000", "label_liang_io_disk_type": "ssd"}, fakeprom.Const(1))
+	prom.SetLabeledSeries(fakeprom.NodeLabels, promModel.Metric{"node": "node2"}, fakeprom.Const(1))
+
+	labels, err := d.RequestPromNodeLabels([]string{model.LabelNICSpeed, model.LabelDiskType})
+	expected := map[string]map[string]string{"node1": {model.LabelNICSpeed: "10000", model.LabelDiskType: "ssd"}}
+	if err != nil || !reflect.DeepEqual(labels, expected) {
+		t.Errorf("labels of nodes should be %v, but get %v %v", expected, labels, err)
+	}
+}
@@ -0,0 +1,427 @@
+package fakeprom
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Liang查询中用到的指标名称
+const (
+	NetReceive          = "node_network_receive_bytes_total"
+	NetTransmit         = "node_network_transmit_bytes_total"
+	NetSpeed            = "node_network_speed_bytes"
+	DiskRead            = "node_disk_read_bytes_total"
+	DiskWritten         = "node_disk_written_bytes_total"
+	DiskReadsCompleted  = "node_disk_reads_completed_total"
+	DiskWritesCompleted = "node_disk_writes_completed_total"
+	CPUSeconds          = "node_cpu_seconds_total"
+	MemAvailable        = "node_memory_MemAvailable_bytes"
+
+	// 磁盘基准测试发布的磁盘IO能力
+	DiskCapacityBytes = "liang_disk_capacity_bytes"
+	DiskCapacityIOPS  = "liang_disk_capacity_iops"
+
+	// 按控制器学习Pod网络需求和推荐注解时查询的容器指标
+	ContainerNetReceive  = "container_network_receive_bytes_total"
+	ContainerNetTransmit = "container_network_transmit_bytes_total"
+	ContainerFsReads     = "container_fs_reads_bytes_total"
+	ContainerFsWrites    = "container_fs_writes_bytes_total"
+
+	// kube-state-metrics导出的Pod注解，标签为annotation_<注解>
+	PodAnnotations = "kube_pod_annotations"
+	// kube-state-metrics导出的命名空间标签，标签为label_<标签>
+	NamespaceLabels = "kube_namespace_labels"
+	// kube-state-metrics导出的节点标签和注解，标签为label_<标签>和annotation_<注解>
+	NodeLabels      = "kube_node_labels"
+	NodeAnnotations = "kube_node_annotations"
+)
+
+// NodeLabel 查询结果中节点对应的标签，和Liang的PromQL中by (job)一致
+const NodeLabel = "job"
+
+// maxRangePoints 和Prometheus一样限制query_range返回的点数
+const maxRangePoints = 11000
+
+var vectorLiteral = regexp.MustCompile(`^\s*vector\(\s*([-+0-9.eE]+)\s*\)\s*$`)
+
+// Series 某个节点的指标在t时刻的取值
+type Series func(t time.Time) float64
+
+// Const 取值不随时间变化
+func Const(v float64) Series {
+	return func(time.Time) float64 {
+		return v
+	}
+}
+
+// Steps 从start开始每隔interval取下一个值，start之前取第一个值，超出后保持最后一个值
+func Steps(start time.Time, interval time.Duration, values ...float64) Series {
+	return func(t time.Time) float64 {
+		if len(values) == 0 {
+			return 0
+		}
+		i := 0
+		if t.After(start) && interval > 0 {
+			i = int(t.Sub(start) / interval)
+		}
+		if i >= len(values) {
+			i = len(values) - 1
+		}
+		return values[i]
+	}
+}
+
+// Fault 注入到查询中的故障
+type Fault struct {
+	Latency    time.Duration // 返回前等待的时长
+	StatusCode int           // 非0时直接返回该HTTP状态码
+	ErrorType  string        // 非空时返回Prometheus格式的错误，如execution/timeout/bad_data
+	Error      string
+	Warnings   []string // 和正常结果一起返回的告警
+	Times      int      // 生效次数，0表示一直生效
+}
+
+// Server 实现了Prometheus HTTP API中/api/v1/query和/api/v1/query_range的假服务
+// 不解析PromQL：查询中出现的第一个已注册指标的数据直接作为查询结果返回，
+// 因此脚本中的取值应该是Liang查询计算后的值，例如网络负载单位为Kbit/s，CPU使用率为0-1
+type Server struct {
+	mu      sync.Mutex
+	series  map[string]map[string]Series // metric -> node -> series
+	labels  map[string]model.Metric      // SetLabeledSeries设置的数据的标签，key为标签的字符串形式
+	faults  map[string]*Fault            // metric -> fault，""对所有查询生效
+	queries map[string]int
+	ts      *httptest.Server
+}
+
+// New 创建没有任何数据的假服务
+func New() *Server {
+	return &Server{
+		series:  make(map[string]map[string]Series),
+		labels:  make(map[string]model.Metric),
+		faults:  make(map[string]*Fault),
+		queries: make(map[string]int),
+	}
+}
+
+// Start 使用httptest启动服务，返回地址
+func (s *Server) Start() string {
+	s.ts = httptest.NewServer(s)
+	return s.ts.URL
+}
+
+// Close 关闭Start启动的服务
+func (s *Server) Close() {
+	if s.ts != nil {
+		s.ts.Close()
+	}
+}
+
+// SetSeries 设置节点node上指标metric的数据
+func (s *Server) SetSeries(metric, node string, series Series) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.series[metric] == nil {
+		s.series[metric] = make(map[string]Series)
+	}
+	s.series[metric][node] = series
+}
+
+// SetLabeledSeries 设置带有任意标签的指标数据，用于不按节点分组的查询，如按Pod和控制器分组
+func (s *Server) SetLabeledSeries(metric string, lbls model.Metric, series Series) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := lbls.String()
+	if s.series[metric] == nil {
+		s.series[metric] = make(map[string]Series)
+	}
+	s.series[metric][key] = series
+	s.labels[key] = lbls
+}
+
+// SetConst 设置多个节点上指标metric的固定取值
+func (s *Server) SetConst(metric string, values map[string]float64) {
+	for node, v := range values {
+		s.SetSeries(metric, node, Const(v))
+	}
+}
+
+// DeleteSeries 删除节点上的指标数据，node为空时删除该指标的全部数据
+func (s *Server) DeleteSeries(metric, node string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if node == "" {
+		delete(s.series, metric)
+		return
+	}
+	delete(s.series[metric], node)
+}
+
+// SetFault 对查询指标metric的请求注入故障，metric为空时对所有请求生效
+func (s *Server) SetFault(metric string, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults[metric] = &f
+}
+
+// ClearFaults 清除所有故障
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = make(map[string]*Fault)
+}
+
+// Queries 指标metric被查询的次数，metric为空时返回无法匹配指标的查询次数
+func (s *Server) Queries(metric string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.queries[metric]
+}
+
+// resolve 找到查询中最先出现的已注册指标
+func (s *Server) resolve(query string) string {
+	metric, pos := "", -1
+	check := func(name string) {
+		if name == "" {
+			return
+		}
+		i := strings.Index(query, name)
+		if i >= 0 && (pos < 0 || i < pos || (i == pos && len(name) > len(metric))) {
+			metric, pos = name, i
+		}
+	}
+	for name := range s.series {
+		check(name)
+	}
+	for name := range s.faults {
+		check(name)
+	}
+
+	return metric
+}
+
+// takeFault 返回对本次查询生效的故障，并扣减生效次数
+func (s *Server) takeFault(metric string) *Fault {
+	for _, key := range []string{metric, ""} {
+		f, ok := s.faults[key]
+		if !ok {
+			continue
+		}
+		res := *f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				delete(s.faults, key)
+			}
+		}
+		return &res
+	}
+
+	return nil
+}
+
+// ServeHTTP 和Prometheus一样同时支持GET和POST表单
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v1/query" && r.URL.Path != "/api/v1/query_range" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_data", err.Error())
+		return
+	}
+	query := r.Form.Get("query")
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "bad_data", "query is empty")
+		return
+	}
+
+	s.mu.Lock()
+	metric := s.resolve(query)
+	s.queries[metric]++
+	fault := s.takeFault(metric)
+	nodes := make(map[string]Series, len(s.series[metric]))
+	metrics := make(map[string]model.Metric, len(s.series[metric]))
+	for node, series := range s.series[metric] {
+		nodes[node] = series
+		metrics[node] = s.labelsOf(node)
+	}
+	s.mu.Unlock()
+
+	var warnings []string
+	if fault != nil {
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fault.StatusCode != 0 && fault.ErrorType == "" {
+			http.Error(w, http.StatusText(fault.StatusCode), fault.StatusCode)
+			return
+		}
+		if fault.ErrorType != "" {
+			code := fault.StatusCode
+			if code == 0 {
+				code = http.StatusUnprocessableEntity
+			}
+			writeError(w, code, fault.ErrorType, fault.Error)
+			return
+		}
+		warnings = fault.Warnings
+	}
+
+	// vector(1)之类的字面量查询，Ping会用到
+	if metric == "" {
+		if m := vectorLiteral.FindStringSubmatch(query); m != nil {
+			v, _ := strconv.ParseFloat(m[1], 64)
+			nodes = map[string]Series{"": Const(v)}
+			metrics = map[string]model.Metric{"": {}}
+		}
+	}
+
+	var (
+		data interface{}
+		err  error
+	)
+	if r.URL.Path == "/api/v1/query" {
+		data, err = instantQuery(r, nodes, metrics)
+	} else {
+		data, err = rangeQuery(r, nodes, metrics)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_data", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":   "success",
+		"data":     data,
+		"warnings": warnings,
+	})
+}
+
+func instantQuery(r *http.Request, nodes map[string]Series, metrics map[string]model.Metric) (interface{}, error) {
+	ts := time.Now()
+	if v := r.Form.Get("time"); v != "" {
+		var err error
+		if ts, err = parseTime(v); err != nil {
+			return nil, err
+		}
+	}
+
+	vector := make(model.Vector, 0, len(nodes))
+	for node, series := range nodes {
+		vector = append(vector, &model.Sample{
+			Metric:    metrics[node],
+			Value:     model.SampleValue(series(ts)),
+			Timestamp: model.TimeFromUnixNano(ts.UnixNano()),
+		})
+	}
+
+	return map[string]interface{}{
+		"resultType": model.ValVector.String(),
+		"result":     vector,
+	}, nil
+}
+
+func rangeQuery(r *http.Request, nodes map[string]Series, metrics map[string]model.Metric) (interface{}, error) {
+	start, err := parseTime(r.Form.Get("start"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid start: %v", err)
+	}
+	end, err := parseTime(r.Form.Get("end"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid end: %v", err)
+	}
+	step, err := parseDuration(r.Form.Get("step"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid step: %v", err)
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("end timestamp must not be before start time")
+	}
+	if step <= 0 {
+		return nil, fmt.Errorf("zero or negative query resolution step widths are not accepted")
+	}
+	if end.Sub(start)/step > maxRangePoints {
+		return nil, fmt.Errorf("exceeded maximum resolution of %d points per timeseries", maxRangePoints)
+	}
+
+	matrix := make(model.Matrix, 0, len(nodes))
+	for node, series := range nodes {
+		stream := &model.SampleStream{Metric: metrics[node]}
+		for t := start; !t.After(end); t = t.Add(step) {
+			stream.Values = append(stream.Values, model.SamplePair{
+				Timestamp: model.TimeFromUnixNano(t.UnixNano()),
+				Value:     model.SampleValue(series(t)),
+			})
+		}
+		matrix = append(matrix, stream)
+	}
+
+	return map[string]interface{}{
+		"resultType": model.ValMatrix.String(),
+		"result":     matrix,
+	}, nil
+}
+
+// labelsOf 数据的标签，SetSeries设置的数据只有节点标签，调用方持有锁
+func (s *Server) labelsOf(node string) model.Metric {
+	if lbls, ok := s.labels[node]; ok {
+		return lbls
+	}
+	if node == "" {
+		return model.Metric{}
+	}
+
+	return model.Metric{NodeLabel: model.LabelValue(node)}
+}
+
+// parseTime 支持unix时间戳和RFC3339
+func parseTime(v string) (time.Time, error) {
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+	}
+
+	return time.Parse(time.RFC3339Nano, v)
+}
+
+// parseDuration 支持秒数和15s格式
+func parseDuration(v string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+
+	return time.ParseDuration(v)
+}
+
+func writeError(w http.ResponseWriter, code int, errorType, msg string) {
+	writeJSON(w, code, map[string]interface{}{
+		"status":    "error",
+		"errorType": errorType,
+		"error":     msg,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,187 @@
+package fakeprom
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+func newAPI(t *testing.T) (*Server, v1.API) {
+	s := New()
+	addr := s.Start()
+	t.Cleanup(s.Close)
+
+	client, err := api.NewClient(api.Config{Address: addr})
+	if err != nil {
+		t.Fatalf("new client error: %v", err)
+	}
+
+	return s, v1.NewAPI(client)
+}
+
+func vectorMap(t *testing.T, v model.Value) map[string]float64 {
+	vector, ok := v.(model.Vector)
+	if !ok {
+		t.Fatalf("result should be vector, but get %T", v)
+	}
+	res := make(map[string]float64)
+	for _, sample := range vector {
+		res[string(sample.Metric[NodeLabel])] = float64(sample.Value)
+	}
+
+	return res
+}
+
+func TestServer_Query(t *testing.T) {
+	s, promAPI := newAPI(t)
+	start := time.Unix(1627776000, 0)
+	s.SetConst(NetReceive, map[string]float64{"node1": 100, "node2": 200})
+	s.SetConst(NetTransmit, map[string]float64{"node1": 300})
+	s.SetSeries(CPUSeconds, "node1", Steps(start, time.Minute, 0.1, 0.2, 0.3))
+
+	ctx := context.Background()
+	cases := []struct {
+		Name     string
+		Query    string
+		Time     time.Time
+		Expected map[string]float64
+	}{
+		{
+			Name:     "test 0: first metric in query",
+			Query:    `(max(irate(node_network_receive_bytes_total[30s])*8/1000) by (job)) > (max(irate(node_network_transmit_bytes_total[30s])*8/1024) by (job))`,
+			Time:     start,
+			Expected: map[string]float64{"node1": 100, "node2": 200},
+		},
+		{
+			Name:     "test 1: steps",
+			Query:    `(1 - avg(rate(node_cpu_seconds_total{mode="idle"}[30s])) by (job))`,
+			Time:     start.Add(90 * time.Second),
+			Expected: map[string]float64{"node1": 0.2},
+		},
+		{
+			Name:     "test 2: steps keep last value",
+			Query:    `(1 - avg(rate(node_cpu_seconds_total{mode="idle"}[30s])) by (job))`,
+			Time:     start.Add(time.Hour),
+			Expected: map[string]float64{"node1": 0.3},
+		},
+		{
+			Name:     "test 3: unknown metric",
+			Query:    `node_load1`,
+			Time:     start,
+			Expected: map[string]float64{},
+		},
+		{
+			Name:     "test 4: vector literal",
+			Query:    `vector(1)`,
+			Time:     start,
+			Expected: map[string]float64{"": 1},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			res, _, err := promAPI.Query(ctx, c.Query, c.Time)
+			if err != nil {
+				t.Fatalf("query error: %v", err)
+			}
+			got := vectorMap(t, res)
+			if len(got) != len(c.Expected) {
+				t.Fatalf("expected %v, but get %v", c.Expected, got)
+			}
+			for k, v := range c.Expected {
+				if got[k] != v {
+					t.Errorf("expected %v, but get %v", c.Expected, got)
+				}
+			}
+		})
+	}
+
+	if n := s.Queries(CPUSeconds); n != 2 {
+		t.Errorf("cpu should be queried 2 times, but get %d", n)
+	}
+}
+
+func TestServer_QueryRange(t *testing.T) {
+	s, promAPI := newAPI(t)
+	start := time.Unix(1627776000, 0)
+	s.SetSeries(MemAvailable, "node1", Steps(start, time.Minute, 0.5, 0.6, 0.7))
+
+	res, _, err := promAPI.QueryRange(context.Background(), MemAvailable, v1.Range{
+		Start: start,
+		End:   start.Add(2 * time.Minute),
+		Step:  30 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("query range error: %v", err)
+	}
+	matrix, ok := res.(model.Matrix)
+	if !ok || len(matrix) != 1 {
+		t.Fatalf("result should be matrix with 1 series, but get %v", res)
+	}
+	expected := []float64{0.5, 0.5, 0.6, 0.6, 0.7}
+	if len(matrix[0].Values) != len(expected) {
+		t.Fatalf("expected %d points, but get %d", len(expected), len(matrix[0].Values))
+	}
+	for i, p := range matrix[0].Values {
+		if float64(p.Value) != expected[i] {
+			t.Errorf("point %d should be %f, but get %f", i, expected[i], float64(p.Value))
+		}
+	}
+
+	_, _, err = promAPI.QueryRange(context.Background(), MemAvailable, v1.Range{
+		Start: start,
+		End:   start.Add(24 * time.Hour),
+		Step:  time.Second,
+	})
+	if err == nil {
+		t.Errorf("too many points should return error")
+	}
+}
+
+func TestServer_Fault(t *testing.T) {
+	s, promAPI := newAPI(t)
+	s.SetConst(CPUSeconds, map[string]float64{"node1": 0.5})
+	s.SetConst(MemAvailable, map[string]float64{"node1": 0.5})
+	ctx := context.Background()
+	now := time.Now()
+
+	// 只生效一次的执行错误
+	s.SetFault(CPUSeconds, Fault{ErrorType: "execution", Error: "query timed out", Times: 1})
+	_, _, err := promAPI.Query(ctx, CPUSeconds, now)
+	var apiErr *v1.Error
+	if !errors.As(err, &apiErr) || apiErr.Type != v1.ErrExec {
+		t.Errorf("should return execution error, but get %v", err)
+	}
+	if _, _, err = promAPI.Query(ctx, CPUSeconds, now); err != nil {
+		t.Errorf("fault should be cleared after 1 time, but get %v", err)
+	}
+	// 其他指标不受影响
+	s.SetFault(CPUSeconds, Fault{StatusCode: 503})
+	if _, _, err = promAPI.Query(ctx, MemAvailable, now); err != nil {
+		t.Errorf("query of other metric should succeed, but get %v", err)
+	}
+	if _, _, err = promAPI.Query(ctx, CPUSeconds, now); err == nil {
+		t.Errorf("should return server error")
+	}
+
+	// 告警和数据一起返回
+	s.ClearFaults()
+	s.SetFault("", Fault{Warnings: []string{"partial response"}})
+	res, warnings, err := promAPI.Query(ctx, MemAvailable, now)
+	if err != nil || len(warnings) != 1 || vectorMap(t, res)["node1"] != 0.5 {
+		t.Errorf("should return data with warnings, but get %v %v %v", res, warnings, err)
+	}
+
+	// 延迟超过客户端超时
+	s.SetFault("", Fault{Latency: time.Second})
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, _, err = promAPI.Query(tctx, MemAvailable, now); err == nil {
+		t.Errorf("query should time out")
+	}
+}
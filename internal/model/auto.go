@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// AlgorithmSwitch 自动选择算法时的一次切换，Utilization为切换时集群各资源的平均利用率，单位为百分比
+type AlgorithmSwitch struct {
+	Time            time.Time          `json:"time"`
+	From            string             `json:"from"`
+	To              string             `json:"to"`
+	Bottleneck      string             `json:"bottleneck"`
+	Utilization     map[string]float64 `json:"utilization"`
+	SnapshotVersion int64              `json:"snapshotVersion"`
+}
+
+// AutoAlgorithmStatus 自动选择算法的状态，Candidate为连续Streak次同步都满足切换条件的算法
+type AutoAlgorithmStatus struct {
+	Enabled     bool               `json:"enabled"`
+	Algorithm   string             `json:"algorithm"`
+	Bottleneck  string             `json:"bottleneck,omitempty"`
+	Utilization map[string]float64 `json:"utilization,omitempty"`
+	Candidate   string             `json:"candidate,omitempty"`
+	Streak      int                `json:"streak"`
+	Switches    []AlgorithmSwitch  `json:"switches"`
+}
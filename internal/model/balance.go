@@ -0,0 +1,38 @@
+package model
+
+// 多资源均衡时合并各资源方差的方式
+const (
+	BalanceWeighted = "weighted" // 按权重加权平均各资源的方差
+	BalanceMax      = "max"      // 取权重大于0的资源中最大的方差，最不均衡的资源决定得分
+)
+
+// BalanceConfig bnp按多个资源的负载方差评分，对应application.toml中的[bnpBalance]
+// Weights的key为指标名，如LiangNetIO/LiangDiskIO/LiangCPU/LiangMem，权重为0的资源不参与评分
+type BalanceConfig struct {
+	Mode    string             `json:"mode"`
+	Weights map[string]float64 `json:"weights"`
+}
+
+// DefaultBalanceConfig 没有配置[bnpBalance]时只均衡网络负载，与原来的bnp相同
+func DefaultBalanceConfig() BalanceConfig {
+	return BalanceConfig{
+		Mode:    BalanceWeighted,
+		Weights: map[string]float64{ResourceNetIOKey: 1},
+	}
+}
+
+// BalanceKeys 参与多资源均衡的资源，顺序即解释结果中的顺序
+func BalanceKeys() []string {
+	return []string{ResourceNetIOKey, ResourceDiskIOKey, ResourceCPUKey, ResourceMemKey}
+}
+
+// MultiDim 是否有网络负载以外的资源参与评分，否则使用原来的bnp算法
+func (cfg BalanceConfig) MultiDim() bool {
+	for key, w := range cfg.Weights {
+		if key != ResourceNetIOKey && w > 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// Capture 录制的一次Prioritize请求，包含评分时使用的指标快照和配置，可以离线回放
+type Capture struct {
+	Time            time.Time                     `json:"time"`
+	Args            *extenderv1.ExtenderArgs      `json:"args"`
+	Algorithm       string                        `json:"algorithm"`
+	TopsisMin       bool                          `json:"topsisMin"`
+	NetBwMap        map[string]int64              `json:"netBwMap"`
+	NetCapMap       map[string]NetCapacity        `json:"netCapMap,omitempty"`
+	DiskCapMap      map[string]DiskCapacity       `json:"diskCapMap,omitempty"`
+	SnapshotVersion int64                         `json:"snapshotVersion"`
+	Snapshot        map[string](map[string]int64) `json:"snapshot"`
+	MissingPolicy   MissingPolicy                 `json:"missingPolicy"`
+	Balance         BalanceConfig                 `json:"balance"`
+	LastKnown       map[string](map[string]int64) `json:"lastKnown,omitempty"`
+	Stochastic      *StochasticNet                `json:"stochastic,omitempty"`
+	Ensemble        *EnsembleConfig               `json:"ensemble,omitempty"`
+	CMDNWeights     map[string]float64            `json:"cmdnWeights,omitempty"`
+	Result          extenderv1.HostPriorityList   `json:"result"`
+	Error           string                        `json:"error,omitempty"`
+}
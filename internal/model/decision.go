@@ -0,0 +1,73 @@
+package model
+
+import "time"
+
+// 评分算法名称
+const (
+	AlgoBNP      = "bnp"
+	AlgoCMDN     = "cmdn"
+	AlgoEnsemble = "ensemble" // 组合多个算法的得分
+)
+
+// Decision 一次Prioritize评分决策的审计记录
+type Decision struct {
+	Time            time.Time      `json:"time"`
+	PodName         string         `json:"podName"`
+	Namespace       string         `json:"namespace"`
+	PodUID          string         `json:"podUID"`
+	Nodes           []string       `json:"nodes"`
+	SnapshotVersion int64          `json:"snapshotVersion"`
+	Algorithm       string         `json:"algorithm"`
+	Policy          string         `json:"policy,omitempty"`       // 为Pod解析得到的调度策略名称
+	PolicySource    string         `json:"policySource,omitempty"` // 策略的来源，annotation/namespace/priorityClass/default/experiment
+	Arm             string         `json:"arm,omitempty"`          // Pod参与A/B实验时所在的分组
+	ConfigHash      string         `json:"configHash"`
+	LatencyUs       int64          `json:"latencyUs"`
+	Scores          []NodeDecision `json:"scores"`
+	Error           string         `json:"error,omitempty"`
+	Shadows         []ShadowResult `json:"shadows,omitempty"` // 影子策略的评分结果，不影响返回给调度器的结果
+}
+
+// NodeDecision 单个候选节点的原始指标和评分，Reason为节点缺少指标或者被过滤掉的原因
+type NodeDecision struct {
+	Host     string             `json:"host"`
+	Criteria map[string]float64 `json:"criteria"`
+	Score    int64              `json:"score"`
+	Reason   string             `json:"reason,omitempty"`
+}
+
+// DecisionFilter 审计记录查询条件，空值表示不过滤
+type DecisionFilter struct {
+	Pod       string
+	Namespace string
+	Node      string
+	Start     time.Time
+	End       time.Time
+	Limit     int
+}
+
+// Match 判断记录是否满足查询条件
+func (f *DecisionFilter) Match(d *Decision) bool {
+	if f.Pod != "" && f.Pod != d.PodName {
+		return false
+	}
+	if f.Namespace != "" && f.Namespace != d.Namespace {
+		return false
+	}
+	if !f.Start.IsZero() && d.Time.Before(f.Start) {
+		return false
+	}
+	if !f.End.IsZero() && d.Time.After(f.End) {
+		return false
+	}
+	if f.Node != "" {
+		for _, name := range d.Nodes {
+			if name == f.Node {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
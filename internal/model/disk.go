@@ -0,0 +1,32 @@
+package model
+
+// MByte 磁盘吞吐能力配置的单位，MB/s换算为B/s
+const MByte = 1024 * 1024
+
+// 节点标签，kube-scheduler传递节点对象时(nodeCacheCapable=false)用于确定节点的磁盘IO能力和网卡带宽
+const (
+	LabelDiskType       = "liang.io/disk-type"       // 磁盘类型，对应配置diskCapacity.types中的一项，如ssd/hdd
+	LabelDiskThroughput = "liang.io/disk-throughput" // 磁盘吞吐能力，单位MB/s
+	LabelDiskIOPS       = "liang.io/disk-iops"       // 磁盘每秒读写次数能力
+	LabelNICSpeed       = "liang.io/nic-speed"       // 网卡带宽，单位Mbps，也可以作为节点注解
+	LabelNICSpeedIn     = "liang.io/nic-speed-in"    // 下行带宽上限，单位Mbps，也可以作为节点注解
+	LabelNICSpeedOut    = "liang.io/nic-speed-out"   // 上行带宽上限，单位Mbps，也可以作为节点注解
+)
+
+// DiskCapacity 节点磁盘IO能力，0表示未知
+type DiskCapacity struct {
+	Throughput int64 `json:"throughput"` // 单位B/s
+	IOPS       int64 `json:"iops"`
+}
+
+// Merge 用other补全未知的能力，已知的能力保持不变
+func (c DiskCapacity) Merge(other DiskCapacity) DiskCapacity {
+	if c.Throughput <= 0 {
+		c.Throughput = other.Throughput
+	}
+	if c.IOPS <= 0 {
+		c.IOPS = other.IOPS
+	}
+
+	return c
+}
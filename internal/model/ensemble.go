@@ -0,0 +1,43 @@
+package model
+
+// 组合多个算法得分的方式
+const (
+	EnsembleWeighted      = "weighted"      // 按权重加权平均各算法的得分
+	EnsembleBorda         = "borda"         // 按权重平均各算法中的排名得分(Borda计数)
+	EnsembleLexicographic = "lexicographic" // 按算法的顺序比较得分，前面的算法得分相同时由后面的算法决定，忽略权重
+)
+
+// EnsembleComponent 组合评分中的一个算法，Algorithm为bnp/cmdn，Mode只对cmdn有效
+// Weight为空表示没有配置，此时权重为1；配置为0时该算法不参与加权
+type EnsembleComponent struct {
+	Algorithm string   `json:"algorithm"`
+	Mode      string   `json:"mode,omitempty"`
+	Weight    *float64 `json:"weight,omitempty"`
+}
+
+// GetWeight 算法的权重，没有配置时为1
+func (c EnsembleComponent) GetWeight() float64 {
+	if c.Weight == nil {
+		return 1
+	}
+
+	return *c.Weight
+}
+
+// EnsembleConfig 组合评分的配置，对应策略中的[policy.policies.<name>.ensemble]
+type EnsembleConfig struct {
+	Method     string              `json:"method"`
+	Components []EnsembleComponent `json:"components"`
+}
+
+// EnsembleExplanation 组合评分的中间结果，Components与配置中的算法一一对应
+type EnsembleExplanation struct {
+	Method     string                    `json:"method"`
+	Components []EnsembleComponentResult `json:"components"`
+}
+
+// EnsembleComponentResult 一个算法单独评分的完整计算过程
+type EnsembleComponentResult struct {
+	EnsembleComponent
+	Explanation *Explanation `json:"explanation"`
+}
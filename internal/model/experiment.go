@@ -0,0 +1,92 @@
+package model
+
+import "time"
+
+// A/B实验的分组
+const (
+	ArmControl   = "control"
+	ArmTreatment = "treatment"
+)
+
+// PolicySourceExperiment 由A/B实验分到实验组的Pod使用的策略的来源
+const PolicySourceExperiment = "experiment"
+
+// 实验跟踪的结果指标，LoadVarianceDelta和NodeLoadOffset的后缀为资源名称
+const (
+	OutcomeTimeToRunning     = "timeToRunningSeconds"
+	OutcomeRestarts          = "restarts"
+	OutcomeLoadVarianceDelta = "loadVarianceDelta"
+	OutcomeNodeLoadOffset    = "nodeLoadOffset"
+)
+
+// ExperimentOutcome 一个参与实验的Pod的结果，Running为Pod是否运行过，Deleted为Pod在运行之前被删除
+// 没有运行过的Pod被删除时计入Deleted，等待超过Timeout时计入NotRunning，都不计入结果指标
+// TimeToRunning为从绑定到节点到所有容器运行的秒数
+// LoadVarianceBefore为Pod第一次绑定到节点时各节点利用率的方差，LoadVarianceDelta为运行Window后的方差减去绑定时的方差，单位为百分比的平方
+// NodeLoadOffset为运行Window后Pod所在节点的利用率减去各节点利用率的均值，单位为百分比，两者都只与该Pod的调度有关
+type ExperimentOutcome struct {
+	PodUID             string             `json:"podUID"`
+	Namespace          string             `json:"namespace"`
+	Name               string             `json:"name"`
+	Arm                string             `json:"arm"`
+	Policy             string             `json:"policy"`
+	Node               string             `json:"node,omitempty"`
+	AssignedAt         time.Time          `json:"assignedAt"`
+	ScheduledAt        time.Time          `json:"scheduledAt"`
+	RunningAt          time.Time          `json:"runningAt"`
+	FinishedAt         time.Time          `json:"finishedAt"`
+	Running            bool               `json:"running"`
+	Deleted            bool               `json:"deleted"`
+	TimeToRunning      float64            `json:"timeToRunning"`
+	Restarts           int32              `json:"restarts"`
+	LoadVarianceBefore map[string]float64 `json:"loadVarianceBefore,omitempty"`
+	LoadVarianceDelta  map[string]float64 `json:"loadVarianceDelta,omitempty"`
+	NodeLoadOffset     map[string]float64 `json:"nodeLoadOffset,omitempty"`
+}
+
+// MetricStats 一个结果指标的样本统计，Lower和Upper为均值的置信区间，样本少于2个时与均值相同
+type MetricStats struct {
+	N      int     `json:"n"`
+	Mean   float64 `json:"mean"`
+	Stddev float64 `json:"stddev"`
+	Lower  float64 `json:"lower"`
+	Upper  float64 `json:"upper"`
+}
+
+// MetricDiff 实验组与对照组均值之差(treatment - control)及其Welch置信区间
+type MetricDiff struct {
+	Diff  float64 `json:"diff"`
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+}
+
+// ArmReport 一个分组的统计，Assigned为分到该组的Pod数量，Tracking为仍在跟踪的数量
+// NotRunning为等待超过Timeout仍没有运行的数量，Deleted为运行之前被删除的数量
+type ArmReport struct {
+	Arm        string                 `json:"arm"`
+	Policy     string                 `json:"policy"`
+	Assigned   int                    `json:"assigned"`
+	Tracking   int                    `json:"tracking"`
+	Completed  int                    `json:"completed"`
+	NotRunning int                    `json:"notRunning"`
+	Deleted    int                    `json:"deleted"`
+	Metrics    map[string]MetricStats `json:"metrics"`
+}
+
+// ExperimentPopulation 实验结果覆盖的Pod，只有经过Prioritize打分的Pod才会分组和跟踪
+// 只有一个可用节点时kube-scheduler不调用Prioritize，这类Pod和不使用该调度器的Pod都不在结果中
+const ExperimentPopulation = "prioritized pods only"
+
+// ExperimentReport A/B实验的统计结果，Difference只包含两组都至少有2个样本的指标
+// Population说明结果只覆盖经过Prioritize的Pod，Disabled为实验因为Pod informer不可用被关闭的原因
+type ExperimentReport struct {
+	Name       string                `json:"name"`
+	Enabled    bool                  `json:"enabled"`
+	Disabled   string                `json:"disabled,omitempty"`
+	Population string                `json:"population"`
+	HashBy     string                `json:"hashBy"`
+	Fraction   float64               `json:"fraction"`
+	Confidence float64               `json:"confidence"`
+	Arms       []ArmReport           `json:"arms"`
+	Difference map[string]MetricDiff `json:"difference"`
+}
@@ -0,0 +1,101 @@
+package model
+
+// NodeRejection 节点在过滤阶段被排除的原因
+type NodeRejection struct {
+	Host   string `json:"host"`
+	Reason string `json:"reason"`
+}
+
+// Explanation 一次评分的详细计算过程，用于调试
+type Explanation struct {
+	Algorithm       string               `json:"algorithm"`
+	Policy          *PolicyResolution    `json:"policy,omitempty"` // 为Pod解析得到的调度策略
+	SnapshotVersion int64                `json:"snapshotVersion"`
+	Forecast        string               `json:"forecast,omitempty"`      // 预测负载使用的模型，为空时使用最新的指标
+	LearnedDemand   *NetDemandEstimate   `json:"learnedDemand,omitempty"` // Pod没有网络需求注解时从控制器的历史负载学习得到的需求
+	CMDN            *CMDNExplanation     `json:"cmdn,omitempty"`
+	BNP             *BNPExplanation      `json:"bnp,omitempty"`
+	Balance         *BalanceExplanation  `json:"balance,omitempty"`
+	Ensemble        *EnsembleExplanation `json:"ensemble,omitempty"`
+	Missing         []MissingValue       `json:"missing"`
+	Scores          []HostScore          `json:"scores"`
+}
+
+// BalanceExplanation bnp多资源均衡评分的中间结果
+// Demand为Pod在各资源上的需求，单位与对应指标相同，CPU和内存为占节点的百分比
+type BalanceExplanation struct {
+	Mode     string                   `json:"mode"`
+	Weights  map[string]float64       `json:"weights"`
+	Demand   map[string]int64         `json:"demand"`
+	Nodes    []BalanceNodeExplanation `json:"nodes"`
+	Rejected []NodeRejection          `json:"rejected"`
+}
+
+// BalanceNodeExplanation 单个节点的多资源均衡计算结果
+// NewLoad为调度到该节点后该节点各资源的负载比例，Variance为调度到该节点后各资源集群负载的方差
+// Combined为按Mode合并后的方差，越小得分越高
+type BalanceNodeExplanation struct {
+	Host     string             `json:"host"`
+	NewLoad  map[string]float64 `json:"newLoad"`
+	Variance map[string]float64 `json:"variance"`
+	Combined float64            `json:"combined"`
+	Score    int64              `json:"score"`
+}
+
+// HostScore 节点最终得分，Reason为节点缺少指标或者被过滤掉的原因
+type HostScore struct {
+	Host   string `json:"host"`
+	Score  int64  `json:"score"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// CMDNExplanation CMDN算法TOPSIS计算的中间结果
+// 矩阵按行对应Nodes，按列对应Criteria
+type CMDNExplanation struct {
+	NetNeed             int64           `json:"netNeed"`
+	NetDemand           *NetDemand      `json:"netDemand,omitempty"`
+	NetStddev           float64         `json:"netStddev,omitempty"`
+	Nodes               []string        `json:"nodes"`
+	Criteria            []string        `json:"criteria"`
+	Matrix              [][]float64     `json:"matrix"`
+	Normalized          [][]float64     `json:"normalized"`
+	Weights             []float64       `json:"weights"`
+	Ideal               []float64       `json:"ideal"`
+	AntiIdeal           []float64       `json:"antiIdeal"`
+	DistanceToIdeal     []float64       `json:"distanceToIdeal"`
+	DistanceToAntiIdeal []float64       `json:"distanceToAntiIdeal"`
+	Closeness           []float64       `json:"closeness"`
+	TopsisMin           bool            `json:"topsisMin"`
+	Rejected            []NodeRejection `json:"rejected"`
+}
+
+// BNPExplanation BNP算法的中间结果，使用有效带宽模型时K不为0，NetStddev为Pod网络需求的标准差
+type BNPExplanation struct {
+	NetNeed   int64                `json:"netNeed"`
+	NetDemand *NetDemand           `json:"netDemand,omitempty"`
+	K         float64              `json:"k,omitempty"`
+	NetStddev float64              `json:"netStddev,omitempty"`
+	Nodes     []BNPNodeExplanation `json:"nodes"`
+	Rejected  []NodeRejection      `json:"rejected"`
+}
+
+// BNPNodeExplanation 单个节点的BNP计算结果
+// CurLoad/NewLoad为调度前后该节点的网络负载比例，Variance为调度到该节点后集群负载的方差
+// 分方向评分时前面的字段为下行方向，Out开头的字段为上行方向，得分按两个方向的方差之和计算
+// 使用有效带宽模型时Current为当前负载加上K倍节点负载的标准差Stddev，NewLoad为调度后的有效负载比例
+type BNPNodeExplanation struct {
+	Host        string  `json:"host"`
+	Current     float64 `json:"current"`
+	Stddev      float64 `json:"stddev,omitempty"`
+	Capacity    float64 `json:"capacity"`
+	CurLoad     float64 `json:"curLoad"`
+	NewLoad     float64 `json:"newLoad"`
+	Variance    float64 `json:"variance"`
+	OutCurrent  float64 `json:"outCurrent,omitempty"`
+	OutStddev   float64 `json:"outStddev,omitempty"`
+	OutCapacity float64 `json:"outCapacity,omitempty"`
+	OutCurLoad  float64 `json:"outCurLoad,omitempty"`
+	OutNewLoad  float64 `json:"outNewLoad,omitempty"`
+	OutVariance float64 `json:"outVariance,omitempty"`
+	Score       int64   `json:"score"`
+}
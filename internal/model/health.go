@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// ProbeReport 启动/存活/就绪探针的检查结果
+type ProbeReport struct {
+	OK         bool          `json:"ok"`
+	Reason     string        `json:"reason,omitempty"`
+	Metrics    []MetricState `json:"metrics,omitempty"`
+	Prometheus string        `json:"prometheus,omitempty"`
+}
+
+// MetricState 单个指标在本地缓存中的新鲜度
+type MetricState struct {
+	Key        string    `json:"key"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	AgeSeconds float64   `json:"ageSeconds"`
+	Fresh      bool      `json:"fresh"`
+}
@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// Sample 节点指标的一个历史数据
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value int64     `json:"value"`
+}
+
+// WindowStats 一个节点一个指标在时间窗口内的统计值，Slope为按最小二乘拟合的每秒变化量
+type WindowStats struct {
+	Count  int       `json:"count"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Mean   float64   `json:"mean"`
+	Stddev float64   `json:"stddev"`
+	Min    float64   `json:"min"`
+	Max    float64   `json:"max"`
+	P50    float64   `json:"p50"`
+	P90    float64   `json:"p90"`
+	P99    float64   `json:"p99"`
+	Slope  float64   `json:"slope"`
+}
+
+// MetricHistory 一个节点一个指标的历史数据及其统计值
+type MetricHistory struct {
+	Metric  string      `json:"metric"`
+	Node    string      `json:"node"`
+	Samples []Sample    `json:"samples"`
+	Stats   WindowStats `json:"stats"`
+}
@@ -0,0 +1,58 @@
+package model
+
+// 节点缺少某个指标时的处理策略
+const (
+	MissingExclude = "exclude" // 排除该节点，得分为0
+	MissingWorst   = "worst"   // 使用候选节点中最差的值
+	MissingMedian  = "median"  // 使用候选节点的中位数
+	MissingLast    = "last"    // 使用最近一次已知的值，没有则排除
+)
+
+// MissingPolicy 每个指标缺失时的处理策略，key为指标名，如LiangCPU
+type MissingPolicy map[string]string
+
+// DefaultMissingPolicy 没有配置的指标使用的策略
+// 网络负载缺失时无法判断节点是否放得下，默认排除；其他指标默认按最差值处理，避免节点看起来完全空闲
+func DefaultMissingPolicy() MissingPolicy {
+	return MissingPolicy{
+		ResourceNetIOKey:  MissingExclude,
+		ResourceCPUKey:    MissingWorst,
+		ResourceMemKey:    MissingWorst,
+		ResourceDiskIOKey: MissingWorst,
+	}
+}
+
+// Uses 是否有指标使用policy，没有配置的指标按默认策略
+func (p MissingPolicy) Uses(policy string) bool {
+	for key := range DefaultMissingPolicy() {
+		if p.Get(key) == policy {
+			return true
+		}
+	}
+	for _, v := range p {
+		if v == policy {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get 返回指标key的策略
+func (p MissingPolicy) Get(key string) string {
+	if v, ok := p[key]; ok {
+		return v
+	}
+
+	return DefaultMissingPolicy()[key]
+}
+
+// MissingValue 节点缺失的指标及其处理结果
+type MissingValue struct {
+	Host      string `json:"host"`
+	Criterion string `json:"criterion"`
+	Policy    string `json:"policy"`
+	Value     int64  `json:"value"`
+	Excluded  bool   `json:"excluded"`
+	Reason    string `json:"reason"`
+}
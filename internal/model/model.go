@@ -7,6 +7,25 @@ const (
 	ResourceDiskIOKey string = "LiangDiskIO"
 	ResourceCPUKey    string = "LiangCPU"
 	ResourceMemKey    string = "LiangMem"
+	ResourceNetCapKey string = "LiangNetCap"
+
+	// 分方向的网络负载，In为下行(接收)，Out为上行(发送)
+	// 作为Pod注解时为Pod的网络需求，单位Mbps，没有声明的方向使用LiangNetIO
+	ResourceNetInKey  string = "LiangNetIn"
+	ResourceNetOutKey string = "LiangNetOut"
+	// Pod网络需求的波动，单位Mbps，此时LiangNetIO为需求的均值，只在开启有效带宽模型时使用
+	ResourceNetIOPeakKey   string = "LiangNetIOPeak"
+	ResourceNetIOStddevKey string = "LiangNetIOStddev"
+	// 分方向的网卡带宽，只参与评分，不是同步的指标
+	ResourceNetInCapKey  string = "LiangNetInCap"
+	ResourceNetOutCapKey string = "LiangNetOutCap"
+
+	// 磁盘IOPS和磁盘IO能力，能力只参与计算磁盘使用率，不是同步的指标
+	ResourceDiskIOPSKey    string = "LiangDiskIOPS"
+	ResourceDiskCapKey     string = "LiangDiskCap"
+	ResourceDiskIOPSCapKey string = "LiangDiskIOPSCap"
+	// 按磁盘能力计算并处理缺失数据后的磁盘使用率，只在cmdn评分时加入快照，不是同步的指标
+	ResourceDiskUsageKey string = "LiangDiskUsage"
 
 	BaseBitPS = 1
 	KbitPS    = BaseBitPS * 1000
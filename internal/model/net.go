@@ -0,0 +1,48 @@
+package model
+
+import "time"
+
+// NetDemand Pod在两个方向上的网络需求，单位Kbit/s
+type NetDemand struct {
+	In  int64 `json:"in"`
+	Out int64 `json:"out"`
+}
+
+// NetCapacity 节点网卡在两个方向上的带宽，单位Kbit/s
+type NetCapacity struct {
+	In  int64 `json:"in"`
+	Out int64 `json:"out"`
+}
+
+// StochasticNet 有效带宽模型的输入，K为超载概率对应的标准正态分布分位数
+// Mean和Stddev为LiangNetIO/LiangNetIn/LiangNetOut每个节点最近一段时间网络负载的均值和标准差，单位Kbit/s
+type StochasticNet struct {
+	K      float64                         `json:"k"`
+	Mean   map[string](map[string]float64) `json:"mean,omitempty"`
+	Stddev map[string](map[string]float64) `json:"stddev"`
+}
+
+// 学习得到的网络需求作为注解加入评分使用的Pod，值为学习时使用的控制器
+const AnnotationNetDemandLearned = "liang.io/net-demand-learned-from"
+
+// PodOwner 管理Pod的控制器，同一控制器下的Pod网络需求相近
+type PodOwner struct {
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+}
+
+// String 格式为namespace/kind/name
+func (o PodOwner) String() string {
+	return o.Namespace + "/" + o.Kind + "/" + o.Name
+}
+
+// NetDemandEstimate 根据控制器下Pod的历史网络负载估计的网络需求，单位Kbit/s
+// Pods为参与估计的Pod个数，Confidence在[0, 1)之间，Pod越多、Pod之间的差异越小越高
+type NetDemandEstimate struct {
+	Owner      PodOwner  `json:"owner"`
+	Demand     NetDemand `json:"demand"`
+	Pods       int       `json:"pods"`
+	Confidence float64   `json:"confidence"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,50 @@
+package model
+
+// AnnotationPolicy Pod注解和命名空间标签中指定调度策略名称的key
+const AnnotationPolicy = "liang.io/policy"
+
+// 调度策略的来源，按优先级从高到低
+const (
+	PolicySourceAnnotation    = "annotation"
+	PolicySourceNamespace     = "namespace"
+	PolicySourcePriorityClass = "priorityClass"
+	PolicySourceDefault       = "default"
+)
+
+// PolicyCluster 没有配置默认策略时，按useBNP、topsisMin和[bnpBalance]得到的集群默认策略的名称
+const PolicyCluster = "cluster"
+
+// 调度策略的效果，balance时cmdn翻转TOPSIS得分，与topsisMin=true相同；compact时不翻转，优先选择负载高的节点
+const (
+	PolicyModeBalance = "balance"
+	PolicyModeCompact = "compact"
+)
+
+// Policy 一个调度策略，对应application.toml中的[policy.policies.<name>]
+// Algorithm为bnp/cmdn/ensemble，bnp只支持balance；Balance为bnp多资源均衡的权重，为空时使用[bnpBalance]
+// Algorithm为ensemble时按Ensemble组合多个算法的得分
+// CMDNWeights为cmdn中TOPSIS各指标的权重，key为决策矩阵的指标，没有配置的指标权重为1
+type Policy struct {
+	Algorithm   string             `json:"algorithm"`
+	Mode        string             `json:"mode"`
+	Balance     *BalanceConfig     `json:"balance,omitempty"`
+	Ensemble    *EnsembleConfig    `json:"ensemble,omitempty"`
+	CMDNWeights map[string]float64 `json:"cmdnWeights,omitempty"`
+}
+
+// PolicyResolution 为一个Pod解析得到的调度策略，Source为策略的来源，Balance为bnp实际使用的多资源均衡配置
+type PolicyResolution struct {
+	Name        string             `json:"name"`
+	Source      string             `json:"source"`
+	Algorithm   string             `json:"algorithm"`
+	Mode        string             `json:"mode"`
+	Balance     BalanceConfig      `json:"balance"`
+	Ensemble    *EnsembleConfig    `json:"ensemble,omitempty"`
+	CMDNWeights map[string]float64 `json:"cmdnWeights,omitempty"` // cmdn中TOPSIS各指标的权重，为空时都为1
+	Arm         string             `json:"arm,omitempty"`         // Pod参与A/B实验时所在的分组
+}
+
+// TopsisMin 是否翻转cmdn得分
+func (p *PolicyResolution) TopsisMin() bool {
+	return p.Mode == PolicyModeBalance
+}
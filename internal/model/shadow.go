@@ -0,0 +1,46 @@
+package model
+
+import "time"
+
+// PolicySourceShadow 影子评分使用的策略的来源
+const PolicySourceShadow = "shadow"
+
+// ShadowResult 一次Prioritize中一个影子策略的评分结果，以及与线上结果排序的差异
+// Top1Agree为两者得分最高的节点是否相同，KendallTau为两者排序的Kendall tau-b，1表示完全一致，-1表示完全相反
+// 线上或影子评分失败时Error不为空，不计算差异
+type ShadowResult struct {
+	Policy     string      `json:"policy"`
+	Algorithm  string      `json:"algorithm"`
+	Scores     []HostScore `json:"scores"`
+	Top1Agree  bool        `json:"top1Agree"`
+	KendallTau float64     `json:"kendallTau"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// ShadowBucket 一个时间段内影子策略与线上结果的差异汇总，Count为成功比较的次数，Dropped为后台队列满时丢弃的比较次数
+// 比较按Prioritize的时间计入时间段
+type ShadowBucket struct {
+	Start          time.Time `json:"start"`
+	Count          int       `json:"count"`
+	Errors         int       `json:"errors"`
+	Dropped        int       `json:"dropped"`
+	Top1Agreement  float64   `json:"top1Agreement"` // top1相同的比例
+	MeanKendallTau float64   `json:"meanKendallTau"`
+	MinKendallTau  float64   `json:"minKendallTau"`
+}
+
+// ShadowPolicyReport 一个影子策略的差异汇总，Algorithm为策略当前使用的算法
+// Total为保留的全部时间段的汇总，Buckets按时间从旧到新
+type ShadowPolicyReport struct {
+	Policy    string         `json:"policy"`
+	Algorithm string         `json:"algorithm"`
+	Total     ShadowBucket   `json:"total"`
+	Buckets   []ShadowBucket `json:"buckets"`
+}
+
+// ShadowReport 全部影子策略的差异汇总，Bucket为每个时间段的长度，Dropped为后台队列满时丢弃的影子评分次数
+type ShadowReport struct {
+	Bucket   string               `json:"bucket"`
+	Dropped  int64                `json:"dropped"`
+	Policies []ShadowPolicyReport `json:"policies"`
+}
@@ -0,0 +1,211 @@
+package recommend
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"liang/internal/model"
+)
+
+// 注解与观测值比较的结果
+const (
+	StatusOK      = "ok"      // 声明的需求与观测值相符
+	StatusMissing = "missing" // 没有声明需求
+	StatusUnder   = "under"   // 声明的需求明显低于观测值
+	StatusOver    = "over"    // 声明的需求明显高于观测值
+	StatusInvalid = "invalid" // 注解不是数字
+)
+
+// resources 推荐的注解，Unit为注解单位换算为查询结果单位的倍数
+var resources = []struct {
+	Key  string
+	Unit float64
+	Name string
+}{
+	{Key: model.ResourceNetIOKey, Unit: model.KbitPS, Name: "Mbps"},
+	{Key: model.ResourceDiskIOKey, Unit: model.MByte, Name: "MB/s"},
+}
+
+// Source 推荐使用的历史数据，dao.Dao实现了该接口
+type Source interface {
+	RequestPromWorkloadUsage(resource string, window time.Duration, quantile float64) (map[model.PodOwner]map[string]float64, error)
+	RequestPromWorkloadAnnotations(keys []string) (map[model.PodOwner]map[string]string, error)
+}
+
+// Options 推荐的参数
+// 声明的需求低于观测值的UnderRatio倍时为under，高于观测值的OverRatio倍时为over，观测值不足1个单位时按1计算
+type Options struct {
+	Window     time.Duration // 查询历史负载的时间范围
+	Percentile float64       // 每个Pod在Window内负载的百分位数，如95
+	UnderRatio float64
+	OverRatio  float64
+	Namespace  string // 只分析该命名空间的工作负载，为空时分析全部
+}
+
+// DefaultOptions 默认分析最近7天的P95负载
+func DefaultOptions() Options {
+	return Options{
+		Window:     7 * 24 * time.Hour,
+		Percentile: 95,
+		UnderRatio: 0.5,
+		OverRatio:  2,
+	}
+}
+
+// Validate 检查时间范围、百分位数和比例
+func (opts Options) Validate() error {
+	if opts.Window <= 0 {
+		return fmt.Errorf("window should be positive")
+	}
+	if opts.Percentile <= 0 || opts.Percentile > 100 {
+		return fmt.Errorf("percentile %v should be in (0, 100]", opts.Percentile)
+	}
+	if opts.UnderRatio <= 0 || opts.UnderRatio > 1 || opts.OverRatio < 1 {
+		return fmt.Errorf("under ratio %v should be in (0, 1] and over ratio %v should not be less than 1", opts.UnderRatio, opts.OverRatio)
+	}
+
+	return nil
+}
+
+// Resource 一个注解的推荐结果，Observed为所有Pod中负载最大的Pod的百分位数，单位与注解相同
+type Resource struct {
+	Key         string  `json:"key"`
+	Unit        string  `json:"unit"`
+	Observed    float64 `json:"observed"`
+	Recommended int64   `json:"recommended"`
+	Declared    string  `json:"declared,omitempty"`
+	Status      string  `json:"status"`
+}
+
+// Workload 一个Deployment或StatefulSet的推荐结果，Patch为需要修改注解时的strategic merge patch
+type Workload struct {
+	Workload  model.PodOwner `json:"workload"`
+	Pods      int            `json:"pods"`
+	Resources []Resource     `json:"resources"`
+	Patch     string         `json:"patch,omitempty"`
+}
+
+// Report 全部工作负载的推荐结果，按命名空间、类型和名称排序
+type Report struct {
+	Window     string      `json:"window"`
+	Percentile float64     `json:"percentile"`
+	Workloads  []*Workload `json:"workloads"`
+}
+
+// Analyze 从src查询历史负载和当前的注解，为每个工作负载推荐注解
+func Analyze(src Source, opts Options) (*Report, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(resources))
+	usage := make([]map[model.PodOwner]map[string]float64, len(resources))
+	for i, r := range resources {
+		keys[i] = r.Key
+		var err error
+		if usage[i], err = src.RequestPromWorkloadUsage(r.Key, opts.Window, opts.Percentile/100); err != nil {
+			return nil, fmt.Errorf("query %s usage error: %v", r.Key, err)
+		}
+	}
+	annotations, err := src.RequestPromWorkloadAnnotations(keys)
+	if err != nil {
+		return nil, fmt.Errorf("query annotations error: %v", err)
+	}
+
+	return recommend(usage, annotations, opts), nil
+}
+
+// recommend usage与resources一一对应，只有观测到负载的工作负载出现在结果中
+func recommend(usage []map[model.PodOwner]map[string]float64, annotations map[model.PodOwner]map[string]string, opts Options) *Report {
+	pods := make(map[model.PodOwner]map[string]bool)
+	for _, u := range usage {
+		for owner, values := range u {
+			if opts.Namespace != "" && owner.Namespace != opts.Namespace {
+				continue
+			}
+			if pods[owner] == nil {
+				pods[owner] = make(map[string]bool)
+			}
+			for pod := range values {
+				pods[owner][pod] = true
+			}
+		}
+	}
+
+	report := &Report{
+		Window:     opts.Window.String(),
+		Percentile: opts.Percentile,
+		Workloads:  make([]*Workload, 0, len(pods)),
+	}
+	for owner, names := range pods {
+		w := &Workload{Workload: owner, Pods: len(names), Resources: make([]Resource, len(resources))}
+		patch := make(map[string]string)
+		for i, r := range resources {
+			observed := 0.0
+			for _, v := range usage[i][owner] {
+				observed = math.Max(observed, v/r.Unit)
+			}
+			res := Resource{
+				Key:         r.Key,
+				Unit:        r.Name,
+				Observed:    math.Round(observed*100) / 100,
+				Recommended: int64(math.Ceil(observed)),
+				Status:      StatusMissing,
+			}
+			if v, ok := annotations[owner][r.Key]; ok {
+				res.Declared = v
+				res.Status = compare(v, observed, opts)
+			}
+			// 没有负载也没有声明时不需要注解
+			if res.Status != StatusOK && !(res.Status == StatusMissing && res.Recommended == 0) {
+				patch[r.Key] = strconv.FormatInt(res.Recommended, 10)
+			}
+			w.Resources[i] = res
+		}
+		if len(patch) > 0 {
+			w.Patch = formatPatch(patch)
+		}
+		report.Workloads = append(report.Workloads, w)
+	}
+	sort.Slice(report.Workloads, func(i, j int) bool {
+		return report.Workloads[i].Workload.String() < report.Workloads[j].Workload.String()
+	})
+
+	return report
+}
+
+// compare 比较声明的需求和观测值
+func compare(declared string, observed float64, opts Options) string {
+	v, err := strconv.ParseFloat(declared, 64)
+	if err != nil || v < 0 {
+		return StatusInvalid
+	}
+	if v < observed*opts.UnderRatio {
+		return StatusUnder
+	}
+	if v > math.Max(observed, 1)*opts.OverRatio {
+		return StatusOver
+	}
+
+	return StatusOK
+}
+
+// formatPatch 生成修改Pod模板注解的strategic merge patch，可以直接用于kubectl patch
+// 注解的值都是数字，按key排序后输出，不需要yaml库
+func formatPatch(annotations map[string]string) string {
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	patch := "spec:\n  template:\n    metadata:\n      annotations:\n"
+	for _, key := range keys {
+		patch += fmt.Sprintf("        %s: %q\n", key, annotations[key])
+	}
+
+	return patch
+}
@@ -0,0 +1,130 @@
+package recommend
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"liang/internal/model"
+)
+
+// fakeSource 按注解返回固定的负载，查询结果的单位与dao相同
+type fakeSource struct {
+	usage       map[string]map[model.PodOwner]map[string]float64
+	annotations map[model.PodOwner]map[string]string
+	err         error
+}
+
+func (f *fakeSource) RequestPromWorkloadUsage(resource string, window time.Duration, quantile float64) (map[model.PodOwner]map[string]float64, error) {
+	return f.usage[resource], f.err
+}
+
+func (f *fakeSource) RequestPromWorkloadAnnotations(keys []string) (map[model.PodOwner]map[string]string, error) {
+	return f.annotations, f.err
+}
+
+func TestAnalyze(t *testing.T) {
+	web := model.PodOwner{Namespace: "default", Kind: "Deployment", Name: "web"}
+	db := model.PodOwner{Namespace: "default", Kind: "StatefulSet", Name: "db"}
+	batch := model.PodOwner{Namespace: "batch", Kind: "Deployment", Name: "etl"}
+	src := &fakeSource{
+		usage: map[string]map[model.PodOwner]map[string]float64{
+			// web的两个Pod中较大的为40.2Mbps，db为0.3Mbps，etl为120Mbps
+			model.ResourceNetIOKey: {
+				web:   {"web-1": 30000, "web-2": 40200},
+				db:    {"db-0": 300},
+				batch: {"etl-1": 120000},
+			},
+			model.ResourceDiskIOKey: {
+				web: {"web-1": 0},
+				db:  {"db-0": 50 * model.MByte},
+			},
+		},
+		annotations: map[model.PodOwner]map[string]string{
+			web:   {model.ResourceNetIOKey: "50"},
+			db:    {model.ResourceNetIOKey: "10", model.ResourceDiskIOKey: "10"},
+			batch: {model.ResourceNetIOKey: "fast"},
+		},
+	}
+
+	report, err := Analyze(src, DefaultOptions())
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	expected := map[string][2]string{
+		batch.String(): {StatusInvalid, StatusMissing},
+		db.String():    {StatusOver, StatusUnder},
+		web.String():   {StatusOK, StatusMissing},
+	}
+	if len(report.Workloads) != len(expected) {
+		t.Fatalf("should analyse %d workloads, but get %d", len(expected), len(report.Workloads))
+	}
+	for _, w := range report.Workloads {
+		status := [2]string{w.Resources[0].Status, w.Resources[1].Status}
+		if status != expected[w.Workload.String()] {
+			t.Errorf("status of %s should be %v, but get %v", w.Workload, expected[w.Workload.String()], status)
+		}
+	}
+	if names := []string{report.Workloads[0].Workload.Name, report.Workloads[1].Workload.Name}; names[0] != "etl" || names[1] != "web" {
+		t.Errorf("workloads should be sorted by namespace, kind and name, but get %v", names)
+	}
+
+	// web的网络需求相符，磁盘没有负载，不需要patch
+	if w := report.Workloads[1]; w.Pods != 2 || w.Resources[0].Observed != 40.2 || w.Resources[0].Recommended != 41 || w.Patch != "" {
+		t.Errorf("recommendation of web is wrong: %+v", w)
+	}
+	dbPatch := "spec:\n  template:\n    metadata:\n      annotations:\n        LiangDiskIO: \"50\"\n        LiangNetIO: \"1\"\n"
+	if p := report.Workloads[2].Patch; p != dbPatch {
+		t.Errorf("patch of db should be\n%s, but get\n%s", dbPatch, p)
+	}
+
+	opts := DefaultOptions()
+	opts.Namespace = "batch"
+	if report, err = Analyze(src, opts); err != nil || len(report.Workloads) != 1 {
+		t.Errorf("only workloads in namespace batch should be analysed, but get %v %v", report, err)
+	}
+
+	src.err = fmt.Errorf("prometheus is unavailable")
+	if _, err = Analyze(src, DefaultOptions()); err == nil {
+		t.Errorf("analyze should fail when prometheus is unavailable")
+	}
+	opts.Percentile = 0
+	if _, err = Analyze(src, opts); err == nil {
+		t.Errorf("percentile 0 should be invalid")
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	report := &Report{
+		Window:     "168h0m0s",
+		Percentile: 95,
+		Workloads: []*Workload{
+			{Workload: model.PodOwner{Namespace: "default", Kind: "StatefulSet", Name: "db"}, Pods: 1,
+				Resources: []Resource{{Key: model.ResourceNetIOKey, Unit: "Mbps", Recommended: 1, Status: StatusMissing}},
+				Patch:     formatPatch(map[string]string{model.ResourceNetIOKey: "1"})},
+			{Workload: model.PodOwner{Namespace: "default", Kind: "Deployment", Name: "web"}, Pods: 2,
+				Resources: []Resource{{Key: model.ResourceNetIOKey, Unit: "Mbps", Recommended: 41, Declared: "50", Status: StatusOK}}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, "yaml", report); err != nil {
+		t.Fatalf("write yaml error: %v", err)
+	}
+	expected := "---\n# kubectl -n default patch statefulset db --patch-file <this document>\n" +
+		"spec:\n  template:\n    metadata:\n      annotations:\n        LiangNetIO: \"1\"\n"
+	if buf.String() != expected {
+		t.Errorf("yaml should be\n%s, but get\n%s", expected, buf.String())
+	}
+
+	for _, format := range []string{"table", "json"} {
+		buf.Reset()
+		if err := WriteReport(&buf, format, report); err != nil || buf.Len() == 0 {
+			t.Errorf("write %s error: %v", format, err)
+		}
+	}
+	if err := WriteReport(&buf, "csv", report); err == nil {
+		t.Errorf("csv should not be supported")
+	}
+}
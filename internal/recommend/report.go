@@ -0,0 +1,64 @@
+package recommend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// WriteReport 按format输出推荐结果，支持table/json/yaml
+// table每行一个工作负载的一个注解，yaml只输出需要修改注解的工作负载的patch，每个patch为一个yaml文档
+func WriteReport(w io.Writer, format string, report *Report) error {
+	switch format {
+	case "table":
+		return writeTable(w, report)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "yaml":
+		return writePatches(w, report)
+	}
+
+	return fmt.Errorf("unknown report format %s, should be table/json/yaml", format)
+}
+
+func writeTable(w io.Writer, report *Report) error {
+	fmt.Fprintf(w, "analysed %d workloads with p%v over %s\n", len(report.Workloads), report.Percentile, report.Window)
+	if len(report.Workloads) == 0 {
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKLOAD\tPODS\tANNOTATION\tOBSERVED\tRECOMMENDED\tDECLARED\tSTATUS")
+	for _, wl := range report.Workloads {
+		for _, r := range wl.Resources {
+			declared := r.Declared
+			if declared == "" {
+				declared = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%v %s\t%d\t%s\t%s\n",
+				wl.Workload, wl.Pods, r.Key, r.Observed, r.Unit, r.Recommended, declared, r.Status)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// writePatches 每个patch前的注释为应用该patch的kubectl命令
+func writePatches(w io.Writer, report *Report) error {
+	for _, wl := range report.Workloads {
+		if wl.Patch == "" {
+			continue
+		}
+		_, err := fmt.Fprintf(w, "---\n# kubectl -n %s patch %s %s --patch-file <this document>\n%s",
+			wl.Workload.Namespace, strings.ToLower(wl.Workload.Kind), wl.Workload.Name, wl.Patch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
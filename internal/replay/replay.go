@@ -0,0 +1,199 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"liang/internal/model"
+	"liang/internal/service"
+
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// Options 回放时覆盖录制时的配置，零值表示使用录制时的配置
+type Options struct {
+	Algorithm string           // bnp/cmdn/ensemble，ensemble只能回放录制时带有组合配置的记录
+	TopsisMin *bool            // cmdn得分是否翻转
+	NetBwMap  map[string]int64 // 网卡带宽，单位Kbit/s
+}
+
+// Diff 一次录制请求回放前后的排名
+type Diff struct {
+	Time         time.Time        `json:"time"`
+	Pod          string           `json:"pod"`
+	Algorithm    string           `json:"algorithm"`
+	Before       []string         `json:"before"`
+	After        []string         `json:"after"`
+	BeforeScores map[string]int64 `json:"beforeScores"`
+	AfterScores  map[string]int64 `json:"afterScores"`
+	TopChanged   bool             `json:"topChanged"`
+	BeforeError  string           `json:"beforeError,omitempty"`
+	AfterError   string           `json:"afterError,omitempty"`
+}
+
+// Report 回放结果，Diffs只包含排名发生变化的请求
+type Report struct {
+	Total      int    `json:"total"`
+	Changed    int    `json:"changed"`
+	TopChanged int    `json:"topChanged"`
+	Diffs      []Diff `json:"diffs"`
+}
+
+// LoadCaptures 读取录制文件，每行一条JSON记录，按文件顺序返回
+func LoadCaptures(paths ...string) ([]*model.Capture, error) {
+	res := make([]*model.Capture, 0)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			c := new(model.Capture)
+			if err = json.Unmarshal(scanner.Bytes(), c); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s line %d: %v", path, line, err)
+			}
+			res = append(res, c)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// Replay 使用当前的评分算法和录制的指标快照重新评分，报告排名发生变化的请求
+func Replay(captures []*model.Capture, opts Options) *Report {
+	report := &Report{Diffs: make([]Diff, 0)}
+	for _, c := range captures {
+		report.Total++
+		diff, changed := replayOne(c, opts)
+		if !changed {
+			continue
+		}
+		report.Changed++
+		if diff.TopChanged {
+			report.TopChanged++
+		}
+		report.Diffs = append(report.Diffs, diff)
+	}
+
+	return report
+}
+
+func replayOne(c *model.Capture, opts Options) (Diff, bool) {
+	algo, topsisMin, netBwMap := c.Algorithm, c.TopsisMin, c.NetBwMap
+	if opts.Algorithm != "" {
+		algo = opts.Algorithm
+	}
+	if opts.TopsisMin != nil {
+		topsisMin = *opts.TopsisMin
+	}
+	if opts.NetBwMap != nil {
+		netBwMap = opts.NetBwMap
+	}
+
+	diff := Diff{
+		Time:         c.Time,
+		Algorithm:    algo,
+		BeforeScores: scoreMap(c.Result),
+		BeforeError:  c.Error,
+	}
+	diff.Before = ranking(c.Result)
+
+	var nodeNames []string
+	if c.Args != nil {
+		if c.Args.Pod != nil {
+			diff.Pod = c.Args.Pod.Namespace + "/" + c.Args.Pod.Name
+		}
+		if c.Args.NodeNames != nil {
+			nodeNames = *c.Args.NodeNames
+		}
+	}
+	if c.Args == nil || c.Args.Pod == nil {
+		diff.AfterError = "capture has no pod"
+		return diff, true
+	}
+	// 录制时不是ensemble的记录没有组合配置，不能悄悄换成其它算法回放
+	if algo == model.AlgoEnsemble && c.Ensemble == nil {
+		diff.AfterError = fmt.Sprintf("capture scored by %s has no ensemble config to replay", c.Algorithm)
+		return diff, true
+	}
+
+	res, _, err := service.ScoreSnapshot(&service.ScoreInput{
+		Algorithm:     algo,
+		TopsisMin:     topsisMin,
+		MissingPolicy: c.MissingPolicy,
+		Balance:       c.Balance,
+		Pod:           c.Args.Pod,
+		NodeNames:     nodeNames,
+		NetBwMap:      netBwMap,
+		NetCapMap:     c.NetCapMap,
+		DiskCapMap:    c.DiskCapMap,
+		Snapshot:      c.Snapshot,
+		LastKnown:     c.LastKnown,
+		Stochastic:    c.Stochastic,
+		Ensemble:      c.Ensemble,
+		CMDNWeights:   c.CMDNWeights,
+	})
+	if err != nil {
+		diff.AfterError = err.Error()
+	}
+	diff.AfterScores = scoreMap(res)
+	diff.After = ranking(res)
+
+	if diff.BeforeError != "" || diff.AfterError != "" {
+		return diff, diff.BeforeError != diff.AfterError
+	}
+	diff.TopChanged = len(diff.Before) > 0 && len(diff.After) > 0 && diff.Before[0] != diff.After[0]
+	if diff.TopChanged || len(diff.Before) != len(diff.After) {
+		return diff, true
+	}
+	for i := range diff.Before {
+		if diff.Before[i] != diff.After[i] {
+			return diff, true
+		}
+	}
+
+	return diff, false
+}
+
+// ranking 按得分从高到低排列节点，得分相同时按节点名排序
+func ranking(res extenderv1.HostPriorityList) []string {
+	sorted := make(extenderv1.HostPriorityList, len(res))
+	copy(sorted, res)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Score != sorted[j].Score {
+			return sorted[i].Score > sorted[j].Score
+		}
+		return sorted[i].Host < sorted[j].Host
+	})
+
+	names := make([]string, len(sorted))
+	for i, hp := range sorted {
+		names[i] = hp.Host
+	}
+
+	return names
+}
+
+func scoreMap(res extenderv1.HostPriorityList) map[string]int64 {
+	scores := make(map[string]int64, len(res))
+	for _, hp := range res {
+		scores[hp.Host] = hp.Score
+	}
+
+	return scores
+}
@@ -0,0 +1,165 @@
+package replay
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"liang/internal/model"
+	"liang/internal/service"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// newCapture 按照在线评分的方式生成一条录制记录
+func newCapture(t *testing.T, algo string, topsisMin bool) *model.Capture {
+	nodeNames := []string{"node1", "node2", "node3"}
+	args := &extenderv1.ExtenderArgs{
+		Pod: &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "nginx-0",
+				Namespace: "default",
+				Annotations: map[string]string{
+					model.ResourceNetIOKey: "100",
+				},
+			},
+		},
+		NodeNames: &nodeNames,
+	}
+	netBwMap := map[string]int64{"node1": 1000 * model.KbitPS, "node2": 1500 * model.KbitPS, "node3": 2500 * model.KbitPS}
+	snapshot := map[string](map[string]int64){
+		model.ResourceNetIOKey:  {"node1": 100 * model.KbitPS, "node2": 600 * model.KbitPS, "node3": 1200 * model.KbitPS},
+		model.ResourceCPUKey:    {"node1": 20, "node2": 40, "node3": 60},
+		model.ResourceMemKey:    {"node1": 30, "node2": 50, "node3": 70},
+		model.ResourceDiskIOKey: {"node1": 1024, "node2": 2048, "node3": 4096},
+	}
+
+	res, _, err := service.ScoreSnapshot(&service.ScoreInput{
+		Algorithm: algo,
+		TopsisMin: topsisMin,
+		Pod:       args.Pod,
+		NodeNames: nodeNames,
+		NetBwMap:  netBwMap,
+		Snapshot:  snapshot,
+	})
+	if err != nil {
+		t.Fatalf("score snapshot error: %v", err)
+	}
+
+	return &model.Capture{
+		Time:      time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC),
+		Args:      args,
+		Algorithm: algo,
+		TopsisMin: topsisMin,
+		NetBwMap:  netBwMap,
+		Snapshot:  snapshot,
+		Result:    res,
+	}
+}
+
+func TestReplay_Unchanged(t *testing.T) {
+	captures := []*model.Capture{
+		newCapture(t, model.AlgoBNP, false),
+		newCapture(t, model.AlgoCMDN, false),
+		newCapture(t, model.AlgoCMDN, true),
+	}
+
+	report := Replay(captures, Options{})
+	if report.Total != 3 || report.Changed != 0 {
+		t.Errorf("replay with captured config should not change rankings, but get %+v", report)
+	}
+}
+
+func TestReplay_Changed(t *testing.T) {
+	captures := []*model.Capture{newCapture(t, model.AlgoCMDN, false)}
+
+	topsisMin := true
+	report := Replay(captures, Options{TopsisMin: &topsisMin})
+	if report.Changed != 1 || report.TopChanged != 1 {
+		t.Fatalf("flip topsis should change the ranking, but get %+v", report)
+	}
+	diff := report.Diffs[0]
+	if diff.Pod != "default/nginx-0" {
+		t.Errorf("pod should be default/nginx-0, but get %s", diff.Pod)
+	}
+	reversed := make([]string, len(diff.Before))
+	for i, name := range diff.Before {
+		reversed[len(reversed)-1-i] = name
+	}
+	if !reflect.DeepEqual(diff.After, reversed) {
+		t.Errorf("ranking should be reversed, before %v after %v", diff.Before, diff.After)
+	}
+
+	// cmdn的录制缺少bnp需要的快照时也可以回放
+	report = Replay(captures, Options{Algorithm: model.AlgoBNP})
+	if report.Total != 1 || len(report.Diffs) != report.Changed {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// 没有组合配置的录制不能按ensemble回放
+	report = Replay(captures, Options{Algorithm: model.AlgoEnsemble})
+	if report.Changed != 1 || report.Diffs[0].AfterError == "" {
+		t.Errorf("replay without ensemble config should report an error, but get %+v", report)
+	}
+}
+
+func TestReplay_Ensemble(t *testing.T) {
+	c := newCapture(t, model.AlgoCMDN, false)
+	c.Algorithm = model.AlgoEnsemble
+	c.Ensemble = &model.EnsembleConfig{
+		Method: model.EnsembleWeighted,
+		Components: []model.EnsembleComponent{
+			{Algorithm: model.AlgoBNP, Mode: model.PolicyModeBalance},
+			{Algorithm: model.AlgoCMDN, Mode: model.PolicyModeBalance},
+		},
+	}
+	res, _, err := service.ScoreSnapshot(&service.ScoreInput{
+		Algorithm: c.Algorithm,
+		Pod:       c.Args.Pod,
+		NodeNames: *c.Args.NodeNames,
+		NetBwMap:  c.NetBwMap,
+		Snapshot:  c.Snapshot,
+		Ensemble:  c.Ensemble,
+	})
+	if err != nil {
+		t.Fatalf("score snapshot error: %v", err)
+	}
+	c.Result = res
+
+	for _, algo := range []string{"", model.AlgoEnsemble} {
+		if report := Replay([]*model.Capture{c}, Options{Algorithm: algo}); report.Changed != 0 {
+			t.Errorf("replay ensemble capture with algorithm %q should not change rankings, but get %+v", algo, report)
+		}
+	}
+}
+
+func TestLoadCaptures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.log")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create capture file error: %v", err)
+	}
+	enc := json.NewEncoder(f)
+	for _, algo := range []string{model.AlgoBNP, model.AlgoCMDN} {
+		if err = enc.Encode(newCapture(t, algo, false)); err != nil {
+			t.Fatalf("encode capture error: %v", err)
+		}
+	}
+	f.Close()
+
+	captures, err := LoadCaptures(path)
+	if err != nil {
+		t.Fatalf("load captures error: %v", err)
+	}
+	if len(captures) != 2 || captures[1].Algorithm != model.AlgoCMDN {
+		t.Fatalf("unexpected captures %+v", captures)
+	}
+	if report := Replay(captures, Options{}); report.Changed != 0 {
+		t.Errorf("replay of loaded captures should not change rankings, but get %+v", report)
+	}
+}
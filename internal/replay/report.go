@@ -0,0 +1,55 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// WriteReport 按format输出回放结果，支持table/json
+// table每行一个排名变化的请求，分数格式为host:score
+func WriteReport(w io.Writer, format string, report *Report) error {
+	switch format {
+	case "table":
+		return writeTable(w, report)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	return fmt.Errorf("unknown report format %s, should be table/json", format)
+}
+
+func writeTable(w io.Writer, report *Report) error {
+	fmt.Fprintf(w, "replayed %d requests, %d rankings changed, %d top nodes changed\n",
+		report.Total, report.Changed, report.TopChanged)
+	if len(report.Diffs) == 0 {
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tPOD\tALGORITHM\tTOP_CHANGED\tBEFORE\tAFTER")
+	for _, d := range report.Diffs {
+		before := formatRanking(d.Before, d.BeforeScores, d.BeforeError)
+		after := formatRanking(d.After, d.AfterScores, d.AfterError)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%s\t%s\n",
+			d.Time.Format("2006-01-02T15:04:05Z07:00"), d.Pod, d.Algorithm, d.TopChanged, before, after)
+	}
+
+	return tw.Flush()
+}
+
+func formatRanking(names []string, scores map[string]int64, errMsg string) string {
+	if errMsg != "" {
+		return "error: " + errMsg
+	}
+	items := make([]string, len(names))
+	for i, name := range names {
+		items[i] = fmt.Sprintf("%s:%d", name, scores[name])
+	}
+
+	return strings.Join(items, ",")
+}
@@ -1,10 +1,15 @@
 package http
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"liang/internal/model"
+	"liang/internal/recommend"
 	"liang/internal/service"
 
 	"github.com/go-kratos/kratos/pkg/conf/paladin"
@@ -38,13 +43,24 @@ func New(s *service.Service) (engine *bm.Engine, err error) {
 
 func initRouter(e *bm.Engine) {
 	e.Ping(ping)
+	e.GET("/healthz/startup", startupProbe)
+	e.GET("/healthz/live", livenessProbe)
+	e.GET("/healthz/ready", readinessProbe)
 	g := e.Group("/v1")
 	{
 		g.GET("/start", howToStart)
 		g.POST("/prioritizeVerb", Prioritize)
+		g.POST("/explain", Explain)
 		g.GET("/test/default", PromDemo)
 		g.GET("/test/prom", RequestPromInfo)
 		g.GET("/test/cache", QueryAllCache)
+		g.GET("/decisions", QueryDecisions)
+		g.GET("/debug/history", QueryMetricHistory)
+		g.GET("/debug/netdemand", QueryNetDemand)
+		g.GET("/debug/algorithm", QueryAutoAlgorithm)
+		g.GET("/debug/shadow", QueryShadowReport)
+		g.GET("/debug/experiment", QueryExperimentReport)
+		g.GET("/recommend", Recommend)
 	}
 }
 
@@ -55,6 +71,28 @@ func ping(ctx *bm.Context) {
 	}
 }
 
+func startupProbe(c *bm.Context) {
+	writeProbe(c, svc.Startup())
+}
+
+func livenessProbe(c *bm.Context) {
+	writeProbe(c, svc.Liveness())
+}
+
+func readinessProbe(c *bm.Context) {
+	writeProbe(c, svc.Readiness(c))
+}
+
+// writeProbe 探针通过返回200，否则返回503
+func writeProbe(c *bm.Context, report *model.ProbeReport) {
+	code := http.StatusOK
+	if !report.OK {
+		code = http.StatusServiceUnavailable
+	}
+	bb, _ := json.Marshal(report)
+	c.Bytes(code, "application/json; charset=utf-8", bb)
+}
+
 // example for http request handler.
 func howToStart(c *bm.Context) {
 	k := &model.Kratos{
@@ -63,28 +101,40 @@ func howToStart(c *bm.Context) {
 	c.JSON(k, nil)
 }
 
-// Prioritize 根据Pod对Nodes评分
-func Prioritize(c *bm.Context) {
+// bindExtenderArgs 解析请求中的ExtenderArgs，NodeNames为空时从Nodes中获取
+func bindExtenderArgs(c *bm.Context) (*extenderv1.ExtenderArgs, error) {
 	var args extenderv1.ExtenderArgs
 	// BindWith will process error
 	if err := c.BindWith(&args, binding.JSON); err != nil {
-		return
+		return nil, err
 	}
 
 	// print args info
 	jres, _ := json.Marshal(args)
-	log.V(7).Info("http Prioritize api - args is: \n%s", string(jres))
+	log.V(7).Info("http %s api - args is: \n%s", c.Request.URL.Path, string(jres))
 
 	// check args nodeNames, it may be nil
 	if args.NodeNames == nil {
-		nodeNames := make([]string, 0, len(args.Nodes.Items))
-		for _, item := range args.Nodes.Items {
-			nodeNames = append(nodeNames, item.Name)
+		nodeNames := make([]string, 0)
+		if args.Nodes != nil {
+			for _, item := range args.Nodes.Items {
+				nodeNames = append(nodeNames, item.Name)
+			}
 		}
 		args.NodeNames = &nodeNames
 	}
 
-	res, err := svc.Prioritize(&args)
+	return &args, nil
+}
+
+// Prioritize 根据Pod对Nodes评分
+func Prioritize(c *bm.Context) {
+	args, err := bindExtenderArgs(c)
+	if err != nil {
+		return
+	}
+
+	res, err := svc.Prioritize(args)
 	if err != nil {
 		c.JSONMap(map[string]interface{}{
 			"error": err.Error(),
@@ -108,6 +158,23 @@ func Prioritize(c *bm.Context) {
 	return
 }
 
+// Explain 使用与prioritizeVerb相同的参数评分，返回每个节点评分的计算过程
+func Explain(c *bm.Context) {
+	args, err := bindExtenderArgs(c)
+	if err != nil {
+		return
+	}
+
+	res, err := svc.Explain(args)
+	if err != nil {
+		c.JSONMap(map[string]interface{}{
+			"message": err.Error(),
+		}, ecode.ServerErr)
+		return
+	}
+	c.JSON(res, ecode.OK)
+}
+
 func PromDemo(c *bm.Context) {
 	svc.PromDemo()
 	c.JSON(nil, ecode.OK)
@@ -144,3 +211,141 @@ func QueryAllCache(c *bm.Context) {
 
 	c.JSON(res, ecode.OK)
 }
+
+// QueryDecisions 按pod/namespace/node/时间查询评分决策审计记录
+// 时间参数start/end支持RFC3339格式或者unix秒
+func QueryDecisions(c *bm.Context) {
+	query := c.Request.URL.Query()
+	filter := &model.DecisionFilter{
+		Pod:       query.Get("pod"),
+		Namespace: query.Get("namespace"),
+		Node:      query.Get("node"),
+	}
+
+	var err error
+	if filter.Start, err = parseTimeParam(query.Get("start")); err != nil {
+		c.JSONMap(map[string]interface{}{
+			"message": err.Error(),
+		}, ecode.RequestErr)
+		return
+	}
+	if filter.End, err = parseTimeParam(query.Get("end")); err != nil {
+		c.JSONMap(map[string]interface{}{
+			"message": err.Error(),
+		}, ecode.RequestErr)
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			c.JSONMap(map[string]interface{}{
+				"message": fmt.Sprintf("invalid limit %s", limit),
+			}, ecode.RequestErr)
+			return
+		}
+	}
+
+	res, err := svc.QueryDecisions(filter)
+	if err != nil {
+		c.JSONMap(map[string]interface{}{
+			"message": err.Error(),
+		}, ecode.ServerErr)
+		return
+	}
+	c.JSON(res, ecode.OK)
+}
+
+// QueryMetricHistory 返回每个节点指标的历史数据和窗口统计值，用于调试
+// metric/node为空时返回全部，window为Go的时长格式，如10m，为空时返回保存的全部数据
+func QueryMetricHistory(c *bm.Context) {
+	query := c.Request.URL.Query()
+	var window time.Duration
+	if v := query.Get("window"); v != "" {
+		var err error
+		if window, err = time.ParseDuration(v); err != nil || window < 0 {
+			c.JSONMap(map[string]interface{}{
+				"message": fmt.Sprintf("invalid window %s", v),
+			}, ecode.RequestErr)
+			return
+		}
+	}
+
+	c.JSON(svc.MetricHistory(query.Get("metric"), query.Get("node"), window), ecode.OK)
+}
+
+// QueryNetDemand 返回按控制器学习得到的Pod网络需求，用于调试
+func QueryNetDemand(c *bm.Context) {
+	c.JSON(svc.NetDemandEstimates(), ecode.OK)
+}
+
+// QueryAutoAlgorithm 返回自动选择算法的状态和最近的切换记录
+func QueryAutoAlgorithm(c *bm.Context) {
+	c.JSON(svc.AutoAlgorithmStatus(), ecode.OK)
+}
+
+// QueryShadowReport 返回影子策略与线上结果的差异汇总，参数policy为空时返回全部影子策略
+func QueryShadowReport(c *bm.Context) {
+	c.JSON(svc.ShadowReport(c.Request.URL.Query().Get("policy")), ecode.OK)
+}
+
+// QueryExperimentReport 返回A/B实验每组的结果统计和置信区间
+func QueryExperimentReport(c *bm.Context) {
+	c.JSON(svc.ExperimentReport(), ecode.OK)
+}
+
+// Recommend 根据工作负载的历史负载推荐注解，参数window为Go的时长格式，percentile为百分位数
+// format为yaml时返回可以直接用于kubectl patch的yaml，否则返回json
+func Recommend(c *bm.Context) {
+	query := c.Request.URL.Query()
+	opts := recommend.DefaultOptions()
+	opts.Namespace = query.Get("namespace")
+	if v := query.Get("window"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil {
+			c.JSONMap(map[string]interface{}{"message": fmt.Sprintf("invalid window %s", v)}, ecode.RequestErr)
+			return
+		}
+		opts.Window = window
+	}
+	if v := query.Get("percentile"); v != "" {
+		percentile, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			c.JSONMap(map[string]interface{}{"message": fmt.Sprintf("invalid percentile %s", v)}, ecode.RequestErr)
+			return
+		}
+		opts.Percentile = percentile
+	}
+	if err := opts.Validate(); err != nil {
+		c.JSONMap(map[string]interface{}{"message": err.Error()}, ecode.RequestErr)
+		return
+	}
+
+	report, err := svc.Recommend(opts)
+	if err != nil {
+		c.JSONMap(map[string]interface{}{
+			"message": err.Error(),
+		}, ecode.ServerErr)
+		return
+	}
+	if query.Get("format") != "yaml" {
+		c.JSON(report, ecode.OK)
+		return
+	}
+	var buf bytes.Buffer
+	_ = recommend.WriteReport(&buf, "yaml", report)
+	c.Bytes(http.StatusOK, "application/yaml; charset=utf-8", buf.Bytes())
+}
+
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %s, should be RFC3339 or unix seconds", v)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/log"
+	"github.com/go-kratos/kratos/pkg/stat/metric"
+)
+
+// maxAlgorithmSwitches 保留的最近切换记录条数
+const maxAlgorithmSwitches = 100
+
+// 自动选择算法导出的指标，由bm的/metrics暴露
+var (
+	autoAlgorithm = metric.NewGaugeVec(&metric.GaugeVecOpts{
+		Namespace: "liang",
+		Subsystem: "auto",
+		Name:      "algorithm",
+		Help:      "Algorithm of the cluster policy chosen by automatic selection, 1 for the current one.",
+		Labels:    []string{"algorithm"},
+	})
+	autoAlgorithmSwitches = metric.NewCounterVec(&metric.CounterVecOpts{
+		Namespace: "liang",
+		Subsystem: "auto",
+		Name:      "algorithm_switches_total",
+		Help:      "Number of automatic algorithm switches.",
+		Labels:    []string{"from", "to"},
+	})
+	autoUtilization = metric.NewGaugeVec(&metric.GaugeVecOpts{
+		Namespace: "liang",
+		Subsystem: "auto",
+		Name:      "cluster_utilization_percent",
+		Help:      "Mean cluster utilization of each resource analysed by automatic selection.",
+		Labels:    []string{"resource"},
+	})
+)
+
+// AutoAlgorithmConfig 按集群瓶颈自动选择cluster策略的算法，对应application.toml中的[autoAlgorithm]
+// 网络平均利用率不低于MinUtilization，且不低于其它资源最大平均利用率的EnterRatio倍时网络是主要瓶颈，使用bnp；
+// 使用bnp时网络利用率低于MinUtilization或者低于其它资源的ExitRatio倍时换回cmdn，连续Consecutive次同步满足条件才切换
+type AutoAlgorithmConfig struct {
+	Enabled        bool
+	EnterRatio     float64
+	ExitRatio      float64
+	MinUtilization float64 // 单位为百分比
+	Consecutive    int
+}
+
+// DefaultAutoAlgorithmConfig 没有配置[autoAlgorithm]时按useBNP选择算法
+func DefaultAutoAlgorithmConfig() AutoAlgorithmConfig {
+	return AutoAlgorithmConfig{
+		EnterRatio:     2,
+		ExitRatio:      1.2,
+		MinUtilization: 20,
+		Consecutive:    3,
+	}
+}
+
+// Validate ExitRatio需要小于EnterRatio，两者之间的区间用于防止来回切换
+func (cfg AutoAlgorithmConfig) Validate() error {
+	if cfg.ExitRatio <= 0 || cfg.ExitRatio >= cfg.EnterRatio {
+		return fmt.Errorf("auto algorithm exit ratio %v should be in (0, enter ratio %v)", cfg.ExitRatio, cfg.EnterRatio)
+	}
+	if cfg.MinUtilization < 0 || cfg.MinUtilization > 100 {
+		return fmt.Errorf("auto algorithm min utilization %v should be in [0, 100]", cfg.MinUtilization)
+	}
+	if cfg.Consecutive < 1 {
+		return fmt.Errorf("auto algorithm consecutive %d should be positive", cfg.Consecutive)
+	}
+
+	return nil
+}
+
+// autoAlgorithmState 自动选择算法的状态，algorithm为cluster策略当前使用的算法
+type autoAlgorithmState struct {
+	mu          sync.RWMutex
+	algorithm   string
+	bottleneck  string
+	utilization map[string]float64
+	candidate   string
+	streak      int
+	switches    []model.AlgorithmSwitch
+}
+
+// clusterUtilization 集群各资源的平均利用率，单位为百分比，没有数据的资源不在结果中
+// CPU和内存为各节点利用率的平均值，网络和磁盘为负载之和除以能力之和，没有能力的节点不参与计算
+func clusterUtilization(snapshot map[string](map[string]int64), nodeNames []string, netBw map[string]int64, diskCap map[string]model.DiskCapacity) map[string]float64 {
+	res := make(map[string]float64, 4)
+	for _, key := range []string{model.ResourceCPUKey, model.ResourceMemKey} {
+		var sum float64
+		var n int
+		for _, name := range nodeNames {
+			if v, ok := snapshot[key][name]; ok {
+				sum += float64(v)
+				n++
+			}
+		}
+		if n > 0 {
+			res[key] = sum / float64(n)
+		}
+	}
+
+	var netSum, netCap, diskSum, diskCapSum float64
+	for _, name := range nodeNames {
+		if v, ok := snapshot[model.ResourceNetIOKey][name]; ok && netBw[name] > 0 {
+			netSum += float64(v)
+			netCap += float64(netBw[name])
+		}
+		if v, ok := snapshot[model.ResourceDiskIOKey][name]; ok && diskCap[name].Throughput > 0 {
+			diskSum += float64(v)
+			diskCapSum += float64(diskCap[name].Throughput)
+		}
+	}
+	if netCap > 0 {
+		res[model.ResourceNetIOKey] = 100 * netSum / netCap
+	}
+	if diskCapSum > 0 {
+		res[model.ResourceDiskIOKey] = 100 * diskSum / diskCapSum
+	}
+
+	return res
+}
+
+// bottleneck 平均利用率最高的资源
+func bottleneck(util map[string]float64) string {
+	res, max := "", -1.0
+	for _, key := range model.BalanceKeys() {
+		if v, ok := util[key]; ok && v > max {
+			res, max = key, v
+		}
+	}
+
+	return res
+}
+
+// targetAlgorithm 按利用率判断应该使用的算法，使用bnp和cmdn时的阈值不同，避免在边界附近来回切换
+func targetAlgorithm(cfg AutoAlgorithmConfig, current string, util map[string]float64) string {
+	net, ok := util[model.ResourceNetIOKey]
+	if !ok {
+		return current
+	}
+	other := 0.0
+	for key, v := range util {
+		if key != model.ResourceNetIOKey && v > other {
+			other = v
+		}
+	}
+
+	if current == model.AlgoBNP {
+		if net < cfg.MinUtilization || net < cfg.ExitRatio*other {
+			return model.AlgoCMDN
+		}
+		return model.AlgoBNP
+	}
+	if net >= cfg.MinUtilization && net >= cfg.EnterRatio*other {
+		return model.AlgoBNP
+	}
+
+	return model.AlgoCMDN
+}
+
+// clusterAlgorithm cluster策略使用的算法，开启自动选择时为最近一次选择的算法，否则按useBNP
+func (s *Service) clusterAlgorithm() string {
+	if s.autoCfg.Enabled {
+		s.auto.mu.RLock()
+		defer s.auto.mu.RUnlock()
+		return s.auto.algorithm
+	}
+	if s.useBNP {
+		return model.AlgoBNP
+	}
+
+	return model.AlgoCMDN
+}
+
+// setAutoAlgorithmMetric 导出当前使用的算法
+func setAutoAlgorithmMetric(algo string) {
+	for _, a := range []string{model.AlgoBNP, model.AlgoCMDN} {
+		v := 0.0
+		if a == algo {
+			v = 1
+		}
+		autoAlgorithm.Set(v, a)
+	}
+}
+
+// selectAlgorithm 每次同步后分析集群瓶颈，连续Consecutive次满足条件后切换cluster策略的算法
+func (s *Service) selectAlgorithm() {
+	if !s.autoCfg.Enabled {
+		return
+	}
+	snapshot, err := s.dao.GetAllInfo()
+	if err != nil {
+		log.Error("get snapshot for algorithm selection error: %v", err)
+		return
+	}
+	nodeNames := s.nodeNames
+	util := clusterUtilization(snapshot, nodeNames, s.netBw(), s.diskCapacity(nodeNames))
+	for key, v := range util {
+		autoUtilization.Set(v, key)
+	}
+
+	s.auto.mu.Lock()
+	defer s.auto.mu.Unlock()
+	s.auto.utilization, s.auto.bottleneck = util, bottleneck(util)
+	target := targetAlgorithm(s.autoCfg, s.auto.algorithm, util)
+	if target == s.auto.algorithm {
+		s.auto.candidate, s.auto.streak = "", 0
+		return
+	}
+	if target != s.auto.candidate {
+		s.auto.candidate, s.auto.streak = target, 0
+	}
+	s.auto.streak++
+	if s.auto.streak < s.autoCfg.Consecutive {
+		log.V(3).Info("algorithm %s is candidate for %d/%d syncs, utilization %v", target, s.auto.streak, s.autoCfg.Consecutive, util)
+		return
+	}
+
+	sw := model.AlgorithmSwitch{
+		Time:            time.Now(),
+		From:            s.auto.algorithm,
+		To:              target,
+		Bottleneck:      s.auto.bottleneck,
+		Utilization:     util,
+		SnapshotVersion: s.snapshotVersion(),
+	}
+	s.auto.switches = append(s.auto.switches, sw)
+	if len(s.auto.switches) > maxAlgorithmSwitches {
+		s.auto.switches = s.auto.switches[len(s.auto.switches)-maxAlgorithmSwitches:]
+	}
+	s.auto.algorithm, s.auto.candidate, s.auto.streak = target, "", 0
+	log.Info("switch algorithm from %s to %s, bottleneck is %s, utilization %v", sw.From, sw.To, sw.Bottleneck, util)
+	autoAlgorithmSwitches.Inc(sw.From, sw.To)
+	setAutoAlgorithmMetric(target)
+}
+
+// AutoAlgorithmStatus 自动选择算法的当前状态和最近的切换记录
+func (s *Service) AutoAlgorithmStatus() *model.AutoAlgorithmStatus {
+	status := &model.AutoAlgorithmStatus{Enabled: s.autoCfg.Enabled, Algorithm: s.clusterAlgorithm()}
+	if !s.autoCfg.Enabled {
+		status.Switches = []model.AlgorithmSwitch{}
+		return status
+	}
+
+	s.auto.mu.RLock()
+	defer s.auto.mu.RUnlock()
+	status.Bottleneck = s.auto.bottleneck
+	status.Utilization = s.auto.utilization
+	status.Candidate = s.auto.candidate
+	status.Streak = s.auto.streak
+	status.Switches = append([]model.AlgorithmSwitch{}, s.auto.switches...)
+
+	return status
+}
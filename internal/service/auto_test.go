@@ -0,0 +1,149 @@
+package service
+
+import (
+	"math"
+	"testing"
+
+	"liang/internal/fakeprom"
+	"liang/internal/model"
+)
+
+func TestAutoAlgorithmConfig_Validate(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Modify func(cfg *AutoAlgorithmConfig)
+		Valid  bool
+	}{
+		{Name: "default", Modify: func(cfg *AutoAlgorithmConfig) {}, Valid: true},
+		{Name: "no hysteresis", Modify: func(cfg *AutoAlgorithmConfig) { cfg.ExitRatio = cfg.EnterRatio }},
+		{Name: "zero exit ratio", Modify: func(cfg *AutoAlgorithmConfig) { cfg.ExitRatio = 0 }},
+		{Name: "utilization too large", Modify: func(cfg *AutoAlgorithmConfig) { cfg.MinUtilization = 120 }},
+		{Name: "zero consecutive", Modify: func(cfg *AutoAlgorithmConfig) { cfg.Consecutive = 0 }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			cfg := DefaultAutoAlgorithmConfig()
+			c.Modify(&cfg)
+			if err := cfg.Validate(); (err == nil) != c.Valid {
+				t.Errorf("valid should be %v, but get %v", c.Valid, err)
+			}
+		})
+	}
+}
+
+func TestClusterUtilization(t *testing.T) {
+	nodeNames := []string{"node1", "node2", "node3"}
+	snapshot := map[string](map[string]int64){
+		model.ResourceCPUKey:    {"node1": 20, "node2": 40, "node3": 60},
+		model.ResourceMemKey:    {"node1": 50, "node2": 70},
+		model.ResourceNetIOKey:  {"node1": 500 * model.KbitPS, "node2": 100 * model.KbitPS, "node3": 900 * model.KbitPS},
+		model.ResourceDiskIOKey: {"node1": 50 * model.MByte},
+	}
+	// node3没有网卡带宽，不参与网络利用率的计算
+	netBw := map[string]int64{"node1": 1000 * model.KbitPS, "node2": 1000 * model.KbitPS}
+	diskCap := map[string]model.DiskCapacity{"node1": {Throughput: 200 * model.MByte}}
+
+	util := clusterUtilization(snapshot, nodeNames, netBw, diskCap)
+	expected := map[string]float64{
+		model.ResourceCPUKey:    40,
+		model.ResourceMemKey:    60,
+		model.ResourceNetIOKey:  30,
+		model.ResourceDiskIOKey: 25,
+	}
+	for key, v := range expected {
+		if math.Abs(util[key]-v) > 1e-9 {
+			t.Errorf("utilization of %s should be %v, but get %v", key, v, util[key])
+		}
+	}
+	if b := bottleneck(util); b != model.ResourceMemKey {
+		t.Errorf("bottleneck should be %s, but get %s", model.ResourceMemKey, b)
+	}
+}
+
+func TestTargetAlgorithm(t *testing.T) {
+	cfg := DefaultAutoAlgorithmConfig()
+	util := func(net, cpu float64) map[string]float64 {
+		return map[string]float64{model.ResourceNetIOKey: net, model.ResourceCPUKey: cpu, model.ResourceMemKey: cpu / 2}
+	}
+	cases := []struct {
+		Name     string
+		Current  string
+		Util     map[string]float64
+		Expected string
+	}{
+		{Name: "network bottleneck", Current: model.AlgoCMDN, Util: util(60, 25), Expected: model.AlgoBNP},
+		{Name: "network is not dominant enough", Current: model.AlgoCMDN, Util: util(45, 25), Expected: model.AlgoCMDN},
+		{Name: "network is idle", Current: model.AlgoCMDN, Util: util(10, 2), Expected: model.AlgoCMDN},
+		// 在EnterRatio和ExitRatio之间时保持当前算法
+		{Name: "keep bnp in hysteresis band", Current: model.AlgoBNP, Util: util(45, 25), Expected: model.AlgoBNP},
+		{Name: "leave bnp", Current: model.AlgoBNP, Util: util(28, 25), Expected: model.AlgoCMDN},
+		{Name: "leave bnp when network is idle", Current: model.AlgoBNP, Util: util(15, 1), Expected: model.AlgoCMDN},
+		{Name: "no network data", Current: model.AlgoBNP, Util: map[string]float64{model.ResourceCPUKey: 90}, Expected: model.AlgoBNP},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if algo := targetAlgorithm(cfg, c.Current, c.Util); algo != c.Expected {
+				t.Errorf("algorithm should be %s, but get %s", c.Expected, algo)
+			}
+		})
+	}
+}
+
+func TestService_AutoAlgorithm(t *testing.T) {
+	prom := fakeprom.New()
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 100000, "node2": 600000, "node3": 1200000})
+	prom.SetConst(fakeprom.DiskWritten, map[string]float64{"node1": 1024, "node2": 2048, "node3": 4096})
+	prom.SetConst(fakeprom.CPUSeconds, map[string]float64{"node1": 0.2, "node2": 0.4, "node3": 0.6})
+	prom.SetConst(fakeprom.MemAvailable, map[string]float64{"node1": 0.3, "node2": 0.5, "node3": 0.7})
+	s := newFakePromService(t, prom, false, `
+[autoAlgorithm]
+enabled = true
+enterRatio = 1.5
+exitRatio = 1.1
+minUtilization = 20.0
+consecutive = 2
+`)
+
+	// 网络利用率38%，内存50%，使用cmdn
+	if status := s.AutoAlgorithmStatus(); status.Algorithm != model.AlgoCMDN || status.Bottleneck != model.ResourceMemKey {
+		t.Fatalf("initial algorithm should be cmdn with memory bottleneck, but get %+v", status)
+	}
+	if s.syncNetIOOnly() || !s.usesAlgorithm(model.AlgoBNP) || !s.usesAlgorithm(model.AlgoCMDN) {
+		t.Errorf("both algorithms may be used when auto selection is enabled")
+	}
+
+	// 网络利用率升高到82%，连续2次同步后切换到bnp
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 900000, "node2": 1200000, "node3": 2000000})
+	if err := s.ParallelSyncInfo(); err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+	if status := s.AutoAlgorithmStatus(); status.Algorithm != model.AlgoCMDN || status.Candidate != model.AlgoBNP || status.Streak != 1 {
+		t.Errorf("bnp should be candidate after one sync, but get %+v", status)
+	}
+	_ = s.ParallelSyncInfo()
+	status := s.AutoAlgorithmStatus()
+	if status.Algorithm != model.AlgoBNP || len(status.Switches) != 1 || status.Switches[0].Bottleneck != model.ResourceNetIOKey {
+		t.Fatalf("should switch to bnp, but get %+v", status)
+	}
+	if p := s.ResolvePolicy(nil); p.Name != model.PolicyCluster || p.Algorithm != model.AlgoBNP {
+		t.Errorf("cluster policy should use bnp, but get %+v", p)
+	}
+
+	// 网络利用率回落到60%，在55%和75%之间的滞后区间内保持bnp
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 800000, "node2": 900000, "node3": 1300000})
+	_ = s.ParallelSyncInfo()
+	_ = s.ParallelSyncInfo()
+	if status = s.AutoAlgorithmStatus(); status.Algorithm != model.AlgoBNP || status.Streak != 0 {
+		t.Errorf("should keep bnp in hysteresis band, but get %+v", status)
+	}
+
+	// 回落到38%后换回cmdn
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 100000, "node2": 600000, "node3": 1200000})
+	_ = s.ParallelSyncInfo()
+	_ = s.ParallelSyncInfo()
+	if status = s.AutoAlgorithmStatus(); status.Algorithm != model.AlgoCMDN || len(status.Switches) != 2 {
+		t.Errorf("should switch back to cmdn, but get %+v", status)
+	}
+}
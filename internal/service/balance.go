@@ -0,0 +1,276 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/log"
+	v1 "k8s.io/api/core/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// BalanceDimension 参与均衡的一个资源，Need/Cur/Cap使用相同的单位
+type BalanceDimension struct {
+	Key    string
+	Weight float64
+	Need   int64
+	Cur    map[string]int64
+	Cap    map[string]int64
+}
+
+// BalancedResourcePriority 多资源均衡评分算法，是bnp在多个资源上的推广
+// 对每个资源分别计算Pod调度到节点i后集群负载比例的方差，按Mode合并后方差越小得分越高
+type BalancedResourcePriority struct {
+	Mode string // weighted/max
+}
+
+// Score 使用权重和Pod需求都大于0的资源评分，没有这样的资源时所有节点为最低分
+func (algo *BalancedResourcePriority) Score(nodeNames []string, dims []BalanceDimension) (extenderv1.HostPriorityList, error) {
+	return algo.score(nodeNames, dims, nil)
+}
+
+// Explain 评分并返回每个节点调度后各资源的负载和方差，以及被过滤掉的节点
+func (algo *BalancedResourcePriority) Explain(nodeNames []string, dims []BalanceDimension) (extenderv1.HostPriorityList, *model.BalanceExplanation, error) {
+	explain := &model.BalanceExplanation{
+		Mode:    algo.Mode,
+		Weights: make(map[string]float64, len(dims)),
+		Demand:  make(map[string]int64, len(dims)),
+	}
+	res, err := algo.score(nodeNames, dims, explain)
+
+	return res, explain, err
+}
+
+func (algo *BalancedResourcePriority) score(nodeNames []string, dims []BalanceDimension, explain *model.BalanceExplanation) (extenderv1.HostPriorityList, error) {
+	emptyScore := GetDefaultScore(nodeNames)
+	active := make([]BalanceDimension, 0, len(dims))
+	for _, dim := range dims {
+		if explain != nil {
+			explain.Weights[dim.Key] = dim.Weight
+			explain.Demand[dim.Key] = dim.Need
+		}
+		if dim.Weight > 0 && dim.Need > 0 {
+			active = append(active, dim)
+		}
+	}
+	if len(active) == 0 {
+		log.V(3).Info("BalancedResourcePriority - pod has no demand on weighted resources, skip")
+		return emptyScore, nil
+	}
+
+	validNames, rejected := FilterNodeByDimensions(nodeNames, active)
+	if explain != nil {
+		explain.Rejected = rejected
+	}
+	if len(validNames) == 0 {
+		log.V(3).Info("none nodes is valid, all nodes's score is 0")
+		return emptyScore, nil
+	}
+
+	nodeNum := len(validNames)
+	newLoad := make([][]float64, len(active))
+	variance := make([][]float64, len(active))
+	for k, dim := range active {
+		curArr, capArr := make([]float64, nodeNum), make([]float64, nodeNum)
+		for i, name := range validNames {
+			curArr[i], capArr[i] = float64(dim.Cur[name]), float64(dim.Cap[name])
+		}
+		_, newLoad[k], variance[k] = placementVariance(dim.Need, curArr, capArr)
+	}
+
+	combined := make([]float64, nodeNum)
+	for i := range combined {
+		var sum, weightSum float64
+		for k, dim := range active {
+			if algo.Mode == model.BalanceMax {
+				combined[i] = math.Max(combined[i], variance[k][i])
+				continue
+			}
+			sum += dim.Weight * variance[k][i]
+			weightSum += dim.Weight
+		}
+		if algo.Mode != model.BalanceMax {
+			combined[i] = sum / weightSum
+		}
+	}
+
+	var scoreMap map[string]int64
+	if nodeNum == 1 {
+		scoreMap = map[string]int64{validNames[0]: model.MaxNodeScore}
+	} else {
+		scoreMap = normalizeVariance(validNames, combined)
+	}
+
+	if explain != nil {
+		explain.Nodes = make([]model.BalanceNodeExplanation, nodeNum)
+		for i, name := range validNames {
+			node := model.BalanceNodeExplanation{
+				Host:     name,
+				NewLoad:  make(map[string]float64, len(active)),
+				Variance: make(map[string]float64, len(active)),
+				Combined: combined[i],
+				Score:    scoreMap[name],
+			}
+			for k, dim := range active {
+				node.NewLoad[dim.Key] = newLoad[k][i]
+				node.Variance[dim.Key] = variance[k][i]
+			}
+			explain.Nodes[i] = node
+		}
+	}
+
+	scoreRes := make(extenderv1.HostPriorityList, len(nodeNames))
+	for i, name := range nodeNames {
+		score, ok := scoreMap[name]
+		if !ok {
+			score = model.MinNodeScore
+		}
+		scoreRes[i] = extenderv1.HostPriority{Host: name, Score: score}
+	}
+	log.V(3).Info("BalancedResourcePriority scoreRes: %v", scoreRes)
+
+	return scoreRes, nil
+}
+
+// FilterNodeByDimensions 过滤掉任意一个资源放不下Pod的节点
+func FilterNodeByDimensions(nodeNames []string, dims []BalanceDimension) (valideNames []string, rejected []model.NodeRejection) {
+	for _, name := range nodeNames {
+		reason := ""
+		for _, dim := range dims {
+			cur, ok := dim.Cur[name]
+			if !ok {
+				reason = fmt.Sprintf("current %s does not exist", dim.Key)
+				break
+			}
+			capacity := dim.Cap[name]
+			if capacity <= 0 {
+				reason = fmt.Sprintf("%s capacity does not exist", dim.Key)
+				break
+			}
+			if dim.Need+cur > capacity {
+				reason = fmt.Sprintf("%s: request %d plus cur %d overflow cap %d", dim.Key, dim.Need, cur, capacity)
+				break
+			}
+		}
+		if reason != "" {
+			log.V(5).Info("node %s is rejected: %s", name, reason)
+			rejected = append(rejected, model.NodeRejection{Host: name, Reason: reason})
+			continue
+		}
+		valideNames = append(valideNames, name)
+	}
+
+	return
+}
+
+// GetPodBalanceDemand 从Pod注解中拿到Pod在各资源上的需求
+// LiangNetIO单位Mbps，转换为Kbit/s；LiangDiskIO单位MB/s，转换为B/s；LiangCPU和LiangMem为占节点的百分比
+func GetPodBalanceDemand(pod *v1.Pod) map[string]int64 {
+	demand := map[string]int64{model.ResourceNetIOKey: GetPodNetIONeed(pod)}
+	units := map[string]int64{model.ResourceDiskIOKey: model.MByte, model.ResourceCPUKey: 1, model.ResourceMemKey: 1}
+	for key, unit := range units {
+		v, ok := pod.Annotations[key]
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			log.Error("parse %s of %s to float64 error:%v", v, key, err)
+			continue
+		}
+		demand[key] = int64(math.Ceil(f * float64(unit)))
+	}
+
+	return demand
+}
+
+// balanceDimensions 根据评分输入构造各资源的均衡维度
+// 有分方向的网络负载时网络拆分为下行和上行两个维度，都使用LiangNetIO的权重
+func balanceDimensions(in *ScoreInput, snapshot map[string](map[string]int64), nodeNames []string) []BalanceDimension {
+	demand := GetPodBalanceDemand(in.Pod)
+	weights := in.Balance.Weights
+	dims := make([]BalanceDimension, 0, 5)
+	if in.NetCapMap != nil && HasNetDirections(snapshot, nodeNames) {
+		netDemand := GetPodNetDemand(in.Pod)
+		inCap := make(map[string]int64, len(in.NetCapMap))
+		outCap := make(map[string]int64, len(in.NetCapMap))
+		for name, c := range in.NetCapMap {
+			inCap[name], outCap[name] = c.In, c.Out
+		}
+		dims = append(dims,
+			BalanceDimension{Key: model.ResourceNetInKey, Weight: weights[model.ResourceNetIOKey], Need: netDemand.In, Cur: snapshot[model.ResourceNetInKey], Cap: inCap},
+			BalanceDimension{Key: model.ResourceNetOutKey, Weight: weights[model.ResourceNetIOKey], Need: netDemand.Out, Cur: snapshot[model.ResourceNetOutKey], Cap: outCap})
+	} else {
+		dims = append(dims, BalanceDimension{Key: model.ResourceNetIOKey, Weight: weights[model.ResourceNetIOKey],
+			Need: demand[model.ResourceNetIOKey], Cur: snapshot[model.ResourceNetIOKey], Cap: in.NetBwMap})
+	}
+
+	diskCap := make(map[string]int64, len(in.DiskCapMap))
+	for name, c := range in.DiskCapMap {
+		diskCap[name] = c.Throughput
+	}
+	// CPU和内存是百分比，容量为100
+	full := make(map[string]int64, len(nodeNames))
+	for _, name := range nodeNames {
+		full[name] = 100
+	}
+	for _, dim := range []struct {
+		key      string
+		capacity map[string]int64
+	}{
+		{model.ResourceDiskIOKey, diskCap},
+		{model.ResourceCPUKey, full},
+		{model.ResourceMemKey, full},
+	} {
+		dims = append(dims, BalanceDimension{Key: dim.key, Weight: weights[dim.key], Need: demand[dim.key], Cur: snapshot[dim.key], Cap: dim.capacity})
+	}
+
+	return dims
+}
+
+// ValidateBalanceConfig 检查多资源均衡的合并方式和权重
+func ValidateBalanceConfig(cfg model.BalanceConfig) error {
+	switch cfg.Mode {
+	case model.BalanceWeighted, model.BalanceMax:
+	default:
+		return fmt.Errorf("bnp balance mode %s should be one of weighted/max", cfg.Mode)
+	}
+
+	valid := make(map[string]bool)
+	for _, key := range model.BalanceKeys() {
+		valid[key] = true
+	}
+	total := 0.0
+	for key, w := range cfg.Weights {
+		if !valid[key] {
+			return fmt.Errorf("bnp balance weight of %s is unknown, should be one of %v", key, model.BalanceKeys())
+		}
+		if w < 0 {
+			return fmt.Errorf("bnp balance weight of %s should not be negative", key)
+		}
+		total += w
+	}
+	if total == 0 {
+		return fmt.Errorf("at least one bnp balance weight should be positive")
+	}
+
+	return nil
+}
+
+// scoreKeys 评分需要的指标，bnp多资源均衡时还需要权重大于0的其他资源
+func scoreKeys(algo string, balance model.BalanceConfig) []string {
+	keys := requiredKeys(algo)
+	if algo != model.AlgoBNP || !balance.MultiDim() {
+		return keys
+	}
+	for _, key := range model.BalanceKeys() {
+		if key != model.ResourceNetIOKey && balance.Weights[key] > 0 {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"testing"
+
+	"liang/internal/dao"
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/conf/paladin"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newBalancePod(annotations map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "fio-0",
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+	}
+}
+
+func newBalanceInput(pod *v1.Pod, balance model.BalanceConfig) *ScoreInput {
+	return &ScoreInput{
+		Algorithm: model.AlgoBNP,
+		Balance:   balance,
+		Pod:       pod,
+		NodeNames: []string{"node1", "node2", "node3"},
+		NetBwMap:  map[string]int64{"node1": 1000 * model.KbitPS, "node2": 1000 * model.KbitPS, "node3": 1000 * model.KbitPS},
+		DiskCapMap: map[string]model.DiskCapacity{
+			"node1": {Throughput: 500 * model.MByte},
+			"node2": {Throughput: 500 * model.MByte},
+			"node3": {Throughput: 150 * model.MByte},
+		},
+		Snapshot: map[string](map[string]int64){
+			model.ResourceNetIOKey:  {"node1": 100 * model.KbitPS, "node2": 200 * model.KbitPS, "node3": 300 * model.KbitPS},
+			model.ResourceDiskIOKey: {"node1": 300 * model.MByte, "node2": 50 * model.MByte, "node3": 50 * model.MByte},
+			model.ResourceCPUKey:    {"node1": 20, "node2": 60, "node3": 20},
+			model.ResourceMemKey:    {"node1": 30, "node2": 30, "node3": 30},
+		},
+		LastKnown: map[string](map[string]int64){},
+	}
+}
+
+func TestGetPodBalanceDemand(t *testing.T) {
+	pod := newBalancePod(map[string]string{
+		model.ResourceNetIOKey:  "10",
+		model.ResourceDiskIOKey: "1.5",
+		model.ResourceCPUKey:    "20",
+		model.ResourceMemKey:    "-1",
+	})
+	demand := GetPodBalanceDemand(pod)
+	if demand[model.ResourceNetIOKey] != 10*model.KbitPS || demand[model.ResourceDiskIOKey] != 1.5*model.MByte ||
+		demand[model.ResourceCPUKey] != 20 || demand[model.ResourceMemKey] != 0 {
+		t.Errorf("unexpected pod demand %v", demand)
+	}
+}
+
+func TestScoreSnapshot_BalanceDiskHeavy(t *testing.T) {
+	// 只声明磁盘需求的Pod
+	pod := newBalancePod(map[string]string{model.ResourceDiskIOKey: "50"})
+
+	// 原来的bnp只看网络需求，所有节点都是最低分
+	res, _, err := ScoreSnapshot(newBalanceInput(pod, model.DefaultBalanceConfig()))
+	if err != nil {
+		t.Fatalf("score snapshot error: %v", err)
+	}
+	for _, hp := range res {
+		if hp.Score != model.MinNodeScore {
+			t.Fatalf("net only bnp should score disk heavy pod 0, but get %v", res)
+		}
+	}
+
+	// 磁盘负载比例：node1 60%，node2 10%，node3 33%
+	balance := model.BalanceConfig{Mode: model.BalanceWeighted, Weights: map[string]float64{model.ResourceNetIOKey: 1, model.ResourceDiskIOKey: 1}}
+	explain, res, err := explainSnapshot(newBalanceInput(pod, balance))
+	if err != nil {
+		t.Fatalf("explain snapshot error: %v", err)
+	}
+	// node3的磁盘能力小，调度后负载比例增加最多
+	if res[1].Score != model.MaxNodeScore || res[2].Score != model.MinNodeScore {
+		t.Errorf("disk heavy pod should prefer node2 and avoid node3, but get %v", res)
+	}
+	if explain.Balance == nil || explain.Balance.Demand[model.ResourceDiskIOKey] != 50*model.MByte || len(explain.Balance.Nodes) != 3 {
+		t.Errorf("unexpected balance explanation %+v", explain.Balance)
+	}
+	// 网络需求为0，不参与评分
+	if _, ok := explain.Balance.Nodes[0].Variance[model.ResourceNetIOKey]; ok {
+		t.Errorf("resource without demand should not be scored, but get %v", explain.Balance.Nodes[0].Variance)
+	}
+}
+
+func TestScoreSnapshot_BalanceMode(t *testing.T) {
+	pod := newBalancePod(map[string]string{model.ResourceDiskIOKey: "20", model.ResourceCPUKey: "10"})
+	weights := map[string]float64{model.ResourceDiskIOKey: 1, model.ResourceCPUKey: 1}
+
+	for _, mode := range []string{model.BalanceWeighted, model.BalanceMax} {
+		explain, res, err := explainSnapshot(newBalanceInput(pod, model.BalanceConfig{Mode: mode, Weights: weights}))
+		if err != nil {
+			t.Fatalf("%s: explain snapshot error: %v", mode, err)
+		}
+		for i, node := range explain.Balance.Nodes {
+			disk, cpu := node.Variance[model.ResourceDiskIOKey], node.Variance[model.ResourceCPUKey]
+			expected := (disk + cpu) / 2
+			if mode == model.BalanceMax {
+				expected = disk
+				if cpu > disk {
+					expected = cpu
+				}
+			}
+			if node.Combined != expected || node.Score != res[i].Score {
+				t.Errorf("%s: unexpected combined variance of %s: %+v", mode, node.Host, node)
+			}
+		}
+	}
+}
+
+func TestScoreSnapshot_BalanceRejected(t *testing.T) {
+	// node3的磁盘能力只有150MB/s，放不下
+	pod := newBalancePod(map[string]string{model.ResourceDiskIOKey: "120"})
+	balance := model.BalanceConfig{Mode: model.BalanceWeighted, Weights: map[string]float64{model.ResourceDiskIOKey: 1}}
+	res, reasons, err := ScoreSnapshot(newBalanceInput(pod, balance))
+	if err != nil {
+		t.Fatalf("score snapshot error: %v", err)
+	}
+	if res[2].Score != model.MinNodeScore || reasons["node3"] == "" {
+		t.Errorf("node3 should be rejected with a reason, but get %v %v", res, reasons)
+	}
+
+	// 缺少CPU指标时按缺失数据策略处理
+	balance.Weights[model.ResourceCPUKey] = 1
+	in := newBalanceInput(pod, balance)
+	delete(in.Snapshot[model.ResourceCPUKey], "node2")
+	in.MissingPolicy = model.MissingPolicy{model.ResourceCPUKey: model.MissingExclude}
+	_, reasons, _ = ScoreSnapshot(in)
+	if reasons["node2"] == "" {
+		t.Errorf("node2 without cpu should be excluded, but get %v", reasons)
+	}
+}
+
+func TestValidateBalanceConfig(t *testing.T) {
+	cases := []struct {
+		Name  string
+		Cfg   model.BalanceConfig
+		Valid bool
+	}{
+		{Name: "default", Cfg: model.DefaultBalanceConfig(), Valid: true},
+		{Name: "max", Cfg: model.BalanceConfig{Mode: model.BalanceMax, Weights: map[string]float64{model.ResourceCPUKey: 1}}, Valid: true},
+		{Name: "unknown mode", Cfg: model.BalanceConfig{Mode: "min", Weights: map[string]float64{model.ResourceCPUKey: 1}}},
+		{Name: "unknown key", Cfg: model.BalanceConfig{Mode: model.BalanceMax, Weights: map[string]float64{"LiangGPU": 1}}},
+		{Name: "negative", Cfg: model.BalanceConfig{Mode: model.BalanceMax, Weights: map[string]float64{model.ResourceCPUKey: -1}}},
+		{Name: "all zero", Cfg: model.BalanceConfig{Mode: model.BalanceMax, Weights: map[string]float64{model.ResourceCPUKey: 0}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if err := ValidateBalanceConfig(c.Cfg); (err == nil) != c.Valid {
+				t.Errorf("valid should be %v, but get %v", c.Valid, err)
+			}
+		})
+	}
+}
+
+func TestService_BalanceConfig(t *testing.T) {
+	base := `
+netbwMapKeys = ["node1"]
+netbwMapValues = [1000.0]
+syncStatusInterval = "0 0 0 1 1 ?"
+maxSnapshotAge = "60s"
+livenessTimeout = "120s"
+topsisMin = false
+useBNP = true
+dryrun = true
+`
+	d, dcf, err := dao.NewWithConfig(&dao.Config{LocalCacheExpire: 300})
+	if err != nil {
+		t.Fatalf("new dao error: %v", err)
+	}
+	defer dcf()
+
+	ac := &paladin.TOML{}
+	if err = ac.Set(base + `
+[bnpBalance]
+mode = "max"
+
+[bnpBalance.weights]
+LiangNetIO = 1.0
+LiangCPU = 0.5
+`); err != nil {
+		t.Fatalf("set config error: %v", err)
+	}
+	s, cf, err := NewWithConfig(d, ac)
+	if err != nil {
+		t.Fatalf("new service error: %v", err)
+	}
+	defer cf()
+	<-s.initDone
+	if s.balance.Mode != model.BalanceMax || s.balance.Weights[model.ResourceCPUKey] != 0.5 {
+		t.Errorf("unexpected balance config %+v", s.balance)
+	}
+	metrics := s.requiredMetrics()
+	if len(metrics) != 2 || metrics[1] != model.ResourceCPUKey {
+		t.Errorf("bnp balance should require cpu, but get %v", metrics)
+	}
+
+	ac = &paladin.TOML{}
+	if err = ac.Set(base + `
+[bnpBalance.weights]
+LiangGPU = 1.0
+`); err != nil {
+		t.Fatalf("set config error: %v", err)
+	}
+	if _, _, err = NewWithConfig(d, ac); err == nil {
+		t.Errorf("unknown bnp balance weight should be rejected")
+	}
+}
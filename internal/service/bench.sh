@@ -10,23 +10,25 @@ $ ./bench.sh
 goos: linux
 goarch: amd64
 pkg: liang/internal/service
-cpu: Intel(R) Core(TM) i7-7600U CPU @ 2.80GHz
-BenchmarkBalanceNetloadPriority_BNPScore10-4              148446              7569 ns/op
-BenchmarkBalanceNetloadPriority_BNPScore100-4              37587             31591 ns/op
-BenchmarkBalanceNetloadPriority_BNPScore1000-4               850           1515090 ns/op
-BenchmarkBalanceNetloadPriority_BNPScore3000-4               100          11901127 ns/op
-BenchmarkBalanceNetloadPriority_BNPScore5000-4                37          35008001 ns/op
-BenchmarkBalanceNetloadPriority_BNPScore7000-4                18          63258157 ns/op
-BenchmarkBalanceNetloadPriority_BNPScore9000-4                10         102124195 ns/op
-BenchmarkBalanceNetloadPriority_BNPScore10000-4                8         127820304 ns/op
-BenchmarkCMDNPriority_Score10-4                            82564             15187 ns/op
-BenchmarkCMDNPriority_Score100-4                            9423            128768 ns/op
-BenchmarkCMDNPriority_Score1000-4                           1015           1228016 ns/op
-BenchmarkCMDNPriority_Score3000-4                            264           4256656 ns/op
-BenchmarkCMDNPriority_Score5000-4                            186           6389366 ns/op
-BenchmarkCMDNPriority_Score7000-4                            100          11807567 ns/op
-BenchmarkCMDNPriority_Score9000-4                             99          12157084 ns/op
-BenchmarkCMDNPriority_Score10000-4                            74          13622703 ns/op
+cpu: Intel(R) Xeon(R) Processor
+BenchmarkBalanceNetloadPriority_BNPScore10          158859              7863 ns/op
+BenchmarkBalanceNetloadPriority_BNPScore100          68304             19718 ns/op
+BenchmarkBalanceNetloadPriority_BNPScore1000          7692            149302 ns/op
+BenchmarkBalanceNetloadPriority_BNPScore10000          831           1317172 ns/op
+BenchmarkBalanceNetloadPriority_BNPScore50000          176           8793607 ns/op
+BenchmarkBalanceNetloadPriority_Score10              97124             14104 ns/op
+BenchmarkBalanceNetloadPriority_Score100             32116             44409 ns/op
+BenchmarkBalanceNetloadPriority_Score1000             2578            454014 ns/op
+BenchmarkBalanceNetloadPriority_Score10000             255           4950532 ns/op
+BenchmarkBalanceNetloadPriority_Score50000              33          32827446 ns/op
+BenchmarkCMDNPriority_Score10                        54824             18956 ns/op
+BenchmarkCMDNPriority_Score100                        7740            160892 ns/op
+BenchmarkCMDNPriority_Score1000                        894           1393264 ns/op
+BenchmarkCMDNPriority_Score10000                        58          18340605 ns/op
+BenchmarkCMDNPriority_Score50000                         9         117722856 ns/op
+BenchmarkCMDNPriority_ScoreCached1000                  3580            414519 ns/op
+BenchmarkCMDNPriority_ScoreCached10000                  211           5793169 ns/op
+BenchmarkCMDNPriority_ScoreCached50000                   27          41498622 ns/op
 PASS
-ok      liang/internal/service  24.979s
-EOF
\ No newline at end of file
+ok      liang/internal/service  24.461s
+EOF
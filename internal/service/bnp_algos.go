@@ -24,7 +24,11 @@ func GetDefaultScore(names []string) extenderv1.HostPriorityList {
 	return res
 }
 
-type BalanceNetloadPriority struct{}
+// BalanceNetloadPriority 按调度后节点网络负载比例的方差评分
+// Stochastic不为空时使用有效带宽模型，Pod的LiangNetIO为需求的均值，节点当前负载和Pod需求都加上k倍标准差后再过滤和评分
+type BalanceNetloadPriority struct {
+	Stochastic *model.StochasticNet
+}
 
 // Score Node评分算法
 // 需要的Node动态资源信息已经在ExtendResource中提供了，Score算法要结合Pod中的资源请求
@@ -32,15 +36,53 @@ type BalanceNetloadPriority struct{}
 // HTTPExtender统一分配一个直接的权重
 // 动态可压缩资源在Pod.MetaData的Annotation中以map形式定义
 func (algo *BalanceNetloadPriority) Score(pod *v1.Pod, nodeNames []string, curMap map[string]int64, capMap map[string]int64) (extenderv1.HostPriorityList, error) {
+	return algo.score(pod, nodeNames, curMap, capMap, nil)
+}
+
+// Explain 评分并返回每个节点调度前后的负载和方差，以及被过滤掉的节点
+func (algo *BalanceNetloadPriority) Explain(pod *v1.Pod, nodeNames []string, curMap map[string]int64, capMap map[string]int64) (extenderv1.HostPriorityList, *model.BNPExplanation, error) {
+	explain := &model.BNPExplanation{}
+	res, err := algo.score(pod, nodeNames, curMap, capMap, explain)
+
+	return res, explain, err
+}
+
+func (algo *BalanceNetloadPriority) score(pod *v1.Pod, nodeNames []string, curMap map[string]int64, capMap map[string]int64, explain *model.BNPExplanation) (extenderv1.HostPriorityList, error) {
 	log.V(5).Info("BalanceNetloadPriority Score - nodeNames: %v, curMap: %v, capMap: %v", nodeNames, curMap, capMap)
 	netNeed := GetPodNetIONeed(pod)
+	var netStd float64
+	if algo.Stochastic != nil {
+		netStd = GetPodNetStddev(pod, algo.Stochastic.K)
+	}
 	emptyScore := GetDefaultScore(nodeNames)
-	if netNeed == 0 {
+	if explain != nil {
+		explain.NetNeed = netNeed
+		explain.NetStddev = netStd
+	}
+	if netNeed == 0 && netStd == 0 {
 		log.V(3).Info("BalanceNetloadPriority - Score net need is %d, skip", netNeed)
 		return emptyScore, nil
 	}
 	nodeNum := len(nodeNames)
-	validNames, curArr, capArr := FilterNodeByNet(nodeNames, netNeed, curMap, capMap)
+	var (
+		validNames                       []string
+		curArr, deltaArr, capArr, stdArr []float64
+		rejected                         []model.NodeRejection
+	)
+	if algo.Stochastic != nil {
+		nodeStd := algo.Stochastic.Stddev[model.ResourceNetIOKey]
+		validNames, curArr, deltaArr, capArr, rejected = FilterNodeByEffectiveNet(nodeNames, netNeed, netStd, curMap, capMap, algo.Stochastic, model.ResourceNetIOKey)
+		stdArr = make([]float64, len(validNames))
+		for i, name := range validNames {
+			stdArr[i] = nodeStd[name]
+		}
+	} else {
+		validNames, curArr, capArr, rejected = FilterNodeByNetWithReason(nodeNames, netNeed, curMap, capMap)
+		deltaArr = uniformDelta(float64(netNeed), len(validNames))
+	}
+	if explain != nil {
+		explain.Rejected = rejected
+	}
 
 	// 没有一个node符合条件
 	if len(validNames) == 0 {
@@ -48,7 +90,13 @@ func (algo *BalanceNetloadPriority) Score(pod *v1.Pod, nodeNames []string, curMa
 		return emptyScore, nil
 	}
 
-	scoreMap := algo.BNPScore(validNames, netNeed, curArr, capArr)
+	scoreMap := algo.bnpScore(validNames, deltaArr, curArr, capArr, explain)
+	if explain != nil && algo.Stochastic != nil {
+		explain.K = algo.Stochastic.K
+		for i := range explain.Nodes {
+			explain.Nodes[i].Stddev = stdArr[i]
+		}
+	}
 	scoreRes := make(extenderv1.HostPriorityList, nodeNum)
 	for i := 0; i < nodeNum; i++ {
 		nodeName := nodeNames[i]
@@ -71,72 +119,281 @@ func (algo *BalanceNetloadPriority) Score(pod *v1.Pod, nodeNames []string, curMa
 // BNPScore 内部评分函数
 // needed 单位 Kbit/s, curMap、capMap单位Kbit/s
 func (algo *BalanceNetloadPriority) BNPScore(nodeNames []string, needed int64, curMap, capMap []float64) map[string]int64 {
-	log.V(5).Info("BalanceNetloadPriority BNPScore - nodeNames: %v, needed: %d, curArr: %v, capArr: %v", nodeNames, needed, curMap, capMap)
-	nodeNum := len(nodeNames)
 	// 如果needed为0，则BNP算法没有意义，所有节点评分为0
 	if needed == 0 {
 		scoreMap := make(map[string]int64)
-		for i := 0; i < nodeNum; i++ {
-			nodeName := nodeNames[i]
+		for _, nodeName := range nodeNames {
 			scoreMap[nodeName] = model.MinNodeScore
 		}
 
 		return scoreMap
 	}
 
+	return algo.bnpScore(nodeNames, uniformDelta(float64(needed), len(nodeNames)), curMap, capMap, nil)
+}
+
+// bnpScore delta为Pod调度到每个节点后该节点负载的增量，确定的需求时每个节点相同
+func (algo *BalanceNetloadPriority) bnpScore(nodeNames []string, delta, curMap, capMap []float64, explain *model.BNPExplanation) map[string]int64 {
+	log.V(5).Info("BalanceNetloadPriority BNPScore - nodeNames: %v, delta: %v, curArr: %v, capArr: %v", nodeNames, delta, curMap, capMap)
+	nodeNum := len(nodeNames)
 	if nodeNum == 0 {
 		scoreArr := make(map[string]int64)
 		return scoreArr
 	}
 
 	if nodeNum == 1 {
+		if explain != nil {
+			explain.Nodes = []model.BNPNodeExplanation{{
+				Host:     nodeNames[0],
+				Current:  curMap[0],
+				Capacity: capMap[0],
+				CurLoad:  curMap[0] / capMap[0],
+				NewLoad:  (curMap[0] + delta[0]) / capMap[0],
+				Score:    model.MaxNodeScore,
+			}}
+		}
 		return map[string]int64{
 			nodeNames[0]: model.MaxNodeScore,
 		}
 	}
 
+	curLoad, newLoad, loadDiff := placementVarianceDelta(delta, curMap, capMap)
+	scoreArr := normalizeVariance(nodeNames, loadDiff)
+
+	if explain != nil {
+		explain.Nodes = make([]model.BNPNodeExplanation, nodeNum)
+		for i := 0; i < nodeNum; i++ {
+			explain.Nodes[i] = model.BNPNodeExplanation{
+				Host:     nodeNames[i],
+				Current:  curMap[i],
+				Capacity: capMap[i],
+				CurLoad:  curLoad[i],
+				NewLoad:  newLoad[i],
+				Variance: loadDiff[i],
+				Score:    scoreArr[nodeNames[i]],
+			}
+		}
+	}
+
+	log.V(3).Info("scoreArr: %v", scoreArr)
+	return scoreArr
+}
+
+// uniformDelta 每个节点的负载增量都为needed
+func uniformDelta(needed float64, n int) []float64 {
+	delta := make([]float64, n)
+	for i := range delta {
+		delta[i] = needed
+	}
+
+	return delta
+}
+
+// placementVariance 计算当前负载比例、Pod调度到节点i后节点i的负载比例，以及调度到节点i后所有节点负载比例的方差
+// 调度到节点i只改变第i个负载，预先计算偏差之和与偏差平方和后每个节点的方差为O(1)，整体为O(n)
+// 与stat.Variance相同，结果为样本方差，节点数小于2时方差为0
+func placementVariance(needed int64, curArr, capArr []float64) (curLoad, newLoad, variance []float64) {
+	return placementVarianceDelta(uniformDelta(float64(needed), len(curArr)), curArr, capArr)
+}
+
+// placementVarianceDelta 同placementVariance，Pod调度到节点i后节点i的负载增量为delta[i]
+func placementVarianceDelta(delta, curArr, capArr []float64) (curLoad, newLoad, variance []float64) {
+	nodeNum := len(curArr)
 	// 1. 计算当前节点的负载
-	curLoad := make([]float64, nodeNum)
+	curLoad = make([]float64, nodeNum)
 	for i := 0; i < nodeNum; i++ {
-		curLoad[i] = curMap[i] / capMap[i]
+		curLoad[i] = curArr[i] / capArr[i]
 	}
 	log.V(5).Info("BalanceNetloadPriority BNPScore - curload %v", curLoad)
 
 	// 2. 计算pod调度到节点i的负载
-	newLoad := make([]float64, nodeNum)
+	newLoad = make([]float64, nodeNum)
 	for i := 0; i < nodeNum; i++ {
-		newLoad[i] = (curMap[i] + float64(needed)) / capMap[i]
+		newLoad[i] = (curArr[i] + delta[i]) / capArr[i]
 	}
 
-	// 3. 计算2中所有节点的平均负载
-	// newAvgLoad := make([]float64, nodeNum)
-	loadDiff := make([]float64, nodeNum)
-	for i := 0; i < nodeNum; i++ {
-		tmp := curLoad[i]
-		curLoad[i] = newLoad[i]
+	variance = make([]float64, nodeNum)
+	if nodeNum < 2 {
+		return curLoad, newLoad, variance
+	}
 
-		// 求均值和标准差
-		// newAvgLoad[i] = stat.Mean(curLoad, nil)
-		loadDiff[i] = stat.Variance(curLoad, nil)
+	// 3. 以当前平均负载为中心计算偏差之和与偏差平方和，避免直接使用平方和时的精度损失
+	n := float64(nodeNum)
+	mean := stat.Mean(curLoad, nil)
+	var sumDev, sumSq float64
+	for _, load := range curLoad {
+		d := load - mean
+		sumDev += d
+		sumSq += d * d
+	}
 
-		curLoad[i] = tmp
+	// 4. 替换第i个负载后的方差：(Σd² - (Σd)²/n) / (n-1)
+	for i := 0; i < nodeNum; i++ {
+		oldDev, newDev := curLoad[i]-mean, newLoad[i]-mean
+		s := sumDev - oldDev + newDev
+		q := sumSq - oldDev*oldDev + newDev*newDev
+		variance[i] = (q - s*s/n) / (n - 1)
 	}
 
-	// 4. 计算并且正则化各个节点的得分
-	loadMin, loadMax := floats.Min(loadDiff), floats.Max(loadDiff)
+	return curLoad, newLoad, variance
+}
+
+// normalizeVariance 方差越小得分越高，正则化到[MinNodeScore, MaxNodeScore]，方差都相同时为最低分
+func normalizeVariance(nodeNames []string, variance []float64) map[string]int64 {
+	loadMin, loadMax := floats.Min(variance), floats.Max(variance)
 	loadBase := loadMax - loadMin
 	log.V(5).Info("loadMax: %f, loadMin: %f, loadBase: %v", loadMax, loadMin, loadBase)
-	log.V(5).Info("loadDiff: %v", loadDiff)
+	log.V(5).Info("loadDiff: %v", variance)
 	scoreArr := make(map[string]int64)
-	for i := 0; i < nodeNum; i++ {
-		nodeName := nodeNames[i]
+	for i, nodeName := range nodeNames {
 		if loadBase != 0.0 {
-			scoreArr[nodeName] = int64(model.MaxNodeScore - (model.MaxNodeScore * (loadDiff[i] - loadMin) / loadBase))
+			scoreArr[nodeName] = int64(model.MaxNodeScore - (model.MaxNodeScore * (variance[i] - loadMin) / loadBase))
 		} else {
 			scoreArr[nodeName] = model.MinNodeScore
 		}
 	}
 
-	log.V(3).Info("scoreArr: %v", scoreArr)
 	return scoreArr
 }
+
+// ScoreDuplex 分别计算下行和上行方向的负载方差，按两个方向方差之和评分
+// curIn、curOut为节点两个方向的网络负载，单位Kbit/s
+func (algo *BalanceNetloadPriority) ScoreDuplex(pod *v1.Pod, nodeNames []string, curIn, curOut map[string]int64, capMap map[string]model.NetCapacity) (extenderv1.HostPriorityList, error) {
+	return algo.scoreDuplex(pod, nodeNames, curIn, curOut, capMap, nil)
+}
+
+// ExplainDuplex 分方向评分并返回每个节点两个方向调度前后的负载和方差
+func (algo *BalanceNetloadPriority) ExplainDuplex(pod *v1.Pod, nodeNames []string, curIn, curOut map[string]int64, capMap map[string]model.NetCapacity) (extenderv1.HostPriorityList, *model.BNPExplanation, error) {
+	explain := &model.BNPExplanation{}
+	res, err := algo.scoreDuplex(pod, nodeNames, curIn, curOut, capMap, explain)
+
+	return res, explain, err
+}
+
+func (algo *BalanceNetloadPriority) scoreDuplex(pod *v1.Pod, nodeNames []string, curIn, curOut map[string]int64, capMap map[string]model.NetCapacity, explain *model.BNPExplanation) (extenderv1.HostPriorityList, error) {
+	log.V(5).Info("BalanceNetloadPriority ScoreDuplex - nodeNames: %v, curIn: %v, curOut: %v, capMap: %v", nodeNames, curIn, curOut, capMap)
+	demand := GetPodNetDemand(pod)
+	netIO := GetPodNetIONeed(pod)
+	var netStd, inStd, outStd float64
+	if algo.Stochastic != nil {
+		netStd = GetPodNetStddev(pod, algo.Stochastic.K)
+		inStd, outStd = podDirectionStddev(netStd, demand, netIO)
+	}
+	emptyScore := GetDefaultScore(nodeNames)
+	if explain != nil {
+		explain.NetNeed = netIO
+		explain.NetDemand = &demand
+		explain.NetStddev = netStd
+	}
+	if demand.In == 0 && demand.Out == 0 && inStd == 0 && outStd == 0 {
+		log.V(3).Info("BalanceNetloadPriority - ScoreDuplex net demand is %+v, skip", demand)
+		return emptyScore, nil
+	}
+	var (
+		validNames []string
+		rejected   []model.NodeRejection
+	)
+	if algo.Stochastic != nil {
+		validNames, rejected = filterEffectiveDirections(algo.Stochastic, nodeNames, demand, inStd, outStd, curIn, curOut, capMap)
+	} else {
+		validNames, rejected = FilterNodeByNetDirections(nodeNames, demand, curIn, curOut, capMap)
+	}
+	if explain != nil {
+		explain.Rejected = rejected
+	}
+	if len(validNames) == 0 {
+		log.V(3).Info("none nodes is valid, all nodes's score is 0")
+		return emptyScore, nil
+	}
+
+	nodeNum := len(validNames)
+	inArr, inCapArr := make([]float64, nodeNum), make([]float64, nodeNum)
+	outArr, outCapArr := make([]float64, nodeNum), make([]float64, nodeNum)
+	inDelta, outDelta := uniformDelta(float64(demand.In), nodeNum), uniformDelta(float64(demand.Out), nodeNum)
+	inStdArr, outStdArr := make([]float64, nodeNum), make([]float64, nodeNum)
+	for i, name := range validNames {
+		inArr[i], inCapArr[i] = float64(curIn[name]), float64(capMap[name].In)
+		outArr[i], outCapArr[i] = float64(curOut[name]), float64(capMap[name].Out)
+		if algo.Stochastic != nil {
+			k := algo.Stochastic.K
+			inStdArr[i] = algo.Stochastic.Stddev[model.ResourceNetInKey][name]
+			outStdArr[i] = algo.Stochastic.Stddev[model.ResourceNetOutKey][name]
+			inMean := nodeNetMean(algo.Stochastic, model.ResourceNetInKey, name, inArr[i])
+			outMean := nodeNetMean(algo.Stochastic, model.ResourceNetOutKey, name, outArr[i])
+			inArr[i], inDelta[i] = effectiveBandwidth(inMean, inStdArr[i], float64(demand.In), inStd, k)
+			outArr[i], outDelta[i] = effectiveBandwidth(outMean, outStdArr[i], float64(demand.Out), outStd, k)
+		}
+	}
+	inCur, inNew, inVar := placementVarianceDelta(inDelta, inArr, inCapArr)
+	outCur, outNew, outVar := placementVarianceDelta(outDelta, outArr, outCapArr)
+
+	var scoreMap map[string]int64
+	if nodeNum == 1 {
+		scoreMap = map[string]int64{validNames[0]: model.MaxNodeScore}
+	} else {
+		variance := make([]float64, nodeNum)
+		for i := range variance {
+			variance[i] = inVar[i] + outVar[i]
+		}
+		scoreMap = normalizeVariance(validNames, variance)
+	}
+
+	if explain != nil {
+		explain.Nodes = make([]model.BNPNodeExplanation, nodeNum)
+		for i, name := range validNames {
+			explain.Nodes[i] = model.BNPNodeExplanation{
+				Host:        name,
+				Current:     inArr[i],
+				Stddev:      inStdArr[i],
+				Capacity:    inCapArr[i],
+				CurLoad:     inCur[i],
+				NewLoad:     inNew[i],
+				Variance:    inVar[i],
+				OutCurrent:  outArr[i],
+				OutStddev:   outStdArr[i],
+				OutCapacity: outCapArr[i],
+				OutCurLoad:  outCur[i],
+				OutNewLoad:  outNew[i],
+				OutVariance: outVar[i],
+				Score:       scoreMap[name],
+			}
+		}
+	}
+
+	if explain != nil && algo.Stochastic != nil {
+		explain.K = algo.Stochastic.K
+	}
+
+	scoreRes := make(extenderv1.HostPriorityList, len(nodeNames))
+	for i, name := range nodeNames {
+		score, ok := scoreMap[name]
+		if !ok {
+			score = model.MinNodeScore
+		}
+		scoreRes[i] = extenderv1.HostPriority{Host: name, Score: score}
+	}
+
+	return scoreRes, nil
+}
+
+// filterEffectiveDirections 同FilterNodeByNetDirections，两个方向都按有效带宽过滤
+func filterEffectiveDirections(net *model.StochasticNet, nodeNames []string, need model.NetDemand, inStd, outStd float64,
+	curIn, curOut map[string]int64, capMap map[string]model.NetCapacity) (valideNames []string, rejected []model.NodeRejection) {
+	capIn := make(map[string]int64, len(capMap))
+	capOut := make(map[string]int64, len(capMap))
+	for name, c := range capMap {
+		capIn[name] = c.In
+		capOut[name] = c.Out
+	}
+
+	inNames, _, _, _, inRejected := FilterNodeByEffectiveNet(nodeNames, need.In, inStd, curIn, capIn, net, model.ResourceNetInKey)
+	outNames, _, _, _, outRejected := FilterNodeByEffectiveNet(inNames, need.Out, outStd, curOut, capOut, net, model.ResourceNetOutKey)
+	for _, r := range inRejected {
+		rejected = append(rejected, model.NodeRejection{Host: r.Host, Reason: "in: " + r.Reason})
+	}
+	for _, r := range outRejected {
+		rejected = append(rejected, model.NodeRejection{Host: r.Host, Reason: "out: " + r.Reason})
+	}
+
+	return outNames, rejected
+}
@@ -2,11 +2,15 @@ package service
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
+	"reflect"
 	"testing"
 
 	"liang/internal/model"
 
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/stat"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
@@ -269,6 +273,116 @@ func TestBalanceNetloadPriority_Score(t *testing.T) {
 	}
 }
 
+func TestBalanceNetloadPriority_ScoreDuplex(t *testing.T) {
+	// 上行为主的CDN Pod
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				model.ResourceNetIOKey:  "10",
+				model.ResourceNetOutKey: "200",
+			},
+		},
+	}
+	nodeNames := []string{"node1", "node2", "node3"}
+	curIn := map[string]int64{"node1": 600 * model.KbitPS, "node2": 100 * model.KbitPS, "node3": 300 * model.KbitPS}
+	curOut := map[string]int64{"node1": 100 * model.KbitPS, "node2": 600 * model.KbitPS, "node3": 300 * model.KbitPS}
+	capMap := map[string]model.NetCapacity{
+		"node1": {In: 1000 * model.KbitPS, Out: 1000 * model.KbitPS},
+		"node2": {In: 1000 * model.KbitPS, Out: 1000 * model.KbitPS},
+		"node3": {In: 1000 * model.KbitPS, Out: 1000 * model.KbitPS},
+	}
+
+	bnp := BalanceNetloadPriority{}
+	res, explain, err := bnp.ExplainDuplex(pod, nodeNames, curIn, curOut, capMap)
+	if err != nil {
+		t.Fatalf("score duplex error: %v", err)
+	}
+	expected := extenderv1.HostPriorityList{
+		{Host: "node1", Score: model.MaxNodeScore},
+		{Host: "node2", Score: model.MinNodeScore},
+		{Host: "node3", Score: 61},
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("upload-heavy pod should prefer node with low upload load, expected %v, but get %v", expected, res)
+	}
+	if len(explain.Nodes) != 3 || explain.Nodes[0].OutVariance == 0 || explain.NetDemand == nil {
+		t.Errorf("explanation should contain both directions, but get %+v", explain)
+	}
+
+	// 只看下行负载时会选择上行已经很忙的node2
+	capNet := map[string]int64{"node1": 1000 * model.KbitPS, "node2": 1000 * model.KbitPS, "node3": 1000 * model.KbitPS}
+	res, _ = bnp.Score(pod, nodeNames, curIn, capNet)
+	if res[1].Score != model.MaxNodeScore {
+		t.Errorf("download only score should prefer node2, but get %v", res)
+	}
+}
+
+// naiveBNPScore 原来O(n²)的实现，每个候选节点重新计算所有节点负载的方差，作为对照
+func naiveBNPScore(nodeNames []string, needed int64, curArr, capArr []float64) map[string]int64 {
+	nodeNum := len(nodeNames)
+	curLoad := make([]float64, nodeNum)
+	for i := 0; i < nodeNum; i++ {
+		curLoad[i] = curArr[i] / capArr[i]
+	}
+	loadDiff := make([]float64, nodeNum)
+	for i := 0; i < nodeNum; i++ {
+		tmp := curLoad[i]
+		curLoad[i] = (curArr[i] + float64(needed)) / capArr[i]
+		loadDiff[i] = stat.Variance(curLoad, nil)
+		curLoad[i] = tmp
+	}
+
+	loadMin, loadMax := floats.Min(loadDiff), floats.Max(loadDiff)
+	loadBase := loadMax - loadMin
+	scoreArr := make(map[string]int64)
+	for i := 0; i < nodeNum; i++ {
+		if loadBase != 0.0 {
+			scoreArr[nodeNames[i]] = int64(model.MaxNodeScore - (model.MaxNodeScore * (loadDiff[i] - loadMin) / loadBase))
+		} else {
+			scoreArr[nodeNames[i]] = model.MinNodeScore
+		}
+	}
+
+	return scoreArr
+}
+
+func TestBalanceNetloadPriority_BNPScoreIncremental(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	bnp := BalanceNetloadPriority{}
+	for _, num := range []int{2, 3, 10, 100, 1000, 3000} {
+		for round := 0; round < 20; round++ {
+			nodeNames := make([]string, num)
+			curArr := make([]float64, num)
+			capArr := make([]float64, num)
+			for i := 0; i < num; i++ {
+				nodeNames[i] = fmt.Sprintf("node-%d", i)
+				capArr[i] = float64(r.Intn(100)+1) * 100 * model.KbitPS
+				curArr[i] = math.Floor(r.Float64() * capArr[i])
+			}
+			needed := int64(r.Intn(1000)+1) * model.KbitPS
+
+			expected := naiveBNPScore(nodeNames, needed, curArr, capArr)
+			res := bnp.BNPScore(nodeNames, needed, curArr, capArr)
+			if !reflect.DeepEqual(res, expected) {
+				for _, name := range nodeNames {
+					if res[name] != expected[name] {
+						t.Fatalf("%d nodes round %d: score of %s should be %d, but get %d", num, round, name, expected[name], res[name])
+					}
+				}
+			}
+		}
+	}
+
+	// 所有节点负载和带宽相同时方差相同，都是最低分
+	nodeNames := []string{"node1", "node2", "node3"}
+	res := bnp.BNPScore(nodeNames, model.KbitPS, []float64{1000, 1000, 1000}, []float64{model.GbitPS, model.GbitPS, model.GbitPS})
+	for _, name := range nodeNames {
+		if res[name] != model.MinNodeScore {
+			t.Errorf("nodes with the same load should get min score, but get %v", res)
+		}
+	}
+}
+
 // 模拟100个Node，1000个Node和10000个Node的算法性能
 type BNPTester struct {
 	Name      string
@@ -352,6 +466,9 @@ func BenchmarkBalanceNetloadPriority_BNPScore1000(b *testing.B) {
 func BenchmarkBalanceNetloadPriority_BNPScore10000(b *testing.B) {
 	benchmarkBalanceNetloadPriority_BNPScore(10000, b)
 }
+func BenchmarkBalanceNetloadPriority_BNPScore50000(b *testing.B) {
+	benchmarkBalanceNetloadPriority_BNPScore(50000, b)
+}
 
 func benchmarkBalanceNetloadPriority_Score(n int, b *testing.B) {
 	BDPAlgo := BalanceNetloadPriority{}
@@ -364,7 +481,7 @@ func benchmarkBalanceNetloadPriority_Score(n int, b *testing.B) {
 }
 
 func BenchmarkBalanceNetloadPriority_Score10(b *testing.B) {
-	benchmarkBalanceNetloadPriority_BNPScore(10, b)
+	benchmarkBalanceNetloadPriority_Score(10, b)
 }
 func BenchmarkBalanceNetloadPriority_Score100(b *testing.B) {
 	benchmarkBalanceNetloadPriority_Score(100, b)
@@ -375,3 +492,67 @@ func BenchmarkBalanceNetloadPriority_Score1000(b *testing.B) {
 func BenchmarkBalanceNetloadPriority_Score10000(b *testing.B) {
 	benchmarkBalanceNetloadPriority_Score(10000, b)
 }
+func BenchmarkBalanceNetloadPriority_Score50000(b *testing.B) {
+	benchmarkBalanceNetloadPriority_Score(50000, b)
+}
+
+func TestBalanceNetloadPriority_Explain(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				model.ResourceNetIOKey: "1",
+			},
+		},
+	}
+	nodeNames := []string{"node1", "node2", "node3", "node4", "node5"}
+	curMap := map[string]int64{
+		"node1": 512,
+		"node2": 4096,
+		"node3": 2048,
+		"node4": model.MbitPS,
+	}
+	capMap := map[string]int64{
+		"node1": model.MbitPS,
+		"node2": model.MbitPS,
+		"node3": model.MbitPS,
+		"node4": model.MbitPS,
+		"node5": model.MbitPS,
+	}
+
+	bnp := BalanceNetloadPriority{}
+	res, explain, err := bnp.Explain(pod, nodeNames, curMap, capMap)
+	if err != nil {
+		t.Fatalf("explain error: %v", err)
+	}
+	expected, _ := bnp.Score(pod, nodeNames, curMap, capMap)
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("result of Explain %v and Score %v not equal", res, expected)
+	}
+
+	if explain.NetNeed != model.KbitPS {
+		t.Errorf("net need should be %d, but get %d", model.KbitPS, explain.NetNeed)
+	}
+	rejected := map[string]bool{}
+	for _, r := range explain.Rejected {
+		rejected[r.Host] = true
+	}
+	if len(explain.Rejected) != 2 || !rejected["node4"] || !rejected["node5"] {
+		t.Errorf("node4 and node5 should be rejected, but get %v", explain.Rejected)
+	}
+
+	if len(explain.Nodes) != 3 {
+		t.Fatalf("num of explained nodes should be 3, but get %d", len(explain.Nodes))
+	}
+	for i, node := range explain.Nodes {
+		if node.NewLoad <= node.CurLoad {
+			t.Errorf("new load %f of %s should be larger than cur load %f", node.NewLoad, node.Host, node.CurLoad)
+		}
+		if node.Score != res[i].Score {
+			t.Errorf("score of %s should be %d, but get %d", node.Host, res[i].Score, node.Score)
+		}
+	}
+	// node1负载最低，调度后方差最小
+	if explain.Nodes[0].Variance >= explain.Nodes[1].Variance || explain.Nodes[0].Variance >= explain.Nodes[2].Variance {
+		t.Errorf("variance of node1 should be the smallest, get %v", explain.Nodes)
+	}
+}
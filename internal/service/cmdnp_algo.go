@@ -9,67 +9,159 @@ import (
 	"liang/internal/utils"
 
 	"github.com/go-kratos/kratos/pkg/log"
+	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/mat"
 	v1 "k8s.io/api/core/v1"
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
 )
 
 // CMDNPriority
-type CMDNPriority struct{}
+type CMDNPriority struct {
+	Cache      *CMDNCache           // 同步指标后预先计算的评分数据，为空或者与快照不匹配时重新计算
+	Version    int64                // 评分使用的快照版本号，与Cache的版本号相同时才使用Cache，为0时不使用
+	Stochastic *model.StochasticNet // 有效带宽模型的输入，不为空时按有效带宽过滤节点，与bnp相同
+	Weights    map[string]float64   // TOPSIS各指标的权重，key为cmdnCriteria中的指标，没有配置的指标权重为1
+}
+
+// cmdnCriteria CMDN决策矩阵各列对应的指标
+var cmdnCriteria = []string{model.ResourceCPUKey, model.ResourceMemKey, model.ResourceNetIOKey, model.ResourceDiskIOKey, model.ResourceNetCapKey}
+
+// cmdnDiskColumn 磁盘IO在cmdnCriteria中的列
+const cmdnDiskColumn = 3
+
+// maxDiskUsage 磁盘使用率的上限，超过能力的磁盘IO按照满载计算
+const maxDiskUsage = 100
+
+// cmdnWeights 按cmdnCriteria的顺序排列的TOPSIS权重，没有配置的指标权重为1
+func cmdnWeights(weights map[string]float64) []float64 {
+	res := make([]float64, len(cmdnCriteria))
+	for i, key := range cmdnCriteria {
+		res[i] = 1
+		if w, ok := weights[key]; ok {
+			res[i] = w
+		}
+	}
+
+	return res
+}
+
+// ValidateCMDNWeights 检查TOPSIS权重的指标是否存在，权重不能为负数且不能全部为0
+func ValidateCMDNWeights(weights map[string]float64) error {
+	for key, w := range weights {
+		found := false
+		for _, c := range cmdnCriteria {
+			found = found || c == key
+		}
+		if !found {
+			return fmt.Errorf("cmdn weight of %s should be one of %v", key, cmdnCriteria)
+		}
+		if w < 0 {
+			return fmt.Errorf("cmdn weight of %s should not be negative", key)
+		}
+	}
+	if len(weights) > 0 && floats.Max(cmdnWeights(weights)) == 0 {
+		return fmt.Errorf("cmdn weights should not be all zero")
+	}
+
+	return nil
+}
 
 // Score
 func (cmdn *CMDNPriority) Score(pod *v1.Pod, nodeNames []string, netCapMap map[string]int64, cacheData map[string](map[string]int64)) (extenderv1.HostPriorityList, error) {
+	return cmdn.score(pod, nodeNames, netCapMap, cacheData, nil)
+}
+
+// Explain 评分并返回TOPSIS计算的决策矩阵、正规化矩阵、理想解和距离等中间结果
+func (cmdn *CMDNPriority) Explain(pod *v1.Pod, nodeNames []string, netCapMap map[string]int64, cacheData map[string](map[string]int64)) (extenderv1.HostPriorityList, *model.CMDNExplanation, error) {
+	explain := &model.CMDNExplanation{Criteria: cmdnCriteria}
+	res, err := cmdn.score(pod, nodeNames, netCapMap, cacheData, explain)
+
+	return res, explain, err
+}
+
+func (cmdn *CMDNPriority) score(pod *v1.Pod, nodeNames []string, netCapMap map[string]int64, cacheData map[string](map[string]int64), explain *model.CMDNExplanation) (extenderv1.HostPriorityList, error) {
 	emptyScore := GetDefaultScore(nodeNames)
 	keys := []string{model.ResourceCPUKey, model.ResourceMemKey, model.ResourceNetIOKey, model.ResourceDiskIOKey}
 	if err := ValidateCacheData(keys, cacheData); err != nil {
 		return emptyScore, err
 	}
 
-	// 根据资源需求、负载等因素过滤掉一些Node
+	// 根据资源需求、负载等因素过滤掉一些Node，有分方向的网络负载时两个方向分别检查
 	netNeed := GetPodNetIONeed(pod)
 	curNetMap := cacheData[model.ResourceNetIOKey]
-	validNames, _, _ := FilterNodeByNet(nodeNames, netNeed, curNetMap, netCapMap)
+	netCapDir, duplex := GetNetCapacities(cacheData, nodeNames)
+	var (
+		validNames []string
+		rejected   []model.NodeRejection
+	)
+	var netStd float64
+	if cmdn.Stochastic != nil {
+		netStd = GetPodNetStddev(pod, cmdn.Stochastic.K)
+	}
+	if duplex {
+		demand := GetPodNetDemand(pod)
+		curIn, curOut := cacheData[model.ResourceNetInKey], cacheData[model.ResourceNetOutKey]
+		if cmdn.Stochastic != nil {
+			inStd, outStd := podDirectionStddev(netStd, demand, netNeed)
+			validNames, rejected = filterEffectiveDirections(cmdn.Stochastic, nodeNames, demand, inStd, outStd, curIn, curOut, netCapDir)
+		} else {
+			validNames, rejected = FilterNodeByNetDirections(nodeNames, demand, curIn, curOut, netCapDir)
+		}
+		if explain != nil {
+			explain.NetDemand = &demand
+		}
+	} else if cmdn.Stochastic != nil {
+		validNames, _, _, _, rejected = FilterNodeByEffectiveNet(nodeNames, netNeed, netStd, curNetMap, netCapMap, cmdn.Stochastic, model.ResourceNetIOKey)
+	} else {
+		validNames, _, _, rejected = FilterNodeByNetWithReason(nodeNames, netNeed, curNetMap, netCapMap)
+	}
+	if explain != nil {
+		explain.NetNeed = netNeed
+		explain.NetStddev = netStd
+		explain.Rejected = rejected
+	}
 	if len(validNames) == 0 {
 		log.Warn("none nodes is valid, all nodes's score is 0")
 		return emptyScore, nil
 	}
 
-	// 同向化指标
-	// TODO: 这里存在问题，因为网卡带宽能力很大，当前NetIO很小时，返回都是0
-	netUsageTmpMap := CalcNetUsage(validNames, curNetMap, netCapMap)
-	netArr := GetUsageArray(model.UsageUpperLimit, validNames, netUsageTmpMap)
-	netCapArr := GetNetCapArr(validNames, netCapMap)
-
-	diskMap := cacheData[model.ResourceDiskIOKey]
-	diskArr := GetUsageArray(model.UsageUpperLimit, validNames, diskMap)
-	cpuMap := cacheData[model.ResourceCPUKey]
-	cpuArr := GetUsageArray(model.UsageUpperLimit, validNames, cpuMap)
-	memMap := cacheData[model.ResourceMemKey]
-	memArr := GetUsageArray(model.UsageUpperLimit, validNames, memMap)
-
-	// 形成矩阵，计算TOPSIS结果
-	nodeNum := len(validNames)
-	colArr := [][]float64{cpuArr, memArr, netArr, diskArr, netCapArr}
-	row := nodeNum
-	col := len(colArr)
-	matrix := mat.NewDense(row, col, nil)
-	for i := 0; i < col; i++ {
-		matrix.SetCol(i, colArr[i])
+	// 形成矩阵，计算TOPSIS结果，有预先计算的数据时直接使用
+	weights := cmdnWeights(cmdn.Weights)
+	matrix, detail, cached := cmdn.Cache.assemble(cmdn.Version, validNames, netCapMap, cacheData, duplex, weights)
+	if !cached {
+		matrix = cmdnMatrix(validNames, netCapMap, cacheData, netCapDir, duplex)
 	}
 	log.V(5).Info("origin resource and node matrix is: \n%v", mat.Formatted(matrix))
+	if explain != nil {
+		explain.Nodes = validNames
+		explain.Matrix = denseRows(matrix)
+	}
 
-	topScore, err := utils.CalcTOPSIS(matrix)
-	if err != nil {
-		log.Error("calc topsis error: %v", err)
-		log.Error("matrix is:\n%v", mat.Formatted(matrix))
-		return emptyScore, err
+	if detail == nil {
+		var err error
+		detail, err = utils.CalcWeightedTOPSISDetail(matrix, weights)
+		if err != nil {
+			log.Error("calc topsis error: %v", err)
+			log.Error("matrix is:\n%v", mat.Formatted(matrix))
+			return emptyScore, err
+		}
+	}
+	topScore := detail.Closeness
+	if explain != nil {
+		explain.Normalized = denseRows(detail.Normalized)
+		explain.Weights = detail.Weights
+		explain.Ideal = detail.Ideal
+		explain.AntiIdeal = detail.AntiIdeal
+		explain.DistanceToIdeal = detail.DPlus
+		explain.DistanceToAntiIdeal = detail.DMinus
+		explain.Closeness = detail.Closeness
 	}
 
-	// 结果100分制正规化
+	// 结果100分制正规化，被过滤掉的节点也要出现在结果中
 	scoreMap := cmdn.ConvertMap(validNames, topScore)
-	scoreRes := make(extenderv1.HostPriorityList, nodeNum)
+	scoreRes := make(extenderv1.HostPriorityList, len(nodeNames))
 	var score int64
-	for i := 0; i < nodeNum; i++ {
+	for i := 0; i < len(nodeNames); i++ {
 		name := nodeNames[i]
 		score = 0
 		if ss, ok := scoreMap[name]; ok {
@@ -90,7 +182,7 @@ func (cmdn *CMDNPriority) ConvertMap(nodeNames []string, scores []float64) map[s
 		panic("ConvertMap num of two arrays does not equal")
 	}
 
-	res := make(map[string]float64)
+	res := make(map[string]float64, num)
 	for i := 0; i < num; i++ {
 		name := nodeNames[i]
 		res[name] = scores[i]
@@ -99,6 +191,52 @@ func (cmdn *CMDNPriority) ConvertMap(nodeNames []string, scores []float64) map[s
 	return res
 }
 
+// cmdnMatrix 构造同向化后的决策矩阵，按行对应validNames，按列对应cmdnCriteria
+func cmdnMatrix(validNames []string, netCapMap map[string]int64, cacheData map[string](map[string]int64), netCapDir map[string]model.NetCapacity, duplex bool) *mat.Dense {
+	// TODO: 这里存在问题，因为网卡带宽能力很大，当前NetIO很小时，返回都是0
+	var netUsageTmpMap map[string]int64
+	if duplex {
+		netUsageTmpMap = CalcNetUsageDirections(validNames, cacheData[model.ResourceNetInKey], cacheData[model.ResourceNetOutKey], netCapDir)
+	} else {
+		netUsageTmpMap = CalcNetUsage(validNames, cacheData[model.ResourceNetIOKey], netCapMap)
+	}
+	netArr := GetUsageArray(model.UsageUpperLimit, validNames, netUsageTmpMap)
+	netCapArr := GetNetCapArr(validNames, netCapMap)
+
+	// 有磁盘能力时磁盘IO为使用率，接近满载的磁盘不能被当作空闲
+	var diskArr []float64
+	if usageMap, ok := cacheData[model.ResourceDiskUsageKey]; ok {
+		diskArr = GetCappedUsageArray(maxDiskUsage, validNames, usageMap)
+	} else if _, ok := cacheData[model.ResourceDiskCapKey]; ok {
+		diskArr = GetCappedUsageArray(maxDiskUsage, validNames, CalcDiskUsage(validNames, cacheData))
+	} else {
+		diskArr = GetUsageArray(model.UsageUpperLimit, validNames, cacheData[model.ResourceDiskIOKey])
+	}
+	cpuMap := cacheData[model.ResourceCPUKey]
+	cpuArr := GetUsageArray(model.UsageUpperLimit, validNames, cpuMap)
+	memMap := cacheData[model.ResourceMemKey]
+	memArr := GetUsageArray(model.UsageUpperLimit, validNames, memMap)
+
+	colArr := [][]float64{cpuArr, memArr, netArr, diskArr, netCapArr}
+	matrix := mat.NewDense(len(validNames), len(colArr), nil)
+	for i := range colArr {
+		matrix.SetCol(i, colArr[i])
+	}
+
+	return matrix
+}
+
+// denseRows 将矩阵按行复制为二维数组
+func denseRows(m *mat.Dense) [][]float64 {
+	row := m.RawMatrix().Rows
+	res := make([][]float64, row)
+	for i := 0; i < row; i++ {
+		res[i] = utils.GetDenseRow(m, i)
+	}
+
+	return res
+}
+
 // GetNetCapArr
 func GetNetCapArr(nodeNames []string, capMap map[string]int64) []float64 {
 	res := make([]float64, 0)
@@ -121,6 +259,81 @@ func CalcNetUsage(nodeNames []string, curMap, capMap map[string]int64) map[strin
 	return resMap
 }
 
+// CalcNetUsageDirections 计算网络使用率，取下行和上行使用率中较大的一个，结果乘以100
+func CalcNetUsageDirections(nodeNames []string, curIn, curOut map[string]int64, capMap map[string]model.NetCapacity) map[string]int64 {
+	resMap := make(map[string]int64)
+	for _, name := range nodeNames {
+		c := capMap[name]
+		var usage float64
+		if c.In > 0 {
+			usage = float64(curIn[name]) * 100 / float64(c.In)
+		}
+		if c.Out > 0 {
+			usage = math.Max(usage, float64(curOut[name])*100/float64(c.Out))
+		}
+		resMap[name] = int64(math.Round(usage))
+	}
+
+	return resMap
+}
+
+// GetNetCapacities 从cacheData中取出分方向的网卡带宽，cacheData中没有分方向的负载或者带宽时ok为false
+func GetNetCapacities(cacheData map[string](map[string]int64), nodeNames []string) (capMap map[string]model.NetCapacity, ok bool) {
+	capIn, okIn := cacheData[model.ResourceNetInCapKey]
+	capOut, okOut := cacheData[model.ResourceNetOutCapKey]
+	if !okIn || !okOut || !HasNetDirections(cacheData, nodeNames) {
+		return nil, false
+	}
+
+	capMap = make(map[string]model.NetCapacity, len(capIn))
+	for name, v := range capIn {
+		capMap[name] = model.NetCapacity{In: v, Out: capOut[name]}
+	}
+	for name, v := range capOut {
+		if _, exist := capMap[name]; !exist {
+			capMap[name] = model.NetCapacity{Out: v}
+		}
+	}
+
+	return capMap, true
+}
+
+// CalcDiskUsage 计算磁盘使用率，取吞吐和IOPS使用率中较大的一个，结果乘以100
+// cacheData中没有磁盘能力时返回原始的磁盘IO，兼容没有配置磁盘能力的快照；吞吐和IOPS能力都没有的节点不在结果中
+func CalcDiskUsage(nodeNames []string, cacheData map[string](map[string]int64)) map[string]int64 {
+	diskMap := cacheData[model.ResourceDiskIOKey]
+	capMap, ok := cacheData[model.ResourceDiskCapKey]
+	if !ok {
+		return diskMap
+	}
+	iopsMap := cacheData[model.ResourceDiskIOPSKey]
+	iopsCapMap := cacheData[model.ResourceDiskIOPSCapKey]
+
+	resMap := make(map[string]int64)
+	for _, name := range nodeNames {
+		iops, hasIOPS := iopsMap[name]
+		capacity := model.DiskCapacity{Throughput: capMap[name], IOPS: iopsCapMap[name]}
+		if usage, ok := diskUsage(diskMap[name], iops, hasIOPS, capacity); ok {
+			resMap[name] = int64(math.Round(usage))
+		}
+	}
+
+	return resMap
+}
+
+// diskUsage 按照节点的磁盘能力计算使用率，取吞吐和IOPS使用率中较大的一个，结果乘以100
+// 吞吐和IOPS能力都无法使用时ok为false
+func diskUsage(disk, iops int64, hasIOPS bool, capacity model.DiskCapacity) (usage float64, ok bool) {
+	if capacity.Throughput > 0 {
+		usage, ok = float64(disk)*100/float64(capacity.Throughput), true
+	}
+	if hasIOPS && capacity.IOPS > 0 {
+		usage, ok = math.Max(usage, float64(iops)*100/float64(capacity.IOPS)), true
+	}
+
+	return usage, ok
+}
+
 // GetPodNetIONeed 从Pod注解中拿到Pod请求的netIO信息
 func GetPodNetIONeed(pod *v1.Pod) int64 {
 	var netIO int64
@@ -142,17 +355,86 @@ func GetPodNetIONeed(pod *v1.Pod) int64 {
 	return netIO
 }
 
+// GetPodNetDemand 从Pod注解中拿到Pod在两个方向上的网络需求，没有声明的方向使用LiangNetIO
+func GetPodNetDemand(pod *v1.Pod) model.NetDemand {
+	netIO := GetPodNetIONeed(pod)
+	demand := model.NetDemand{In: netIO, Out: netIO}
+	parse := func(key string, target *int64) {
+		v, ok := pod.Annotations[key]
+		if !ok {
+			return
+		}
+		vInt, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Error("parse %s of %s to int64 error:%v", v, key, err)
+			return
+		}
+		*target = vInt * model.KbitPS
+	}
+	parse(model.ResourceNetInKey, &demand.In)
+	parse(model.ResourceNetOutKey, &demand.Out)
+	log.V(3).Info("GetPodNetDemand - net demand is %+v", demand)
+
+	return demand
+}
+
+// HasNetDirections 快照中是否有所有节点分方向的网络负载，没有时按照单一网络负载评分
+func HasNetDirections(cacheData map[string](map[string]int64), nodeNames []string) bool {
+	inMap, okIn := cacheData[model.ResourceNetInKey]
+	outMap, okOut := cacheData[model.ResourceNetOutKey]
+	if !okIn || !okOut {
+		return false
+	}
+	for _, name := range nodeNames {
+		_, okIn = inMap[name]
+		_, okOut = outMap[name]
+		if !okIn || !okOut {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FilterNodeByNetDirections 分别检查下行和上行带宽，两个方向都放得下的节点才会保留
+func FilterNodeByNetDirections(nodeNames []string, need model.NetDemand, curIn, curOut map[string]int64, capMap map[string]model.NetCapacity) (valideNames []string, rejected []model.NodeRejection) {
+	capIn := make(map[string]int64, len(capMap))
+	capOut := make(map[string]int64, len(capMap))
+	for name, c := range capMap {
+		capIn[name] = c.In
+		capOut[name] = c.Out
+	}
+
+	inNames, _, _, inRejected := FilterNodeByNetWithReason(nodeNames, need.In, curIn, capIn)
+	outNames, _, _, outRejected := FilterNodeByNetWithReason(inNames, need.Out, curOut, capOut)
+	for _, r := range inRejected {
+		rejected = append(rejected, model.NodeRejection{Host: r.Host, Reason: "in: " + r.Reason})
+	}
+	for _, r := range outRejected {
+		rejected = append(rejected, model.NodeRejection{Host: r.Host, Reason: "out: " + r.Reason})
+	}
+
+	return outNames, rejected
+}
+
 func FilterDiskIO(nodeNames []string, diskMap map[string]int64) []float64 {
 	return nil
 }
 
 func FilterNodeByNet(nodeNames []string, needNet int64, curNetMap, capNetMap map[string]int64) (valideNames []string, curArr []float64, capArr []float64) {
+	valideNames, curArr, capArr, _ = FilterNodeByNetWithReason(nodeNames, needNet, curNetMap, capNetMap)
+	return
+}
+
+// FilterNodeByNetWithReason 同FilterNodeByNet，额外返回被过滤掉的节点及原因
+func FilterNodeByNetWithReason(nodeNames []string, needNet int64, curNetMap, capNetMap map[string]int64) (valideNames []string, curArr []float64, capArr []float64, rejected []model.NodeRejection) {
 	nodeNum := len(nodeNames)
 	for i := 0; i < nodeNum; i++ {
 		nodeName := nodeNames[i]
 		curNet, ok := curNetMap[nodeName]
 		if !ok {
 			log.V(5).Info("current net info of node %s does not exist, skip", nodeName)
+			rejected = append(rejected, model.NodeRejection{Host: nodeName, Reason: "current net io does not exist"})
 			continue
 		}
 
@@ -160,12 +442,17 @@ func FilterNodeByNet(nodeNames []string, needNet int64, curNetMap, capNetMap map
 		// 过滤掉不存在或者资源超出的情况
 		if !ok1 {
 			log.V(5).Info("cap net info of node %s does not exist, skip", nodeName)
+			rejected = append(rejected, model.NodeRejection{Host: nodeName, Reason: "net capacity does not exist"})
 			continue
 		}
 
 		if needNet+curNet > capNet {
 			log.V(5).Info("request net %d plus cur net %d overflow net cap %d, skip",
 				needNet, curNet, capNet)
+			rejected = append(rejected, model.NodeRejection{
+				Host:   nodeName,
+				Reason: fmt.Sprintf("request net %d plus cur net %d overflow net cap %d", needNet, curNet, capNet),
+			})
 			continue
 		}
 
@@ -195,6 +482,20 @@ func GetUsageArray(upperLimit int64, nodeNames []string, usageMap map[string]int
 	return res
 }
 
+// GetCappedUsageArray 返回使用率数组，超过upperLimit的按upperLimit计算，不存在的为0
+func GetCappedUsageArray(upperLimit int64, nodeNames []string, usageMap map[string]int64) []float64 {
+	res := make([]float64, 0, len(nodeNames))
+	for _, name := range nodeNames {
+		v := usageMap[name]
+		if v > upperLimit {
+			v = upperLimit
+		}
+		res = append(res, float64(v))
+	}
+
+	return res
+}
+
 // ValidateCacheData 根据Keys验证cacheData中数据是否存在
 func ValidateCacheData(keys []string, cacheData map[string](map[string]int64)) error {
 	for _, key := range keys {
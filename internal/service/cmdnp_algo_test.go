@@ -2,8 +2,10 @@ package service
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 
 	"liang/internal/model"
@@ -241,6 +243,101 @@ func TestCMDNPriority_Score(t *testing.T) {
 	}
 }
 
+func TestCMDNPriority_Explain(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				model.ResourceNetIOKey: "2",
+			},
+		},
+	}
+	nodeNames := []string{"node1", "node2", "node3"}
+	netCapMap := map[string]int64{
+		"node1": 1000000,
+		"node2": 1500000,
+		"node3": 2000,
+	}
+	cacheData := map[string](map[string]int64){
+		model.ResourceCPUKey:    {"node1": 25, "node2": 18, "node3": 10},
+		model.ResourceMemKey:    {"node1": 68, "node2": 38, "node3": 10},
+		model.ResourceDiskIOKey: {"node1": 8, "node2": 35, "node3": 10},
+		model.ResourceNetIOKey:  {"node1": 18, "node2": 22, "node3": 10},
+	}
+
+	cmdn := CMDNPriority{}
+	res, explain, err := cmdn.Explain(pod, nodeNames, netCapMap, cacheData)
+	if err != nil {
+		t.Fatalf("explain error: %v", err)
+	}
+
+	if len(explain.Rejected) != 1 || explain.Rejected[0].Host != "node3" {
+		t.Errorf("node3 should be rejected, but get %v", explain.Rejected)
+	}
+	if !reflect.DeepEqual(explain.Nodes, []string{"node1", "node2"}) {
+		t.Errorf("valid nodes should be node1 and node2, but get %v", explain.Nodes)
+	}
+	if len(explain.Matrix) != 2 || len(explain.Matrix[0]) != len(explain.Criteria) {
+		t.Fatalf("shape of matrix %v is wrong", explain.Matrix)
+	}
+	if explain.Matrix[0][0] != 25 || explain.Matrix[1][1] != 38 {
+		t.Errorf("origin matrix should keep raw values, get %v", explain.Matrix)
+	}
+	for j := range explain.Criteria {
+		if explain.Ideal[j] < explain.AntiIdeal[j] {
+			t.Errorf("ideal %v should not less than anti ideal %v", explain.Ideal, explain.AntiIdeal)
+		}
+	}
+	for i, name := range explain.Nodes {
+		closeness := explain.DistanceToAntiIdeal[i] / (explain.DistanceToIdeal[i] + explain.DistanceToAntiIdeal[i])
+		if math.Abs(closeness-explain.Closeness[i]) > 1e-9 {
+			t.Errorf("closeness of %s should be %f, but get %f", name, closeness, explain.Closeness[i])
+		}
+		if int64(math.Round(closeness*model.MaxNodeScore)) != res[i].Score {
+			t.Errorf("score of %s should be %d, but get %d", name, res[i].Score, int64(math.Round(closeness*model.MaxNodeScore)))
+		}
+	}
+}
+
+func TestCMDNPriority_ScoreRejected(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				model.ResourceNetIOKey: "2",
+			},
+		},
+	}
+	// 被过滤掉的node3排在最前面，其余节点也不能丢失
+	nodeNames := []string{"node3", "node1", "node2"}
+	netCapMap := map[string]int64{
+		"node1": 1000000,
+		"node2": 1500000,
+		"node3": 2000,
+	}
+	cacheData := map[string](map[string]int64){
+		model.ResourceCPUKey:    {"node1": 25, "node2": 18, "node3": 10},
+		model.ResourceMemKey:    {"node1": 68, "node2": 38, "node3": 10},
+		model.ResourceDiskIOKey: {"node1": 8, "node2": 35, "node3": 10},
+		model.ResourceNetIOKey:  {"node1": 18, "node2": 22, "node3": 10},
+	}
+
+	cmdn := CMDNPriority{}
+	res, err := cmdn.Score(pod, nodeNames, netCapMap, cacheData)
+	if err != nil {
+		t.Fatalf("score error: %v", err)
+	}
+	if len(res) != len(nodeNames) {
+		t.Fatalf("every node should have a score, but get %v", res)
+	}
+	for i, hp := range res {
+		if hp.Host != nodeNames[i] {
+			t.Errorf("score %d should belong to %s, but get %v", i, nodeNames[i], res)
+		}
+	}
+	if res[0].Score != 0 {
+		t.Errorf("rejected node3 should get 0, but get %d", res[0].Score)
+	}
+}
+
 func TestCMDNPriority_ConvertMap(t *testing.T) {
 	cases := []struct {
 		Name      string
@@ -430,6 +527,207 @@ func TestFilterNodeByNet(t *testing.T) {
 	}
 }
 
+func TestGetPodNetDemand(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				model.ResourceNetIOKey:  "20",
+				model.ResourceNetOutKey: "500",
+			},
+		},
+	}
+	// 没有声明的下行需求使用LiangNetIO
+	expected := model.NetDemand{In: 20 * model.KbitPS, Out: 500 * model.KbitPS}
+	if res := GetPodNetDemand(pod); res != expected {
+		t.Errorf("net demand should be %+v, but get %+v", expected, res)
+	}
+
+	pod.Annotations[model.ResourceNetInKey] = "fast"
+	if res := GetPodNetDemand(pod); res != expected {
+		t.Errorf("invalid net in demand should be ignored, but get %+v", res)
+	}
+}
+
+func TestFilterNodeByNetDirections(t *testing.T) {
+	nodeNames := []string{"node1", "node2", "node3"}
+	curIn := map[string]int64{"node1": 100 * model.KbitPS, "node2": 100 * model.KbitPS, "node3": 900 * model.KbitPS}
+	curOut := map[string]int64{"node1": 50 * model.KbitPS, "node2": 50 * model.KbitPS, "node3": 50 * model.KbitPS}
+	// node1上行带宽只有100M
+	capMap := map[string]model.NetCapacity{
+		"node1": {In: 1000 * model.KbitPS, Out: 100 * model.KbitPS},
+		"node2": {In: 1000 * model.KbitPS, Out: 1000 * model.KbitPS},
+		"node3": {In: 1000 * model.KbitPS, Out: 1000 * model.KbitPS},
+	}
+
+	cases := []struct {
+		Name     string
+		Need     model.NetDemand
+		ExpNames []string
+		Rejected map[string]string
+	}{
+		{
+			Name:     "cdn",
+			Need:     model.NetDemand{In: 10 * model.KbitPS, Out: 200 * model.KbitPS},
+			ExpNames: []string{"node2", "node3"},
+			Rejected: map[string]string{"node1": "out: "},
+		},
+		{
+			Name:     "ingestion",
+			Need:     model.NetDemand{In: 200 * model.KbitPS, Out: 10 * model.KbitPS},
+			ExpNames: []string{"node1", "node2"},
+			Rejected: map[string]string{"node3": "in: "},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			names, rejected := FilterNodeByNetDirections(nodeNames, tc.Need, curIn, curOut, capMap)
+			if !reflect.DeepEqual(names, tc.ExpNames) {
+				t.Errorf("test %s error: names should be %v, but get %v", tc.Name, tc.ExpNames, names)
+			}
+			if len(rejected) != len(tc.Rejected) {
+				t.Fatalf("test %s error: rejected should be %v, but get %v", tc.Name, tc.Rejected, rejected)
+			}
+			for _, r := range rejected {
+				if prefix, ok := tc.Rejected[r.Host]; !ok || !strings.HasPrefix(r.Reason, prefix) {
+					t.Errorf("test %s error: unexpected rejection %+v", tc.Name, r)
+				}
+			}
+		})
+	}
+}
+
+func TestCMDNPriority_ScoreDuplex(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				model.ResourceNetIOKey:  "10",
+				model.ResourceNetOutKey: "200",
+			},
+		},
+	}
+	nodeNames := []string{"node1", "node2"}
+	netCapMap := map[string]int64{"node1": 1000 * model.KbitPS, "node2": 1000 * model.KbitPS}
+	cacheData := map[string](map[string]int64){
+		model.ResourceNetIOKey:     {"node1": 100 * model.KbitPS, "node2": 100 * model.KbitPS},
+		model.ResourceNetInKey:     {"node1": 100 * model.KbitPS, "node2": 100 * model.KbitPS},
+		model.ResourceNetOutKey:    {"node1": 50 * model.KbitPS, "node2": 300 * model.KbitPS},
+		model.ResourceNetInCapKey:  {"node1": 1000 * model.KbitPS, "node2": 1000 * model.KbitPS},
+		model.ResourceNetOutCapKey: {"node1": 100 * model.KbitPS, "node2": 1000 * model.KbitPS},
+		model.ResourceCPUKey:       {"node1": 20, "node2": 20},
+		model.ResourceMemKey:       {"node1": 20, "node2": 20},
+		model.ResourceDiskIOKey:    {"node1": 20, "node2": 20},
+	}
+
+	cmdn := CMDNPriority{}
+	res, explain, err := cmdn.Explain(pod, nodeNames, netCapMap, cacheData)
+	if err != nil {
+		t.Fatalf("explain error: %v", err)
+	}
+	// node1上行放不下，只按单一网络负载时不会被过滤
+	if len(explain.Rejected) != 1 || explain.Rejected[0].Host != "node1" || res[0].Score != model.MinNodeScore {
+		t.Errorf("node1 should be rejected by upload bandwidth, but get %v %+v", res, explain.Rejected)
+	}
+	if explain.NetDemand == nil || explain.NetDemand.Out != 200*model.KbitPS {
+		t.Errorf("explanation should contain net demand, but get %+v", explain.NetDemand)
+	}
+
+	// 没有分方向的带宽时保持原来的行为
+	delete(cacheData, model.ResourceNetOutCapKey)
+	_, explain, _ = cmdn.Explain(pod, nodeNames, netCapMap, cacheData)
+	if len(explain.Rejected) != 0 || explain.NetDemand != nil {
+		t.Errorf("legacy net filter should not reject any node, but get %+v", explain.Rejected)
+	}
+}
+
+func TestCalcNetUsageDirections(t *testing.T) {
+	capMap := map[string]model.NetCapacity{"node1": {In: 1000, Out: 100}, "node2": {In: 1000, Out: 1000}}
+	curIn := map[string]int64{"node1": 100, "node2": 600}
+	curOut := map[string]int64{"node1": 50, "node2": 100}
+	res := CalcNetUsageDirections([]string{"node1", "node2"}, curIn, curOut, capMap)
+	if res["node1"] != 50 || res["node2"] != 60 {
+		t.Errorf("net usage should be the max of both directions, but get %v", res)
+	}
+}
+
+func TestCalcDiskUsage(t *testing.T) {
+	nodeNames := []string{"ssd", "hdd"}
+	cacheData := map[string](map[string]int64){
+		model.ResourceDiskIOKey: {"ssd": 100 * model.MByte, "hdd": 100 * model.MByte},
+	}
+	// 没有磁盘能力时保持原始的磁盘IO
+	if res := CalcDiskUsage(nodeNames, cacheData); res["ssd"] != 100*model.MByte {
+		t.Errorf("disk usage without capacity should be raw disk io, but get %v", res)
+	}
+
+	// 相同的吞吐，HDD的使用率更高
+	cacheData[model.ResourceDiskCapKey] = map[string]int64{"ssd": 500 * model.MByte, "hdd": 125 * model.MByte}
+	res := CalcDiskUsage(nodeNames, cacheData)
+	if res["ssd"] != 20 || res["hdd"] != 80 {
+		t.Errorf("disk usage should be ssd 20 and hdd 80, but get %v", res)
+	}
+
+	// IOPS使用率更高时取IOPS使用率
+	cacheData[model.ResourceDiskIOPSKey] = map[string]int64{"ssd": 40000, "hdd": 100}
+	cacheData[model.ResourceDiskIOPSCapKey] = map[string]int64{"ssd": 80000, "hdd": 200}
+	res = CalcDiskUsage(nodeNames, cacheData)
+	if res["ssd"] != 50 || res["hdd"] != 80 {
+		t.Errorf("disk usage should be ssd 50 and hdd 80, but get %v", res)
+	}
+}
+
+func TestScoreSnapshot_DiskUsage(t *testing.T) {
+	newInput := func(diskCap map[string]model.DiskCapacity, policy string) *ScoreInput {
+		return &ScoreInput{
+			Algorithm:     model.AlgoCMDN,
+			TopsisMin:     true,
+			MissingPolicy: model.MissingPolicy{model.ResourceDiskIOKey: policy},
+			Pod:           &v1.Pod{},
+			NodeNames:     []string{"busy", "idle", "unknown"},
+			NetBwMap:      map[string]int64{"busy": 1000 * model.KbitPS, "idle": 1000 * model.KbitPS, "unknown": 1000 * model.KbitPS},
+			DiskCapMap:    diskCap,
+			Snapshot: map[string](map[string]int64){
+				model.ResourceCPUKey:    {"busy": 30, "idle": 30, "unknown": 30},
+				model.ResourceMemKey:    {"busy": 30, "idle": 30, "unknown": 30},
+				model.ResourceNetIOKey:  {"busy": 100 * model.KbitPS, "idle": 100 * model.KbitPS, "unknown": 100 * model.KbitPS},
+				model.ResourceDiskIOKey: {"busy": 90 * model.MByte, "idle": 10 * model.MByte, "unknown": 10 * model.MByte},
+			},
+		}
+	}
+	diskCap := map[string]model.DiskCapacity{
+		"busy":    {Throughput: 100 * model.MByte},
+		"idle":    {Throughput: 100 * model.MByte},
+		"unknown": {Throughput: 100 * model.MByte},
+	}
+
+	// 90%的磁盘不能因为超过负载上限被当作空闲
+	res, _, err := ScoreSnapshot(newInput(diskCap, model.MissingExclude))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores := make(map[string]int64)
+	for _, hp := range res {
+		scores[hp.Host] = hp.Score
+	}
+	if scores["busy"] >= scores["idle"] {
+		t.Errorf("node with 90%% disk usage should score worse than 10%%, but get %v", scores)
+	}
+
+	// 没有磁盘能力的节点按照缺失数据策略处理，不能被当作空闲
+	delete(diskCap, "unknown")
+	explain, _, err := explainSnapshot(newInput(diskCap, model.MissingWorst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(explain.Missing) != 1 || explain.Missing[0].Host != "unknown" || explain.Missing[0].Value != 90 {
+		t.Fatalf("disk usage of node without capacity should be the worst 90, but get %+v", explain.Missing)
+	}
+	explain, _, _ = explainSnapshot(newInput(diskCap, model.MissingExclude))
+	if len(explain.Missing) != 1 || !explain.Missing[0].Excluded {
+		t.Fatalf("node without disk capacity should be excluded, but get %+v", explain.Missing)
+	}
+}
+
 func TestGetUsageArray(t *testing.T) {
 	cases := []struct {
 		Name       string
@@ -636,3 +934,6 @@ func BenchmarkCMDNPriority_Score1000(b *testing.B) {
 func BenchmarkCMDNPriority_Score10000(b *testing.B) {
 	benchmarkCMDNPriority_Score(10000, b)
 }
+func BenchmarkCMDNPriority_Score50000(b *testing.B) {
+	benchmarkCMDNPriority_Score(50000, b)
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"math"
+	"sync/atomic"
+
+	"liang/internal/model"
+	"liang/internal/utils"
+
+	"github.com/go-kratos/kratos/pkg/log"
+	"gonum.org/v1/gonum/mat"
+	v1 "k8s.io/api/core/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// CMDNCache 同步指标后预先计算的cmdn评分数据，只依赖指标快照和节点能力，与Pod无关
+// 保存每个节点决策矩阵的行，以及全部节点每列的平方和
+// 评分时只需要按Pod的需求过滤节点，用预先计算的行组装矩阵，从平方和中减去没有参与评分的节点得到正规化的分母，
+// 再用O(n·k)的时间重新计算理想解和距离，不需要重新构造矩阵
+type CMDNCache struct {
+	version    int64          // 构造时使用的快照版本号，评分使用同一个版本的快照时才使用缓存
+	duplex     bool           // 是否按分方向的网络负载计算
+	hasDiskCap bool           // 快照中是否有磁盘能力
+	index      map[string]int // 节点在matrix中的下标
+	capacity   [][5]int64     // 构造时每个节点使用的能力，见cmdnCapacities
+	matrix     [][]float64    // 每个节点决策矩阵的行，按cmdnCriteria排列
+	sumSquares []float64      // 全部节点每列的平方和
+	nonZero    []int          // 全部节点每列不为0的个数，参与评分的节点全为0的列与utils.ResetZeroCol一样按1计算
+
+	hits   int64 // 使用缓存评分的次数
+	misses int64 // 快照版本或者节点能力不匹配，需要重新计算的次数
+}
+
+// NewCMDNCache 使用网络需求为0的Pod对in.NodeNames评分，缓存评分的中间结果，没有节点参与评分时返回nil
+// 快照中需要有磁盘能力和分方向网卡带宽时，in.Snapshot应该已经包含这些key，in.SnapshotVersion为0时缓存不会被使用
+func NewCMDNCache(in *ScoreInput) (*CMDNCache, error) {
+	cmdn := CMDNPriority{Stochastic: in.Stochastic}
+	_, explain, err := cmdn.Explain(&v1.Pod{}, in.NodeNames, in.NetBwMap, in.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if len(explain.Nodes) == 0 {
+		return nil, nil
+	}
+
+	c := &CMDNCache{
+		version:  in.SnapshotVersion,
+		duplex:   explain.NetDemand != nil,
+		index:    make(map[string]int, len(explain.Nodes)),
+		capacity: make([][5]int64, len(explain.Nodes)),
+		matrix:   explain.Matrix,
+
+		sumSquares: make([]float64, len(cmdnCriteria)),
+		nonZero:    make([]int, len(cmdnCriteria)),
+	}
+	for _, row := range c.matrix {
+		for j, v := range row {
+			c.sumSquares[j] += math.Pow(v, 2)
+			if v != 0 {
+				c.nonZero[j]++
+			}
+		}
+	}
+	_, c.hasDiskCap = in.Snapshot[model.ResourceDiskCapKey]
+	capacity := newCMDNCapacities(in.NetBwMap, in.Snapshot)
+	for i, name := range explain.Nodes {
+		c.index[name] = i
+		c.capacity[i] = capacity.get(name)
+	}
+
+	return c, nil
+}
+
+// assemble 按validNames的顺序返回决策矩阵和用缓存计算的TOPSIS结果，节点重复或者只有一个节点时TOPSIS结果为空
+// 快照版本、节点能力与构造时不同，或者有节点不在缓存中时ok为false，需要重新计算；weights为空时权重都为1
+func (c *CMDNCache) assemble(version int64, validNames []string, netCapMap map[string]int64, cacheData map[string](map[string]int64), duplex bool, weights []float64) (matrix *mat.Dense, detail *utils.TOPSISDetail, ok bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+	if !c.match(version, cacheData, duplex) {
+		c.miss("cmdn cache of snapshot %d does not match snapshot %d, recalculate", c.version, version)
+		return nil, nil, false
+	}
+
+	col := len(cmdnCriteria)
+	capacity := newCMDNCapacities(netCapMap, cacheData)
+	// 磁盘使用率在评分时按缺失数据策略补全，可能与构造时不同，按节点比较
+	usageMap, hasUsage := cacheData[model.ResourceDiskUsageKey]
+	rows := make([]int, len(validNames))
+	seen := make([]bool, len(c.matrix))
+	unique := true
+	data := make([]float64, 0, len(validNames)*col)
+	for i, name := range validNames {
+		idx, exist := c.index[name]
+		if !exist || c.capacity[idx] != capacity.get(name) ||
+			hasUsage && c.matrix[idx][cmdnDiskColumn] != GetCappedUsageArray(maxDiskUsage, []string{name}, usageMap)[0] {
+			c.miss("cmdn cache of node %s does not match, recalculate", name)
+			return nil, nil, false
+		}
+		unique = unique && !seen[idx]
+		seen[idx] = true
+		rows[i] = idx
+		data = append(data, c.matrix[idx]...)
+	}
+	matrix = mat.NewDense(len(validNames), col, data)
+	atomic.AddInt64(&c.hits, 1)
+	// 只有一个节点时TOPSIS不需要正规化，由utils.CalcWeightedTOPSISDetail处理
+	if !unique || len(rows) < 2 {
+		return matrix, nil, true
+	}
+	if weights == nil {
+		weights = cmdnWeights(nil)
+	}
+
+	return matrix, c.topsis(rows, seen, weights), true
+}
+
+// topsis 按utils.CalcWeightedTOPSISDetail的方法计算rows中节点的TOPSIS结果，included为参与评分的节点
+// 每列的平方和由全部节点的平方和减去没有参与评分的节点得到，只需要遍历一次缓存的行
+func (c *CMDNCache) topsis(rows []int, included []bool, weights []float64) *utils.TOPSISDetail {
+	col := len(cmdnCriteria)
+	sums := append([]float64{}, c.sumSquares...)
+	nonZero := append([]int{}, c.nonZero...)
+	for idx, row := range c.matrix {
+		if included[idx] {
+			continue
+		}
+		for j, v := range row {
+			sums[j] -= math.Pow(v, 2)
+			if v != 0 {
+				nonZero[j]--
+			}
+		}
+	}
+
+	detail := &utils.TOPSISDetail{
+		Normalized: mat.NewDense(len(rows), col, nil),
+		Weights:    weights,
+		Ideal:      make([]float64, col),
+		AntiIdeal:  make([]float64, col),
+		DPlus:      make([]float64, len(rows)),
+		DMinus:     make([]float64, len(rows)),
+		Closeness:  make([]float64, len(rows)),
+	}
+	for j := 0; j < col; j++ {
+		allZero := nonZero[j] == 0
+		if allZero {
+			sums[j] = float64(len(rows))
+		} else if sums[j] <= 0 {
+			// 排除的节点远大于参与评分的节点时减法可能损失精度，直接求和
+			sums[j] = 0
+			for _, idx := range rows {
+				sums[j] += math.Pow(c.matrix[idx][j], 2)
+			}
+		}
+		for i, idx := range rows {
+			v := 1.0
+			if !allZero {
+				v = c.matrix[idx][j]
+			}
+			norm := v / sums[j] * weights[j]
+			detail.Normalized.Set(i, j, norm)
+			if i == 0 || norm > detail.Ideal[j] {
+				detail.Ideal[j] = norm
+			}
+			if i == 0 || norm < detail.AntiIdeal[j] {
+				detail.AntiIdeal[j] = norm
+			}
+		}
+	}
+	for i := range rows {
+		rowArr := detail.Normalized.RawRowView(i)
+		maxSum, minSum := 0.0, 0.0
+		for j := 0; j < col; j++ {
+			maxSum += math.Pow(rowArr[j]-detail.Ideal[j], 2)
+			minSum += math.Pow(rowArr[j]-detail.AntiIdeal[j], 2)
+		}
+		detail.DPlus[i] = math.Sqrt(maxSum)
+		detail.DMinus[i] = math.Sqrt(minSum)
+		detail.Closeness[i] = detail.DMinus[i] / (detail.DMinus[i] + detail.DPlus[i])
+	}
+
+	return detail
+}
+
+// match 评分使用的指标是否为构造缓存时同一个版本的快照，版本号为0的快照不是同步得到的，不能使用缓存
+func (c *CMDNCache) match(version int64, cacheData map[string](map[string]int64), duplex bool) bool {
+	if version == 0 || version != c.version || duplex != c.duplex {
+		return false
+	}
+	_, ok := cacheData[model.ResourceDiskCapKey]
+
+	return ok == c.hasDiskCap
+}
+
+// miss 记录一次没有使用缓存的评分
+func (c *CMDNCache) miss(format string, args ...interface{}) {
+	atomic.AddInt64(&c.misses, 1)
+	log.V(3).Info(format, args...)
+}
+
+// stats 使用缓存和重新计算的评分次数
+func (c *CMDNCache) stats() (hits, misses int64) {
+	return atomic.LoadInt64(&c.hits), atomic.LoadInt64(&c.misses)
+}
+
+// cmdnCapacities 评分使用的网卡带宽、分方向网卡带宽、磁盘吞吐和IOPS能力
+type cmdnCapacities [5]map[string]int64
+
+func newCMDNCapacities(netCapMap map[string]int64, cacheData map[string](map[string]int64)) cmdnCapacities {
+	return cmdnCapacities{
+		netCapMap,
+		cacheData[model.ResourceNetInCapKey],
+		cacheData[model.ResourceNetOutCapKey],
+		cacheData[model.ResourceDiskCapKey],
+		cacheData[model.ResourceDiskIOPSCapKey],
+	}
+}
+
+// get 节点的各项能力，不存在的为0
+func (caps cmdnCapacities) get(name string) (res [5]int64) {
+	for i, m := range caps {
+		res[i] = m[name]
+	}
+
+	return res
+}
+
+// refreshCMDNCache 同步指标后为全部节点预先计算cmdn评分数据，所有策略都使用bnp算法时不需要
+func (s *Service) refreshCMDNCache() {
+	if !s.usesAlgorithm(model.AlgoCMDN) {
+		return
+	}
+
+	var c *CMDNCache
+	cacheData, version, err := s.allSnapshot()
+	if err == nil {
+		nodeNames := s.nodeNames
+		in := s.scoreInput(model.AlgoCMDN, &extenderv1.ExtenderArgs{NodeNames: &nodeNames}, cacheData)
+		in.Snapshot = withCapacity(in, cacheData)
+		in.SnapshotVersion = version
+		c, err = NewCMDNCache(in)
+	}
+	if err != nil {
+		log.Warn("precompute cmdn score error: %v", err)
+	}
+
+	s.cmdnCacheMu.Lock()
+	defer s.cmdnCacheMu.Unlock()
+	old := s.cmdnCache
+	// 并发的同步可能先完成较新的版本，不用旧版本覆盖
+	if old != nil && c != nil && c.version < old.version {
+		return
+	}
+	s.cmdnCache = c
+	if old != nil {
+		if hits, misses := old.stats(); misses > 0 {
+			log.Info("cmdn cache of snapshot %d: %d hits, %d misses", old.version, hits, misses)
+		}
+	}
+}
+
+// loadCMDNCache 最近一次同步后预先计算的cmdn评分数据
+func (s *Service) loadCMDNCache() *CMDNCache {
+	s.cmdnCacheMu.RLock()
+	defer s.cmdnCacheMu.RUnlock()
+
+	return s.cmdnCache
+}
@@ -0,0 +1,381 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"liang/internal/model"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newCMDNCacheInput 生成包含num个节点的cmdn评分输入，部分节点的网络负载较高，需求大时会被过滤掉
+func newCMDNCacheInput(num int, duplex bool) *ScoreInput {
+	nodeNames := make([]string, num)
+	netBw := make(map[string]int64, num)
+	netCap := make(map[string]model.NetCapacity, num)
+	diskCap := make(map[string]model.DiskCapacity, num)
+	snapshot := map[string](map[string]int64){
+		model.ResourceCPUKey:      {},
+		model.ResourceMemKey:      {},
+		model.ResourceNetIOKey:    {},
+		model.ResourceDiskIOKey:   {},
+		model.ResourceDiskIOPSKey: {},
+	}
+	if duplex {
+		snapshot[model.ResourceNetInKey] = map[string]int64{}
+		snapshot[model.ResourceNetOutKey] = map[string]int64{}
+	}
+	for i := range nodeNames {
+		name := fmt.Sprintf("node-%d", i)
+		nodeNames[i] = name
+		capNet := int64(rand.Intn(20)+1) * 100 * model.KbitPS
+		netBw[name] = capNet
+		netCap[name] = model.NetCapacity{In: capNet, Out: capNet / 2}
+		diskCap[name] = model.DiskCapacity{Throughput: int64(rand.Intn(5)+1) * 100 * model.MByte, IOPS: 5000}
+
+		snapshot[model.ResourceCPUKey][name] = int64(rand.Intn(100))
+		snapshot[model.ResourceMemKey][name] = int64(rand.Intn(100))
+		snapshot[model.ResourceNetIOKey][name] = rand.Int63n(capNet)
+		snapshot[model.ResourceDiskIOKey][name] = rand.Int63n(diskCap[name].Throughput)
+		snapshot[model.ResourceDiskIOPSKey][name] = rand.Int63n(5000)
+		if duplex {
+			snapshot[model.ResourceNetInKey][name] = snapshot[model.ResourceNetIOKey][name]
+			snapshot[model.ResourceNetOutKey][name] = rand.Int63n(capNet / 2)
+		}
+	}
+
+	in := &ScoreInput{
+		Algorithm:  model.AlgoCMDN,
+		TopsisMin:  true,
+		Pod:        &v1.Pod{},
+		NodeNames:  nodeNames,
+		NetBwMap:   netBw,
+		DiskCapMap: diskCap,
+		Snapshot:   snapshot,
+		LastKnown:  map[string](map[string]int64){},
+
+		SnapshotVersion: 1,
+	}
+	if duplex {
+		in.NetCapMap = netCap
+	}
+
+	return in
+}
+
+func newCMDNCachePod(netIO string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "nginx-0",
+			Annotations: map[string]string{model.ResourceNetIOKey: netIO},
+		},
+	}
+}
+
+func TestCMDNCache_ScoreSnapshot(t *testing.T) {
+	for _, duplex := range []bool{false, true} {
+		in := newCMDNCacheInput(200, duplex)
+		pre := *in
+		pre.Snapshot = withCapacity(in, in.Snapshot)
+		c, err := NewCMDNCache(&pre)
+		if err != nil || c == nil {
+			t.Fatalf("duplex %v: new cmdn cache error: %v", duplex, err)
+		}
+
+		shuffled := append([]string{}, in.NodeNames...)
+		rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		cases := []struct {
+			Name      string
+			Pod       *v1.Pod
+			NodeNames []string
+		}{
+			{Name: "zero demand", Pod: &v1.Pod{}, NodeNames: in.NodeNames},
+			{Name: "zero demand shuffled", Pod: &v1.Pod{}, NodeNames: shuffled},
+			{Name: "filtered by demand", Pod: newCMDNCachePod("500"), NodeNames: shuffled},
+			{Name: "subset", Pod: newCMDNCachePod("1"), NodeNames: shuffled[:50]},
+		}
+		for _, tc := range cases {
+			t.Run(fmt.Sprintf("duplex %v %s", duplex, tc.Name), func(t *testing.T) {
+				base := *in
+				base.Pod, base.NodeNames = tc.Pod, tc.NodeNames
+				expectedExplain, expected, err := explainSnapshot(&base)
+				if err != nil {
+					t.Fatalf("explain snapshot error: %v", err)
+				}
+
+				cached := base
+				cached.CMDNCache = c
+				explain, res, err := explainSnapshot(&cached)
+				if err != nil {
+					t.Fatalf("explain snapshot with cache error: %v", err)
+				}
+				if !reflect.DeepEqual(res, expected) {
+					t.Errorf("score with cache should be %v, but get %v", expected, res)
+				}
+				if !reflect.DeepEqual(explain.CMDN.Matrix, expectedExplain.CMDN.Matrix) ||
+					!reflect.DeepEqual(explain.CMDN.Rejected, expectedExplain.CMDN.Rejected) {
+					t.Errorf("explanation with cache does not match")
+				}
+				for i, closeness := range expectedExplain.CMDN.Closeness {
+					if !floatEqual(explain.CMDN.Closeness[i], closeness) {
+						t.Fatalf("closeness of %s should be %v, but get %v", explain.CMDN.Nodes[i], closeness, explain.CMDN.Closeness[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCMDNCache_Assemble(t *testing.T) {
+	in := newCMDNCacheInput(20, false)
+	pre := *in
+	pre.Snapshot = withCapacity(in, in.Snapshot)
+	c, err := NewCMDNCache(&pre)
+	if err != nil {
+		t.Fatalf("new cmdn cache error: %v", err)
+	}
+
+	// 全部节点、部分节点和配置了权重时都使用缓存计算TOPSIS
+	snapshot := withCapacity(in, in.Snapshot)
+	_, detail, ok := c.assemble(1, in.NodeNames, in.NetBwMap, snapshot, false, nil)
+	if !ok || detail == nil {
+		t.Fatalf("cache should be used for all nodes, ok %v", ok)
+	}
+	_, detail, ok = c.assemble(1, in.NodeNames[:10], in.NetBwMap, snapshot, false, nil)
+	if !ok || detail == nil || len(detail.Closeness) != 10 {
+		t.Errorf("cache should be used for subset, ok %v", ok)
+	}
+	matrix, detail, ok := c.assemble(1, in.NodeNames, in.NetBwMap, snapshot, false, cmdnWeights(map[string]float64{model.ResourceCPUKey: 3}))
+	if !ok || matrix == nil || detail == nil {
+		t.Errorf("cache should be used for other weights, ok %v", ok)
+	}
+	// 只有一个节点或者节点重复时只使用决策矩阵的行
+	if _, detail, ok = c.assemble(1, in.NodeNames[:1], in.NetBwMap, snapshot, false, nil); !ok || detail != nil {
+		t.Errorf("cache should only assemble matrix for a single node, ok %v", ok)
+	}
+	if _, detail, ok = c.assemble(1, []string{"node-1", "node-1"}, in.NetBwMap, snapshot, false, nil); !ok || detail != nil {
+		t.Errorf("cache should only assemble matrix for duplicated nodes, ok %v", ok)
+	}
+
+	// 快照版本或能力与构造时不同时重新计算
+	for _, version := range []int64{0, 2} {
+		if _, _, ok = c.assemble(version, in.NodeNames, in.NetBwMap, snapshot, false, nil); ok {
+			t.Errorf("cache should not be used for snapshot %d", version)
+		}
+	}
+	diskCap := make(map[string]model.DiskCapacity)
+	for name, v := range in.DiskCapMap {
+		diskCap[name] = v
+	}
+	diskCap["node-0"] = model.DiskCapacity{Throughput: 1, IOPS: 1}
+	in.DiskCapMap = diskCap
+	if _, _, ok = c.assemble(1, in.NodeNames, in.NetBwMap, withCapacity(in, in.Snapshot), false, nil); ok {
+		t.Errorf("cache should not be used when disk capacity changes")
+	}
+	if _, _, ok = c.assemble(1, in.NodeNames, in.NetBwMap, snapshot, true, nil); ok {
+		t.Errorf("cache should not be used when net direction changes")
+	}
+	var empty *CMDNCache
+	if _, _, ok = empty.assemble(1, in.NodeNames, in.NetBwMap, snapshot, false, nil); ok {
+		t.Errorf("nil cache should not be used")
+	}
+}
+
+func TestCMDNCache_Subset(t *testing.T) {
+	in := newCMDNCacheInput(100, false)
+	// 部分节点的CPU为0，使子集中出现全为0的列
+	for i, name := range in.NodeNames {
+		if i%4 == 0 {
+			in.Snapshot[model.ResourceCPUKey][name] = 0
+		}
+	}
+	in.Snapshot = withCapacity(in, in.Snapshot)
+	c, err := NewCMDNCache(in)
+	if err != nil || c == nil {
+		t.Fatalf("new cmdn cache error: %v", err)
+	}
+
+	zeroCPU := make([]string, 0)
+	for i, name := range in.NodeNames {
+		if i%4 == 0 {
+			zeroCPU = append(zeroCPU, name)
+		}
+	}
+	shuffled := append([]string{}, in.NodeNames...)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	cases := []struct {
+		Name      string
+		NodeNames []string
+		Weights   map[string]float64
+	}{
+		{Name: "all nodes", NodeNames: shuffled},
+		{Name: "half", NodeNames: shuffled[:50]},
+		{Name: "two nodes", NodeNames: shuffled[:2]},
+		{Name: "zero column", NodeNames: zeroCPU[:10]},
+		{Name: "weighted subset", NodeNames: shuffled[30:70], Weights: map[string]float64{model.ResourceCPUKey: 4, model.ResourceNetCapKey: 0}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if _, detail, ok := c.assemble(1, tc.NodeNames, in.NetBwMap, in.Snapshot, false, cmdnWeights(tc.Weights)); !ok || detail == nil {
+				t.Fatalf("cache should compute topsis for the subset, ok %v", ok)
+			}
+			plain := CMDNPriority{Weights: tc.Weights}
+			expected, err := plain.Score(&v1.Pod{}, tc.NodeNames, in.NetBwMap, in.Snapshot)
+			if err != nil {
+				t.Fatalf("score error: %v", err)
+			}
+			cached := CMDNPriority{Cache: c, Version: 1, Weights: tc.Weights}
+			res, err := cached.Score(&v1.Pod{}, tc.NodeNames, in.NetBwMap, in.Snapshot)
+			if err != nil {
+				t.Fatalf("score with cache error: %v", err)
+			}
+			if !reflect.DeepEqual(res, expected) {
+				t.Errorf("score with cache should be %v, but get %v", expected, res)
+			}
+
+			_, expectedExplain, _ := plain.Explain(&v1.Pod{}, tc.NodeNames, in.NetBwMap, in.Snapshot)
+			_, explain, _ := cached.Explain(&v1.Pod{}, tc.NodeNames, in.NetBwMap, in.Snapshot)
+			for j := range expectedExplain.Ideal {
+				if !floatEqual(explain.Ideal[j], expectedExplain.Ideal[j]) || !floatEqual(explain.AntiIdeal[j], expectedExplain.AntiIdeal[j]) {
+					t.Errorf("ideal of column %d should be %v/%v, but get %v/%v", j,
+						expectedExplain.Ideal[j], expectedExplain.AntiIdeal[j], explain.Ideal[j], explain.AntiIdeal[j])
+				}
+			}
+			for i, closeness := range expectedExplain.Closeness {
+				if !floatEqual(explain.Closeness[i], closeness) {
+					t.Fatalf("closeness of %s should be %v, but get %v", explain.Nodes[i], closeness, explain.Closeness[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCMDNCache_Weights(t *testing.T) {
+	in := newCMDNCacheInput(20, false)
+	in.Snapshot = withCapacity(in, in.Snapshot)
+	c, err := NewCMDNCache(in)
+	if err != nil || c == nil {
+		t.Fatalf("new cmdn cache error: %v", err)
+	}
+
+	// 配置了权重的策略使用缓存的决策矩阵，评分与不使用缓存时相同
+	weighted := *in
+	weighted.CMDNWeights = map[string]float64{model.ResourceCPUKey: 5, model.ResourceNetCapKey: 0}
+	expectedExplain, expected, err := explainSnapshot(&weighted)
+	if err != nil {
+		t.Fatalf("explain snapshot error: %v", err)
+	}
+	if !reflect.DeepEqual(expectedExplain.CMDN.Weights, []float64{5, 1, 1, 1, 0}) {
+		t.Errorf("weights should follow cmdn criteria, but get %v", expectedExplain.CMDN.Weights)
+	}
+	cached := weighted
+	cached.CMDNCache = c
+	explain, res, err := explainSnapshot(&cached)
+	if err != nil {
+		t.Fatalf("explain snapshot with cache error: %v", err)
+	}
+	if !reflect.DeepEqual(res, expected) || !reflect.DeepEqual(explain.CMDN.Weights, expectedExplain.CMDN.Weights) {
+		t.Errorf("weighted score with cache should be %v, but get %v", expected, res)
+	}
+	_, unweighted, err := explainSnapshot(in)
+	if err != nil {
+		t.Fatalf("explain snapshot error: %v", err)
+	}
+	if reflect.DeepEqual(unweighted, expected) {
+		t.Errorf("weights should change the score")
+	}
+}
+
+func TestCMDNCache_Version(t *testing.T) {
+	in := newCMDNCacheInput(20, false)
+	pre := *in
+	pre.Snapshot = withCapacity(in, in.Snapshot)
+	c, err := NewCMDNCache(&pre)
+	if err != nil || c == nil {
+		t.Fatalf("new cmdn cache error: %v", err)
+	}
+
+	cases := []struct {
+		Name    string
+		Version int64
+		Missing bool
+		Hit     bool
+	}{
+		{Name: "same version", Version: 1, Hit: true},
+		{Name: "newer version", Version: 2},
+		{Name: "unknown version", Version: 0},
+		{Name: "filled missing value", Version: 1, Missing: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			base := *in
+			base.SnapshotVersion = tc.Version
+			if tc.Missing {
+				// 缺少CPU的节点按最差值补全，与构造缓存时的快照不同
+				cpu := make(map[string]int64)
+				for name, v := range in.Snapshot[model.ResourceCPUKey] {
+					cpu[name] = v
+				}
+				delete(cpu, "node-3")
+				base.Snapshot = make(map[string](map[string]int64))
+				for key, values := range in.Snapshot {
+					base.Snapshot[key] = values
+				}
+				base.Snapshot[model.ResourceCPUKey] = cpu
+				base.MissingPolicy = model.MissingPolicy{model.ResourceCPUKey: model.MissingWorst}
+			}
+			_, expected, err := explainSnapshot(&base)
+			if err != nil {
+				t.Fatalf("explain snapshot error: %v", err)
+			}
+
+			hits, misses := c.stats()
+			cached := base
+			cached.CMDNCache = c
+			_, res, err := explainSnapshot(&cached)
+			if err != nil {
+				t.Fatalf("explain snapshot with cache error: %v", err)
+			}
+			if !reflect.DeepEqual(res, expected) {
+				t.Errorf("score with cache should be %v, but get %v", expected, res)
+			}
+			h, m := c.stats()
+			if (h > hits) != tc.Hit || (m > misses) == tc.Hit {
+				t.Errorf("cache hit should be %v, but get %d hits and %d misses", tc.Hit, h-hits, m-misses)
+			}
+		})
+	}
+}
+
+func floatEqual(a, b float64) bool {
+	return a-b < 1e-12 && b-a < 1e-12
+}
+
+func benchmarkCMDNPriority_ScoreCached(n int, b *testing.B) {
+	in := newCMDNCacheInput(n, false)
+	in.Snapshot = withCapacity(in, in.Snapshot)
+	c, err := NewCMDNCache(in)
+	if err != nil {
+		b.Fatalf("new cmdn cache error: %v", err)
+	}
+	cmdn := CMDNPriority{Cache: c, Version: in.SnapshotVersion}
+	pod := &v1.Pod{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cmdn.Score(pod, in.NodeNames, in.NetBwMap, in.Snapshot)
+	}
+}
+
+func BenchmarkCMDNPriority_ScoreCached1000(b *testing.B) {
+	benchmarkCMDNPriority_ScoreCached(1000, b)
+}
+func BenchmarkCMDNPriority_ScoreCached10000(b *testing.B) {
+	benchmarkCMDNPriority_ScoreCached(10000, b)
+}
+func BenchmarkCMDNPriority_ScoreCached50000(b *testing.B) {
+	benchmarkCMDNPriority_ScoreCached(50000, b)
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/log"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// calcConfigHash 计算影响评分结果的配置的摘要，用于审计记录
+func (s *Service) calcConfigHash() string {
+	netBwMap := s.netBw()
+	names := make([]string, 0, len(netBwMap))
+	for name := range netBwMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha1.New()
+	fmt.Fprintf(h, "useBNP=%v;topsisMin=%v;", s.useBNP, s.topsisMin)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%d;", name, netBwMap[name])
+	}
+	for _, key := range requiredKeys(model.AlgoCMDN) {
+		fmt.Fprintf(h, "missing.%s=%s;", key, s.missingPolicy.Get(key))
+	}
+	fmt.Fprintf(h, "diskCapacity=%+v;", s.diskCapConfig)
+	fmt.Fprintf(h, "bnpBalance=%+v;", s.balance)
+	fmt.Fprintf(h, "forecast=%+v;", s.forecastCfg)
+	fmt.Fprintf(h, "netDemand=%+v;", s.netDemandCfg)
+	fmt.Fprintf(h, "stochasticNet=%+v;", s.stochasticCfg)
+	// 策略中的多资源均衡配置为指针，按值写入摘要
+	fmt.Fprintf(h, "policy.default=%s;policy.namespaceLabel=%s;policy.priorityClasses=%v;",
+		s.policyCfg.Default, s.policyCfg.NamespaceLabel, s.policyCfg.PriorityClasses)
+	fmt.Fprintf(h, "autoAlgorithm=%+v;", s.autoCfg)
+	fmt.Fprintf(h, "experiment=%+v;", s.experimentCfg)
+	for _, p := range s.allPolicies()[1:] {
+		fmt.Fprintf(h, "policy.%s=%s/%s/%+v/%v;", p.Name, p.Algorithm, p.Mode, p.Balance, p.CMDNWeights)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// refreshConfigHash 配置加载或者网卡带宽变化后重新计算配置摘要
+func (s *Service) refreshConfigHash() {
+	s.configHash.Store(s.calcConfigHash())
+}
+
+// currentConfigHash 当前配置的摘要
+func (s *Service) currentConfigHash() string {
+	v, _ := s.configHash.Load().(string)
+	return v
+}
+
+// recordDecision 记录一次评分决策，in为评分时使用的输入，reasons为节点没有正常参与评分的原因，shadows为影子策略的结果
+func (s *Service) recordDecision(args *extenderv1.ExtenderArgs, in *ScoreInput, version int64,
+	res *extenderv1.HostPriorityList, reasons map[string]string, shadows []model.ShadowResult, scoreErr error, latency time.Duration) {
+	if !s.dao.DecisionLogEnabled() {
+		return
+	}
+	decision := &model.Decision{
+		Time:            time.Now(),
+		Nodes:           *args.NodeNames,
+		SnapshotVersion: version,
+		Algorithm:       in.Algorithm,
+		ConfigHash:      s.currentConfigHash(),
+		LatencyUs:       latency.Microseconds(),
+		Shadows:         shadows,
+	}
+	if in.Policy != nil {
+		decision.Policy = in.Policy.Name
+		decision.PolicySource = in.Policy.Source
+		decision.Arm = in.Policy.Arm
+	}
+	if args.Pod != nil {
+		decision.PodName = args.Pod.Name
+		decision.Namespace = args.Pod.Namespace
+		decision.PodUID = string(args.Pod.UID)
+	}
+	if scoreErr != nil {
+		decision.Error = scoreErr.Error()
+	}
+
+	scores := make(map[string]int64)
+	if res != nil {
+		for _, hp := range *res {
+			scores[hp.Host] = hp.Score
+		}
+	}
+	decision.Scores = make([]model.NodeDecision, 0, len(decision.Nodes))
+	for _, name := range decision.Nodes {
+		criteria := make(map[string]float64)
+		for key, valueMap := range in.Snapshot {
+			if v, ok := valueMap[name]; ok {
+				criteria[key] = float64(v)
+			}
+		}
+		if v, ok := in.NetBwMap[name]; ok {
+			criteria[model.ResourceNetCapKey] = float64(v)
+		}
+		decision.Scores = append(decision.Scores, model.NodeDecision{
+			Host:     name,
+			Criteria: criteria,
+			Score:    scores[name],
+			Reason:   reasons[name],
+		})
+	}
+
+	if err := s.dao.AddDecision(decision); err != nil {
+		log.Error("add decision of pod %s/%s error: %v", decision.Namespace, decision.PodName, err)
+	}
+}
+
+// QueryDecisions 查询评分决策审计记录
+func (s *Service) QueryDecisions(filter *model.DecisionFilter) ([]*model.Decision, error) {
+	return s.dao.QueryDecisions(filter)
+}
+
+// capture 录制一次Prioritize请求和评分使用的指标快照，用于回放对比
+func (s *Service) capture(args *extenderv1.ExtenderArgs, in *ScoreInput, version int64,
+	res *extenderv1.HostPriorityList, scoreErr error) {
+	c := &model.Capture{
+		Time:            time.Now(),
+		Args:            args,
+		Algorithm:       in.Algorithm,
+		TopsisMin:       in.TopsisMin,
+		NetBwMap:        in.NetBwMap,
+		NetCapMap:       in.NetCapMap,
+		DiskCapMap:      in.DiskCapMap,
+		SnapshotVersion: version,
+		Snapshot:        in.Snapshot,
+		MissingPolicy:   in.MissingPolicy,
+		Balance:         in.Balance,
+		LastKnown:       usedLastKnown(in),
+		Stochastic:      in.Stochastic,
+		Ensemble:        in.Ensemble,
+		CMDNWeights:     in.CMDNWeights,
+	}
+	// 回放时使用评分时的Pod，包含学习得到的网络需求
+	if in.Pod != args.Pod {
+		a := *args
+		a.Pod = in.Pod
+		c.Args = &a
+	}
+	if res != nil {
+		c.Result = *res
+	}
+	if scoreErr != nil {
+		c.Error = scoreErr.Error()
+	}
+
+	if err := s.dao.AddCapture(c); err != nil {
+		log.Error("capture prioritize request error: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/log"
+)
+
+// DiskCapacitySpec 配置中的磁盘IO能力，Throughput单位MB/s，Type为DiskCapacityConfig.Types中的磁盘类型
+type DiskCapacitySpec struct {
+	Type       string
+	Throughput float64
+	IOPS       float64
+}
+
+// capacity 转换为内部单位，吞吐为B/s
+func (spec DiskCapacitySpec) capacity() model.DiskCapacity {
+	return model.DiskCapacity{
+		Throughput: int64(spec.Throughput * model.MByte),
+		IOPS:       int64(spec.IOPS),
+	}
+}
+
+// DiskCapacityConfig 磁盘IO能力配置，对应application.toml中的[diskCapacity]
+type DiskCapacityConfig struct {
+	Default DiskCapacitySpec            // 无法从其他来源确定能力时使用
+	Types   map[string]DiskCapacitySpec // 按磁盘类型，如ssd/hdd
+	Nodes   map[string]DiskCapacitySpec // 按节点，可以只指定Type
+}
+
+// DefaultDiskCapacityConfig 没有配置[diskCapacity]时使用的磁盘IO能力
+func DefaultDiskCapacityConfig() DiskCapacityConfig {
+	return DiskCapacityConfig{
+		Default: DiskCapacitySpec{Throughput: 200, IOPS: 5000},
+		Types: map[string]DiskCapacitySpec{
+			"nvme": {Throughput: 2000, IOPS: 400000},
+			"ssd":  {Throughput: 500, IOPS: 80000},
+			"hdd":  {Throughput: 150, IOPS: 200},
+		},
+	}
+}
+
+// Validate 检查默认能力和节点引用的磁盘类型
+func (cfg DiskCapacityConfig) Validate() error {
+	if cfg.Default.Throughput <= 0 {
+		return fmt.Errorf("default disk throughput is %f, should be positive", cfg.Default.Throughput)
+	}
+	check := func(name string, spec DiskCapacitySpec) error {
+		if spec.Throughput < 0 || spec.IOPS < 0 {
+			return fmt.Errorf("disk capacity of %s should not be negative", name)
+		}
+		return nil
+	}
+	for name, spec := range cfg.Types {
+		if err := check(name, spec); err != nil {
+			return err
+		}
+	}
+	for name, spec := range cfg.Nodes {
+		if err := check(name, spec); err != nil {
+			return err
+		}
+		if _, ok := cfg.Types[spec.Type]; spec.Type != "" && !ok {
+			return fmt.Errorf("disk type %s of node %s does not exist", spec.Type, name)
+		}
+	}
+
+	return nil
+}
+
+// resolveDiskCapacity 按优先级确定每个节点的磁盘IO能力，吞吐和IOPS分别确定：
+// 配置中节点的能力 > 基准测试指标 > 节点标签中的能力 > 磁盘类型(配置优先于节点标签) > 默认能力
+func resolveDiskCapacity(cfg DiskCapacityConfig, bench map[string]model.DiskCapacity, labels map[string]map[string]string, nodeNames []string) map[string]model.DiskCapacity {
+	res := make(map[string]model.DiskCapacity, len(nodeNames))
+	for _, name := range nodeNames {
+		spec := cfg.Nodes[name]
+		c := spec.capacity().Merge(bench[name]).Merge(labelDiskCapacity(name, labels[name]))
+
+		diskType := spec.Type
+		if diskType == "" {
+			diskType = labels[name][model.LabelDiskType]
+		}
+		if typeSpec, ok := cfg.Types[diskType]; ok {
+			c = c.Merge(typeSpec.capacity())
+		} else if diskType != "" {
+			log.Warn("disk type %s of node %s does not exist, use default capacity", diskType, name)
+		}
+		res[name] = c.Merge(cfg.Default.capacity())
+	}
+
+	return res
+}
+
+// labelDiskCapacity 从节点标签中读取磁盘IO能力，标签值不合法时忽略
+func labelDiskCapacity(name string, labels map[string]string) model.DiskCapacity {
+	var c model.DiskCapacity
+	parse := func(key string) float64 {
+		v, ok := labels[key]
+		if !ok {
+			return 0
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			log.Warn("label %s=%s of node %s is invalid, ignore", key, v, name)
+			return 0
+		}
+		return f
+	}
+	c.Throughput = int64(parse(model.LabelDiskThroughput) * model.MByte)
+	c.IOPS = int64(parse(model.LabelDiskIOPS))
+
+	return c
+}
+
+// diskCapacity 各候选节点的磁盘IO能力，节点标签来自定期的同步，见SyncNodeLabels
+func (s *Service) diskCapacity(nodeNames []string) map[string]model.DiskCapacity {
+	s.diskBenchMu.RLock()
+	defer s.diskBenchMu.RUnlock()
+	s.nodeLabelsMu.RLock()
+	defer s.nodeLabelsMu.RUnlock()
+
+	return resolveDiskCapacity(s.diskCapConfig, s.diskBench, s.nodeLabels, nodeNames)
+}
+
+// SyncDiskCapacity 从Prometheus同步磁盘基准测试得到的磁盘IO能力，失败时保留上一次的结果
+func (s *Service) SyncDiskCapacity() error {
+	res, err := s.dao.RequestPromDiskCapacity()
+	if err != nil {
+		log.Warn("get disk capacity from prom error: %v", err)
+		return err
+	}
+
+	bench := make(map[string]model.DiskCapacity)
+	for _, name := range s.nodeNames {
+		if c, ok := res[name]; ok {
+			bench[name] = c
+		}
+	}
+	s.diskBenchMu.Lock()
+	s.diskBench = bench
+	s.diskBenchMu.Unlock()
+	log.V(5).Info("disk capacity from benchmark: %v", bench)
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"testing"
+
+	"liang/internal/model"
+)
+
+func TestResolveDiskCapacity(t *testing.T) {
+	cfg := DefaultDiskCapacityConfig()
+	cfg.Nodes = map[string]DiskCapacitySpec{
+		"node1": {Throughput: 300},
+		"node2": {Type: "hdd"},
+	}
+	bench := map[string]model.DiskCapacity{
+		"node1": {Throughput: 400 * model.MByte, IOPS: 60000},
+		"node3": {IOPS: 70000},
+	}
+	labels := map[string]map[string]string{
+		"node2": {model.LabelDiskType: "ssd"},
+		"node3": {model.LabelDiskThroughput: "800", model.LabelDiskIOPS: "bad"},
+		"node4": {model.LabelDiskType: "nvme"},
+	}
+
+	res := resolveDiskCapacity(cfg, bench, labels, []string{"node1", "node2", "node3", "node4", "node5"})
+	expected := map[string]model.DiskCapacity{
+		// 配置的吞吐优先于基准测试，IOPS来自基准测试
+		"node1": {Throughput: 300 * model.MByte, IOPS: 60000},
+		// 配置的磁盘类型优先于节点标签
+		"node2": {Throughput: 150 * model.MByte, IOPS: 200},
+		// 不合法的标签被忽略
+		"node3": {Throughput: 800 * model.MByte, IOPS: 70000},
+		"node4": {Throughput: 2000 * model.MByte, IOPS: 400000},
+		"node5": {Throughput: 200 * model.MByte, IOPS: 5000},
+	}
+	for name, c := range expected {
+		if res[name] != c {
+			t.Errorf("disk capacity of %s should be %+v, but get %+v", name, c, res[name])
+		}
+	}
+}
+
+func TestDiskCapacityConfig_Validate(t *testing.T) {
+	cfg := DefaultDiskCapacityConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("default config should be valid, but get %v", err)
+	}
+
+	cfg.Nodes = map[string]DiskCapacitySpec{"node1": {Type: "tape"}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("unknown disk type should be rejected")
+	}
+
+	cfg = DefaultDiskCapacityConfig()
+	cfg.Default.Throughput = 0
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("default throughput should be positive")
+	}
+}
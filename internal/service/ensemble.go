@@ -0,0 +1,266 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"liang/internal/model"
+
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// normalizeEnsemble 没有配置method时为weighted，算法没有配置mode时为balance，没有配置权重时为1
+func normalizeEnsemble(cfg *model.EnsembleConfig) *model.EnsembleConfig {
+	if cfg == nil {
+		return nil
+	}
+	res := &model.EnsembleConfig{Method: cfg.Method, Components: make([]model.EnsembleComponent, len(cfg.Components))}
+	if res.Method == "" {
+		res.Method = model.EnsembleWeighted
+	}
+	for i, c := range cfg.Components {
+		if c.Mode == "" {
+			c.Mode = model.PolicyModeBalance
+		}
+		if c.Weight == nil {
+			w := c.GetWeight()
+			c.Weight = &w
+		}
+		res.Components[i] = c
+	}
+
+	return res
+}
+
+// ValidateEnsembleConfig 检查组合方式和每个算法，算法只能是bnp/cmdn，不能嵌套组合
+func ValidateEnsembleConfig(cfg *model.EnsembleConfig) error {
+	if cfg == nil || len(cfg.Components) == 0 {
+		return fmt.Errorf("ensemble should have at least one component")
+	}
+	switch cfg.Method {
+	case model.EnsembleWeighted, model.EnsembleBorda, model.EnsembleLexicographic:
+	default:
+		return fmt.Errorf("ensemble method %s should be one of weighted/borda/lexicographic", cfg.Method)
+	}
+	for i, c := range cfg.Components {
+		if c.Algorithm != model.AlgoBNP && c.Algorithm != model.AlgoCMDN {
+			return fmt.Errorf("algorithm %s of ensemble component %d should be one of bnp/cmdn", c.Algorithm, i)
+		}
+		if c.Mode != model.PolicyModeBalance && c.Mode != model.PolicyModeCompact {
+			return fmt.Errorf("mode %s of ensemble component %d should be one of balance/compact", c.Mode, i)
+		}
+		if c.Algorithm == model.AlgoBNP && c.Mode == model.PolicyModeCompact {
+			return fmt.Errorf("ensemble component %d: bnp only supports balance mode", i)
+		}
+		if w := c.GetWeight(); w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return fmt.Errorf("weight %v of ensemble component %d should be non-negative", w, i)
+		}
+	}
+
+	return nil
+}
+
+// ensembleUses 组合评分中是否有算法为algo
+func ensembleUses(cfg *model.EnsembleConfig, algo string) bool {
+	if cfg == nil {
+		return false
+	}
+	for _, c := range cfg.Components {
+		if c.Algorithm == algo {
+			return true
+		}
+	}
+
+	return false
+}
+
+// excludedHosts 一次评分中因为缺失数据被排除或者在过滤阶段被排除的节点
+func excludedHosts(explain *model.Explanation) map[string]bool {
+	res := make(map[string]bool)
+	for _, mv := range explain.Missing {
+		if mv.Excluded {
+			res[mv.Host] = true
+		}
+	}
+	var rejected []model.NodeRejection
+	switch {
+	case explain.Balance != nil:
+		rejected = explain.Balance.Rejected
+	case explain.BNP != nil:
+		rejected = explain.BNP.Rejected
+	case explain.CMDN != nil:
+		rejected = explain.CMDN.Rejected
+	}
+	for _, r := range rejected {
+		res[r.Host] = true
+	}
+
+	return res
+}
+
+// explainEnsemble 在同一份快照上分别运行每个算法，再按配置的方式组合得分
+// 任一算法排除的节点得分为最低分，原因中带上算法的名称
+func explainEnsemble(in *ScoreInput) (*model.Explanation, extenderv1.HostPriorityList, error) {
+	cfg := in.Ensemble
+	if err := ValidateEnsembleConfig(cfg); err != nil {
+		return nil, GetDefaultScore(in.NodeNames), err
+	}
+
+	explain := &model.Explanation{
+		Algorithm: model.AlgoEnsemble,
+		Ensemble:  &model.EnsembleExplanation{Method: cfg.Method},
+		Missing:   []model.MissingValue{},
+	}
+	results := make([]map[string]int64, len(cfg.Components))
+	zero := make(map[string]bool)
+	reasons := make(map[string][]string)
+	seen := make(map[string]bool)
+	for i, c := range cfg.Components {
+		sub := *in
+		sub.Algorithm = c.Algorithm
+		sub.TopsisMin = c.Mode == model.PolicyModeBalance
+		sub.Ensemble = nil
+		e, res, err := explainSnapshot(&sub)
+		if err != nil {
+			return explain, GetDefaultScore(in.NodeNames), fmt.Errorf("ensemble component %s: %v", c.Algorithm, err)
+		}
+		explain.Ensemble.Components = append(explain.Ensemble.Components, model.EnsembleComponentResult{EnsembleComponent: c, Explanation: e})
+
+		results[i] = make(map[string]int64, len(res))
+		for _, hp := range res {
+			results[i][hp.Host] = hp.Score
+		}
+		for host := range excludedHosts(e) {
+			zero[host] = true
+		}
+		for _, hs := range e.Scores {
+			if hs.Reason != "" {
+				reasons[hs.Host] = append(reasons[hs.Host], c.Algorithm+": "+hs.Reason)
+			}
+		}
+		for _, mv := range e.Missing {
+			key := mv.Host + "/" + mv.Criterion
+			if !seen[key] {
+				seen[key] = true
+				explain.Missing = append(explain.Missing, mv)
+			}
+		}
+	}
+
+	scores := blendScores(cfg, in.NodeNames, results)
+	full := make(extenderv1.HostPriorityList, len(in.NodeNames))
+	explain.Scores = make([]model.HostScore, len(in.NodeNames))
+	for i, name := range in.NodeNames {
+		score := scores[name]
+		if zero[name] {
+			score = model.MinNodeScore
+		}
+		full[i] = extenderv1.HostPriority{Host: name, Score: score}
+		explain.Scores[i] = model.HostScore{Host: name, Score: score, Reason: strings.Join(reasons[name], "; ")}
+	}
+
+	return explain, full, nil
+}
+
+// blendScores 按组合方式计算每个节点的得分，results与cfg.Components一一对应
+func blendScores(cfg *model.EnsembleConfig, nodeNames []string, results []map[string]int64) map[string]int64 {
+	switch cfg.Method {
+	case model.EnsembleBorda:
+		points := make([]map[string]int64, len(results))
+		for i, r := range results {
+			points[i] = bordaPoints(nodeNames, r)
+		}
+		return weightedScores(cfg.Components, nodeNames, points)
+	case model.EnsembleLexicographic:
+		return lexicographicScores(nodeNames, results)
+	default:
+		return weightedScores(cfg.Components, nodeNames, results)
+	}
+}
+
+// weightedScores 按权重加权平均各算法的得分，权重之和为0时平均分配
+func weightedScores(components []model.EnsembleComponent, nodeNames []string, results []map[string]int64) map[string]int64 {
+	weights := make([]float64, len(components))
+	var total float64
+	for i, c := range components {
+		weights[i] = c.GetWeight()
+		total += weights[i]
+	}
+	if total == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+		total = float64(len(weights))
+	}
+	res := make(map[string]int64, len(nodeNames))
+	for _, name := range nodeNames {
+		var sum float64
+		for i, w := range weights {
+			sum += w * float64(results[i][name])
+		}
+		res[name] = int64(math.Round(sum / total))
+	}
+
+	return res
+}
+
+// bordaPoints Borda计数，节点的分数为得分严格低于它的节点数量，再按比例映射到[MinNodeScore, MaxNodeScore]
+// 得分相同的节点分数相同，只有一个节点时为最高分
+func bordaPoints(nodeNames []string, scores map[string]int64) map[string]int64 {
+	res := make(map[string]int64, len(nodeNames))
+	n := len(nodeNames)
+	for _, name := range nodeNames {
+		lower := 0
+		for _, other := range nodeNames {
+			if scores[other] < scores[name] {
+				lower++
+			}
+		}
+		if n <= 1 {
+			res[name] = model.MaxNodeScore
+			continue
+		}
+		res[name] = model.MinNodeScore + int64(math.Round(float64(lower)*float64(model.MaxNodeScore-model.MinNodeScore)/float64(n-1)))
+	}
+
+	return res
+}
+
+// lexicographicScores 按第一个算法的得分排序，相同时依次比较后面算法的得分
+// 按排序后不同的得分组合均匀分配[MinNodeScore, MaxNodeScore]，得分组合完全相同的节点得分相同
+func lexicographicScores(nodeNames []string, results []map[string]int64) map[string]int64 {
+	less := func(a, b string) bool {
+		for _, r := range results {
+			if r[a] != r[b] {
+				return r[a] > r[b]
+			}
+		}
+		return false
+	}
+	sorted := append([]string{}, nodeNames...)
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+
+	groups := make([]int, len(sorted))
+	for i := 1; i < len(sorted); i++ {
+		groups[i] = groups[i-1]
+		if less(sorted[i-1], sorted[i]) {
+			groups[i]++
+		}
+	}
+	res := make(map[string]int64, len(sorted))
+	if len(sorted) == 0 {
+		return res
+	}
+	last := groups[len(groups)-1]
+	for i, name := range sorted {
+		if last == 0 {
+			res[name] = model.MaxNodeScore
+			continue
+		}
+		res[name] = model.MaxNodeScore - int64(math.Round(float64(groups[i])*float64(model.MaxNodeScore-model.MinNodeScore)/float64(last)))
+	}
+
+	return res
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"testing"
+
+	"liang/internal/fakeprom"
+	"liang/internal/model"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func TestValidateEnsembleConfig(t *testing.T) {
+	cases := []struct {
+		Name  string
+		Cfg   *model.EnsembleConfig
+		Valid bool
+	}{
+		{Name: "nil"},
+		{Name: "no component", Cfg: &model.EnsembleConfig{}},
+		{Name: "unknown method", Cfg: &model.EnsembleConfig{Method: "median", Components: []model.EnsembleComponent{{Algorithm: model.AlgoBNP}}}},
+		{Name: "nested ensemble", Cfg: &model.EnsembleConfig{Components: []model.EnsembleComponent{{Algorithm: model.AlgoEnsemble}}}},
+		{Name: "compact bnp", Cfg: &model.EnsembleConfig{Components: []model.EnsembleComponent{{Algorithm: model.AlgoBNP, Mode: model.PolicyModeCompact}}}},
+		{Name: "negative weight", Cfg: &model.EnsembleConfig{Components: []model.EnsembleComponent{{Algorithm: model.AlgoBNP, Weight: ensembleWeight(-1)}}}},
+		{Name: "valid", Cfg: &model.EnsembleConfig{Method: model.EnsembleBorda, Components: []model.EnsembleComponent{
+			{Algorithm: model.AlgoCMDN, Mode: model.PolicyModeCompact, Weight: ensembleWeight(2)}, {Algorithm: model.AlgoBNP}}}, Valid: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if err := ValidateEnsembleConfig(normalizeEnsemble(c.Cfg)); (err == nil) != c.Valid {
+				t.Errorf("valid should be %v, but get %v", c.Valid, err)
+			}
+		})
+	}
+}
+
+func ensembleWeight(w float64) *float64 {
+	return &w
+}
+
+func TestNormalizeEnsemble_Weight(t *testing.T) {
+	cfg := normalizeEnsemble(&model.EnsembleConfig{Components: []model.EnsembleComponent{
+		{Algorithm: model.AlgoCMDN}, {Algorithm: model.AlgoBNP, Weight: ensembleWeight(0)},
+	}})
+	if w := cfg.Components[0].Weight; w == nil || *w != 1 {
+		t.Errorf("omitted weight should default to 1, but get %v", w)
+	}
+	if w := cfg.Components[1].Weight; w == nil || *w != 0 {
+		t.Errorf("explicit zero weight should be kept, but get %v", w)
+	}
+
+	nodeNames := []string{"node1", "node2"}
+	results := []map[string]int64{{"node1": 100, "node2": 0}, {"node1": 0, "node2": 60}}
+	// 权重为0的bnp不参与加权，得分只由cmdn决定
+	scores := weightedScores(cfg.Components, nodeNames, results)
+	if scores["node1"] != 100 || scores["node2"] != 0 {
+		t.Errorf("zero weight component should be ignored, but get %v", scores)
+	}
+	// 权重全部为0时平均分配
+	zero := []model.EnsembleComponent{{Algorithm: model.AlgoCMDN, Weight: ensembleWeight(0)}, {Algorithm: model.AlgoBNP, Weight: ensembleWeight(0)}}
+	scores = weightedScores(normalizeEnsemble(&model.EnsembleConfig{Components: zero}).Components, nodeNames, results)
+	if scores["node1"] != 50 || scores["node2"] != 30 {
+		t.Errorf("all zero weights should split equally, but get %v", scores)
+	}
+}
+
+func TestBlendScores(t *testing.T) {
+	nodeNames := []string{"node1", "node2", "node3", "node4"}
+	results := []map[string]int64{
+		{"node1": 80, "node2": 80, "node3": 40, "node4": 0},
+		{"node1": 10, "node2": 90, "node3": 100, "node4": 100},
+	}
+	components := []model.EnsembleComponent{{Algorithm: model.AlgoCMDN, Weight: ensembleWeight(3)}, {Algorithm: model.AlgoBNP, Weight: ensembleWeight(1)}}
+	cases := []struct {
+		Method   string
+		Expected map[string]int64
+	}{
+		// (3*80+10)/4=62.5, (3*80+90)/4=82.5
+		{Method: model.EnsembleWeighted, Expected: map[string]int64{"node1": 63, "node2": 83, "node3": 55, "node4": 25}},
+		// cmdn的排名得分为67/67/33/0，bnp为0/33/67/67，(3*67+33)/4=58.5
+		{Method: model.EnsembleBorda, Expected: map[string]int64{"node1": 50, "node2": 59, "node3": 42, "node4": 17}},
+		// cmdn相同的node1和node2由bnp决定先后
+		{Method: model.EnsembleLexicographic, Expected: map[string]int64{"node2": 100, "node1": 67, "node3": 33, "node4": 0}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Method, func(t *testing.T) {
+			scores := blendScores(&model.EnsembleConfig{Method: c.Method, Components: components}, nodeNames, results)
+			for name, v := range c.Expected {
+				if scores[name] != v {
+					t.Errorf("score of %s should be %d, but get %d", name, v, scores[name])
+				}
+			}
+		})
+	}
+
+	// 得分完全相同的节点得分相同
+	tied := lexicographicScores([]string{"node1", "node2"}, []map[string]int64{{"node1": 50, "node2": 50}})
+	if tied["node1"] != model.MaxNodeScore || tied["node2"] != model.MaxNodeScore {
+		t.Errorf("tied nodes should both get max score, but get %v", tied)
+	}
+}
+
+func TestService_Ensemble(t *testing.T) {
+	prom := fakeprom.New()
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 100000, "node2": 600000, "node3": 2400000})
+	prom.SetConst(fakeprom.DiskWritten, map[string]float64{"node1": 1024, "node2": 2048, "node3": 4096})
+	prom.SetConst(fakeprom.CPUSeconds, map[string]float64{"node1": 0.2, "node2": 0.4, "node3": 0.6})
+	prom.SetConst(fakeprom.MemAvailable, map[string]float64{"node1": 0.3, "node2": 0.5, "node3": 0.7})
+	s := newFakePromService(t, prom, true, `
+[policy]
+default = "cmdn-net"
+
+[policy.policies.cmdn-net]
+algorithm = "ensemble"
+
+[policy.policies.cmdn-net.ensemble]
+method = "lexicographic"
+
+[[policy.policies.cmdn-net.ensemble.components]]
+algorithm = "cmdn"
+
+[[policy.policies.cmdn-net.ensemble.components]]
+algorithm = "bnp"
+weight = 0.5
+`)
+
+	p := s.ResolvePolicy(nil)
+	if p.Algorithm != model.AlgoEnsemble || p.Ensemble == nil || len(p.Ensemble.Components) != 2 {
+		t.Fatalf("default policy should be the ensemble, but get %+v", p)
+	}
+	if c := p.Ensemble.Components[0]; c.Mode != model.PolicyModeBalance || c.Weight == nil || *c.Weight != 1 {
+		t.Errorf("component should be normalized, but get %+v", c)
+	}
+	if w := p.Ensemble.Components[1].Weight; w == nil || *w != 0.5 {
+		t.Errorf("weight of bnp should be 0.5, but get %v", w)
+	}
+	if !s.usesAlgorithm(model.AlgoCMDN) || s.syncNetIOOnly() {
+		t.Errorf("cmdn in ensemble should be used and all metrics should be synced")
+	}
+
+	nodeNames := []string{"node1", "node2", "node3"}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default",
+		Annotations: map[string]string{model.ResourceNetIOKey: "1000"}}}
+	args := &extenderv1.ExtenderArgs{Pod: pod, NodeNames: &nodeNames}
+	res, err := s.Prioritize(args)
+	if err != nil {
+		t.Fatalf("prioritize error: %v", err)
+	}
+	explain, err := s.Explain(args)
+	if err != nil {
+		t.Fatalf("explain error: %v", err)
+	}
+	if explain.Algorithm != model.AlgoEnsemble || explain.Ensemble == nil || len(explain.Ensemble.Components) != 2 {
+		t.Fatalf("explanation should contain both components, but get %+v", explain)
+	}
+	if explain.Ensemble.Components[0].Explanation.CMDN == nil || explain.Ensemble.Components[1].Explanation.BNP == nil {
+		t.Errorf("each component should have its own explanation")
+	}
+	for i, hs := range explain.Scores {
+		if (*res)[i].Host != hs.Host || (*res)[i].Score != hs.Score {
+			t.Errorf("explain score %+v differs from prioritize %+v", hs, (*res)[i])
+		}
+	}
+	// node3剩余带宽不足1000Mbps，被bnp过滤后得分最低
+	if explain.Scores[2].Score != model.MinNodeScore || explain.Scores[2].Reason == "" {
+		t.Errorf("node3 rejected by bnp should get min score with reason, but get %+v", explain.Scores[2])
+	}
+}
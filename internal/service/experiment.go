@@ -0,0 +1,601 @@
+package service
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"liang/internal/dao"
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/log"
+	xtime "github.com/go-kratos/kratos/pkg/time"
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/gonum/stat/distuv"
+	v1 "k8s.io/api/core/v1"
+)
+
+// 实验分组使用的哈希键
+const (
+	HashByNamespace = "namespace"
+	HashByOwner     = "owner"
+)
+
+// ExperimentConfig A/B实验的配置，对应application.toml中的[experiment]
+// 解析得到Control策略的Pod中，按HashBy哈希后Fraction比例的Pod改用Treatment策略，其余为对照组
+// 通过Pod informer跟踪每组Pod从绑定到运行的时间、重启次数，以及运行Window后节点负载方差的变化和所在节点相对均值的负载，按Confidence计算置信区间
+type ExperimentConfig struct {
+	Enabled     bool
+	Name        string
+	Control     string  // 对照组的策略，默认为cluster
+	Treatment   string  // 实验组的策略
+	Fraction    float64 // 分到实验组的比例
+	HashBy      string  // namespace或owner，同一个命名空间或者控制器的Pod总在同一组
+	Salt        string  // 改变Salt可以重新分组
+	Kubeconfig  string  // 为空时使用集群内的ServiceAccount
+	Window      xtime.Duration
+	Timeout     xtime.Duration // 分组后超过Timeout仍没有运行的Pod结束跟踪，计入NotRunning
+	Confidence  float64
+	MaxOutcomes int // 保留的完成结果和同时跟踪的Pod的最大数量
+}
+
+// DefaultExperimentConfig 没有配置[experiment]时不做实验
+func DefaultExperimentConfig() ExperimentConfig {
+	return ExperimentConfig{
+		Control:     model.PolicyCluster,
+		Fraction:    0.1,
+		HashBy:      HashByNamespace,
+		Window:      xtime.Duration(10 * time.Minute),
+		Timeout:     xtime.Duration(30 * time.Minute),
+		Confidence:  0.95,
+		MaxOutcomes: 10000,
+	}
+}
+
+// Validate 检查两组的策略和实验参数
+func (cfg ExperimentConfig) Validate(policyCfg PolicyConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Control == "" || !policyCfg.exist(cfg.Control) {
+		return fmt.Errorf("experiment control policy %s does not exist", cfg.Control)
+	}
+	if cfg.Treatment == "" || !policyCfg.exist(cfg.Treatment) {
+		return fmt.Errorf("experiment treatment policy %s does not exist", cfg.Treatment)
+	}
+	if cfg.Control == cfg.Treatment {
+		return fmt.Errorf("experiment treatment policy should differ from control %s", cfg.Control)
+	}
+	if cfg.Fraction < 0 || cfg.Fraction > 1 {
+		return fmt.Errorf("experiment fraction %v should be in [0, 1]", cfg.Fraction)
+	}
+	if cfg.HashBy != HashByNamespace && cfg.HashBy != HashByOwner {
+		return fmt.Errorf("experiment hashBy %s should be one of namespace/owner", cfg.HashBy)
+	}
+	if cfg.Window <= 0 || cfg.Timeout <= 0 {
+		return fmt.Errorf("experiment window and timeout should be positive")
+	}
+	if cfg.Confidence <= 0 || cfg.Confidence >= 1 {
+		return fmt.Errorf("experiment confidence %v should be in (0, 1)", cfg.Confidence)
+	}
+	if cfg.MaxOutcomes < 1 {
+		return fmt.Errorf("experiment max outcomes %d should be positive", cfg.MaxOutcomes)
+	}
+
+	return nil
+}
+
+// experimentState 正在跟踪的Pod和已经完成的结果，outcomes按完成时间从旧到新
+// disabled不为空时Pod informer不可用，实验已经关闭
+type experimentState struct {
+	mu       sync.Mutex
+	disabled string
+	pending  map[string]*model.ExperimentOutcome
+	outcomes []*model.ExperimentOutcome
+	assigned map[string]int
+}
+
+// experimentActive 开启了实验并且Pod informer没有出错
+func (s *Service) experimentActive() bool {
+	if !s.experimentCfg.Enabled {
+		return false
+	}
+	s.experiment.mu.Lock()
+	defer s.experiment.mu.Unlock()
+
+	return s.experiment.disabled == ""
+}
+
+// disableExperiment Pod informer不可用时无法得到实验结果，关闭实验并丢弃正在跟踪的Pod，之后所有Pod使用原来的策略
+func (s *Service) disableExperiment(err error) {
+	s.experiment.mu.Lock()
+	defer s.experiment.mu.Unlock()
+	s.experiment.disabled = err.Error()
+	for key := range s.experiment.pending {
+		delete(s.experiment.pending, key)
+	}
+}
+
+// experimentHashKey Pod用于分组的键，owner时使用控制器，没有控制器的Pod使用自身
+func experimentHashKey(pod *v1.Pod, hashBy string) string {
+	if hashBy == HashByOwner {
+		for _, ref := range pod.OwnerReferences {
+			if ref.Controller != nil && *ref.Controller {
+				return pod.Namespace + "/" + ref.Kind + "/" + ref.Name
+			}
+		}
+		return pod.Namespace + "/Pod/" + pod.Name
+	}
+
+	return pod.Namespace
+}
+
+// experimentArm 按哈希值把Pod均匀映射到[0, 1)，小于Fraction的分到实验组
+// 使用sha1而不是fnv，只差最后一个字符的命名空间在fnv下哈希值非常接近，会分到同一组
+func experimentArm(cfg ExperimentConfig, pod *v1.Pod) string {
+	sum := sha1.Sum([]byte(cfg.Salt + "/" + experimentHashKey(pod, cfg.HashBy)))
+	if float64(binary.BigEndian.Uint64(sum[:8]))/(1<<64) < cfg.Fraction {
+		return model.ArmTreatment
+	}
+
+	return model.ArmControl
+}
+
+// assignExperiment 解析得到对照组策略的Pod参与实验，实验组改用Treatment策略
+func (s *Service) assignExperiment(pod *v1.Pod, p *model.PolicyResolution) *model.PolicyResolution {
+	if !s.experimentActive() || pod == nil || p.Name != s.experimentCfg.Control {
+		return p
+	}
+	if experimentArm(s.experimentCfg, pod) == model.ArmControl {
+		p.Arm = model.ArmControl
+		return p
+	}
+	res := s.namedPolicy(s.experimentCfg.Treatment, model.PolicySourceExperiment)
+	res.Arm = model.ArmTreatment
+
+	return res
+}
+
+// experimentPodKey 跟踪Pod使用的键，没有UID时使用namespace/name
+func experimentPodKey(pod *v1.Pod) string {
+	if pod.UID != "" {
+		return string(pod.UID)
+	}
+
+	return pod.Namespace + "/" + pod.Name
+}
+
+// trackExperiment Prioritize成功评分后开始跟踪参与实验的Pod，调度重试时保留第一次的分组
+func (s *Service) trackExperiment(pod *v1.Pod, p *model.PolicyResolution, now time.Time) {
+	if pod == nil || p == nil || p.Arm == "" {
+		return
+	}
+	key := experimentPodKey(pod)
+
+	s.experiment.mu.Lock()
+	defer s.experiment.mu.Unlock()
+	if s.experiment.disabled != "" {
+		return
+	}
+	if s.experiment.pending == nil {
+		s.experiment.pending = make(map[string]*model.ExperimentOutcome)
+		s.experiment.assigned = make(map[string]int)
+	}
+	if _, ok := s.experiment.pending[key]; ok {
+		return
+	}
+	if len(s.experiment.pending) >= s.experimentCfg.MaxOutcomes {
+		log.Warn("experiment is tracking %d pods, skip pod %s/%s", len(s.experiment.pending), pod.Namespace, pod.Name)
+		return
+	}
+	s.experiment.pending[key] = &model.ExperimentOutcome{
+		PodUID:     string(pod.UID),
+		Namespace:  pod.Namespace,
+		Name:       pod.Name,
+		Arm:        p.Arm,
+		Policy:     p.Name,
+		AssignedAt: now,
+	}
+	s.experiment.assigned[p.Arm]++
+}
+
+// podScheduledTime Pod绑定到节点的时间，没有PodScheduled条件时为now
+func podScheduledTime(pod *v1.Pod, now time.Time) time.Time {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodScheduled && c.Status == v1.ConditionTrue && !c.LastTransitionTime.IsZero() {
+			return c.LastTransitionTime.Time
+		}
+	}
+
+	return now
+}
+
+// podRunningTime 所有容器都开始运行的时间，即容器中最晚的启动时间，没有容器状态时为now
+func podRunningTime(pod *v1.Pod, now time.Time) time.Time {
+	var res time.Time
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Running == nil {
+			return now
+		}
+		if cs.State.Running.StartedAt.Time.After(res) {
+			res = cs.State.Running.StartedAt.Time
+		}
+	}
+	if res.IsZero() {
+		return now
+	}
+
+	return res
+}
+
+// podRestarts 所有容器的重启次数之和
+func podRestarts(pod *v1.Pod) int32 {
+	var res int32
+	for _, cs := range pod.Status.ContainerStatuses {
+		res += cs.RestartCount
+	}
+
+	return res
+}
+
+// updateOutcome 按Pod的最新状态更新跟踪中的结果
+func updateOutcome(o *model.ExperimentOutcome, pod *v1.Pod, now time.Time) {
+	if pod.Spec.NodeName != "" {
+		o.Node = pod.Spec.NodeName
+		if o.ScheduledAt.IsZero() {
+			o.ScheduledAt = podScheduledTime(pod, now)
+		}
+	}
+	if restarts := podRestarts(pod); restarts > o.Restarts {
+		o.Restarts = restarts
+	}
+	if pod.Status.Phase == v1.PodRunning && !o.Running && !o.ScheduledAt.IsZero() {
+		o.Running = true
+		o.RunningAt = podRunningTime(pod, now)
+		o.TimeToRunning = math.Max(0, o.RunningAt.Sub(o.ScheduledAt).Seconds())
+	}
+}
+
+// ObserveExperimentPod 处理informer中Pod的新增和更新，运行结束的Pod立即结束跟踪
+func (s *Service) ObserveExperimentPod(pod *v1.Pod) {
+	s.observeExperimentPod(pod, time.Now())
+}
+
+func (s *Service) observeExperimentPod(pod *v1.Pod, now time.Time) {
+	key := experimentPodKey(pod)
+	s.experiment.mu.Lock()
+	o, ok := s.experiment.pending[key]
+	placed := false
+	if ok {
+		placed = o.Node == "" && pod.Spec.NodeName != ""
+		updateOutcome(o, pod, now)
+	}
+	s.experiment.mu.Unlock()
+
+	// 第一次绑定到节点时记录调度前的负载方差，每个Pod使用各自绑定时的快照
+	if placed {
+		variance := loadVariance(s.clusterUtilization())
+		s.experiment.mu.Lock()
+		if s.experiment.pending[key] == o {
+			o.LoadVarianceBefore = variance
+		}
+		s.experiment.mu.Unlock()
+	}
+
+	if ok && (pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed) {
+		s.finishExperimentPods(now, key)
+	}
+}
+
+// ForgetExperimentPod 处理informer中Pod的删除，按最后的状态结束跟踪，还没有运行的Pod记为Deleted
+func (s *Service) ForgetExperimentPod(pod *v1.Pod) {
+	now := time.Now()
+	key := experimentPodKey(pod)
+	s.observeExperimentPod(pod, now)
+	s.experiment.mu.Lock()
+	if o, ok := s.experiment.pending[key]; ok && !o.Running {
+		o.Deleted = true
+	}
+	s.experiment.mu.Unlock()
+	s.finishExperimentPods(now, key)
+}
+
+// FinishExperimentPods 每次同步后结束运行超过Window或者等待超过Timeout的Pod的跟踪
+func (s *Service) FinishExperimentPods() {
+	if s.experimentActive() {
+		s.finishExperimentPods(time.Now())
+	}
+}
+
+// finishExperimentPods 结束keys中Pod的跟踪，keys为空时结束全部到期的Pod
+// 结束时记录节点负载方差相对绑定时的变化，以及Pod所在节点相对各节点均值的负载
+func (s *Service) finishExperimentPods(now time.Time, keys ...string) {
+	window, timeout := time.Duration(s.experimentCfg.Window), time.Duration(s.experimentCfg.Timeout)
+	s.experiment.mu.Lock()
+	due := make([]string, 0)
+	if len(keys) > 0 {
+		for _, key := range keys {
+			if _, ok := s.experiment.pending[key]; ok {
+				due = append(due, key)
+			}
+		}
+	} else {
+		for key, o := range s.experiment.pending {
+			if (o.Running && now.Sub(o.RunningAt) >= window) || (!o.Running && now.Sub(o.AssignedAt) >= timeout) {
+				due = append(due, key)
+			}
+		}
+	}
+	s.experiment.mu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	util := s.clusterUtilization()
+	variance := loadVariance(util)
+	s.experiment.mu.Lock()
+	defer s.experiment.mu.Unlock()
+	sort.Strings(due)
+	for _, key := range due {
+		o, ok := s.experiment.pending[key]
+		if !ok {
+			continue
+		}
+		delete(s.experiment.pending, key)
+		o.FinishedAt = now
+		if o.Running {
+			o.LoadVarianceDelta = varianceDelta(o.LoadVarianceBefore, variance)
+			o.NodeLoadOffset = nodeLoadOffset(util, o.Node)
+		}
+		s.experiment.outcomes = append(s.experiment.outcomes, o)
+		log.V(3).Info("experiment outcome of pod %s/%s: %+v", o.Namespace, o.Name, o)
+	}
+	if len(s.experiment.outcomes) > s.experimentCfg.MaxOutcomes {
+		s.experiment.outcomes = s.experiment.outcomes[len(s.experiment.outcomes)-s.experimentCfg.MaxOutcomes:]
+	}
+}
+
+// experimentLoadKeys 实验中计算负载方差的资源
+var experimentLoadKeys = []string{model.ResourceCPUKey, model.ResourceMemKey, model.ResourceNetIOKey}
+
+// clusterUtilization 当前快照中CPU、内存和网络在各节点上的利用率，网络为负载占网卡带宽的百分比
+func (s *Service) clusterUtilization() map[string]map[string]float64 {
+	snapshot, err := s.dao.GetAllInfo()
+	if err != nil {
+		log.Error("get snapshot for experiment load error: %v", err)
+		return nil
+	}
+	netBw := s.netBw()
+	res := make(map[string]map[string]float64, len(experimentLoadKeys))
+	for _, key := range experimentLoadKeys {
+		util := make(map[string]float64, len(s.nodeNames))
+		for _, name := range s.nodeNames {
+			v, ok := snapshot[key][name]
+			if !ok {
+				continue
+			}
+			if key == model.ResourceNetIOKey {
+				if netBw[name] <= 0 {
+					continue
+				}
+				util[name] = 100 * float64(v) / float64(netBw[name])
+				continue
+			}
+			util[name] = float64(v)
+		}
+		if len(util) > 0 {
+			res[key] = util
+		}
+	}
+
+	return res
+}
+
+// utilValues 按节点名称排序的利用率，保证求和的顺序固定
+func utilValues(util map[string]float64) []float64 {
+	names := make([]string, 0, len(util))
+	for name := range util {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := make([]float64, len(names))
+	for i, name := range names {
+		res[i] = util[name]
+	}
+
+	return res
+}
+
+// loadVariance 各资源在各节点上利用率的总体方差
+func loadVariance(util map[string]map[string]float64) map[string]float64 {
+	if util == nil {
+		return nil
+	}
+	res := make(map[string]float64, len(util))
+	for key, values := range util {
+		res[key] = stat.PopVariance(utilValues(values), nil)
+	}
+
+	return res
+}
+
+// varianceDelta 两个时刻负载方差之差，只包含两个时刻都有的资源
+func varianceDelta(before, after map[string]float64) map[string]float64 {
+	if before == nil || after == nil {
+		return nil
+	}
+	res := make(map[string]float64, len(after))
+	for key, v := range after {
+		if b, ok := before[key]; ok {
+			res[key] = v - b
+		}
+	}
+
+	return res
+}
+
+// nodeLoadOffset node的各资源利用率减去各节点利用率的均值，为正时Pod被调度到负载高于平均的节点
+func nodeLoadOffset(util map[string]map[string]float64, node string) map[string]float64 {
+	if util == nil || node == "" {
+		return nil
+	}
+	res := make(map[string]float64, len(util))
+	for key, values := range util {
+		if v, ok := values[node]; ok {
+			res[key] = v - stat.Mean(utilValues(values), nil)
+		}
+	}
+
+	return res
+}
+
+// metricStats 样本的均值、标准差和均值的t分布置信区间
+func metricStats(values []float64, confidence float64) model.MetricStats {
+	res := model.MetricStats{N: len(values)}
+	if len(values) == 0 {
+		return res
+	}
+	if len(values) == 1 {
+		res.Mean, res.Lower, res.Upper = values[0], values[0], values[0]
+		return res
+	}
+	res.Mean, res.Stddev = stat.MeanStdDev(values, nil)
+	t := distuv.StudentsT{Mu: 0, Sigma: 1, Nu: float64(len(values) - 1)}.Quantile(1 - (1-confidence)/2)
+	half := t * res.Stddev / math.Sqrt(float64(len(values)))
+	res.Lower, res.Upper = res.Mean-half, res.Mean+half
+
+	return res
+}
+
+// welchDiff 实验组与对照组均值之差的Welch置信区间，两组都至少需要2个样本
+func welchDiff(treatment, control model.MetricStats, confidence float64) (model.MetricDiff, bool) {
+	if treatment.N < 2 || control.N < 2 {
+		return model.MetricDiff{}, false
+	}
+	diff := model.MetricDiff{Diff: treatment.Mean - control.Mean}
+	vt := treatment.Stddev * treatment.Stddev / float64(treatment.N)
+	vc := control.Stddev * control.Stddev / float64(control.N)
+	se := math.Sqrt(vt + vc)
+	if se == 0 {
+		diff.Lower, diff.Upper = diff.Diff, diff.Diff
+		return diff, true
+	}
+	df := (vt + vc) * (vt + vc) / (vt*vt/float64(treatment.N-1) + vc*vc/float64(control.N-1))
+	t := distuv.StudentsT{Mu: 0, Sigma: 1, Nu: df}.Quantile(1 - (1-confidence)/2)
+	diff.Lower, diff.Upper = diff.Diff-t*se, diff.Diff+t*se
+
+	return diff, true
+}
+
+// outcomeSamples 运行过的Pod的各结果指标的样本
+func outcomeSamples(outcomes []*model.ExperimentOutcome) map[string][]float64 {
+	res := make(map[string][]float64)
+	for _, o := range outcomes {
+		if !o.Running {
+			continue
+		}
+		res[model.OutcomeTimeToRunning] = append(res[model.OutcomeTimeToRunning], o.TimeToRunning)
+		res[model.OutcomeRestarts] = append(res[model.OutcomeRestarts], float64(o.Restarts))
+		for key, v := range o.LoadVarianceDelta {
+			name := model.OutcomeLoadVarianceDelta + "." + key
+			res[name] = append(res[name], v)
+		}
+		for key, v := range o.NodeLoadOffset {
+			name := model.OutcomeNodeLoadOffset + "." + key
+			res[name] = append(res[name], v)
+		}
+	}
+
+	return res
+}
+
+// ExperimentReport 每组的结果统计和两组之差的置信区间
+func (s *Service) ExperimentReport() *model.ExperimentReport {
+	cfg := s.experimentCfg
+	report := &model.ExperimentReport{
+		Name:       cfg.Name,
+		Enabled:    cfg.Enabled,
+		Population: model.ExperimentPopulation,
+		HashBy:     cfg.HashBy,
+		Fraction:   cfg.Fraction,
+		Confidence: cfg.Confidence,
+		Arms:       []model.ArmReport{},
+		Difference: map[string]model.MetricDiff{},
+	}
+	if !cfg.Enabled {
+		return report
+	}
+
+	s.experiment.mu.Lock()
+	report.Disabled = s.experiment.disabled
+	byArm := make(map[string][]*model.ExperimentOutcome)
+	for _, o := range s.experiment.outcomes {
+		byArm[o.Arm] = append(byArm[o.Arm], o)
+	}
+	tracking := make(map[string]int)
+	for _, o := range s.experiment.pending {
+		tracking[o.Arm]++
+	}
+	assigned := make(map[string]int, len(s.experiment.assigned))
+	for arm, n := range s.experiment.assigned {
+		assigned[arm] = n
+	}
+	s.experiment.mu.Unlock()
+
+	arms := []struct{ Arm, Policy string }{
+		{Arm: model.ArmControl, Policy: cfg.Control},
+		{Arm: model.ArmTreatment, Policy: cfg.Treatment},
+	}
+	stats := make(map[string]map[string]model.MetricStats, len(arms))
+	for _, a := range arms {
+		r := model.ArmReport{
+			Arm:       a.Arm,
+			Policy:    a.Policy,
+			Assigned:  assigned[a.Arm],
+			Tracking:  tracking[a.Arm],
+			Completed: len(byArm[a.Arm]),
+			Metrics:   make(map[string]model.MetricStats),
+		}
+		for _, o := range byArm[a.Arm] {
+			switch {
+			case o.Deleted:
+				r.Deleted++
+			case !o.Running:
+				r.NotRunning++
+			}
+		}
+		for name, values := range outcomeSamples(byArm[a.Arm]) {
+			r.Metrics[name] = metricStats(values, cfg.Confidence)
+		}
+		stats[a.Arm] = r.Metrics
+		report.Arms = append(report.Arms, r)
+	}
+	for name, control := range stats[model.ArmControl] {
+		if diff, ok := welchDiff(stats[model.ArmTreatment][name], control, cfg.Confidence); ok {
+			report.Difference[name] = diff
+		}
+	}
+
+	return report
+}
+
+// watchExperimentPods 开启实验时通过informer跟踪Pod的状态，dryrun时没有集群，不跟踪
+// informer不可用时关闭实验，避免Pod被分到实验组却没有结果
+func (s *Service) watchExperimentPods() error {
+	if !s.experimentCfg.Enabled || s.dryrun {
+		return nil
+	}
+	err := dao.WatchPods(s.experimentCfg.Kubeconfig, 0, dao.PodHandler{
+		OnPodUpdate: s.ObserveExperimentPod,
+		OnPodDelete: s.ForgetExperimentPod,
+	}, s.closeCh)
+	if err != nil {
+		s.disableExperiment(fmt.Errorf("watch pods error: %v", err))
+	}
+
+	return err
+}
@@ -0,0 +1,394 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"liang/internal/fakeprom"
+	"liang/internal/model"
+
+	"gonum.org/v1/gonum/stat"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func TestExperimentConfig_Validate(t *testing.T) {
+	policyCfg := DefaultPolicyConfig()
+	policyCfg.Policies = map[string]model.Policy{"spread": {Algorithm: model.AlgoBNP, Mode: model.PolicyModeBalance}}
+	cases := []struct {
+		Name   string
+		Modify func(cfg *ExperimentConfig)
+		Valid  bool
+	}{
+		{Name: "disabled", Modify: func(cfg *ExperimentConfig) { cfg.Enabled = false }, Valid: true},
+		{Name: "enabled", Modify: func(cfg *ExperimentConfig) {}, Valid: true},
+		{Name: "unknown treatment", Modify: func(cfg *ExperimentConfig) { cfg.Treatment = "pack" }},
+		{Name: "same policy", Modify: func(cfg *ExperimentConfig) { cfg.Control = "spread" }},
+		{Name: "fraction too large", Modify: func(cfg *ExperimentConfig) { cfg.Fraction = 1.5 }},
+		{Name: "unknown hash", Modify: func(cfg *ExperimentConfig) { cfg.HashBy = "node" }},
+		{Name: "zero window", Modify: func(cfg *ExperimentConfig) { cfg.Window = 0 }},
+		{Name: "full confidence", Modify: func(cfg *ExperimentConfig) { cfg.Confidence = 1 }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			cfg := DefaultExperimentConfig()
+			cfg.Enabled = true
+			cfg.Treatment = "spread"
+			c.Modify(&cfg)
+			if err := cfg.Validate(policyCfg); (err == nil) != c.Valid {
+				t.Errorf("valid should be %v, but get %v", c.Valid, err)
+			}
+		})
+	}
+}
+
+func TestExperimentArm(t *testing.T) {
+	cfg := DefaultExperimentConfig()
+	cfg.Fraction = 0.3
+	treatment := 0
+	for i := 0; i < 2000; i++ {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: fmt.Sprintf("ns-%d", i)}}
+		if experimentArm(cfg, pod) == model.ArmTreatment {
+			treatment++
+		}
+	}
+	if treatment < 500 || treatment > 700 {
+		t.Errorf("about 30%% of 2000 namespaces should be treatment, but get %d", treatment)
+	}
+
+	// 只差最后一个字符的命名空间也要分散到两组
+	cfg.Fraction = 0.5
+	similar := make(map[string]bool)
+	for _, ns := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		similar[experimentArm(cfg, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: ns}})] = true
+	}
+	if len(similar) != 2 {
+		t.Errorf("similar namespaces should be spread over both arms, but get %v", similar)
+	}
+
+	// 按控制器分组时同一个控制器的Pod在同一组
+	controller := true
+	cfg.HashBy = HashByOwner
+	cfg.Fraction = 0.5
+	arms := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("web-%d", i), Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-6d4cf56db6", Controller: &controller}}}}
+		arms[experimentArm(cfg, pod)] = true
+	}
+	if len(arms) != 1 {
+		t.Errorf("pods of one owner should be in one arm, but get %v", arms)
+	}
+
+	for _, fraction := range []float64{0, 1} {
+		cfg.Fraction = fraction
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}
+		if arm := experimentArm(cfg, pod); (arm == model.ArmTreatment) != (fraction == 1) {
+			t.Errorf("arm with fraction %v should not be %s", fraction, arm)
+		}
+	}
+}
+
+func TestMetricStats(t *testing.T) {
+	s := metricStats([]float64{1, 2, 3, 4, 5}, 0.95)
+	// t(0.975, 4)=2.7764，标准差为sqrt(2.5)
+	half := 2.7764451 * math.Sqrt(2.5) / math.Sqrt(5)
+	if s.N != 5 || s.Mean != 3 || math.Abs(s.Lower-(3-half)) > 1e-6 || math.Abs(s.Upper-(3+half)) > 1e-6 {
+		t.Errorf("stats are wrong: %+v", s)
+	}
+	if one := metricStats([]float64{7}, 0.95); one.Lower != 7 || one.Upper != 7 {
+		t.Errorf("interval of one sample should be the sample, but get %+v", one)
+	}
+
+	// 方差相同、样本数相同时Welch自由度为2n-2=8
+	treatment := metricStats([]float64{11, 12, 13, 14, 15}, 0.95)
+	diff, ok := welchDiff(treatment, s, 0.95)
+	halfDiff := 2.3060041 * math.Sqrt(2*2.5/5)
+	if !ok || diff.Diff != 10 || math.Abs(diff.Lower-(10-halfDiff)) > 1e-6 || math.Abs(diff.Upper-(10+halfDiff)) > 1e-6 {
+		t.Errorf("difference is wrong: %+v", diff)
+	}
+	if _, ok = welchDiff(treatment, metricStats([]float64{1}, 0.95), 0.95); ok {
+		t.Errorf("difference needs at least 2 samples in each arm")
+	}
+}
+
+// experimentPod 返回在scheduled绑定、running开始运行的Pod，running为零值时仍在Pending
+func experimentPod(name, namespace string, scheduled, running time.Time, restarts int32) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID(namespace + "-" + name)},
+		Spec:       v1.PodSpec{NodeName: "node1"},
+		Status: v1.PodStatus{
+			Phase:      v1.PodPending,
+			Conditions: []v1.PodCondition{{Type: v1.PodScheduled, Status: v1.ConditionTrue, LastTransitionTime: metav1.NewTime(scheduled)}},
+		},
+	}
+	if !running.IsZero() {
+		pod.Status.Phase = v1.PodRunning
+		pod.Status.ContainerStatuses = []v1.ContainerStatus{{
+			RestartCount: restarts,
+			State:        v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: metav1.NewTime(running)}},
+		}}
+	}
+
+	return pod
+}
+
+// fakeKubeconfig 启动只返回空Pod列表的apiserver，watch请求一直保持到连接关闭，返回指向它的kubeconfig路径
+func fakeKubeconfig(t *testing.T) string {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("watch") == "true" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","metadata":{"resourceVersion":"1"},"items":[]}`))
+	}))
+	t.Cleanup(func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	})
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	conf := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: fake
+  cluster:
+    server: %s
+contexts:
+- name: fake
+  context:
+    cluster: fake
+    user: fake
+current-context: fake
+users:
+- name: fake
+  user: {}
+`, ts.URL)
+	if err := os.WriteFile(path, []byte(conf), 0o600); err != nil {
+		t.Fatalf("write kubeconfig error: %v", err)
+	}
+
+	return path
+}
+
+func TestService_Experiment(t *testing.T) {
+	prom := fakeprom.New()
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 100000, "node2": 600000, "node3": 1200000})
+	prom.SetConst(fakeprom.DiskWritten, map[string]float64{"node1": 1024, "node2": 2048, "node3": 4096})
+	prom.SetConst(fakeprom.CPUSeconds, map[string]float64{"node1": 0.2, "node2": 0.4, "node3": 0.6})
+	prom.SetConst(fakeprom.MemAvailable, map[string]float64{"node1": 0.3, "node2": 0.5, "node3": 0.7})
+	s := newFakePromService(t, prom, false, `
+[policy.policies.spread]
+algorithm = "bnp"
+
+[experiment]
+enabled = true
+name = "bnp-vs-cmdn"
+treatment = "spread"
+fraction = 0.5
+kubeconfig = "`+fakeKubeconfig(t)+`"
+`)
+
+	// 按哈希结果挑出两组各3个命名空间
+	namespaces := map[string][]string{}
+	for i := 0; len(namespaces[model.ArmControl]) < 3 || len(namespaces[model.ArmTreatment]) < 3; i++ {
+		ns := fmt.Sprintf("ns-%d", i)
+		arm := experimentArm(s.experimentCfg, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: ns}})
+		if len(namespaces[arm]) < 3 {
+			namespaces[arm] = append(namespaces[arm], ns)
+		}
+	}
+
+	nodeNames := []string{"node1", "node2", "node3"}
+	base := time.Now()
+	for arm, list := range namespaces {
+		for i, ns := range list {
+			pod := experimentPod("pod", ns, base, time.Time{}, 0)
+			p := s.ResolvePolicy(pod)
+			if p.Arm != arm || (arm == model.ArmTreatment) != (p.Name == "spread" && p.Source == model.PolicySourceExperiment) {
+				t.Fatalf("pod in %s should be %s, but get %+v", ns, arm, p)
+			}
+			if _, err := s.Prioritize(&extenderv1.ExtenderArgs{Pod: pod, NodeNames: &nodeNames}); err != nil {
+				t.Fatalf("prioritize error: %v", err)
+			}
+			// 实验组启动更慢，每组最后一个Pod一直没有运行
+			if i == len(list)-1 {
+				continue
+			}
+			delay := time.Duration(i+1) * time.Second
+			if arm == model.ArmTreatment {
+				delay += 10 * time.Second
+			}
+			s.observeExperimentPod(experimentPod("pod", ns, base, base.Add(delay), int32(i)), base.Add(delay))
+		}
+	}
+	s.records.flush()
+	decisions, err := s.QueryDecisions(&model.DecisionFilter{})
+	if err != nil || len(decisions) != 6 {
+		t.Fatalf("should record 6 decisions, but get %v %v", decisions, err)
+	}
+	for _, d := range decisions {
+		if d.Arm == "" || (d.Arm == model.ArmTreatment) != (d.Algorithm == model.AlgoBNP) {
+			t.Errorf("decision should record the arm, but get %+v", d)
+		}
+	}
+
+	// window之后运行的Pod结束跟踪，timeout之后没有运行的Pod计入notRunning
+	s.finishExperimentPods(base.Add(11 * time.Minute))
+	if report := s.ExperimentReport(); report.Arms[0].Completed != 2 || report.Arms[0].Tracking != 1 {
+		t.Fatalf("running pods should finish after window, but get %+v", report.Arms)
+	}
+	// 对照组没有运行的Pod被删除，计入deleted而不是notRunning
+	s.ForgetExperimentPod(experimentPod("pod", namespaces[model.ArmControl][2], base, time.Time{}, 0))
+	s.finishExperimentPods(base.Add(31 * time.Minute))
+
+	report := s.ExperimentReport()
+	if report.Name != "bnp-vs-cmdn" || len(report.Arms) != 2 || report.Disabled != "" || report.Population != model.ExperimentPopulation {
+		t.Fatalf("report should contain both arms, but get %+v", report)
+	}
+	deleted := map[string]int{model.ArmControl: 1, model.ArmTreatment: 0}
+	for _, arm := range report.Arms {
+		if arm.Assigned != 3 || arm.Completed != 3 || arm.NotRunning != 1-deleted[arm.Arm] || arm.Deleted != deleted[arm.Arm] || arm.Tracking != 0 {
+			t.Errorf("arm %s counts are wrong: %+v", arm.Arm, arm)
+		}
+		if arm.Metrics[model.OutcomeTimeToRunning].N != 2 || arm.Metrics[model.OutcomeLoadVarianceDelta+"."+model.ResourceCPUKey].N != 2 ||
+			arm.Metrics[model.OutcomeNodeLoadOffset+"."+model.ResourceCPUKey].N != 2 {
+			t.Errorf("arm %s metrics are wrong: %+v", arm.Arm, arm.Metrics)
+		}
+	}
+	if mean := report.Arms[0].Metrics[model.OutcomeTimeToRunning].Mean; mean != 1.5 {
+		t.Errorf("mean time to running of control should be 1.5s, but get %v", mean)
+	}
+	if diff := report.Difference[model.OutcomeTimeToRunning]; diff.Diff != 10 || diff.Lower > 10 || diff.Upper < 10 {
+		t.Errorf("treatment should be 10s slower, but get %+v", diff)
+	}
+	if diff := report.Difference[model.OutcomeRestarts]; diff.Diff != 0 {
+		t.Errorf("restarts should not differ, but get %+v", diff)
+	}
+}
+
+func TestService_ExperimentLoadEffect(t *testing.T) {
+	prom := fakeprom.New()
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 100000, "node2": 600000, "node3": 1200000})
+	prom.SetConst(fakeprom.DiskWritten, map[string]float64{"node1": 1024, "node2": 2048, "node3": 4096})
+	prom.SetConst(fakeprom.CPUSeconds, map[string]float64{"node1": 0.2, "node2": 0.4, "node3": 0.6})
+	prom.SetConst(fakeprom.MemAvailable, map[string]float64{"node1": 0.3, "node2": 0.5, "node3": 0.7})
+	s := newFakePromService(t, prom, false, `
+[policy.policies.spread]
+algorithm = "bnp"
+
+[experiment]
+enabled = true
+treatment = "spread"
+fraction = 0.5
+kubeconfig = "`+fakeKubeconfig(t)+`"
+`)
+
+	namespaces := map[string]string{}
+	for i := 0; len(namespaces) < 2; i++ {
+		ns := fmt.Sprintf("ns-%d", i)
+		arm := experimentArm(s.experimentCfg, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: ns}})
+		if _, ok := namespaces[arm]; !ok {
+			namespaces[arm] = ns
+		}
+	}
+	base := time.Now()
+	place := func(arm, node string) *v1.Pod {
+		pod := experimentPod("pod", namespaces[arm], base, time.Time{}, 0)
+		pod.Spec.NodeName = node
+		s.trackExperiment(pod, s.ResolvePolicy(pod), base)
+		s.observeExperimentPod(pod, base)
+		return pod
+	}
+
+	// 对照组在CPU为20/40/60时调度到node1，之后负载变为10/40/90，实验组再调度到node3
+	control := place(model.ArmControl, "node1")
+	prom.SetConst(fakeprom.CPUSeconds, map[string]float64{"node1": 0.1, "node2": 0.4, "node3": 0.9})
+	if err := s.ParallelSyncInfo(); err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+	treatment := place(model.ArmTreatment, "node3")
+	for _, pod := range []*v1.Pod{control, treatment} {
+		pod.Status.Phase = v1.PodRunning
+		pod.Status.ContainerStatuses = []v1.ContainerStatus{{State: v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: metav1.NewTime(base)}}}}
+		s.observeExperimentPod(pod, base)
+	}
+	s.finishExperimentPods(base.Add(11 * time.Minute))
+
+	outcomes := map[string]*model.ExperimentOutcome{}
+	for _, o := range s.experiment.outcomes {
+		outcomes[o.Arm] = o
+	}
+	if len(outcomes) != 2 {
+		t.Fatalf("both arms should finish, but get %v", s.experiment.outcomes)
+	}
+	// 结束时的方差相同，两组绑定时的方差不同，所在节点相对均值的负载也不同
+	before := stat.PopVariance([]float64{20, 40, 60}, nil)
+	after := stat.PopVariance([]float64{10, 40, 90}, nil)
+	cases := []struct {
+		Arm    string
+		Delta  float64
+		Offset float64
+	}{
+		{Arm: model.ArmControl, Delta: after - before, Offset: 10 - 140.0/3},
+		{Arm: model.ArmTreatment, Delta: 0, Offset: 90 - 140.0/3},
+	}
+	for _, c := range cases {
+		o := outcomes[c.Arm]
+		if delta := o.LoadVarianceDelta[model.ResourceCPUKey]; math.Abs(delta-c.Delta) > 1e-9 {
+			t.Errorf("cpu variance delta of %s should be %v, but get %v", c.Arm, c.Delta, delta)
+		}
+		if offset := o.NodeLoadOffset[model.ResourceCPUKey]; math.Abs(offset-c.Offset) > 1e-9 {
+			t.Errorf("cpu offset of %s should be %v, but get %v", c.Arm, c.Offset, offset)
+		}
+	}
+	report := s.ExperimentReport()
+	if report.Arms[0].Metrics[model.OutcomeNodeLoadOffset+"."+model.ResourceCPUKey].Mean ==
+		report.Arms[1].Metrics[model.OutcomeNodeLoadOffset+"."+model.ResourceCPUKey].Mean {
+		t.Errorf("arms should have different node load offsets, but get %+v", report.Arms)
+	}
+}
+
+func TestService_ExperimentInformerUnavailable(t *testing.T) {
+	prom := fakeprom.New()
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 100000, "node2": 600000, "node3": 1200000})
+	s := newFakePromService(t, prom, true, `
+[policy.policies.spread]
+algorithm = "bnp"
+
+[experiment]
+enabled = true
+treatment = "spread"
+fraction = 1.0
+kubeconfig = "/nonexistent/kubeconfig"
+`)
+
+	// 没有Pod informer时关闭实验，Pod不再分到实验组
+	deadline := time.Now().Add(5 * time.Second)
+	for s.ExperimentReport().Disabled == "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("experiment should be disabled without pod informer")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	pod := experimentPod("pod", "default", time.Now(), time.Time{}, 0)
+	if p := s.ResolvePolicy(pod); p.Arm != "" || p.Source == model.PolicySourceExperiment {
+		t.Errorf("pod should use the control policy without arm, but get %+v", p)
+	}
+	s.trackExperiment(pod, &model.PolicyResolution{Name: "spread", Arm: model.ArmTreatment}, time.Now())
+	if report := s.ExperimentReport(); !report.Enabled || report.Arms[1].Assigned != 0 || report.Arms[1].Tracking != 0 {
+		t.Errorf("disabled experiment should not track pods, but get %+v", report)
+	}
+}
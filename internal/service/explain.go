@@ -0,0 +1,40 @@
+package service
+
+import (
+	"liang/internal/model"
+
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// Explain 使用与Prioritize相同的数据和算法评分，返回评分的计算过程
+func (s *Service) Explain(args *extenderv1.ExtenderArgs) (*model.Explanation, error) {
+	var (
+		snapshot map[string](map[string]int64)
+		version  int64
+		err      error
+	)
+	policy := s.ResolvePolicy(args.Pod)
+	if policy.Algorithm == model.AlgoBNP {
+		snapshot, version, err = s.bnpSnapshot(policy.Balance)
+	} else {
+		snapshot, version, err = s.allSnapshot()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	in := s.policyScoreInput(policy, args, snapshot)
+	in.SnapshotVersion = version
+	explain, _, err := explainSnapshot(in)
+	if err != nil {
+		return nil, err
+	}
+	explain.Policy = policy
+	explain.SnapshotVersion = version
+	if s.forecastCfg.Enabled() {
+		explain.Forecast = s.forecastCfg.Model
+	}
+	explain.LearnedDemand = s.learnedNetDemand(args.Pod)
+
+	return explain, nil
+}
@@ -0,0 +1,282 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/log"
+	xtime "github.com/go-kratos/kratos/pkg/time"
+)
+
+// 负载预测使用的模型
+const (
+	ForecastNone        = "none"         // 不预测，使用最新的指标评分
+	ForecastEWMA        = "ewma"         // 指数加权移动平均，预测值为平滑后的水平
+	ForecastHolt        = "holt"         // Holt线性趋势，在水平上叠加趋势
+	ForecastHoltWinters = "holt-winters" // Holt-Winters加法季节模型，在趋势上叠加周期性波动
+)
+
+// ForecastConfig 负载预测配置，对应application.toml中的[forecast]
+// Horizon为预测的时长，通常取Pod的典型运行时长；Season为Holt-Winters的周期，如每天的定时任务为24h
+type ForecastConfig struct {
+	Model   string
+	Horizon xtime.Duration
+	Alpha   float64 // 水平的平滑系数
+	Beta    float64 // 趋势的平滑系数
+	Gamma   float64 // 季节项的平滑系数
+	Season  xtime.Duration
+}
+
+// DefaultForecastConfig 没有配置[forecast]时不预测
+func DefaultForecastConfig() ForecastConfig {
+	return ForecastConfig{
+		Model:   ForecastNone,
+		Horizon: xtime.Duration(30 * time.Minute),
+		Alpha:   0.5,
+		Beta:    0.1,
+		Gamma:   0.3,
+		Season:  xtime.Duration(24 * time.Hour),
+	}
+}
+
+// Enabled 是否使用预测的负载评分
+func (cfg ForecastConfig) Enabled() bool {
+	return cfg.Model != ForecastNone
+}
+
+// Validate 检查模型和平滑系数
+func (cfg ForecastConfig) Validate() error {
+	switch cfg.Model {
+	case ForecastNone, ForecastEWMA, ForecastHolt, ForecastHoltWinters:
+	default:
+		return fmt.Errorf("forecast model %s should be one of none/ewma/holt/holt-winters", cfg.Model)
+	}
+	if cfg.Horizon < 0 {
+		return fmt.Errorf("forecast horizon should not be negative")
+	}
+	if cfg.Alpha <= 0 || cfg.Alpha > 1 {
+		return fmt.Errorf("forecast alpha %f should be in (0, 1]", cfg.Alpha)
+	}
+	if cfg.Beta < 0 || cfg.Beta > 1 || cfg.Gamma < 0 || cfg.Gamma > 1 {
+		return fmt.Errorf("forecast beta %f and gamma %f should be in [0, 1]", cfg.Beta, cfg.Gamma)
+	}
+	if cfg.Model == ForecastHoltWinters && cfg.Season <= 0 {
+		return fmt.Errorf("season of holt-winters should be positive")
+	}
+
+	return nil
+}
+
+// Forecaster 根据等间隔的历史数据预测steps步之后的值
+type Forecaster interface {
+	// MinSamples 预测需要的最少数据个数
+	MinSamples() int
+	Forecast(series []float64, steps int) float64
+}
+
+// EWMA 指数加权移动平均，没有趋势，预测值与步数无关
+type EWMA struct {
+	Alpha float64
+}
+
+// MinSamples EWMA至少需要2个数据
+func (m EWMA) MinSamples() int {
+	return 2
+}
+
+// Forecast 返回平滑后的水平
+func (m EWMA) Forecast(series []float64, steps int) float64 {
+	level := series[0]
+	for _, x := range series[1:] {
+		level = m.Alpha*x + (1-m.Alpha)*level
+	}
+
+	return level
+}
+
+// Holt Holt线性趋势模型
+type Holt struct {
+	Alpha, Beta float64
+}
+
+// MinSamples 初始趋势使用前两个数据，至少需要3个数据
+func (m Holt) MinSamples() int {
+	return 3
+}
+
+// Forecast 返回水平加上steps步的趋势
+func (m Holt) Forecast(series []float64, steps int) float64 {
+	level, trend := series[0], series[1]-series[0]
+	for _, x := range series[1:] {
+		last := level
+		level = m.Alpha*x + (1-m.Alpha)*(level+trend)
+		trend = m.Beta*(level-last) + (1-m.Beta)*trend
+	}
+
+	return level + float64(steps)*trend
+}
+
+// HoltWinters Holt-Winters加法季节模型，Period为一个周期包含的数据个数
+type HoltWinters struct {
+	Alpha, Beta, Gamma float64
+	Period             int
+}
+
+// MinSamples 初始化水平、趋势和季节项需要两个完整的周期
+func (m HoltWinters) MinSamples() int {
+	return 2 * m.Period
+}
+
+// Forecast 返回水平加上steps步的趋势，再加上预测时刻在周期中对应的季节项
+func (m HoltWinters) Forecast(series []float64, steps int) float64 {
+	p := m.Period
+	first, second := 0.0, 0.0
+	for i := 0; i < p; i++ {
+		first += series[i]
+		second += series[p+i]
+	}
+	first, second = first/float64(p), second/float64(p)
+
+	// 第一个周期的平均值对应周期的中点，初始季节项去掉周期内的趋势，初始水平对应第一个周期的最后一个数据
+	// 季节项按数据下标保存，seasonal[t]为第t个数据的季节项
+	trend := (second - first) / float64(p)
+	center := float64(p-1) / 2
+	seasonal := make([]float64, len(series))
+	for i := 0; i < p; i++ {
+		seasonal[i] = series[i] - (first + (float64(i)-center)*trend)
+	}
+	level := first + center*trend
+	for t := p; t < len(series); t++ {
+		last := level
+		level = m.Alpha*(series[t]-seasonal[t-p]) + (1-m.Alpha)*(level+trend)
+		trend = m.Beta*(level-last) + (1-m.Beta)*trend
+		seasonal[t] = m.Gamma*(series[t]-level) + (1-m.Gamma)*seasonal[t-p]
+	}
+
+	n := len(series)
+	s := seasonal[n-p+(steps-1+p)%p]
+	if steps == 0 {
+		s = seasonal[n-1]
+	}
+
+	return level + float64(steps)*trend + s
+}
+
+// forecastSamples 按数据的平均间隔把预测时长和周期换算为步数，使用配置的模型预测一个节点的指标
+// 数据不足时ok为false，评分时使用最新的值
+func forecastSamples(cfg ForecastConfig, samples []model.Sample) (value float64, ok bool) {
+	n := len(samples)
+	if n < 2 {
+		return 0, false
+	}
+	interval := samples[n-1].Time.Sub(samples[0].Time) / time.Duration(n-1)
+	if interval <= 0 {
+		return 0, false
+	}
+	steps := int(math.Round(float64(cfg.Horizon) / float64(interval)))
+
+	var m Forecaster
+	switch cfg.Model {
+	case ForecastEWMA:
+		m = EWMA{Alpha: cfg.Alpha}
+	case ForecastHolt:
+		m = Holt{Alpha: cfg.Alpha, Beta: cfg.Beta}
+	case ForecastHoltWinters:
+		period := int(math.Round(float64(cfg.Season) / float64(interval)))
+		if period < 2 {
+			return 0, false
+		}
+		m = HoltWinters{Alpha: cfg.Alpha, Beta: cfg.Beta, Gamma: cfg.Gamma, Period: period}
+	default:
+		return 0, false
+	}
+	if n < m.MinSamples() {
+		return 0, false
+	}
+
+	series := make([]float64, n)
+	for i, sample := range samples {
+		series[i] = float64(sample.Value)
+	}
+
+	return m.Forecast(series, steps), true
+}
+
+// forecastState 最近一次同步后每个指标的预测值
+type forecastState struct {
+	mu        sync.RWMutex
+	predicted map[string]prediction
+}
+
+// prediction 一个指标的预测值，version为预测时使用的快照版本号，只有评分使用同一个版本的快照时才使用预测值
+type prediction struct {
+	version int64
+	values  map[string]int64
+}
+
+// updateForecast 记录同步得到的指标的历史数据，并重新预测这些指标
+func (s *Service) updateForecast(keys ...string) {
+	now := time.Now()
+	// 与快照版本号一起读取，预测值总是对应读取时的版本
+	snapshot, version, _ := s.readSnapshot(func() (map[string](map[string]int64), error) {
+		res := make(map[string](map[string]int64), len(keys))
+		for _, key := range keys {
+			if values, err := s.dao.GetKV(key); err == nil && values != nil {
+				res[key] = values
+			}
+		}
+
+		return res, nil
+	})
+	for _, key := range keys {
+		values, ok := snapshot[key]
+		if !ok {
+			continue
+		}
+		s.dao.AppendHistory(key, values, now)
+		if !s.forecastCfg.Enabled() {
+			continue
+		}
+
+		history := s.dao.GetHistory(key, time.Time{})
+		predicted := make(map[string]int64, len(values))
+		for name, v := range values {
+			predicted[name] = v
+			if f, ok := forecastSamples(s.forecastCfg, history[name]); ok {
+				predicted[name] = int64(math.Round(math.Max(f, 0)))
+			}
+		}
+		log.V(5).Info("forecast of %s after %s: %v, current: %v", key, time.Duration(s.forecastCfg.Horizon), predicted, values)
+
+		s.forecast.mu.Lock()
+		if s.forecast.predicted == nil {
+			s.forecast.predicted = make(map[string]prediction)
+		}
+		s.forecast.predicted[key] = prediction{version: version, values: predicted}
+		s.forecast.mu.Unlock()
+	}
+}
+
+// withForecast 开启负载预测时，用预测值替换版本号为version的快照中的指标，snapshot本身不会被修改
+// 只使用同一个版本的快照得到的预测值，之后同步写入了指标但还没有重新预测时使用最新的指标
+func (s *Service) withForecast(snapshot map[string](map[string]int64), version int64) map[string](map[string]int64) {
+	if !s.forecastCfg.Enabled() || snapshot == nil {
+		return snapshot
+	}
+
+	s.forecast.mu.RLock()
+	defer s.forecast.mu.RUnlock()
+	res := make(map[string](map[string]int64), len(snapshot))
+	for key, values := range snapshot {
+		res[key] = values
+		if p, ok := s.forecast.predicted[key]; ok && p.version == version {
+			res[key] = p.values
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"liang/internal/dao"
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/conf/paladin"
+	xtime "github.com/go-kratos/kratos/pkg/time"
+)
+
+func TestForecaster(t *testing.T) {
+	constant := []float64{50, 50, 50, 50}
+	linear := []float64{10, 12, 14, 16, 18, 20}
+	// 周期为4，每个周期上升8，季节项为0,10,0,-10
+	season := []float64{0, 10, 0, -10}
+	seasonal := make([]float64, 0, 24)
+	for i := 0; i < 24; i++ {
+		seasonal = append(seasonal, 100+2*float64(i)+season[i%4])
+	}
+
+	cases := []struct {
+		Name     string
+		Model    Forecaster
+		Series   []float64
+		Steps    int
+		Expected float64
+	}{
+		{Name: "ewma constant", Model: EWMA{Alpha: 0.5}, Series: constant, Steps: 10, Expected: 50},
+		{Name: "ewma smooth", Model: EWMA{Alpha: 0.5}, Series: []float64{0, 100}, Steps: 1, Expected: 50},
+		{Name: "holt linear", Model: Holt{Alpha: 0.5, Beta: 0.5}, Series: linear, Steps: 5, Expected: 30},
+		{Name: "holt-winters one step", Model: HoltWinters{Alpha: 0.5, Beta: 0.1, Gamma: 0.3, Period: 4}, Series: seasonal, Steps: 1, Expected: 148},
+		{Name: "holt-winters peak", Model: HoltWinters{Alpha: 0.5, Beta: 0.1, Gamma: 0.3, Period: 4}, Series: seasonal, Steps: 6, Expected: 168},
+		{Name: "holt-winters current", Model: HoltWinters{Alpha: 0.5, Beta: 0.1, Gamma: 0.3, Period: 4}, Series: seasonal, Steps: 0, Expected: 136},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if len(c.Series) < c.Model.MinSamples() {
+				t.Fatalf("series is too short for the model")
+			}
+			if v := c.Model.Forecast(c.Series, c.Steps); math.Abs(v-c.Expected) > 1e-6 {
+				t.Errorf("forecast should be %v, but get %v", c.Expected, v)
+			}
+		})
+	}
+}
+
+func TestForecastSamples(t *testing.T) {
+	start := time.Unix(0, 0)
+	samples := make([]model.Sample, 6)
+	for i := range samples {
+		samples[i] = model.Sample{Time: start.Add(time.Duration(i) * 10 * time.Second), Value: int64(10 + 2*i)}
+	}
+	cfg := DefaultForecastConfig()
+	cfg.Model = ForecastHolt
+	cfg.Alpha, cfg.Beta = 0.5, 0.5
+
+	// 数据间隔为10s，预测50s之后即5步之后
+	cfg.Horizon = xtime.Duration(50 * time.Second)
+	if v, ok := forecastSamples(cfg, samples); !ok || math.Abs(v-30) > 1e-6 {
+		t.Errorf("forecast should be 30, but get %v %v", v, ok)
+	}
+	if _, ok := forecastSamples(cfg, samples[:2]); ok {
+		t.Errorf("holt should not forecast with 2 samples")
+	}
+
+	// 周期为1天，数据不足两个周期时不预测
+	cfg.Model = ForecastHoltWinters
+	if _, ok := forecastSamples(cfg, samples); ok {
+		t.Errorf("holt-winters should not forecast without two seasons")
+	}
+	cfg.Season = xtime.Duration(20 * time.Second)
+	if _, ok := forecastSamples(cfg, samples); !ok {
+		t.Errorf("holt-winters should forecast with three seasons")
+	}
+}
+
+func TestForecastConfig_Validate(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Modify func(cfg *ForecastConfig)
+		Valid  bool
+	}{
+		{Name: "default", Modify: func(cfg *ForecastConfig) {}, Valid: true},
+		{Name: "holt-winters", Modify: func(cfg *ForecastConfig) { cfg.Model = ForecastHoltWinters }, Valid: true},
+		{Name: "unknown model", Modify: func(cfg *ForecastConfig) { cfg.Model = "arima" }},
+		{Name: "zero alpha", Modify: func(cfg *ForecastConfig) { cfg.Model, cfg.Alpha = ForecastEWMA, 0 }},
+		{Name: "beta too large", Modify: func(cfg *ForecastConfig) { cfg.Model, cfg.Beta = ForecastHolt, 1.5 }},
+		{Name: "no season", Modify: func(cfg *ForecastConfig) { cfg.Model, cfg.Season = ForecastHoltWinters, 0 }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			cfg := DefaultForecastConfig()
+			c.Modify(&cfg)
+			if err := cfg.Validate(); (err == nil) != c.Valid {
+				t.Errorf("valid should be %v, but get %v", c.Valid, err)
+			}
+		})
+	}
+}
+
+func TestService_Forecast(t *testing.T) {
+	d, dcf, err := dao.NewWithConfig(&dao.Config{LocalCacheExpire: 300})
+	if err != nil {
+		t.Fatalf("new dao error: %v", err)
+	}
+	defer dcf()
+
+	ac := &paladin.TOML{}
+	if err = ac.Set(`
+netbwMapKeys = ["node1", "node2"]
+netbwMapValues = [1000.0, 1000.0]
+syncStatusInterval = "0 0 0 1 1 ?"
+maxSnapshotAge = "60s"
+livenessTimeout = "120s"
+topsisMin = false
+useBNP = false
+dryrun = true
+
+[forecast]
+model = "holt"
+horizon = "50s"
+alpha = 0.5
+beta = 0.5
+`); err != nil {
+		t.Fatalf("set config error: %v", err)
+	}
+	s, cf, err := NewWithConfig(d, ac)
+	if err != nil {
+		t.Fatalf("new service error: %v", err)
+	}
+	defer cf()
+	<-s.initDone
+
+	// node3的cpu每10s上升2，预测50s之后为30，node1只有一次同步的数据，使用最新的值
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		d.AppendHistory(model.ResourceCPUKey, map[string]int64{"node3": int64(10 + 2*i)}, now.Add(time.Duration(i-5)*10*time.Second))
+	}
+	cpuMap := map[string]int64{"node1": 40, "node3": 20}
+	if err = s.writeSnapshot(map[string](map[string]int64){model.ResourceCPUKey: cpuMap}); err != nil {
+		t.Fatalf("set cpu error: %v", err)
+	}
+	s.updateForecast(model.ResourceCPUKey)
+
+	snapshot, err := s.GetAllCache()
+	if err != nil {
+		t.Fatalf("get all cache error: %v", err)
+	}
+	if cpu := snapshot[model.ResourceCPUKey]; cpu["node1"] != 40 || math.Abs(float64(cpu["node3"]-30)) > 1 {
+		t.Errorf("cpu should be forecast to about 30 for node3, but get %v", cpu)
+	}
+
+	// 同步写入新的快照后，没有重新预测之前使用最新的值
+	if err = s.writeSnapshot(map[string](map[string]int64){model.ResourceCPUKey: {"node1": 40, "node3": 25}}); err != nil {
+		t.Fatalf("set cpu error: %v", err)
+	}
+	snapshot, _ = s.GetAllCache()
+	if cpu := snapshot[model.ResourceCPUKey]; cpu["node3"] != 25 {
+		t.Errorf("stale forecast should not be used, but get %v", cpu)
+	}
+}
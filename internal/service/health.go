@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/log"
+)
+
+const (
+	initialSyncMinBackoff = time.Second
+	initialSyncMaxBackoff = 30 * time.Second
+
+	// 没有配置maxSnapshotAge和livenessTimeout时的默认值
+	defaultMaxSnapshotAge  = 60 * time.Second
+	defaultLivenessTimeout = 120 * time.Second
+)
+
+// initialSync 后台同步首份指标快照，失败后按指数退避重试，直到成功或者服务关闭
+// Prometheus暂时不可用时不影响进程启动，只是在成功之前就绪探针不通过
+func (s *Service) initialSync() {
+	defer close(s.initDone)
+
+	// 网卡带宽发现失败时使用静态配置，不影响首次同步
+	if !s.dryrun {
+		_ = s.SyncNICCapacity()
+		_ = s.SyncNodeLabels()
+	}
+	if !s.dryrun && s.netDemandCfg.Enabled {
+		_ = s.SyncNetDemand()
+	}
+	if s.syncNamespacePolicyEnabled() {
+		_ = s.SyncNamespacePolicies()
+	}
+	backoff := initialSyncMinBackoff
+	for {
+		err := s.ParallelSyncInfo()
+		s.markAttempt(err)
+		if err == nil {
+			log.Info("initial sync of prometheus info succeeded")
+			return
+		}
+		log.Error("initial sync of prometheus info error: %v, retry after %s", err, backoff)
+
+		select {
+		case <-s.closeCh:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > initialSyncMaxBackoff {
+			backoff = initialSyncMaxBackoff
+		}
+	}
+}
+
+// Startup 启动探针，配置加载完成并且首次同步至少尝试过一次后通过
+func (s *Service) Startup() *model.ProbeReport {
+	if t, _ := s.lastSyncAttempt(); t.IsZero() {
+		return &model.ProbeReport{Reason: "initial sync has not been attempted yet"}
+	}
+
+	return &model.ProbeReport{OK: true}
+}
+
+// Liveness 存活探针，检查定时同步任务是否还在运行，不依赖Prometheus是否可用
+func (s *Service) Liveness() *model.ProbeReport {
+	t, err := s.lastSyncAttempt()
+	if t.IsZero() {
+		// 还没有尝试过同步，交给启动探针判断
+		return &model.ProbeReport{OK: true}
+	}
+
+	report := &model.ProbeReport{OK: true}
+	if err != nil {
+		report.Reason = fmt.Sprintf("last sync error: %v", err)
+	}
+	if age := time.Since(t); age > s.livenessTimeout {
+		report.OK = false
+		report.Reason = fmt.Sprintf("no sync attempt in %s, last attempt at %s", age, t.Format(time.RFC3339))
+	}
+
+	return report
+}
+
+// Readiness 就绪探针，当前算法需要的每个指标都有不超过maxSnapshotAge的快照时通过
+func (s *Service) Readiness(ctx context.Context) *model.ProbeReport {
+	report := &model.ProbeReport{OK: true}
+	now := time.Now()
+	for _, key := range s.requiredMetrics() {
+		state := model.MetricState{Key: key}
+		if t, ok := s.metricUpdated(key); ok {
+			state.UpdatedAt = t
+			state.AgeSeconds = now.Sub(t).Seconds()
+			state.Fresh = now.Sub(t) <= s.maxSnapshotAge
+		}
+		if !state.Fresh {
+			report.OK = false
+			report.Reason = fmt.Sprintf("snapshot of %s is missing or older than %s", key, s.maxSnapshotAge)
+		}
+		report.Metrics = append(report.Metrics, state)
+	}
+
+	if s.dryrun {
+		report.Prometheus = "dryrun"
+	} else if err := s.dao.Ping(ctx); err != nil {
+		report.Prometheus = err.Error()
+	} else {
+		report.Prometheus = "ok"
+	}
+
+	return report
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"liang/internal/dao"
+	"liang/internal/fakeprom"
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/conf/paladin"
+)
+
+func TestService_Readiness(t *testing.T) {
+	cases := []struct {
+		Name     string
+		UseBNP   bool
+		Synced   []string
+		Age      time.Duration
+		Expected bool
+	}{
+		{
+			Name:     "test 0: nothing synced",
+			UseBNP:   true,
+			Expected: false,
+		},
+		{
+			Name:     "test 1: bnp only needs net io",
+			UseBNP:   true,
+			Synced:   []string{model.ResourceNetIOKey},
+			Expected: true,
+		},
+		{
+			Name:     "test 2: cmdn needs all metrics",
+			UseBNP:   false,
+			Synced:   []string{model.ResourceNetIOKey, model.ResourceCPUKey},
+			Expected: false,
+		},
+		{
+			Name:     "test 3: cmdn with all metrics",
+			UseBNP:   false,
+			Synced:   []string{model.ResourceNetIOKey, model.ResourceCPUKey, model.ResourceMemKey, model.ResourceDiskIOKey},
+			Expected: true,
+		},
+		{
+			Name:     "test 4: snapshot too old",
+			UseBNP:   true,
+			Synced:   []string{model.ResourceNetIOKey},
+			Age:      2 * time.Minute,
+			Expected: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			s := &Service{
+				useBNP:         tc.UseBNP,
+				dryrun:         true,
+				maxSnapshotAge: time.Minute,
+			}
+			s.markSynced(tc.Synced...)
+			for _, key := range tc.Synced {
+				s.syncState.updated[key] = time.Now().Add(-tc.Age)
+			}
+
+			report := s.Readiness(context.Background())
+			if report.OK != tc.Expected {
+				t.Errorf("test %s, readiness should be %v, but get %v: %s", tc.Name, tc.Expected, report.OK, report.Reason)
+			}
+			if len(report.Metrics) != len(s.requiredMetrics()) {
+				t.Errorf("test %s, report should contain %d metrics, but get %d", tc.Name, len(s.requiredMetrics()), len(report.Metrics))
+			}
+		})
+	}
+}
+
+func TestService_Liveness(t *testing.T) {
+	s := &Service{livenessTimeout: time.Minute}
+	if report := s.Startup(); report.OK {
+		t.Errorf("startup should fail before the first sync attempt")
+	}
+	if report := s.Liveness(); !report.OK {
+		t.Errorf("liveness should pass before the first sync attempt: %s", report.Reason)
+	}
+
+	s.markAttempt(context.DeadlineExceeded)
+	if report := s.Startup(); !report.OK {
+		t.Errorf("startup should pass after the first sync attempt: %s", report.Reason)
+	}
+	if report := s.Liveness(); !report.OK {
+		t.Errorf("liveness should pass even if the last sync failed: %s", report.Reason)
+	}
+
+	s.syncState.lastAttempt = time.Now().Add(-2 * time.Minute)
+	if report := s.Liveness(); report.OK {
+		t.Errorf("liveness should fail when sync is stalled")
+	}
+}
+
+func TestNewWithConfig_HealthDefaults(t *testing.T) {
+	prom := fakeprom.New()
+	addr := prom.Start()
+	defer prom.Close()
+	d, dcf, err := dao.NewWithConfig(&dao.Config{PromAddr: addr, LocalCacheExpire: 300})
+	if err != nil {
+		t.Fatalf("new dao error: %v", err)
+	}
+	defer dcf()
+
+	base := `
+netbwMapKeys = ["node1"]
+netbwMapValues = [1000.0]
+syncStatusInterval = "0 0 0 1 1 ?"
+topsisMin = true
+useBNP = true
+dryrun = true
+`
+	cases := []struct {
+		Name     string
+		Extra    string
+		Valid    bool
+		Age      time.Duration
+		Liveness time.Duration
+	}{
+		{Name: "missing keys use defaults", Valid: true, Age: defaultMaxSnapshotAge, Liveness: defaultLivenessTimeout},
+		{Name: "configured", Extra: "maxSnapshotAge = \"30s\"\nlivenessTimeout = \"90s\"\n", Valid: true, Age: 30 * time.Second, Liveness: 90 * time.Second},
+		{Name: "non-positive", Extra: "maxSnapshotAge = \"0s\"\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			ac := &paladin.TOML{}
+			if err := ac.Set(base + c.Extra); err != nil {
+				t.Fatalf("set config error: %v", err)
+			}
+			s, cf, err := NewWithConfig(d, ac)
+			if (err == nil) != c.Valid {
+				t.Fatalf("valid should be %v, but get %v", c.Valid, err)
+			}
+			if err != nil {
+				return
+			}
+			defer cf()
+			if s.maxSnapshotAge != c.Age || s.livenessTimeout != c.Liveness {
+				t.Errorf("durations should be %s/%s, but get %s/%s", c.Age, c.Liveness, s.maxSnapshotAge, s.livenessTimeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"liang/internal/dao"
+	"liang/internal/model"
+)
+
+// MetricHistory 返回最近window内每个节点每个指标的历史数据和统计值，按指标和节点排序
+// metric或node为空时返回全部指标或节点，window为0时返回保存的全部数据
+func (s *Service) MetricHistory(metric, node string, window time.Duration) []*model.MetricHistory {
+	var since time.Time
+	if window > 0 {
+		since = time.Now().Add(-window)
+	}
+	keys := s.dao.GetHistoryKeys()
+	if metric != "" {
+		keys = []string{metric}
+	}
+
+	res := make([]*model.MetricHistory, 0)
+	for _, key := range keys {
+		series := s.dao.GetHistory(key, since)
+		names := make([]string, 0, len(series))
+		for name := range series {
+			if node == "" || name == node {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			res = append(res, &model.MetricHistory{
+				Metric:  key,
+				Node:    name,
+				Samples: series[name],
+				Stats:   dao.WindowStats(series[name]),
+			})
+		}
+	}
+
+	return res
+}
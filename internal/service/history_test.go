@@ -0,0 +1,61 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"liang/internal/dao"
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/conf/paladin"
+)
+
+func TestService_MetricHistory(t *testing.T) {
+	d, dcf, err := dao.NewWithConfig(&dao.Config{LocalCacheExpire: 300})
+	if err != nil {
+		t.Fatalf("new dao error: %v", err)
+	}
+	defer dcf()
+
+	ac := &paladin.TOML{}
+	if err = ac.Set(`
+netbwMapKeys = ["node1", "node2"]
+netbwMapValues = [1000.0, 1000.0]
+syncStatusInterval = "0 0 0 1 1 ?"
+maxSnapshotAge = "60s"
+livenessTimeout = "120s"
+topsisMin = false
+useBNP = false
+dryrun = true
+`); err != nil {
+		t.Fatalf("set config error: %v", err)
+	}
+	s, cf, err := NewWithConfig(d, ac)
+	if err != nil {
+		t.Fatalf("new service error: %v", err)
+	}
+	defer cf()
+	<-s.initDone
+
+	// 首次同步和这次同步的数据都会记录
+	if err = s.DryrunSyncInfo(); err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+	all := s.MetricHistory("", "", 0)
+	if len(all) != 14 {
+		t.Fatalf("history should have 7 metrics of 2 nodes, but get %d", len(all))
+	}
+	for _, h := range all {
+		if len(h.Samples) != 2 || h.Stats.Count != 2 {
+			t.Errorf("%s of %s should have 2 samples, but get %+v", h.Metric, h.Node, h)
+		}
+	}
+
+	res := s.MetricHistory(model.ResourceCPUKey, "node2", time.Minute)
+	if len(res) != 1 || res[0].Metric != model.ResourceCPUKey || res[0].Node != "node2" || len(res[0].Samples) != 2 {
+		t.Errorf("unexpected cpu history of node2 %+v", res)
+	}
+	if res := s.MetricHistory("LiangGPU", "", 0); len(res) != 0 {
+		t.Errorf("unknown metric should have no history, but get %+v", res)
+	}
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"liang/internal/dao"
+	"liang/internal/fakeprom"
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/conf/paladin"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// newFakePromService 创建连接假Prometheus的service，定时同步任务不会在测试期间触发，决策审计记录写入临时目录，extra为额外的配置
+func newFakePromService(t *testing.T, prom *fakeprom.Server, useBNP bool, extra ...string) *Service {
+	addr := prom.Start()
+	t.Cleanup(prom.Close)
+
+	d, dcf, err := dao.NewWithConfig(&dao.Config{
+		PromAddr:         addr,
+		LocalCacheExpire: 300,
+		DecisionLogPath:  filepath.Join(t.TempDir(), "decisions.log"),
+	})
+	if err != nil {
+		t.Fatalf("new dao error: %v", err)
+	}
+	t.Cleanup(dcf)
+
+	ac := &paladin.TOML{}
+	conf := `
+netbwMapKeys = ["node1", "node2", "node3"]
+netbwMapValues = [1000.0, 1500.0, 2500.0]
+syncStatusInterval = "0 0 0 1 1 ?"
+maxSnapshotAge = "60s"
+livenessTimeout = "120s"
+topsisMin = true
+dryrun = false
+`
+	if useBNP {
+		conf += "useBNP = true\n"
+	} else {
+		conf += "useBNP = false\n"
+	}
+	for _, c := range extra {
+		conf += c
+	}
+	if err = ac.Set(conf); err != nil {
+		t.Fatalf("set config error: %v", err)
+	}
+
+	s, cf, err := NewWithConfig(d, ac)
+	if err != nil {
+		t.Fatalf("new service error: %v", err)
+	}
+	t.Cleanup(cf)
+
+	select {
+	case <-s.initDone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("initial sync does not finish")
+	}
+
+	return s
+}
+
+func newPrioritizeArgs(netIO string) *extenderv1.ExtenderArgs {
+	nodeNames := []string{"node1", "node2", "node3"}
+	return &extenderv1.ExtenderArgs{
+		Pod: &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "nginx-0",
+				Namespace:   "default",
+				Annotations: map[string]string{model.ResourceNetIOKey: netIO},
+			},
+		},
+		NodeNames: &nodeNames,
+	}
+}
+
+func TestService_FakePromCMDN(t *testing.T) {
+	prom := fakeprom.New()
+	// node4不在netbwMapKeys中，会被过滤掉
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 100000, "node2": 600000, "node3": 1200000, "node4": 1})
+	prom.SetConst(fakeprom.NetTransmit, map[string]float64{"node1": 50000, "node2": 900000, "node3": 300000, "node4": 1})
+	prom.SetConst(fakeprom.DiskWritten, map[string]float64{"node1": 1024, "node2": 2048, "node3": 4096, "node4": 1})
+	prom.SetConst(fakeprom.DiskWritesCompleted, map[string]float64{"node1": 10, "node2": 20, "node3": 30, "node4": 1})
+	prom.SetConst(fakeprom.DiskCapacityBytes, map[string]float64{"node1": 100 * model.MByte})
+	// node1的网卡速度被发现为10G，node2的0被忽略
+	prom.SetConst(fakeprom.NetSpeed, map[string]float64{"node1": 10000 * model.KbitPS, "node2": 0})
+	prom.SetConst(fakeprom.CPUSeconds, map[string]float64{"node1": 0.2, "node2": 0.4, "node3": 0.6, "node4": 1})
+	prom.SetConst(fakeprom.MemAvailable, map[string]float64{"node1": 0.3, "node2": 0.5, "node3": 0.7, "node4": 1})
+	s := newFakePromService(t, prom, false)
+
+	snapshot := map[string](map[string]int64){
+		model.ResourceNetIOKey:    {"node1": 100000, "node2": 600000, "node3": 1200000},
+		model.ResourceNetInKey:    {"node1": 100000, "node2": 600000, "node3": 1200000},
+		model.ResourceNetOutKey:   {"node1": 50000, "node2": 900000, "node3": 300000},
+		model.ResourceDiskIOKey:   {"node1": 1024, "node2": 2048, "node3": 4096},
+		model.ResourceDiskIOPSKey: {"node1": 10, "node2": 20, "node3": 30},
+		model.ResourceCPUKey:      {"node1": 20, "node2": 40, "node3": 60},
+		model.ResourceMemKey:      {"node1": 30, "node2": 50, "node3": 70},
+	}
+	cache, err := s.GetAllCache()
+	if err != nil {
+		t.Fatalf("get all cache error: %v", err)
+	}
+	if !reflect.DeepEqual(cache, snapshot) {
+		t.Fatalf("cache should be %v, but get %v", snapshot, cache)
+	}
+	if report := s.Readiness(context.Background()); !report.OK || report.Prometheus != "ok" {
+		t.Errorf("service should be ready, but get %+v", report)
+	}
+
+	expectedNetBw := map[string]int64{"node1": 10000 * model.KbitPS, "node2": 1500 * model.KbitPS, "node3": 2500 * model.KbitPS}
+	if !reflect.DeepEqual(s.netBw(), expectedNetBw) {
+		t.Errorf("net capacity should be %v, but get %v", expectedNetBw, s.netBw())
+	}
+
+	args := newPrioritizeArgs("100")
+	// node1的磁盘吞吐能力来自基准测试指标，其余使用默认能力
+	diskCap := s.diskCapacity(*args.NodeNames)
+	if diskCap["node1"] != (model.DiskCapacity{Throughput: 100 * model.MByte, IOPS: 5000}) ||
+		diskCap["node2"] != (model.DiskCapacity{Throughput: 200 * model.MByte, IOPS: 5000}) {
+		t.Errorf("unexpected disk capacity %v", diskCap)
+	}
+	// 同步后预先计算了cmdn评分数据，评分结果与重新计算相同
+	if s.loadCMDNCache() == nil {
+		t.Fatalf("cmdn cache should be precomputed after sync")
+	}
+	res, err := s.Prioritize(args)
+	if err != nil {
+		t.Fatalf("prioritize error: %v", err)
+	}
+	expected, _, _ := ScoreSnapshot(s.scoreInput(model.AlgoCMDN, args, snapshot))
+	if !reflect.DeepEqual(*res, expected) {
+		t.Errorf("expected %v, but get %v", expected, *res)
+	}
+
+	// cpu查询失败时同步返回错误，缓存中保留上一次的数据
+	version := s.snapshotVersion()
+	prom.SetConst(fakeprom.CPUSeconds, map[string]float64{"node1": 0.9, "node2": 0.9, "node3": 0.9})
+	prom.SetFault(fakeprom.CPUSeconds, fakeprom.Fault{ErrorType: "execution", Error: "query timed out"})
+	if err = s.ParallelSyncInfo(); err == nil {
+		t.Errorf("sync should fail when cpu query fails")
+	}
+	// 查询成功的指标仍然写入，快照版本号随之变化，避免与之前的快照混用
+	if s.snapshotVersion() != version+1 {
+		t.Errorf("snapshot version should be %d after failed sync, but get %d", version+1, s.snapshotVersion())
+	}
+	cache, _ = s.GetAllCache()
+	if !reflect.DeepEqual(cache[model.ResourceCPUKey], snapshot[model.ResourceCPUKey]) {
+		t.Errorf("cpu cache should keep %v, but get %v", snapshot[model.ResourceCPUKey], cache[model.ResourceCPUKey])
+	}
+
+	// 恢复后使用新数据评分
+	prom.ClearFaults()
+	if err = s.ParallelSyncInfo(); err != nil {
+		t.Fatalf("sync error: %v", err)
+	}
+	if s.snapshotVersion() != version+2 {
+		t.Errorf("snapshot version should be %d, but get %d", version+2, s.snapshotVersion())
+	}
+	cache, _ = s.GetAllCache()
+	if cache[model.ResourceCPUKey]["node1"] != 90 {
+		t.Errorf("cpu of node1 should be 90, but get %d", cache[model.ResourceCPUKey]["node1"])
+	}
+	in := s.scoreInput(model.AlgoCMDN, args, cache)
+	if _, _, ok := in.CMDNCache.assemble(s.snapshotVersion(), *args.NodeNames, in.NetBwMap, withCapacity(in, cache), true, nil); !ok {
+		t.Errorf("cmdn cache should be refreshed after sync")
+	}
+}
+
+func TestService_FakePromBNP(t *testing.T) {
+	prom := fakeprom.New()
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 100000, "node2": 600000, "node3": 1200000})
+	prom.SetConst(fakeprom.NetTransmit, map[string]float64{"node1": 50000, "node2": 900000, "node3": 300000})
+	s := newFakePromService(t, prom, true)
+
+	// 首次同步使用ParallelSyncInfo，其他指标没有数据也会成功
+	if err := s.SyncNetIO(); err != nil {
+		t.Fatalf("sync net io error: %v", err)
+	}
+	args := newPrioritizeArgs("100")
+	res, err := s.Prioritize(args)
+	if err != nil {
+		t.Fatalf("prioritize error: %v", err)
+	}
+	expected, _, _ := ScoreSnapshot(s.scoreInput(model.AlgoBNP, args, map[string](map[string]int64){
+		model.ResourceNetIOKey:  {"node1": 100000, "node2": 600000, "node3": 1200000},
+		model.ResourceNetInKey:  {"node1": 100000, "node2": 600000, "node3": 1200000},
+		model.ResourceNetOutKey: {"node1": 50000, "node2": 900000, "node3": 300000},
+	}))
+	if !reflect.DeepEqual(*res, expected) {
+		t.Errorf("expected %v, but get %v", expected, *res)
+	}
+	explain, err := s.Explain(args)
+	if err != nil || explain.BNP.NetDemand == nil || len(explain.BNP.Nodes) != 3 || explain.BNP.Nodes[1].OutCurrent != 900000 {
+		t.Errorf("bnp should score both directions, but get %+v %v", explain, err)
+	}
+
+	// Prometheus不可用时就绪检查报告错误
+	prom.SetFault("", fakeprom.Fault{StatusCode: 503})
+	if err = s.SyncNetIO(); err == nil {
+		t.Errorf("sync net io should fail when prometheus is unavailable")
+	}
+	if report := s.Readiness(context.Background()); report.Prometheus == "ok" {
+		t.Errorf("readiness should report prometheus error, but get %+v", report)
+	}
+}
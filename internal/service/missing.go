@@ -0,0 +1,271 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"liang/internal/model"
+)
+
+// resolveMissing 按照缺失数据策略处理候选节点缺少的keys指标
+// 返回参与评分的节点、补全后的指标快照以及每个缺失指标的处理结果，in.Snapshot本身不会被修改
+// 网络负载按照负载比例计算最差值和中位数，再乘以节点的网卡带宽，因为各节点带宽不同
+// cmdn有磁盘能力时磁盘IO按使用率处理，结果保存在ResourceDiskUsageKey中，见resolveDiskUsage
+func resolveMissing(in *ScoreInput, keys []string) ([]string, map[string](map[string]int64), []model.MissingValue) {
+	snapshot := make(map[string](map[string]int64), len(in.Snapshot))
+	for k, v := range in.Snapshot {
+		snapshot[k] = v
+	}
+
+	// 均衡策略下负载越高越差，紧凑策略下负载越低越差
+	balance := in.Algorithm == model.AlgoBNP || in.TopsisMin
+	excluded := make(map[string]bool)
+	missing := make([]model.MissingValue, 0)
+	for _, key := range keys {
+		if key == model.ResourceDiskIOKey && in.Algorithm == model.AlgoCMDN && in.DiskCapMap != nil {
+			usage, mvs := resolveDiskUsage(in, balance)
+			for _, mv := range mvs {
+				excluded[mv.Host] = excluded[mv.Host] || mv.Excluded
+			}
+			missing = append(missing, mvs...)
+			snapshot[model.ResourceDiskUsageKey] = usage
+			continue
+		}
+		values := in.Snapshot[key]
+		isNet := key == model.ResourceNetIOKey
+
+		// 候选节点中已有的值，网络负载为负载比例
+		present := make([]float64, 0, len(in.NodeNames))
+		absent := make([]string, 0)
+		for _, name := range in.NodeNames {
+			v, ok := values[name]
+			if !ok {
+				absent = append(absent, name)
+				continue
+			}
+			if isNet {
+				if capNet := in.NetBwMap[name]; capNet > 0 {
+					present = append(present, float64(v)/float64(capNet))
+				}
+				continue
+			}
+			present = append(present, float64(v))
+		}
+		if len(absent) == 0 {
+			continue
+		}
+
+		filled := make(map[string]int64, len(values)+len(absent))
+		for name, v := range values {
+			filled[name] = v
+		}
+		for _, name := range absent {
+			mv := model.MissingValue{Host: name, Criterion: key, Policy: in.MissingPolicy.Get(key)}
+			value, ok, reason := imputeValue(in, mv.Policy, key, name, present, balance)
+			if ok && isNet && mv.Policy != model.MissingLast {
+				if capNet := in.NetBwMap[name]; capNet > 0 {
+					value = math.Round(value * float64(capNet))
+				} else {
+					ok, reason = false, "net capacity does not exist"
+				}
+			}
+
+			if ok {
+				mv.Value = int64(math.Round(value))
+				mv.Reason = fmt.Sprintf("%s is missing, use %s value %d", key, mv.Policy, mv.Value)
+				filled[name] = mv.Value
+			} else {
+				mv.Excluded = true
+				mv.Reason = fmt.Sprintf("%s is missing, excluded: %s", key, reason)
+				excluded[name] = true
+			}
+			missing = append(missing, mv)
+		}
+		snapshot[key] = filled
+	}
+
+	validNames := make([]string, 0, len(in.NodeNames))
+	for _, name := range in.NodeNames {
+		if !excluded[name] {
+			validNames = append(validNames, name)
+		}
+	}
+
+	return validNames, snapshot, missing
+}
+
+// filledMissing 是否按缺失数据策略补全了快照中的指标，被排除的节点不参与评分，磁盘使用率在评分时按节点比较，都不计入
+func filledMissing(in *ScoreInput, missing []model.MissingValue) bool {
+	diskUsage := in.Algorithm == model.AlgoCMDN && in.DiskCapMap != nil
+	for _, mv := range missing {
+		if !mv.Excluded && !(diskUsage && mv.Criterion == model.ResourceDiskIOKey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveDiskUsage 按照节点的磁盘能力计算磁盘使用率，缺少磁盘IO或者没有磁盘能力的节点都按照磁盘IO的缺失数据策略处理
+// 最差值和中位数按使用率计算，因为各节点磁盘能力不同
+func resolveDiskUsage(in *ScoreInput, balance bool) (map[string]int64, []model.MissingValue) {
+	key := model.ResourceDiskIOKey
+	diskMap, iopsMap := in.Snapshot[key], in.Snapshot[model.ResourceDiskIOPSKey]
+	usageMap := make(map[string]int64, len(in.NodeNames))
+	present := make([]float64, 0, len(in.NodeNames))
+	absent := make([]string, 0)
+	causes := make(map[string]string)
+	for _, name := range in.NodeNames {
+		disk, ok := diskMap[name]
+		if !ok {
+			absent = append(absent, name)
+			causes[name] = fmt.Sprintf("%s is missing", key)
+			continue
+		}
+		iops, hasIOPS := iopsMap[name]
+		usage, ok := diskUsage(disk, iops, hasIOPS, in.DiskCapMap[name])
+		if !ok {
+			absent = append(absent, name)
+			causes[name] = "disk capacity is missing"
+			continue
+		}
+		usageMap[name] = int64(math.Round(usage))
+		present = append(present, usage)
+	}
+
+	missing := make([]model.MissingValue, 0, len(absent))
+	for _, name := range absent {
+		mv := model.MissingValue{Host: name, Criterion: key, Policy: in.MissingPolicy.Get(key)}
+		var (
+			value  float64
+			ok     bool
+			reason string
+		)
+		if mv.Policy == model.MissingLast {
+			value, ok, reason = lastDiskUsage(in, name)
+		} else {
+			value, ok, reason = imputeValue(in, mv.Policy, key, name, present, balance)
+		}
+
+		if ok {
+			mv.Value = int64(math.Round(value))
+			mv.Reason = fmt.Sprintf("%s, use %s disk usage %d", causes[name], mv.Policy, mv.Value)
+			usageMap[name] = mv.Value
+		} else {
+			mv.Excluded = true
+			mv.Reason = fmt.Sprintf("%s, excluded: %s", causes[name], reason)
+		}
+		missing = append(missing, mv)
+	}
+
+	return usageMap, missing
+}
+
+// lastDiskUsage 使用最近一次已知的磁盘IO和IOPS计算磁盘使用率
+func lastDiskUsage(in *ScoreInput, name string) (float64, bool, string) {
+	disk, ok := in.lastKnown(model.ResourceDiskIOKey, name)
+	if !ok {
+		return 0, false, "no last known value"
+	}
+	iops, hasIOPS := in.lastKnown(model.ResourceDiskIOPSKey, name)
+	usage, ok := diskUsage(disk, iops, hasIOPS, in.DiskCapMap[name])
+	if !ok {
+		return 0, false, "disk capacity does not exist"
+	}
+
+	return usage, true, ""
+}
+
+// imputeValue 计算缺失指标的替代值，ok为false时节点被排除，reason为排除的原因
+func imputeValue(in *ScoreInput, policy, key, name string, present []float64, balance bool) (value float64, ok bool, reason string) {
+	switch policy {
+	case model.MissingWorst, model.MissingMedian:
+		if len(present) == 0 {
+			return 0, false, "no candidate node has this metric"
+		}
+		if policy == model.MissingMedian {
+			return median(present), true, ""
+		}
+		sorted := append([]float64(nil), present...)
+		sort.Float64s(sorted)
+		if balance {
+			return sorted[len(sorted)-1], true, ""
+		}
+		return sorted[0], true, ""
+	case model.MissingLast:
+		if v, exist := in.lastKnown(key, name); exist {
+			return float64(v), true, ""
+		}
+		return 0, false, "no last known value"
+	case model.MissingExclude:
+		return 0, false, "policy is exclude"
+	}
+
+	return 0, false, fmt.Sprintf("unknown policy %s", policy)
+}
+
+// median 中位数，个数为偶数时取中间两个数的平均值
+func median(arr []float64) float64 {
+	sorted := append([]float64(nil), arr...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// ValidateMissingPolicy 检查配置的策略是否合法
+func ValidateMissingPolicy(policy model.MissingPolicy) error {
+	for key, v := range policy {
+		switch v {
+		case model.MissingExclude, model.MissingWorst, model.MissingMedian, model.MissingLast:
+		default:
+			return fmt.Errorf("missing data policy %s of %s should be one of exclude/worst/median/last", v, key)
+		}
+	}
+
+	return nil
+}
+
+// lastKnown 节点name的指标key最近一次已知的值
+func (in *ScoreInput) lastKnown(key, name string) (int64, bool) {
+	if in.LastKnownValue != nil {
+		return in.LastKnownValue(key, name)
+	}
+	v, ok := in.LastKnown[key][name]
+
+	return v, ok
+}
+
+// usedLastKnown 评分时可能用到的最近一次已知的值，即使用last策略且快照中缺少的节点和指标，用于录制
+func usedLastKnown(in *ScoreInput) map[string](map[string]int64) {
+	res := make(map[string](map[string]int64))
+	for _, key := range requiredKeys(model.AlgoCMDN) {
+		if in.MissingPolicy.Get(key) != model.MissingLast {
+			continue
+		}
+		for _, name := range in.NodeNames {
+			if _, ok := in.Snapshot[key][name]; ok {
+				continue
+			}
+			lookup := []string{key}
+			if key == model.ResourceDiskIOKey {
+				// 按磁盘使用率补全时还会用到IOPS
+				lookup = append(lookup, model.ResourceDiskIOPSKey)
+			}
+			for _, k := range lookup {
+				if v, ok := in.lastKnown(k, name); ok {
+					if res[k] == nil {
+						res[k] = make(map[string]int64)
+					}
+					res[k][name] = v
+				}
+			}
+		}
+	}
+
+	return res
+}
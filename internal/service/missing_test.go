@@ -0,0 +1,230 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"liang/internal/dao"
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/conf/paladin"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newMissingInput(algo string, topsisMin bool, policy model.MissingPolicy) *ScoreInput {
+	return &ScoreInput{
+		Algorithm:     algo,
+		TopsisMin:     topsisMin,
+		MissingPolicy: policy,
+		Pod: &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "nginx-0",
+				Namespace:   "default",
+				Annotations: map[string]string{model.ResourceNetIOKey: "100"},
+			},
+		},
+		NodeNames: []string{"node1", "node2", "node3"},
+		NetBwMap:  map[string]int64{"node1": 1000 * model.KbitPS, "node2": 1500 * model.KbitPS, "node3": 2500 * model.KbitPS},
+		Snapshot: map[string](map[string]int64){
+			model.ResourceNetIOKey:  {"node1": 100 * model.KbitPS, "node2": 600 * model.KbitPS, "node3": 500 * model.KbitPS},
+			model.ResourceCPUKey:    {"node1": 20, "node2": 40},
+			model.ResourceMemKey:    {"node1": 30, "node2": 50, "node3": 40},
+			model.ResourceDiskIOKey: {"node1": 10, "node2": 20, "node3": 30},
+		},
+		LastKnown: map[string](map[string]int64){},
+	}
+}
+
+func TestResolveMissing(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Algorithm string
+		TopsisMin bool
+		Policy    string
+		LastKnown map[string](map[string]int64)
+		Excluded  bool
+		Value     int64
+	}{
+		{Name: "exclude", Algorithm: model.AlgoCMDN, Policy: model.MissingExclude, Excluded: true},
+		// 紧凑策略下负载越低越差
+		{Name: "worst compact", Algorithm: model.AlgoCMDN, Policy: model.MissingWorst, Value: 20},
+		{Name: "worst balance", Algorithm: model.AlgoCMDN, TopsisMin: true, Policy: model.MissingWorst, Value: 40},
+		{Name: "median", Algorithm: model.AlgoCMDN, Policy: model.MissingMedian, Value: 30},
+		{Name: "last", Algorithm: model.AlgoCMDN, Policy: model.MissingLast,
+			LastKnown: map[string](map[string]int64){model.ResourceCPUKey: {"node3": 55}}, Value: 55},
+		{Name: "last without value", Algorithm: model.AlgoCMDN, Policy: model.MissingLast, Excluded: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			in := newMissingInput(c.Algorithm, c.TopsisMin, model.MissingPolicy{model.ResourceCPUKey: c.Policy})
+			if c.LastKnown != nil {
+				in.LastKnown = c.LastKnown
+			}
+			validNames, snapshot, missing := resolveMissing(in, requiredKeys(c.Algorithm))
+
+			if len(missing) != 1 || missing[0].Host != "node3" || missing[0].Criterion != model.ResourceCPUKey {
+				t.Fatalf("only cpu of node3 should be missing, but get %+v", missing)
+			}
+			if missing[0].Excluded != c.Excluded || missing[0].Reason == "" {
+				t.Errorf("unexpected missing value %+v", missing[0])
+			}
+			if c.Excluded {
+				if !reflect.DeepEqual(validNames, []string{"node1", "node2"}) {
+					t.Errorf("node3 should be excluded, but get %v", validNames)
+				}
+				return
+			}
+			if len(validNames) != 3 {
+				t.Errorf("all nodes should be valid, but get %v", validNames)
+			}
+			if v := snapshot[model.ResourceCPUKey]["node3"]; v != c.Value || missing[0].Value != c.Value {
+				t.Errorf("cpu of node3 should be %d, but get %d", c.Value, v)
+			}
+			if _, ok := in.Snapshot[model.ResourceCPUKey]["node3"]; ok {
+				t.Errorf("input snapshot should not be modified")
+			}
+		})
+	}
+}
+
+func TestResolveMissing_NetLoadRatio(t *testing.T) {
+	in := newMissingInput(model.AlgoBNP, false, model.MissingPolicy{model.ResourceNetIOKey: model.MissingWorst})
+	delete(in.Snapshot[model.ResourceNetIOKey], "node3")
+	_, snapshot, missing := resolveMissing(in, requiredKeys(model.AlgoBNP))
+
+	// 最差的负载比例为node2的40%，乘以node3的带宽
+	expected := int64(1000 * model.KbitPS)
+	if len(missing) != 1 || snapshot[model.ResourceNetIOKey]["node3"] != expected {
+		t.Errorf("net io of node3 should be %d, but get %v %+v", expected, snapshot[model.ResourceNetIOKey], missing)
+	}
+}
+
+func TestScoreSnapshot_AllCandidates(t *testing.T) {
+	for _, algo := range []string{model.AlgoBNP, model.AlgoCMDN} {
+		in := newMissingInput(algo, true, nil)
+		delete(in.Snapshot[model.ResourceNetIOKey], "node2")
+
+		res, reasons, err := ScoreSnapshot(in)
+		if err != nil {
+			t.Fatalf("%s: score snapshot error: %v", algo, err)
+		}
+		if len(res) != len(in.NodeNames) {
+			t.Fatalf("%s: every candidate node should have a score, but get %v", algo, res)
+		}
+		for i, hp := range res {
+			if hp.Host != in.NodeNames[i] {
+				t.Errorf("%s: result should keep the order of candidates, but get %v", algo, res)
+			}
+		}
+		// 网络负载默认排除，翻转得分后也只能得到最低分
+		if res[1].Score != model.MinNodeScore || reasons["node2"] == "" {
+			t.Errorf("%s: node2 should be excluded with a reason, but get %v %v", algo, res, reasons)
+		}
+		if algo == model.AlgoCMDN && reasons["node3"] == "" {
+			t.Errorf("%s: node3 should have a reason for missing cpu, but get %v", algo, reasons)
+		}
+	}
+}
+
+func TestScoreSnapshot_RejectedLast(t *testing.T) {
+	for _, topsisMin := range []bool{false, true} {
+		in := newMissingInput(model.AlgoCMDN, topsisMin, nil)
+		in.Snapshot[model.ResourceCPUKey]["node3"] = 30
+		// node3剩余带宽不足，被网络过滤排除
+		in.Snapshot[model.ResourceNetIOKey]["node3"] = 2450 * model.KbitPS
+
+		res, reasons, err := ScoreSnapshot(in)
+		if err != nil {
+			t.Fatalf("score snapshot error: %v", err)
+		}
+		if len(res) != 3 || res[2].Host != "node3" || res[2].Score != model.MinNodeScore || reasons["node3"] == "" {
+			t.Errorf("topsisMin %v: rejected node3 should get min score with a reason, but get %v %v", topsisMin, res, reasons)
+		}
+
+		cmdn := CMDNPriority{}
+		expected, _, err := cmdn.Explain(in.Pod, []string{"node1", "node2"}, in.NetBwMap, in.Snapshot)
+		if err != nil {
+			t.Fatalf("explain error: %v", err)
+		}
+		for i := range expected {
+			score := expected[i].Score
+			if topsisMin {
+				score = model.MaxNodeScore - score
+			}
+			if res[i].Host != expected[i].Host || res[i].Score != score {
+				t.Errorf("topsisMin %v: score of %s should be %d, but get %v", topsisMin, expected[i].Host, score, res)
+			}
+		}
+	}
+}
+
+func TestService_MissingDataPolicyConfig(t *testing.T) {
+	base := `
+netbwMapKeys = ["node1"]
+netbwMapValues = [1000.0]
+syncStatusInterval = "0 0 0 1 1 ?"
+maxSnapshotAge = "60s"
+livenessTimeout = "120s"
+topsisMin = false
+useBNP = false
+dryrun = true
+`
+	d, dcf, err := dao.NewWithConfig(&dao.Config{LocalCacheExpire: 300})
+	if err != nil {
+		t.Fatalf("new dao error: %v", err)
+	}
+	defer dcf()
+
+	ac := &paladin.TOML{}
+	if err = ac.Set(base + `
+[missingDataPolicy]
+LiangCPU = "median"
+LiangNetIO = "last"
+`); err != nil {
+		t.Fatalf("set config error: %v", err)
+	}
+	s, cf, err := NewWithConfig(d, ac)
+	if err != nil {
+		t.Fatalf("new service error: %v", err)
+	}
+	defer cf()
+	<-s.initDone
+	if s.missingPolicy.Get(model.ResourceCPUKey) != model.MissingMedian ||
+		s.missingPolicy.Get(model.ResourceNetIOKey) != model.MissingLast ||
+		s.missingPolicy.Get(model.ResourceMemKey) != model.MissingWorst {
+		t.Errorf("unexpected missing data policy %v", s.missingPolicy)
+	}
+
+	ac = &paladin.TOML{}
+	if err = ac.Set(base + `
+[missingDataPolicy]
+LiangCPU = "zero"
+`); err != nil {
+		t.Fatalf("set config error: %v", err)
+	}
+	if _, _, err = NewWithConfig(d, ac); err == nil {
+		t.Errorf("unknown missing data policy should be rejected")
+	}
+}
+
+func TestResolveMissing_LastKnownLookup(t *testing.T) {
+	in := newMissingInput(model.AlgoCMDN, false, model.MissingPolicy{model.ResourceCPUKey: model.MissingLast})
+	var calls []string
+	in.LastKnownValue = func(key, name string) (int64, bool) {
+		calls = append(calls, key+"/"+name)
+		return 55, true
+	}
+
+	_, snapshot, _ := resolveMissing(in, requiredKeys(model.AlgoCMDN))
+	if len(calls) != 1 || calls[0] != model.ResourceCPUKey+"/node3" {
+		t.Fatalf("last known value should be looked up only for cpu of node3, but get %v", calls)
+	}
+	if snapshot[model.ResourceCPUKey]["node3"] != 55 {
+		t.Fatalf("cpu of node3 should be 55, but get %d", snapshot[model.ResourceCPUKey]["node3"])
+	}
+	if used := usedLastKnown(in); len(used) != 1 || used[model.ResourceCPUKey]["node3"] != 55 {
+		t.Fatalf("capture should record only the used last known value, but get %v", used)
+	}
+}
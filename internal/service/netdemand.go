@@ -0,0 +1,235 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/log"
+	xtime "github.com/go-kratos/kratos/pkg/time"
+	"gonum.org/v1/gonum/stat"
+	v1 "k8s.io/api/core/v1"
+)
+
+const defaultNetDemandInterval = "0 */10 * * * ?"
+
+// netDemandOwnerKinds 按控制器学习网络需求的控制器类型，Deployment的Pod由ReplicaSet管理，见podOwners
+var netDemandOwnerKinds = map[string]bool{
+	"ReplicaSet":  true,
+	"StatefulSet": true,
+	"Job":         true,
+}
+
+// NetDemandConfig 学习Pod网络需求的配置，对应application.toml中的[netDemand]
+// Pod没有网络需求注解时，使用同一控制器下Pod在Window内网络负载的Quantile分位数的平均值作为需求
+type NetDemandConfig struct {
+	Enabled       bool
+	Window        xtime.Duration // 查询历史网络负载的时间范围
+	Quantile      float64        // 每个Pod在Window内网络负载的分位数
+	MinConfidence float64        // 置信度不高于该值的估计不使用，只有一个Pod时置信度最多为0.5，默认不使用
+	Interval      string         // 刷新估计的cron表达式
+}
+
+// DefaultNetDemandConfig 没有配置[netDemand]时不学习网络需求
+func DefaultNetDemandConfig() NetDemandConfig {
+	return NetDemandConfig{
+		Window:        xtime.Duration(6 * time.Hour),
+		Quantile:      0.95,
+		MinConfidence: 0.5,
+		Interval:      defaultNetDemandInterval,
+	}
+}
+
+// Validate 检查时间范围、分位数和置信度
+func (cfg NetDemandConfig) Validate() error {
+	if cfg.Window <= 0 {
+		return fmt.Errorf("net demand window should be positive")
+	}
+	if cfg.Quantile <= 0 || cfg.Quantile > 1 {
+		return fmt.Errorf("net demand quantile %f should be in (0, 1]", cfg.Quantile)
+	}
+	if cfg.MinConfidence < 0 || cfg.MinConfidence > 1 {
+		return fmt.Errorf("net demand min confidence %f should be in [0, 1]", cfg.MinConfidence)
+	}
+
+	return nil
+}
+
+// netDemandState 最近一次学习得到的每个控制器的网络需求，key为PodOwner.String()
+type netDemandState struct {
+	mu        sync.RWMutex
+	estimates map[string]*model.NetDemandEstimate
+}
+
+// estimateNetDemand 按控制器合并两个方向上每个Pod的网络负载
+// 需求为各Pod负载的平均值，置信度为n/(n+1)/(1+cv)，n为Pod个数，cv为两个方向中较大的变异系数
+func estimateNetDemand(in, out map[model.PodOwner]map[string]int64, now time.Time) map[string]*model.NetDemandEstimate {
+	pods := make(map[model.PodOwner]map[string]bool)
+	for _, usage := range []map[model.PodOwner]map[string]int64{in, out} {
+		for owner, values := range usage {
+			if pods[owner] == nil {
+				pods[owner] = make(map[string]bool)
+			}
+			for pod := range values {
+				pods[owner][pod] = true
+			}
+		}
+	}
+
+	res := make(map[string]*model.NetDemandEstimate, len(pods))
+	for owner, names := range pods {
+		n := len(names)
+		inMean, inCV := meanCV(in[owner], names)
+		outMean, outCV := meanCV(out[owner], names)
+		res[owner.String()] = &model.NetDemandEstimate{
+			Owner:      owner,
+			Demand:     model.NetDemand{In: int64(math.Round(inMean)), Out: int64(math.Round(outMean))},
+			Pods:       n,
+			Confidence: float64(n) / float64(n+1) / (1 + math.Max(inCV, outCV)),
+			UpdatedAt:  now,
+		}
+	}
+
+	return res
+}
+
+// meanCV 控制器下各Pod负载的平均值和变异系数，没有该方向数据的Pod按0计算
+func meanCV(values map[string]int64, names map[string]bool) (mean, cv float64) {
+	x := make([]float64, 0, len(names))
+	for name := range names {
+		x = append(x, float64(values[name]))
+	}
+	mean, std := stat.PopMeanStdDev(x, nil)
+	if mean <= 0 {
+		return 0, 0
+	}
+
+	return mean, std / mean
+}
+
+// SyncNetDemand 从Prometheus查询Pod的历史网络负载，重新估计每个控制器的网络需求，查询失败时保留上一次的结果
+func (s *Service) SyncNetDemand() error {
+	window, quantile := time.Duration(s.netDemandCfg.Window), s.netDemandCfg.Quantile
+	in, err := s.dao.RequestPromPodNetIO(model.NetIOTypeDown, window, quantile)
+	if err != nil {
+		log.Warn("get pod download netload from prom error: %v", err)
+		return err
+	}
+	out, err := s.dao.RequestPromPodNetIO(model.NetIOTypeUp, window, quantile)
+	if err != nil {
+		log.Warn("get pod upload netload from prom error: %v", err)
+		return err
+	}
+
+	estimates := estimateNetDemand(in, out, time.Now())
+	s.netDemand.mu.Lock()
+	s.netDemand.estimates = estimates
+	s.netDemand.mu.Unlock()
+	log.Info("learned net demand of %d pod owners", len(estimates))
+
+	return nil
+}
+
+// NetDemandEstimates 最近一次学习得到的全部网络需求，按控制器排序
+func (s *Service) NetDemandEstimates() []*model.NetDemandEstimate {
+	s.netDemand.mu.RLock()
+	res := make([]*model.NetDemandEstimate, 0, len(s.netDemand.estimates))
+	for _, e := range s.netDemand.estimates {
+		res = append(res, e)
+	}
+	s.netDemand.mu.RUnlock()
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Owner.String() < res[j].Owner.String()
+	})
+
+	return res
+}
+
+// podOwners 按优先级返回查找网络需求时使用的Pod控制器，只支持netDemandOwnerKinds中的类型
+// Deployment创建的ReplicaSet名称为<deployment>-<pod-template-hash>，此时先使用Deployment，滚动更新后估计仍然有效，再使用ReplicaSet
+func podOwners(pod *v1.Pod) []model.PodOwner {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Controller == nil || !*ref.Controller || !netDemandOwnerKinds[ref.Kind] {
+			continue
+		}
+		owner := model.PodOwner{Namespace: pod.Namespace, Kind: ref.Kind, Name: ref.Name}
+		hash := pod.Labels[podTemplateHashLabel]
+		if ref.Kind == "ReplicaSet" && hash != "" && strings.HasSuffix(ref.Name, "-"+hash) {
+			deployment := model.PodOwner{Namespace: pod.Namespace, Kind: "Deployment", Name: strings.TrimSuffix(ref.Name, "-"+hash)}
+			return []model.PodOwner{deployment, owner}
+		}
+		return []model.PodOwner{owner}
+	}
+
+	return nil
+}
+
+// podTemplateHashLabel Deployment为ReplicaSet和Pod添加的标签，也是ReplicaSet名称的后缀
+const podTemplateHashLabel = "pod-template-hash"
+
+// hasNetDemandAnnotation Pod是否声明了任意方向的网络需求
+func hasNetDemandAnnotation(pod *v1.Pod) bool {
+	for _, key := range []string{model.ResourceNetIOKey, model.ResourceNetInKey, model.ResourceNetOutKey} {
+		if _, ok := pod.Annotations[key]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// learnedNetDemand Pod没有声明网络需求时，返回其控制器学习得到的需求，没有学习结果或者置信度不高于MinConfidence时返回nil
+func (s *Service) learnedNetDemand(pod *v1.Pod) *model.NetDemandEstimate {
+	if !s.netDemandCfg.Enabled || pod == nil || hasNetDemandAnnotation(pod) {
+		return nil
+	}
+	var e *model.NetDemandEstimate
+	s.netDemand.mu.RLock()
+	for _, owner := range podOwners(pod) {
+		if e = s.netDemand.estimates[owner.String()]; e != nil {
+			break
+		}
+	}
+	s.netDemand.mu.RUnlock()
+	if e == nil || e.Confidence <= s.netDemandCfg.MinConfidence {
+		return nil
+	}
+
+	return e
+}
+
+// podWithNetDemand 把学习得到的网络需求按注解的格式加入Pod的副本，评分和录制都使用副本，pod本身不会被修改
+// 注解的单位为Mbps，学习得到的需求向上取整，避免较小的需求被忽略
+func (s *Service) podWithNetDemand(pod *v1.Pod) *v1.Pod {
+	e := s.learnedNetDemand(pod)
+	if e == nil {
+		return pod
+	}
+
+	mbps := func(kbps int64) string {
+		return strconv.FormatInt((kbps+model.KbitPS-1)/model.KbitPS, 10)
+	}
+	res := *pod
+	res.Annotations = make(map[string]string, len(pod.Annotations)+4)
+	for k, v := range pod.Annotations {
+		res.Annotations[k] = v
+	}
+	netIO := e.Demand.In
+	if e.Demand.Out > netIO {
+		netIO = e.Demand.Out
+	}
+	res.Annotations[model.ResourceNetIOKey] = mbps(netIO)
+	res.Annotations[model.ResourceNetInKey] = mbps(e.Demand.In)
+	res.Annotations[model.ResourceNetOutKey] = mbps(e.Demand.Out)
+	res.Annotations[model.AnnotationNetDemandLearned] = e.Owner.String()
+	log.V(3).Info("use net demand %+v learned from %s with confidence %.2f for pod %s/%s",
+		e.Demand, e.Owner, e.Confidence, pod.Namespace, pod.Name)
+
+	return &res
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"liang/internal/fakeprom"
+	"liang/internal/model"
+
+	promModel "github.com/prometheus/common/model"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func TestEstimateNetDemand(t *testing.T) {
+	web := model.PodOwner{Namespace: "default", Kind: "ReplicaSet", Name: "web-7d4b9"}
+	db := model.PodOwner{Namespace: "default", Kind: "StatefulSet", Name: "db"}
+	job := model.PodOwner{Namespace: "batch", Kind: "Job", Name: "etl"}
+	in := map[model.PodOwner]map[string]int64{
+		web: {"web-1": 8000, "web-2": 8000, "web-3": 8000},
+		db:  {"db-0": 1000, "db-1": 3000},
+	}
+	out := map[model.PodOwner]map[string]int64{
+		web: {"web-1": 2000, "web-2": 2000, "web-3": 2000},
+		job: {"etl-x": 500},
+	}
+	res := estimateNetDemand(in, out, time.Now())
+
+	cases := []struct {
+		Owner      model.PodOwner
+		Demand     model.NetDemand
+		Pods       int
+		Confidence float64
+	}{
+		// 3个Pod的负载相同，置信度为3/4
+		{Owner: web, Demand: model.NetDemand{In: 8000, Out: 2000}, Pods: 3, Confidence: 0.75},
+		// 下行的变异系数为0.5，置信度为2/3/1.5
+		{Owner: db, Demand: model.NetDemand{In: 2000}, Pods: 2, Confidence: 4.0 / 9},
+		// 只有1个Pod，置信度为1/2
+		{Owner: job, Demand: model.NetDemand{Out: 500}, Pods: 1, Confidence: 0.5},
+	}
+	if len(res) != len(cases) {
+		t.Fatalf("should estimate %d owners, but get %d", len(cases), len(res))
+	}
+	for _, c := range cases {
+		e := res[c.Owner.String()]
+		if e == nil {
+			t.Fatalf("estimate of %s does not exist", c.Owner)
+		}
+		if e.Owner != c.Owner || e.Demand != c.Demand || e.Pods != c.Pods || math.Abs(e.Confidence-c.Confidence) > 1e-9 {
+			t.Errorf("estimate of %s should be %+v, but get %+v", c.Owner, c, e)
+		}
+	}
+}
+
+func TestService_LearnedNetDemandConfidence(t *testing.T) {
+	job := model.PodOwner{Namespace: "batch", Kind: "Job", Name: "etl"}
+	s := &Service{netDemandCfg: DefaultNetDemandConfig()}
+	s.netDemandCfg.Enabled = true
+	pod := newOwnedPod("etl-x", "Job", "etl", nil)
+	pod.Namespace = "batch"
+
+	// 只有一个Pod的估计置信度为0.5，等于默认的minConfidence，不使用
+	s.netDemand.estimates = estimateNetDemand(nil, map[model.PodOwner]map[string]int64{job: {"etl-x": 500}}, time.Now())
+	if e := s.learnedNetDemand(pod); e != nil {
+		t.Errorf("estimate of a single pod should not be used with default min confidence, but get %+v", e)
+	}
+	s.netDemand.estimates = estimateNetDemand(nil, map[model.PodOwner]map[string]int64{job: {"etl-x": 500, "etl-y": 500}}, time.Now())
+	if e := s.learnedNetDemand(pod); e == nil {
+		t.Errorf("estimate of two identical pods should be used")
+	}
+}
+
+func TestNetDemandConfig_Validate(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Modify func(cfg *NetDemandConfig)
+		Valid  bool
+	}{
+		{Name: "default", Modify: func(cfg *NetDemandConfig) {}, Valid: true},
+		{Name: "zero window", Modify: func(cfg *NetDemandConfig) { cfg.Window = 0 }},
+		{Name: "quantile too large", Modify: func(cfg *NetDemandConfig) { cfg.Quantile = 95 }},
+		{Name: "negative confidence", Modify: func(cfg *NetDemandConfig) { cfg.MinConfidence = -0.1 }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			cfg := DefaultNetDemandConfig()
+			c.Modify(&cfg)
+			if err := cfg.Validate(); (err == nil) != c.Valid {
+				t.Errorf("valid should be %v, but get %v", c.Valid, err)
+			}
+		})
+	}
+}
+
+// newOwnedPod 控制器为owner的Pod，annotations为空时没有网络需求注解
+func newOwnedPod(name, kind, owner string, annotations map[string]string) *v1.Pod {
+	controller := true
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: kind, Name: owner, Controller: &controller},
+			},
+		},
+	}
+}
+
+func TestService_NetDemand(t *testing.T) {
+	prom := fakeprom.New()
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 100000, "node2": 600000, "node3": 1200000})
+	prom.SetConst(fakeprom.DiskWritten, map[string]float64{"node1": 1024, "node2": 2048, "node3": 4096})
+	prom.SetConst(fakeprom.CPUSeconds, map[string]float64{"node1": 0.2, "node2": 0.4, "node3": 0.6})
+	prom.SetConst(fakeprom.MemAvailable, map[string]float64{"node1": 0.3, "node2": 0.5, "node3": 0.7})
+	pod := func(name, kind, owner string) promModel.Metric {
+		return promModel.Metric{"namespace": "default", "pod": promModel.LabelValue(name),
+			"owner_kind": promModel.LabelValue(kind), "owner_name": promModel.LabelValue(owner)}
+	}
+	// web的3个Pod下行7.5Mbps、上行2Mbps，db只有一个Pod，置信度不足
+	for _, name := range []string{"web-1", "web-2", "web-3"} {
+		prom.SetLabeledSeries(fakeprom.ContainerNetReceive, pod(name, "ReplicaSet", "web-7d4b9"), fakeprom.Const(7500))
+		prom.SetLabeledSeries(fakeprom.ContainerNetTransmit, pod(name, "ReplicaSet", "web-7d4b9"), fakeprom.Const(2000))
+	}
+	prom.SetLabeledSeries(fakeprom.ContainerNetReceive, pod("db-0", "StatefulSet", "db"), fakeprom.Const(50000))
+	// api的Pod按Deployment分组，下行3Mbps、上行2Mbps
+	for _, name := range []string{"api-1", "api-2", "api-3"} {
+		prom.SetLabeledSeries(fakeprom.ContainerNetReceive, pod(name, "Deployment", "api"), fakeprom.Const(3000))
+		prom.SetLabeledSeries(fakeprom.ContainerNetTransmit, pod(name, "Deployment", "api"), fakeprom.Const(2000))
+	}
+	s := newFakePromService(t, prom, false, `
+[netDemand]
+enabled = true
+window = "6h"
+quantile = 0.95
+minConfidence = 0.6
+`)
+
+	if estimates := s.NetDemandEstimates(); len(estimates) != 3 {
+		t.Fatalf("initial sync should learn net demand of 3 owners, but get %v", estimates)
+	}
+	// 滚动更新后新的ReplicaSet仍然使用Deployment的估计
+	rollout := newOwnedPod("api-4", "ReplicaSet", "api-6b7c9", nil)
+	rollout.Labels = map[string]string{podTemplateHashLabel: "6b7c9"}
+
+	nodeNames := []string{"node1", "node2", "node3"}
+	cases := []struct {
+		Name    string
+		Pod     *v1.Pod
+		Learned bool
+		NetNeed int64
+	}{
+		{Name: "learned from replicaset", Pod: newOwnedPod("web-4", "ReplicaSet", "web-7d4b9", nil), Learned: true, NetNeed: 8 * model.KbitPS},
+		{Name: "annotation first", Pod: newOwnedPod("web-5", "ReplicaSet", "web-7d4b9", map[string]string{model.ResourceNetIOKey: "1"}), NetNeed: model.KbitPS},
+		{Name: "low confidence", Pod: newOwnedPod("db-1", "StatefulSet", "db", nil)},
+		{Name: "unknown owner", Pod: newOwnedPod("api-1", "ReplicaSet", "api-5f6c8", nil)},
+		{Name: "learned from deployment", Pod: rollout, Learned: true, NetNeed: 3 * model.KbitPS},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			args := &extenderv1.ExtenderArgs{Pod: c.Pod, NodeNames: &nodeNames}
+			in := s.scoreInput(model.AlgoCMDN, args, nil)
+			if learned := in.Pod != c.Pod; learned != c.Learned {
+				t.Fatalf("learned should be %v", c.Learned)
+			}
+			if c.Learned && (in.Pod.Annotations[model.ResourceNetOutKey] != "2" || c.Pod.Annotations != nil) {
+				t.Errorf("learned demand should only be added to the copy, but get %v", in.Pod.Annotations)
+			}
+
+			explain, err := s.Explain(args)
+			if err != nil {
+				t.Fatalf("explain error: %v", err)
+			}
+			if explain.CMDN.NetNeed != c.NetNeed || (explain.LearnedDemand != nil) != c.Learned {
+				t.Errorf("net need should be %d, but get %d, learned demand %+v", c.NetNeed, explain.CMDN.NetNeed, explain.LearnedDemand)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/log"
+)
+
+const (
+	defaultNICCapacityMinMbps   = 10
+	defaultNICCapacityMaxMbps   = 400000
+	defaultNICDiscoveryInterval = "0 */5 * * * ?"
+)
+
+// nicCapacity 节点网卡带宽的各个来源，单位Kbit/s
+// 优先级：节点注解或标签 > Prometheus中物理网卡的速度 > application.toml中的静态配置
+type nicCapacity struct {
+	mu         sync.RWMutex
+	min, max   int64            // 合法的网卡带宽范围
+	nodes      []string         // netbwMapKeys，只有这些节点会同步指标
+	static     map[string]int64 // netbwMapKeys/netbwMapValues
+	discovered map[string]int64 // node_network_speed_bytes
+	labeled    map[string]int64 // 节点注解或标签liang.io/nic-speed
+	effective  map[string]int64 // 合并后的结果，变化时整体替换，调用方不能修改
+
+	// 分方向的带宽，没有设置的方向使用effective中的网卡速度，标签优先于静态配置
+	staticDir  map[string]model.NetCapacity // netbwInMapValues/netbwOutMapValues
+	labeledDir map[string]model.NetCapacity // 节点注解或标签liang.io/nic-speed-in、liang.io/nic-speed-out
+}
+
+// newNICCapacity 静态配置中的带宽不合法时返回错误，staticDir中为0的方向表示使用网卡速度
+func newNICCapacity(nodes []string, static map[string]int64, staticDir map[string]model.NetCapacity, min, max int64) (*nicCapacity, error) {
+	nc := &nicCapacity{
+		min:        min,
+		max:        max,
+		nodes:      nodes,
+		static:     static,
+		discovered: make(map[string]int64),
+		labeled:    make(map[string]int64),
+		staticDir:  staticDir,
+		labeledDir: make(map[string]model.NetCapacity),
+	}
+	for _, name := range nodes {
+		if err := nc.validate(static[name]); err != nil {
+			return nil, fmt.Errorf("netbwMapValues of %s: %v", name, err)
+		}
+		c := staticDir[name]
+		if c.In != 0 {
+			if err := nc.validate(c.In); err != nil {
+				return nil, fmt.Errorf("netbwInMapValues of %s: %v", name, err)
+			}
+		}
+		if c.Out != 0 {
+			if err := nc.validate(c.Out); err != nil {
+				return nil, fmt.Errorf("netbwOutMapValues of %s: %v", name, err)
+			}
+		}
+	}
+	nc.effective = nc.merge()
+
+	return nc, nil
+}
+
+// validate 拒绝为0、为负或者明显错误的网卡带宽
+func (nc *nicCapacity) validate(capNet int64) error {
+	if capNet < nc.min || capNet > nc.max {
+		return fmt.Errorf("net capacity %d Kbit/s should be in [%d, %d]", capNet, nc.min, nc.max)
+	}
+
+	return nil
+}
+
+// merge 按优先级合并各来源，调用方持有锁
+func (nc *nicCapacity) merge() map[string]int64 {
+	res := make(map[string]int64, len(nc.nodes))
+	for _, name := range nc.nodes {
+		if v, ok := nc.labeled[name]; ok {
+			res[name] = v
+		} else if v, ok := nc.discovered[name]; ok {
+			res[name] = v
+		} else {
+			res[name] = nc.static[name]
+		}
+	}
+
+	return res
+}
+
+// get 当前生效的网卡带宽
+func (nc *nicCapacity) get() map[string]int64 {
+	nc.mu.RLock()
+	defer nc.mu.RUnlock()
+
+	return nc.effective
+}
+
+// update 用values替换一个来源中scope内节点的网卡带宽，不合法的值被忽略，返回生效的带宽是否发生变化
+func (nc *nicCapacity) update(source string, values map[string]int64, scope []string) bool {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	target := nc.discovered
+	if source == nicSourceLabel {
+		target = nc.labeled
+	}
+	for _, name := range scope {
+		delete(target, name)
+	}
+	for name, v := range values {
+		if err := nc.validate(v); err != nil {
+			log.Warn("ignore %s net capacity of %s: %v", source, name, err)
+			continue
+		}
+		target[name] = v
+	}
+
+	effective := nc.merge()
+	changed := false
+	for _, name := range nc.nodes {
+		if old := nc.effective[name]; old != effective[name] {
+			log.Info("net capacity of %s changed from %d to %d Kbit/s by %s", name, old, effective[name], source)
+			changed = true
+		}
+	}
+	if changed {
+		nc.effective = effective
+	}
+
+	return changed
+}
+
+// directional 当前生效的分方向带宽，每次返回新的map
+func (nc *nicCapacity) directional() map[string]model.NetCapacity {
+	nc.mu.RLock()
+	defer nc.mu.RUnlock()
+
+	res := make(map[string]model.NetCapacity, len(nc.nodes))
+	for _, name := range nc.nodes {
+		c := model.NetCapacity{In: nc.effective[name], Out: nc.effective[name]}
+		for _, o := range []model.NetCapacity{nc.staticDir[name], nc.labeledDir[name]} {
+			if o.In > 0 {
+				c.In = o.In
+			}
+			if o.Out > 0 {
+				c.Out = o.Out
+			}
+		}
+		res[name] = c
+	}
+
+	return res
+}
+
+// updateDirections 用values替换scope内节点标签中的分方向带宽，不合法的方向被忽略，返回是否发生变化
+func (nc *nicCapacity) updateDirections(values map[string]model.NetCapacity, scope []string) bool {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	check := func(name, dir string, v int64) int64 {
+		if v == 0 {
+			return 0
+		}
+		if err := nc.validate(v); err != nil {
+			log.Warn("ignore %s net capacity of %s: %v", dir, name, err)
+			return 0
+		}
+		return v
+	}
+	changed := false
+	for _, name := range scope {
+		v := values[name]
+		c := model.NetCapacity{In: check(name, "in", v.In), Out: check(name, "out", v.Out)}
+		if old := nc.labeledDir[name]; old != c {
+			log.Info("directional net capacity of %s changed from %+v to %+v Kbit/s by %s", name, old, c, nicSourceLabel)
+			changed = true
+		}
+		if c == (model.NetCapacity{}) {
+			delete(nc.labeledDir, name)
+		} else {
+			nc.labeledDir[name] = c
+		}
+	}
+
+	return changed
+}
+
+// 网卡带宽的来源
+const (
+	nicSourceProm  = "prometheus"
+	nicSourceLabel = "label"
+)
+
+// netBw 当前生效的网卡带宽，单位Kbit/s
+func (s *Service) netBw() map[string]int64 {
+	return s.nicCap.get()
+}
+
+// netCap 当前生效的分方向网卡带宽，单位Kbit/s
+func (s *Service) netCap() map[string]model.NetCapacity {
+	return s.nicCap.directional()
+}
+
+// SyncNICCapacity 从Prometheus同步物理网卡的速度，查询失败时保留上一次的结果
+func (s *Service) SyncNICCapacity() error {
+	res, err := s.dao.RequestPromNICSpeed()
+	if err != nil {
+		log.Warn("get nic speed from prom error: %v", err)
+		return err
+	}
+
+	if s.nicCap.update(nicSourceProm, s.filterByNodeName(res), s.nodeNames) {
+		s.refreshConfigHash()
+	}
+
+	return nil
+}
+
+// nodeLabelKeys 从节点标签中读取的网卡带宽和磁盘能力，网卡带宽也可以作为节点注解
+var (
+	nodeLabelKeys = []string{model.LabelNICSpeed, model.LabelNICSpeedIn, model.LabelNICSpeedOut,
+		model.LabelDiskType, model.LabelDiskThroughput, model.LabelDiskIOPS}
+	nodeAnnotationKeys = []string{model.LabelNICSpeed, model.LabelNICSpeedIn, model.LabelNICSpeedOut}
+)
+
+// SyncNodeLabels 从kube-state-metrics同步节点的标签和注解，更新其中的网卡带宽和磁盘能力，注解优先
+// 与kube-scheduler是否传递节点对象无关，查询失败时保留上一次的结果
+func (s *Service) SyncNodeLabels() error {
+	labels, err := s.dao.RequestPromNodeLabels(nodeLabelKeys)
+	if err != nil {
+		log.Warn("get node labels from prom error: %v", err)
+		return err
+	}
+	annotations, err := s.dao.RequestPromNodeAnnotations(nodeAnnotationKeys)
+	if err != nil {
+		log.Warn("get node annotations from prom error: %v", err)
+		return err
+	}
+
+	values := make(map[string]int64)
+	dirValues := make(map[string]model.NetCapacity)
+	nodeLabels := make(map[string]map[string]string)
+	for _, name := range s.nodeNames {
+		if v, ok := nodeNICSpeed(annotations[name], labels[name], name, model.LabelNICSpeed); ok {
+			values[name] = v
+		}
+		in, _ := nodeNICSpeed(annotations[name], labels[name], name, model.LabelNICSpeedIn)
+		out, _ := nodeNICSpeed(annotations[name], labels[name], name, model.LabelNICSpeedOut)
+		if in > 0 || out > 0 {
+			dirValues[name] = model.NetCapacity{In: in, Out: out}
+		}
+		if l, ok := labels[name]; ok {
+			nodeLabels[name] = l
+		}
+	}
+	changed := s.nicCap.update(nicSourceLabel, values, s.nodeNames)
+	if s.nicCap.updateDirections(dirValues, s.nodeNames) || changed {
+		s.refreshConfigHash()
+	}
+
+	s.nodeLabelsMu.Lock()
+	s.nodeLabels = nodeLabels
+	s.nodeLabelsMu.Unlock()
+	log.V(5).Info("labels of nodes are %v", nodeLabels)
+
+	return nil
+}
+
+// nodeNICSpeed 读取节点注解或标签中的网卡带宽，注解优先，单位从Mbps转换为Kbit/s
+func nodeNICSpeed(annotations, labels map[string]string, name, key string) (int64, bool) {
+	v, ok := annotations[key]
+	if !ok {
+		v, ok = labels[key]
+	}
+	if !ok {
+		return 0, false
+	}
+	mbps, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Warn("%s=%s of node %s is invalid, ignore", key, v, name)
+		return 0, false
+	}
+
+	return int64(mbps * model.KbitPS), true
+}
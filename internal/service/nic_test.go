@@ -0,0 +1,169 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"liang/internal/dao"
+	"liang/internal/fakeprom"
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/conf/paladin"
+	promModel "github.com/prometheus/common/model"
+)
+
+func newTestNICCapacity(t *testing.T) *nicCapacity {
+	static := map[string]int64{"node1": 1000 * model.KbitPS, "node2": 1500 * model.KbitPS, "node3": 2500 * model.KbitPS}
+	nc, err := newNICCapacity([]string{"node1", "node2", "node3"}, static, nil,
+		defaultNICCapacityMinMbps*model.KbitPS, defaultNICCapacityMaxMbps*model.KbitPS)
+	if err != nil {
+		t.Fatalf("new nic capacity error: %v", err)
+	}
+
+	return nc
+}
+
+func TestNICCapacity_Priority(t *testing.T) {
+	nc := newTestNICCapacity(t)
+	nodes := []string{"node1", "node2", "node3"}
+
+	// 0和明显错误的值被忽略
+	changed := nc.update(nicSourceProm, map[string]int64{
+		"node1": 10000 * model.KbitPS,
+		"node2": 0,
+		"node3": 1e9 * model.KbitPS,
+	}, nodes)
+	if !changed {
+		t.Errorf("net capacity of node1 should change")
+	}
+	expected := map[string]int64{"node1": 10000 * model.KbitPS, "node2": 1500 * model.KbitPS, "node3": 2500 * model.KbitPS}
+	if !reflect.DeepEqual(nc.get(), expected) {
+		t.Errorf("net capacity should be %v, but get %v", expected, nc.get())
+	}
+
+	// 节点标签优先于Prometheus
+	nc.update(nicSourceLabel, map[string]int64{"node1": 25000 * model.KbitPS}, []string{"node1"})
+	if v := nc.get()["node1"]; v != 25000*model.KbitPS {
+		t.Errorf("label should override prometheus, but get %d", v)
+	}
+	// 标签被删除后回到Prometheus发现的值
+	nc.update(nicSourceLabel, nil, []string{"node1"})
+	if v := nc.get()["node1"]; v != 10000*model.KbitPS {
+		t.Errorf("net capacity should fall back to prometheus, but get %d", v)
+	}
+	// Prometheus中没有该节点时回到静态配置
+	nc.update(nicSourceProm, map[string]int64{}, nodes)
+	if v := nc.get()["node1"]; v != 1000*model.KbitPS {
+		t.Errorf("net capacity should fall back to static config, but get %d", v)
+	}
+	if nc.update(nicSourceProm, map[string]int64{}, nodes) {
+		t.Errorf("net capacity should not change")
+	}
+}
+
+func TestService_SyncNodeLabels(t *testing.T) {
+	prom := fakeprom.New()
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 100000, "node2": 600000, "node3": 1200000})
+	prom.SetConst(fakeprom.DiskWritten, map[string]float64{"node1": 1024, "node2": 2048, "node3": 4096})
+	prom.SetConst(fakeprom.CPUSeconds, map[string]float64{"node1": 0.2, "node2": 0.4, "node3": 0.6})
+	prom.SetConst(fakeprom.MemAvailable, map[string]float64{"node1": 0.3, "node2": 0.5, "node3": 0.7})
+	prom.SetLabeledSeries(fakeprom.NodeLabels,
+		promModel.Metric{"node": "node1", "label_liang_io_nic_speed": "5000"}, fakeprom.Const(1))
+	prom.SetLabeledSeries(fakeprom.NodeLabels,
+		promModel.Metric{"node": "node2", "label_liang_io_nic_speed": "fast", "label_liang_io_disk_type": "ssd"}, fakeprom.Const(1))
+	prom.SetLabeledSeries(fakeprom.NodeAnnotations,
+		promModel.Metric{"node": "node1", "annotation_liang_io_nic_speed": "10000"}, fakeprom.Const(1))
+	// 节点标签在首次同步时读取，与kube-scheduler是否传递节点对象无关
+	s := newFakePromService(t, prom, false)
+
+	// 注解优先于标签，不合法的值被忽略
+	expected := map[string]int64{"node1": 10000 * model.KbitPS, "node2": 1500 * model.KbitPS, "node3": 2500 * model.KbitPS}
+	if !reflect.DeepEqual(s.netBw(), expected) {
+		t.Errorf("net capacity should be %v, but get %v", expected, s.netBw())
+	}
+	if c := s.diskCapacity([]string{"node2"})["node2"]; c != (model.DiskCapacity{Throughput: 500 * model.MByte, IOPS: 80000}) {
+		t.Errorf("disk capacity of node2 should come from its disk type label, but get %+v", c)
+	}
+
+	hash := s.currentConfigHash()
+	prom.SetLabeledSeries(fakeprom.NodeLabels,
+		promModel.Metric{"node": "node3", "label_liang_io_nic_speed": "8000"}, fakeprom.Const(1))
+	if err := s.SyncNodeLabels(); err != nil {
+		t.Fatalf("sync node labels error: %v", err)
+	}
+	if v := s.netBw()["node3"]; v != 8000*model.KbitPS {
+		t.Errorf("net capacity of node3 should be updated by label, but get %d", v)
+	}
+	if s.currentConfigHash() == hash {
+		t.Errorf("config hash should change with net capacity")
+	}
+}
+
+func TestNICCapacity_Directional(t *testing.T) {
+	static := map[string]int64{"node1": 1000 * model.KbitPS, "node2": 1000 * model.KbitPS}
+	staticDir := map[string]model.NetCapacity{"node1": {Out: 100 * model.KbitPS}}
+	nc, err := newNICCapacity([]string{"node1", "node2"}, static, staticDir,
+		defaultNICCapacityMinMbps*model.KbitPS, defaultNICCapacityMaxMbps*model.KbitPS)
+	if err != nil {
+		t.Fatalf("new nic capacity error: %v", err)
+	}
+
+	// 没有设置的方向使用网卡速度
+	expected := map[string]model.NetCapacity{
+		"node1": {In: 1000 * model.KbitPS, Out: 100 * model.KbitPS},
+		"node2": {In: 1000 * model.KbitPS, Out: 1000 * model.KbitPS},
+	}
+	if !reflect.DeepEqual(nc.directional(), expected) {
+		t.Errorf("directional net capacity should be %v, but get %v", expected, nc.directional())
+	}
+
+	// 网卡速度变化时跟随变化，标签优先于静态配置，不合法的方向被忽略
+	nc.update(nicSourceProm, map[string]int64{"node2": 10000 * model.KbitPS}, []string{"node1", "node2"})
+	changed := nc.updateDirections(map[string]model.NetCapacity{
+		"node1": {Out: 200 * model.KbitPS},
+		"node2": {In: 1, Out: 500 * model.KbitPS},
+	}, []string{"node1", "node2"})
+	if !changed {
+		t.Errorf("directional net capacity should change")
+	}
+	expected = map[string]model.NetCapacity{
+		"node1": {In: 1000 * model.KbitPS, Out: 200 * model.KbitPS},
+		"node2": {In: 10000 * model.KbitPS, Out: 500 * model.KbitPS},
+	}
+	if !reflect.DeepEqual(nc.directional(), expected) {
+		t.Errorf("directional net capacity should be %v, but get %v", expected, nc.directional())
+	}
+	if nc.updateDirections(map[string]model.NetCapacity{
+		"node1": {Out: 200 * model.KbitPS},
+		"node2": {Out: 500 * model.KbitPS},
+	}, []string{"node1", "node2"}) {
+		t.Errorf("directional net capacity should not change")
+	}
+}
+
+func TestService_InvalidStaticNICCapacity(t *testing.T) {
+	d, dcf, err := dao.NewWithConfig(&dao.Config{LocalCacheExpire: 300})
+	if err != nil {
+		t.Fatalf("new dao error: %v", err)
+	}
+	defer dcf()
+
+	for _, values := range []string{"[1000.0, 0.0]", "[1000.0, 10000000.0]", "[1000.0, 1000.0]\nnetbwOutMapValues = [1.0, 0.0]", "[1000.0, 1000.0]\nnetbwInMapValues = [100.0]"} {
+		ac := &paladin.TOML{}
+		if err = ac.Set(`
+netbwMapKeys = ["node1", "node2"]
+netbwMapValues = ` + values + `
+syncStatusInterval = "0 0 0 1 1 ?"
+maxSnapshotAge = "60s"
+livenessTimeout = "120s"
+topsisMin = false
+useBNP = false
+dryrun = true
+`); err != nil {
+			t.Fatalf("set config error: %v", err)
+		}
+		if _, _, err = NewWithConfig(d, ac); err == nil {
+			t.Errorf("net capacity %s should be rejected", values)
+		}
+	}
+}
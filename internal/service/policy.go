@@ -0,0 +1,253 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/log"
+	v1 "k8s.io/api/core/v1"
+)
+
+const defaultPolicyInterval = "0 */5 * * * ?"
+
+// PolicyConfig 按Pod选择调度策略的配置，对应application.toml中的[policy]
+// 依次使用Pod注解liang.io/policy、命名空间标签NamespaceLabel、Pod的PriorityClass在PriorityClasses中对应的策略和Default
+// 指定的策略不存在时跳过该来源，名称cluster表示按useBNP(或自动选择的算法)、topsisMin和[bnpBalance]得到的策略，Default为空时使用cluster
+type PolicyConfig struct {
+	Default         string
+	NamespaceLabel  string                  // 命名空间上指定策略的标签，为空时不按命名空间选择
+	Interval        string                  // 刷新命名空间标签的cron表达式
+	PriorityClasses map[string]string       // PriorityClass名称到策略名称
+	Policies        map[string]model.Policy // 按名称定义的策略
+}
+
+// DefaultPolicyConfig 没有配置[policy]时所有Pod都使用cluster策略
+func DefaultPolicyConfig() PolicyConfig {
+	return PolicyConfig{
+		NamespaceLabel: model.AnnotationPolicy,
+		Interval:       defaultPolicyInterval,
+	}
+}
+
+// normalize 策略没有配置mode时为balance，多资源均衡没有配置mode时为weighted，组合评分的默认值见normalizeEnsemble
+func (cfg *PolicyConfig) normalize() {
+	for name, p := range cfg.Policies {
+		if p.Mode == "" {
+			p.Mode = model.PolicyModeBalance
+		}
+		if p.Balance != nil && p.Balance.Mode == "" {
+			balance := *p.Balance
+			balance.Mode = model.BalanceWeighted
+			p.Balance = &balance
+		}
+		p.Ensemble = normalizeEnsemble(p.Ensemble)
+		cfg.Policies[name] = p
+	}
+}
+
+// Validate 检查每个策略的算法、模式和多资源均衡配置，以及引用的策略是否存在
+func (cfg PolicyConfig) Validate() error {
+	for name, p := range cfg.Policies {
+		if name == model.PolicyCluster {
+			return fmt.Errorf("policy name %s is reserved", name)
+		}
+		switch p.Algorithm {
+		case model.AlgoBNP, model.AlgoCMDN:
+		case model.AlgoEnsemble:
+			if err := ValidateEnsembleConfig(p.Ensemble); err != nil {
+				return fmt.Errorf("policy %s: %v", name, err)
+			}
+		default:
+			return fmt.Errorf("algorithm %s of policy %s should be one of bnp/cmdn/ensemble", p.Algorithm, name)
+		}
+		if p.Mode != model.PolicyModeBalance && p.Mode != model.PolicyModeCompact {
+			return fmt.Errorf("mode %s of policy %s should be one of balance/compact", p.Mode, name)
+		}
+		if p.Algorithm == model.AlgoBNP && p.Mode == model.PolicyModeCompact {
+			return fmt.Errorf("policy %s: bnp only supports balance mode", name)
+		}
+		if p.Balance != nil {
+			if err := ValidateBalanceConfig(*p.Balance); err != nil {
+				return fmt.Errorf("policy %s: %v", name, err)
+			}
+		}
+		if err := ValidateCMDNWeights(p.CMDNWeights); err != nil {
+			return fmt.Errorf("policy %s: %v", name, err)
+		}
+	}
+	if !cfg.exist(cfg.Default) {
+		return fmt.Errorf("default policy %s does not exist", cfg.Default)
+	}
+	for class, name := range cfg.PriorityClasses {
+		if name == "" || !cfg.exist(name) {
+			return fmt.Errorf("policy %s of priority class %s does not exist", name, class)
+		}
+	}
+
+	return nil
+}
+
+// exist 策略是否存在，空名称和cluster总是存在
+func (cfg PolicyConfig) exist(name string) bool {
+	_, ok := cfg.Policies[name]
+	return ok || name == "" || name == model.PolicyCluster
+}
+
+// policyState 最近一次从Prometheus同步的每个命名空间上指定的策略名称
+type policyState struct {
+	mu         sync.RWMutex
+	namespaces map[string]string
+}
+
+// legacyPolicy 按topsisMin和[bnpBalance]得到的使用algo的策略
+func (s *Service) legacyPolicy(algo string) *model.PolicyResolution {
+	p := &model.PolicyResolution{
+		Name:      model.PolicyCluster,
+		Source:    model.PolicySourceDefault,
+		Algorithm: algo,
+		Mode:      model.PolicyModeCompact,
+		Balance:   s.balance,
+	}
+	if s.topsisMin || algo == model.AlgoBNP {
+		p.Mode = model.PolicyModeBalance
+	}
+
+	return p
+}
+
+// namedPolicy 名称为name的策略，不存在时返回nil
+func (s *Service) namedPolicy(name, source string) *model.PolicyResolution {
+	p, ok := s.policyCfg.Policies[name]
+	if !ok {
+		if name != model.PolicyCluster {
+			return nil
+		}
+		res := s.legacyPolicy(s.clusterAlgorithm())
+		res.Source = source
+		return res
+	}
+
+	res := &model.PolicyResolution{
+		Name:        name,
+		Source:      source,
+		Algorithm:   p.Algorithm,
+		Mode:        p.Mode,
+		Balance:     s.balance,
+		Ensemble:    p.Ensemble,
+		CMDNWeights: p.CMDNWeights,
+	}
+	if p.Balance != nil {
+		res.Balance = *p.Balance
+	}
+
+	return res
+}
+
+// ResolvePolicy 按Pod注解、命名空间标签、PriorityClass和集群默认策略的顺序为Pod选择调度策略
+// 开启A/B实验时，得到对照组策略的Pod再按哈希分组
+func (s *Service) ResolvePolicy(pod *v1.Pod) *model.PolicyResolution {
+	return s.assignExperiment(pod, s.resolvePolicy(pod))
+}
+
+func (s *Service) resolvePolicy(pod *v1.Pod) *model.PolicyResolution {
+	if pod != nil {
+		candidates := []struct {
+			Name   string
+			Source string
+		}{
+			{Name: pod.Annotations[model.AnnotationPolicy], Source: model.PolicySourceAnnotation},
+			{Name: s.namespacePolicy(pod.Namespace), Source: model.PolicySourceNamespace},
+			{Name: s.policyCfg.PriorityClasses[pod.Spec.PriorityClassName], Source: model.PolicySourcePriorityClass},
+		}
+		for _, c := range candidates {
+			if c.Name == "" {
+				continue
+			}
+			if p := s.namedPolicy(c.Name, c.Source); p != nil {
+				return p
+			}
+			log.Warn("policy %s from %s of pod %s/%s does not exist, skip", c.Name, c.Source, pod.Namespace, pod.Name)
+		}
+	}
+
+	name := s.policyCfg.Default
+	if name == "" {
+		name = model.PolicyCluster
+	}
+
+	return s.namedPolicy(name, model.PolicySourceDefault)
+}
+
+// allPolicies 可能被选择的全部策略，用于确定需要同步的指标和是否预先计算cmdn评分数据
+func (s *Service) allPolicies() []*model.PolicyResolution {
+	names := make([]string, 0, len(s.policyCfg.Policies))
+	for name := range s.policyCfg.Policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := []*model.PolicyResolution{s.namedPolicy(model.PolicyCluster, model.PolicySourceDefault)}
+	for _, name := range names {
+		res = append(res, s.namedPolicy(name, model.PolicySourceDefault))
+	}
+	// 自动选择算法时cluster策略可能使用任意一种算法
+	if s.autoCfg.Enabled {
+		res = append(res, s.legacyPolicy(model.AlgoBNP), s.legacyPolicy(model.AlgoCMDN))
+	}
+
+	return res
+}
+
+// usesAlgorithm 是否有策略使用algo，包括组合评分中的算法
+func (s *Service) usesAlgorithm(algo string) bool {
+	for _, p := range s.allPolicies() {
+		if p.Algorithm == algo || ensembleUses(p.Ensemble, algo) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// syncNetIOOnly 所有策略都只使用网络负载时，定时同步只需要同步网络负载
+func (s *Service) syncNetIOOnly() bool {
+	for _, p := range s.allPolicies() {
+		if p.Algorithm != model.AlgoBNP || p.Balance.MultiDim() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// namespacePolicy 命名空间上指定的策略名称
+func (s *Service) namespacePolicy(namespace string) string {
+	s.policy.mu.RLock()
+	defer s.policy.mu.RUnlock()
+
+	return s.policy.namespaces[namespace]
+}
+
+// syncNamespacePolicyEnabled 配置了策略并且指定了命名空间标签时才需要同步命名空间标签
+func (s *Service) syncNamespacePolicyEnabled() bool {
+	return !s.dryrun && len(s.policyCfg.Policies) > 0 && s.policyCfg.NamespaceLabel != ""
+}
+
+// SyncNamespacePolicies 从Prometheus同步每个命名空间上指定的策略，失败时保留上一次的结果
+func (s *Service) SyncNamespacePolicies() error {
+	namespaces, err := s.dao.RequestPromNamespaceLabel(s.policyCfg.NamespaceLabel)
+	if err != nil {
+		log.Error("sync namespace label %s error: %v", s.policyCfg.NamespaceLabel, err)
+		return err
+	}
+
+	s.policy.mu.Lock()
+	s.policy.namespaces = namespaces
+	s.policy.mu.Unlock()
+	log.V(3).Info("policies of namespaces are %v", namespaces)
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"liang/internal/fakeprom"
+	"liang/internal/model"
+
+	promModel "github.com/prometheus/common/model"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func TestPolicyConfig_Validate(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Modify func(cfg *PolicyConfig)
+		Valid  bool
+	}{
+		{Name: "default", Modify: func(cfg *PolicyConfig) {}, Valid: true},
+		{Name: "cluster as default", Modify: func(cfg *PolicyConfig) { cfg.Default = model.PolicyCluster }, Valid: true},
+		{Name: "unknown default", Modify: func(cfg *PolicyConfig) { cfg.Default = "spread" }},
+		{Name: "unknown priority class policy", Modify: func(cfg *PolicyConfig) { cfg.PriorityClasses = map[string]string{"low": "pack"} }},
+		{Name: "reserved name", Modify: func(cfg *PolicyConfig) {
+			cfg.Policies = map[string]model.Policy{model.PolicyCluster: {Algorithm: model.AlgoBNP}}
+		}},
+		{Name: "unknown algorithm", Modify: func(cfg *PolicyConfig) {
+			cfg.Policies = map[string]model.Policy{"pack": {Algorithm: "topsis"}}
+		}},
+		{Name: "compact bnp", Modify: func(cfg *PolicyConfig) {
+			cfg.Policies = map[string]model.Policy{"pack": {Algorithm: model.AlgoBNP, Mode: model.PolicyModeCompact}}
+		}},
+		{Name: "invalid balance", Modify: func(cfg *PolicyConfig) {
+			cfg.Policies = map[string]model.Policy{"spread": {Algorithm: model.AlgoBNP, Balance: &model.BalanceConfig{Weights: map[string]float64{"GPU": 1}}}}
+		}},
+		{Name: "unknown cmdn weight", Modify: func(cfg *PolicyConfig) {
+			cfg.Policies = map[string]model.Policy{"pack": {Algorithm: model.AlgoCMDN, CMDNWeights: map[string]float64{"GPU": 1}}}
+		}},
+		{Name: "negative cmdn weight", Modify: func(cfg *PolicyConfig) {
+			cfg.Policies = map[string]model.Policy{"pack": {Algorithm: model.AlgoCMDN, CMDNWeights: map[string]float64{model.ResourceCPUKey: -1}}}
+		}},
+		{Name: "policies", Modify: func(cfg *PolicyConfig) {
+			cfg.Policies = map[string]model.Policy{
+				"pack":   {Algorithm: model.AlgoCMDN, Mode: model.PolicyModeCompact, CMDNWeights: map[string]float64{model.ResourceNetIOKey: 3}},
+				"spread": {Algorithm: model.AlgoBNP, Balance: &model.BalanceConfig{Weights: map[string]float64{model.ResourceCPUKey: 1}}},
+			}
+			cfg.Default = "spread"
+			cfg.PriorityClasses = map[string]string{"low": "pack"}
+		}, Valid: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			cfg := DefaultPolicyConfig()
+			c.Modify(&cfg)
+			cfg.normalize()
+			if err := cfg.Validate(); (err == nil) != c.Valid {
+				t.Errorf("valid should be %v, but get %v", c.Valid, err)
+			}
+		})
+	}
+}
+
+func TestService_ResolvePolicy(t *testing.T) {
+	prom := fakeprom.New()
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 100000, "node2": 600000, "node3": 1200000})
+	prom.SetConst(fakeprom.DiskWritten, map[string]float64{"node1": 1024, "node2": 2048, "node3": 4096})
+	prom.SetConst(fakeprom.CPUSeconds, map[string]float64{"node1": 0.2, "node2": 0.4, "node3": 0.6})
+	prom.SetConst(fakeprom.MemAvailable, map[string]float64{"node1": 0.3, "node2": 0.5, "node3": 0.7})
+	prom.SetLabeledSeries(fakeprom.NamespaceLabels,
+		promModel.Metric{"namespace": "batch", "label_liang_io_policy": "pack"}, fakeprom.Const(1))
+	s := newFakePromService(t, prom, false, `
+[policy]
+default = "spread"
+
+[policy.priorityClasses]
+best-effort = "pack"
+
+[policy.policies.pack]
+algorithm = "cmdn"
+mode = "compact"
+
+[policy.policies.pack.cmdnWeights]
+LiangNetIO = 2.0
+
+[policy.policies.spread]
+algorithm = "bnp"
+
+[policy.policies.spread.balance]
+[policy.policies.spread.balance.weights]
+LiangNetIO = 1.0
+LiangCPU = 1.0
+`)
+
+	pod := func(namespace, priorityClass string, annotations map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: namespace, Annotations: annotations},
+			Spec:       v1.PodSpec{PriorityClassName: priorityClass},
+		}
+	}
+	cases := []struct {
+		Name      string
+		Pod       *v1.Pod
+		Policy    string
+		Source    string
+		Algorithm string
+	}{
+		{Name: "annotation", Pod: pod("batch", "best-effort", map[string]string{model.AnnotationPolicy: "cluster"}),
+			Policy: model.PolicyCluster, Source: model.PolicySourceAnnotation, Algorithm: model.AlgoCMDN},
+		{Name: "unknown annotation", Pod: pod("batch", "", map[string]string{model.AnnotationPolicy: "fast"}),
+			Policy: "pack", Source: model.PolicySourceNamespace, Algorithm: model.AlgoCMDN},
+		{Name: "priority class", Pod: pod("default", "best-effort", nil),
+			Policy: "pack", Source: model.PolicySourcePriorityClass, Algorithm: model.AlgoCMDN},
+		{Name: "default", Pod: pod("default", "", nil),
+			Policy: "spread", Source: model.PolicySourceDefault, Algorithm: model.AlgoBNP},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			p := s.ResolvePolicy(c.Pod)
+			if p.Name != c.Policy || p.Source != c.Source || p.Algorithm != c.Algorithm {
+				t.Errorf("policy should be %s from %s with %s, but get %+v", c.Policy, c.Source, c.Algorithm, p)
+			}
+		})
+	}
+
+	// 集群策略的topsisMin为true，pack为compact，两者的cmdn得分相反
+	if p := s.ResolvePolicy(cases[0].Pod); !p.TopsisMin() || s.ResolvePolicy(cases[2].Pod).TopsisMin() {
+		t.Errorf("cluster policy should balance and pack should be compact")
+	}
+	pack := s.policyScoreInput(s.ResolvePolicy(cases[2].Pod), newPrioritizeArgs("0"), nil)
+	if !reflect.DeepEqual(pack.CMDNWeights, map[string]float64{model.ResourceNetIOKey: 2}) {
+		t.Errorf("pack should use its own cmdn weights, but get %v", pack.CMDNWeights)
+	}
+	if p := s.ResolvePolicy(cases[3].Pod); !p.Balance.MultiDim() {
+		t.Errorf("spread should use its own balance weights, but get %+v", p.Balance)
+	}
+	if s.syncNetIOOnly() || !s.usesAlgorithm(model.AlgoCMDN) {
+		t.Errorf("all metrics should be synced when any policy uses cmdn")
+	}
+
+	nodeNames := []string{"node1", "node2", "node3"}
+	for _, c := range cases[2:] {
+		args := &extenderv1.ExtenderArgs{Pod: c.Pod, NodeNames: &nodeNames}
+		if _, err := s.Prioritize(args); err != nil {
+			t.Fatalf("prioritize error: %v", err)
+		}
+		explain, err := s.Explain(args)
+		if err != nil {
+			t.Fatalf("explain error: %v", err)
+		}
+		if explain.Algorithm != c.Algorithm || explain.Policy == nil || explain.Policy.Name != c.Policy {
+			t.Errorf("explanation should use policy %s, but get %s %+v", c.Policy, explain.Algorithm, explain.Policy)
+		}
+	}
+	s.records.flush()
+	decisions, err := s.QueryDecisions(&model.DecisionFilter{Namespace: "default"})
+	if err != nil || len(decisions) != 2 {
+		t.Fatalf("should record 2 decisions, but get %v %v", decisions, err)
+	}
+	for _, d := range decisions {
+		if d.Policy == "" || d.PolicySource == "" || (d.Policy == "pack") != (d.Algorithm == model.AlgoCMDN) {
+			t.Errorf("decision should record the policy, but get %+v", d)
+		}
+	}
+}
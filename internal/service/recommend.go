@@ -0,0 +1,10 @@
+package service
+
+import (
+	"liang/internal/recommend"
+)
+
+// Recommend 根据Prometheus中Deployment和StatefulSet的历史负载推荐LiangNetIO和LiangDiskIO注解，与评分无关
+func (s *Service) Recommend(opts recommend.Options) (*recommend.Report, error) {
+	return recommend.Analyze(s.dao, opts)
+}
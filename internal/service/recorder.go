@@ -0,0 +1,140 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kratos/kratos/pkg/log"
+)
+
+// recordQueueSize 后台记录队列的长度
+const recordQueueSize = 1024
+
+// recordSubmitWait 审计记录队列满时最多等待的时间，超时后在调用方直接写入，审计记录不会被丢弃
+const recordSubmitWait = 100 * time.Millisecond
+
+// recorder 在后台按顺序执行任务，用于写入审计记录和录制数据以及影子评分，不占用调度请求的时间
+// wait为0时队列满直接丢弃任务；不为0时最多等待wait，仍然满时在调用方执行，任务不会丢失
+// 为nil时在调用方直接执行，便于只构造部分字段的Service使用
+type recorder struct {
+	name    string // 用于日志的队列名称
+	wait    time.Duration
+	jobs    chan func()
+	stop    chan struct{}
+	pending sync.WaitGroup
+	dropped int64
+	inline  int64 // 队列满或者已经关闭时在调用方执行的任务数量
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newRecorder(name string, size int, wait time.Duration) *recorder {
+	r := &recorder{
+		name: name,
+		wait: wait,
+		jobs: make(chan func(), size),
+		stop: make(chan struct{}),
+	}
+	go r.run()
+
+	return r
+}
+
+func (r *recorder) run() {
+	for {
+		select {
+		case job := <-r.jobs:
+			job()
+			r.pending.Done()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// submit 把job放入队列，返回false表示job被丢弃
+// 不丢弃任务的队列在满了wait之后或者关闭之后在调用方执行job
+func (r *recorder) submit(job func()) bool {
+	if r == nil {
+		job()
+		return true
+	}
+
+	r.mu.RLock()
+	if r.closed {
+		r.mu.RUnlock()
+		return r.overflow(job)
+	}
+	r.pending.Add(1)
+	select {
+	case r.jobs <- job:
+		r.mu.RUnlock()
+		return true
+	default:
+	}
+	if r.wait > 0 {
+		timer := time.NewTimer(r.wait)
+		defer timer.Stop()
+		select {
+		case r.jobs <- job:
+			r.mu.RUnlock()
+			return true
+		case <-timer.C:
+		}
+	}
+	r.pending.Done()
+	r.mu.RUnlock()
+
+	return r.overflow(job)
+}
+
+// overflow 队列满或者已经关闭时的处理，不丢弃任务的队列在调用方执行，否则丢弃
+func (r *recorder) overflow(job func()) bool {
+	if r.wait > 0 {
+		if n := atomic.AddInt64(&r.inline, 1); n%100 == 1 {
+			log.Warn("%s queue is full or closed, %d jobs run by the caller", r.name, n)
+		}
+		job()
+		return true
+	}
+	if n := atomic.AddInt64(&r.dropped, 1); n%100 == 1 {
+		log.Warn("%s queue is full, %d jobs dropped", r.name, n)
+	}
+
+	return false
+}
+
+// droppedCount 队列满时丢弃的任务数量
+func (r *recorder) droppedCount() int64 {
+	if r == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&r.dropped)
+}
+
+// flush 等待队列中的记录全部写入
+func (r *recorder) flush() {
+	if r != nil {
+		r.pending.Wait()
+	}
+}
+
+// close 不再接收新的任务，执行完队列中剩余的任务后停止后台写入
+func (r *recorder) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	r.mu.Unlock()
+
+	r.pending.Wait()
+	close(r.stop)
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecorder_Submit(t *testing.T) {
+	// 不启动后台写入，队列满后丢弃
+	r := &recorder{jobs: make(chan func(), 2), stop: make(chan struct{})}
+	done := 0
+	for i := 0; i < 3; i++ {
+		if ok := r.submit(func() { done++ }); ok != (i < 2) {
+			t.Errorf("submit %d should return %v", i, i < 2)
+		}
+	}
+	if r.dropped != 1 {
+		t.Errorf("should drop 1 record, but get %d", r.dropped)
+	}
+
+	go r.run()
+	r.flush()
+	r.close()
+	if done != 2 {
+		t.Errorf("queued records should be written, but get %d", done)
+	}
+
+	// 关闭后丢弃任务的队列不再接收
+	if r.submit(func() { done++ }) || done != 2 {
+		t.Errorf("closed recorder should drop jobs")
+	}
+
+	// nil recorder直接执行
+	var nilRecorder *recorder
+	nilRecorder.submit(func() { done++ })
+	if done != 3 {
+		t.Errorf("nil recorder should run the record directly")
+	}
+}
+
+func TestRecorder_Lossless(t *testing.T) {
+	// 不启动后台写入，队列满等待超时后在调用方执行
+	r := &recorder{wait: time.Millisecond, jobs: make(chan func(), 1), stop: make(chan struct{})}
+	var done []int
+	for i := 0; i < 3; i++ {
+		i := i
+		if !r.submit(func() { done = append(done, i) }) {
+			t.Errorf("submit %d should not drop the job", i)
+		}
+	}
+	if len(done) != 2 || r.dropped != 0 || r.inline != 2 {
+		t.Fatalf("full queue should run jobs by the caller, but get %v", done)
+	}
+
+	// 关闭时执行完队列中的任务，关闭后的任务在调用方执行
+	go r.run()
+	r.close()
+	if len(done) != 3 || done[2] != 0 {
+		t.Fatalf("queued job should run before close returns, but get %v", done)
+	}
+	r.submit(func() { done = append(done, 3) })
+	if len(done) != 4 {
+		t.Errorf("job after close should run by the caller, but get %v", done)
+	}
+}
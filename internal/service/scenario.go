@@ -0,0 +1,152 @@
+package service
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+
+	"liang/internal/model"
+)
+
+// ResourcePattern 单个资源在dryrun场景中的负载模式，使用率为0-1的比例
+// 第step次同步时节点的使用率为：
+// Base + Diurnal*sin(2π*step/StepsPerDay + 节点相位) + Drift*step + 噪声 + 尖峰 + 热点负载
+type ResourcePattern struct {
+	Base      float64 // 基础使用率
+	Diurnal   float64 // 日周期波动的幅度
+	Drift     float64 // 每次同步的缓慢漂移量，可以为负
+	Noise     float64 // 高斯噪声的标准差
+	SpikeProb float64 // 每次同步每个节点出现尖峰的概率
+	SpikeSize float64 // 尖峰增加的使用率
+	Hotspot   float64 // 热点节点额外增加的使用率
+}
+
+// ScenarioConfig dryrun场景配置，对应application.toml中的[dryrunScenario]
+// 相同的Seed和节点列表总是生成相同的数据序列
+type ScenarioConfig struct {
+	Seed         int64
+	StepsPerDay  int      // 一个日周期包含的同步次数
+	Hotspots     []string // 热点节点
+	DiskCapacity float64  // 节点没有磁盘吞吐能力时，磁盘使用率为1对应的磁盘IO，单位B/s
+	CPU          ResourcePattern
+	Mem          ResourcePattern
+	Net          ResourcePattern // 下行使用率，相对于节点下行带宽
+	NetOut       ResourcePattern // 上行使用率，相对于节点上行带宽
+	Disk         ResourcePattern
+}
+
+// DefaultScenarioConfig 没有配置[dryrunScenario]时使用的场景
+func DefaultScenarioConfig() ScenarioConfig {
+	return ScenarioConfig{
+		Seed:         1,
+		StepsPerDay:  360,
+		DiskCapacity: 10000,
+		CPU:          ResourcePattern{Base: 0.4, Diurnal: 0.2, Noise: 0.03, SpikeProb: 0.05, SpikeSize: 0.2, Hotspot: 0.2},
+		Mem:          ResourcePattern{Base: 0.45, Diurnal: 0.1, Drift: 0.0005, Noise: 0.02, Hotspot: 0.15},
+		Net:          ResourcePattern{Base: 0.3, Diurnal: 0.2, Noise: 0.03, SpikeProb: 0.05, SpikeSize: 0.3, Hotspot: 0.3},
+		NetOut:       ResourcePattern{Base: 0.2, Diurnal: 0.15, Noise: 0.03, SpikeProb: 0.05, SpikeSize: 0.3, Hotspot: 0.1},
+		Disk:         ResourcePattern{Base: 0.3, Diurnal: 0.1, Noise: 0.05, SpikeProb: 0.02, SpikeSize: 0.4},
+	}
+}
+
+// scenario 根据ScenarioConfig为每个节点生成指标数据
+type scenario struct {
+	mu       sync.Mutex
+	cfg      ScenarioConfig
+	nodes    []string
+	netCap   map[string]model.NetCapacity
+	diskCap  map[string]model.DiskCapacity
+	hotspots map[string]bool
+	phases   map[string]float64 // 每个节点的日周期相位，错开各节点的高峰
+	rnd      *rand.Rand
+	step     int
+}
+
+func newScenario(cfg ScenarioConfig, nodes []string, netCap map[string]model.NetCapacity, diskCap map[string]model.DiskCapacity) *scenario {
+	if cfg.StepsPerDay <= 0 {
+		cfg.StepsPerDay = DefaultScenarioConfig().StepsPerDay
+	}
+	sc := &scenario{
+		cfg:      cfg,
+		nodes:    nodes,
+		netCap:   netCap,
+		diskCap:  diskCap,
+		hotspots: make(map[string]bool),
+		phases:   make(map[string]float64),
+		rnd:      rand.New(rand.NewSource(cfg.Seed)),
+	}
+	for _, name := range cfg.Hotspots {
+		sc.hotspots[name] = true
+	}
+	for _, name := range nodes {
+		sc.phases[name] = sc.rnd.Float64() * 2 * math.Pi
+	}
+
+	return sc
+}
+
+// usage 计算节点当前的使用率，调用顺序固定，因此序列可以复现
+func (sc *scenario) usage(p ResourcePattern, node string) float64 {
+	x := 2*math.Pi*float64(sc.step)/float64(sc.cfg.StepsPerDay) + sc.phases[node]
+	u := p.Base + p.Diurnal*math.Sin(x) + p.Drift*float64(sc.step)
+	u += sc.rnd.NormFloat64() * p.Noise
+	if spike := sc.rnd.Float64(); spike < p.SpikeProb {
+		u += p.SpikeSize
+	}
+	if sc.hotspots[node] {
+		u += p.Hotspot
+	}
+
+	return u
+}
+
+// clamp 将使用率限制在[0, upper]
+func clamp(u, upper float64) float64 {
+	return math.Max(0, math.Min(u, upper))
+}
+
+// Next 生成下一次同步的数据，CPU和内存为百分比且不超过UsageUpperLimit，
+// 网络为Kbit/s且不超过对应方向的带宽，LiangNetIO与同步时一样取上下行负载的较大值，磁盘为B/s且不超过节点的磁盘吞吐能力
+func (sc *scenario) Next() map[string](map[string]int64) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	upper := float64(model.UsageUpperLimit) / 100
+	cpuMap := make(map[string]int64, len(sc.nodes))
+	memMap := make(map[string]int64, len(sc.nodes))
+	netMap := make(map[string]int64, len(sc.nodes))
+	netOutMap := make(map[string]int64, len(sc.nodes))
+	netIOMap := make(map[string]int64, len(sc.nodes))
+	diskMap := make(map[string]int64, len(sc.nodes))
+	iopsMap := make(map[string]int64, len(sc.nodes))
+	for _, name := range sc.nodes {
+		cpuMap[name] = int64(math.Round(clamp(sc.usage(sc.cfg.CPU, name), upper) * 100))
+		memMap[name] = int64(math.Round(clamp(sc.usage(sc.cfg.Mem, name), upper) * 100))
+		netMap[name] = int64(clamp(sc.usage(sc.cfg.Net, name), 1) * float64(sc.netCap[name].In))
+		netOutMap[name] = int64(clamp(sc.usage(sc.cfg.NetOut, name), 1) * float64(sc.netCap[name].Out))
+		netIOMap[name] = netMap[name]
+		if netOutMap[name] > netIOMap[name] {
+			netIOMap[name] = netOutMap[name]
+		}
+
+		// 吞吐和IOPS使用相同的磁盘使用率
+		diskUsage := clamp(sc.usage(sc.cfg.Disk, name), 1)
+		diskCap := sc.cfg.DiskCapacity
+		if c := sc.diskCap[name]; c.Throughput > 0 {
+			diskCap = float64(c.Throughput)
+		}
+		diskMap[name] = int64(diskUsage * diskCap)
+		iopsMap[name] = int64(diskUsage * float64(sc.diskCap[name].IOPS))
+	}
+	sc.step++
+
+	return map[string](map[string]int64){
+		model.ResourceCPUKey:      cpuMap,
+		model.ResourceMemKey:      memMap,
+		model.ResourceNetIOKey:    netIOMap,
+		model.ResourceNetInKey:    netMap,
+		model.ResourceNetOutKey:   netOutMap,
+		model.ResourceDiskIOKey:   diskMap,
+		model.ResourceDiskIOPSKey: iopsMap,
+	}
+}
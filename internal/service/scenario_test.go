@@ -0,0 +1,188 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"liang/internal/dao"
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/conf/paladin"
+)
+
+var scenarioNetBwMap = map[string]int64{
+	"node1": 1000 * model.KbitPS,
+	"node2": 1500 * model.KbitPS,
+	"node3": 2500 * model.KbitPS,
+}
+
+// scenarioNetCap 上行带宽为下行带宽的一半
+func scenarioNetCap() map[string]model.NetCapacity {
+	res := make(map[string]model.NetCapacity, len(scenarioNetBwMap))
+	for name, v := range scenarioNetBwMap {
+		res[name] = model.NetCapacity{In: v, Out: v / 2}
+	}
+
+	return res
+}
+
+func TestScenario_Reproducible(t *testing.T) {
+	nodes := []string{"node1", "node2", "node3"}
+	sc1 := newScenario(DefaultScenarioConfig(), nodes, scenarioNetCap(), nil)
+	sc2 := newScenario(DefaultScenarioConfig(), nodes, scenarioNetCap(), nil)
+	for i := 0; i < 100; i++ {
+		d1, d2 := sc1.Next(), sc2.Next()
+		if !reflect.DeepEqual(d1, d2) {
+			t.Fatalf("step %d: same seed should generate same data, %v != %v", i, d1, d2)
+		}
+	}
+
+	cfg := DefaultScenarioConfig()
+	cfg.Seed = 2
+	sc3 := newScenario(cfg, nodes, scenarioNetCap(), nil)
+	if reflect.DeepEqual(sc1.Next(), sc3.Next()) {
+		t.Errorf("different seed should generate different data")
+	}
+}
+
+func TestScenario_Limits(t *testing.T) {
+	nodes := []string{"node1", "node2", "node3"}
+	cfg := DefaultScenarioConfig()
+	// 极端的尖峰和漂移也不能超过上限
+	cfg.CPU.SpikeProb, cfg.CPU.SpikeSize = 1, 1
+	cfg.Mem.Drift = 0.01
+	cfg.Net.Base = 2
+	cfg.NetOut.Base = 2
+	cfg.Disk.Base = -1
+	sc := newScenario(cfg, nodes, scenarioNetCap(), nil)
+
+	for i := 0; i < 200; i++ {
+		data := sc.Next()
+		for _, name := range nodes {
+			if v := data[model.ResourceCPUKey][name]; v < 0 || v > model.UsageUpperLimit {
+				t.Fatalf("step %d: cpu of %s is %d, should be in [0, %d]", i, name, v, model.UsageUpperLimit)
+			}
+			if v := data[model.ResourceMemKey][name]; v < 0 || v > model.UsageUpperLimit {
+				t.Fatalf("step %d: mem of %s is %d, should be in [0, %d]", i, name, v, model.UsageUpperLimit)
+			}
+			if v := data[model.ResourceNetIOKey][name]; v != scenarioNetBwMap[name] {
+				t.Fatalf("step %d: net of %s is %d, should be capped to %d", i, name, v, scenarioNetBwMap[name])
+			}
+			if v := data[model.ResourceNetOutKey][name]; v != scenarioNetBwMap[name]/2 {
+				t.Fatalf("step %d: upload net of %s is %d, should be capped to %d", i, name, v, scenarioNetBwMap[name]/2)
+			}
+			if v := data[model.ResourceDiskIOKey][name]; v != 0 {
+				t.Fatalf("step %d: disk of %s is %d, should be 0", i, name, v)
+			}
+		}
+	}
+}
+
+func TestScenario_NetIOIsMaxOfDirections(t *testing.T) {
+	nodes := []string{"node1", "node2", "node3"}
+	cfg := ScenarioConfig{
+		Seed:        1,
+		StepsPerDay: 4,
+		Net:         ResourcePattern{Base: 0.1},
+		NetOut:      ResourcePattern{Base: 0.9},
+	}
+	sc := newScenario(cfg, nodes, scenarioNetCap(), nil)
+
+	data := sc.Next()
+	for _, name := range nodes {
+		in, out := data[model.ResourceNetInKey][name], data[model.ResourceNetOutKey][name]
+		if out <= in {
+			t.Fatalf("upload of %s should be busier than download, but get %d/%d", name, out, in)
+		}
+		if v := data[model.ResourceNetIOKey][name]; v != out {
+			t.Errorf("net io of %s should be the larger direction %d, but get %d", name, out, v)
+		}
+	}
+}
+
+func TestScenario_Patterns(t *testing.T) {
+	nodes := []string{"node1", "node2"}
+	cfg := ScenarioConfig{
+		Seed:        1,
+		StepsPerDay: 4,
+		Hotspots:    []string{"node2"},
+		CPU:         ResourcePattern{Base: 0.2, Hotspot: 0.3},
+		Mem:         ResourcePattern{Base: 0.1, Drift: 0.1},
+		Net:         ResourcePattern{Base: 0.5, Diurnal: 0.4},
+	}
+	sc := newScenario(cfg, nodes, scenarioNetCap(), nil)
+
+	var netValues []int64
+	for i := 0; i < 4; i++ {
+		data := sc.Next()
+		if data[model.ResourceCPUKey]["node1"] != 20 || data[model.ResourceCPUKey]["node2"] != 50 {
+			t.Errorf("step %d: hotspot node2 should have 30%% more cpu, but get %v", i, data[model.ResourceCPUKey])
+		}
+		// 漂移：10% 20% 30% 40%
+		if v := data[model.ResourceMemKey]["node1"]; v != int64(10*(i+1)) {
+			t.Errorf("step %d: mem should drift to %d%%, but get %d", i, 10*(i+1), v)
+		}
+		netValues = append(netValues, data[model.ResourceNetIOKey]["node1"])
+	}
+	// 一个日周期内先后经过高峰和低谷
+	min, max := netValues[0], netValues[0]
+	for _, v := range netValues {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	if max-min < scenarioNetBwMap["node1"]/2 {
+		t.Errorf("diurnal pattern should vary net io, but get %v", netValues)
+	}
+}
+
+func TestService_DryrunScenarioConfig(t *testing.T) {
+	ac := &paladin.TOML{}
+	err := ac.Set(`
+netbwMapKeys = ["node-a", "node-b"]
+netbwMapValues = [1000.0, 1000.0]
+syncStatusInterval = "0 0 0 1 1 ?"
+maxSnapshotAge = "60s"
+livenessTimeout = "120s"
+topsisMin = false
+useBNP = false
+dryrun = true
+
+[dryrunScenario]
+seed = 7
+hotspots = ["node-b"]
+
+[dryrunScenario.cpu]
+base = 0.1
+diurnal = 0.0
+noise = 0.0
+spikeProb = 0.0
+hotspot = 0.5
+`)
+	if err != nil {
+		t.Fatalf("set config error: %v", err)
+	}
+	d, dcf, err := dao.NewWithConfig(&dao.Config{LocalCacheExpire: 300})
+	if err != nil {
+		t.Fatalf("new dao error: %v", err)
+	}
+	defer dcf()
+	s, cf, err := NewWithConfig(d, ac)
+	if err != nil {
+		t.Fatalf("new service error: %v", err)
+	}
+	defer cf()
+	<-s.initDone
+
+	if s.scenario.cfg.Seed != 7 || s.scenario.cfg.StepsPerDay != DefaultScenarioConfig().StepsPerDay {
+		t.Errorf("unexpected scenario config %+v", s.scenario.cfg)
+	}
+	cache, _ := s.GetAllCache()
+	expected := map[string]int64{"node-a": 10, "node-b": 60}
+	if !reflect.DeepEqual(cache[model.ResourceCPUKey], expected) {
+		t.Errorf("cpu should be %v, but get %v", expected, cache[model.ResourceCPUKey])
+	}
+}
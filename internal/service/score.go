@@ -1,54 +1,347 @@
 package service
 
 import (
+	"fmt"
+	"time"
+
 	"liang/internal/model"
 
 	"github.com/go-kratos/kratos/pkg/log"
+	v1 "k8s.io/api/core/v1"
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
 )
 
 func (s *Service) Prioritize(args *extenderv1.ExtenderArgs) (*extenderv1.HostPriorityList, error) {
-	if s.useBNP {
+	var (
+		res     *extenderv1.HostPriorityList
+		in      *ScoreInput
+		reasons map[string]string
+		err     error
+	)
+	start := time.Now()
+	policy := s.ResolvePolicy(args.Pod)
+	log.V(3).Info("use policy %s from %s, algorithm %s, mode %s", policy.Name, policy.Source, policy.Algorithm, policy.Mode)
+	if policy.Algorithm == model.AlgoBNP {
 		log.V(3).Info("use bnp algo to score...")
-		return s.bnpScore(args)
+		res, in, reasons, err = s.bnpScore(args, policy)
+	} else if policy.Algorithm == model.AlgoEnsemble {
+		log.V(3).Info("use ensemble of %d algos to score...", len(policy.Ensemble.Components))
+		res, in, reasons, err = s.cmdapScore(args, policy)
+	} else {
+		log.V(3).Info("use cmdn topsis algo to score...")
+		res, in, reasons, err = s.cmdapScore(args, policy)
+	}
+	// 审计记录中的耗时只包含线上策略的评分
+	latency := time.Since(start)
+	if err == nil {
+		s.trackExperiment(args.Pod, policy, start)
+	}
+	// 评分结果返回后不再修改，后台只读取
+	record := func(shadows []model.ShadowResult) {
+		s.records.submit(func() {
+			s.recordDecision(args, in, in.SnapshotVersion, res, reasons, shadows, err, latency)
+			s.capture(args, in, in.SnapshotVersion, res, err)
+		})
+	}
+	// 影子评分在后台使用线上评分的输入，完成后再写入审计记录，队列满时丢弃影子评分
+	// 差异按Prioritize的时间计入时间段，与后台何时执行无关
+	if len(s.shadowCfg.Policies) == 0 {
+		record(nil)
+	} else if !s.shadowWorker.submit(func() { record(s.shadowScore(start, args, in, res, err)) }) {
+		s.dropShadow(start)
+		record(nil)
 	}
 
-	log.V(3).Info("use cmdn topsis algo to score...")
-	return s.cmdapScore(args)
+	return res, err
 }
 
-func (s *Service) bnpScore(args *extenderv1.ExtenderArgs) (*extenderv1.HostPriorityList, error) {
-	curMap, err := s.dao.GetNetIO()
+func (s *Service) bnpScore(args *extenderv1.ExtenderArgs, policy *model.PolicyResolution) (*extenderv1.HostPriorityList, *ScoreInput, map[string]string, error) {
+	var (
+		snapshot map[string](map[string]int64)
+		version  int64
+		err      error
+	)
+	// 影子策略与线上使用同一份快照，影子策略需要其它指标时使用全部指标
+	if s.shadowNeedsAllMetrics() {
+		snapshot, version, err = s.allSnapshot()
+	} else {
+		snapshot, version, err = s.bnpSnapshot(policy.Balance)
+	}
+	curMap := snapshot[model.ResourceNetIOKey]
+	in := s.policyScoreInput(policy, args, snapshot)
+	in.SnapshotVersion = version
 	if err != nil || len(curMap) == 0 {
 		log.Error("Prioritize: get empty curMap %v or run into error: %v",
 			curMap, err)
-		return nil, err
+		return nil, in, nil, err
 	}
 
-	bnp := BalanceNetloadPriority{}
-	res, err := bnp.Score(args.Pod, *args.NodeNames, curMap, s.netBwMap)
+	res, reasons, err := ScoreSnapshot(in)
 	log.V(3).Info("score result of BNP is: %#v", res)
 
-	return &res, err
+	return &res, in, reasons, err
 }
 
-// cmdapScore cmdap算法评分入口
-func (s *Service) cmdapScore(args *extenderv1.ExtenderArgs) (*extenderv1.HostPriorityList, error) {
-	nodeNames := *args.NodeNames
-	cacheData, err := s.GetAllCache()
+// bnpSnapshot bnp使用的指标快照和版本号，包含网络负载和可选的分方向网络负载，多资源均衡时为全部指标
+func (s *Service) bnpSnapshot(balance model.BalanceConfig) (map[string](map[string]int64), int64, error) {
+	if balance.MultiDim() {
+		return s.allSnapshot()
+	}
+	snapshot, version, err := s.readSnapshot(func() (map[string](map[string]int64), error) {
+		curMap, err := s.dao.GetNetIO()
+		snapshot := map[string](map[string]int64){model.ResourceNetIOKey: curMap}
+		if err != nil {
+			return snapshot, err
+		}
+		for _, key := range []string{model.ResourceNetInKey, model.ResourceNetOutKey} {
+			values, err := s.dao.GetKV(key)
+			if err != nil {
+				return snapshot, err
+			}
+			if values != nil {
+				snapshot[key] = values
+			}
+		}
+
+		return snapshot, nil
+	})
+	if err != nil {
+		return snapshot, version, err
+	}
+
+	return s.withForecast(snapshot, version), version, nil
+}
+
+// cmdapScore cmdap算法评分入口，组合评分同样使用全部指标的快照
+func (s *Service) cmdapScore(args *extenderv1.ExtenderArgs, policy *model.PolicyResolution) (*extenderv1.HostPriorityList, *ScoreInput, map[string]string, error) {
+	cacheData, version, err := s.allSnapshot()
+	in := s.policyScoreInput(policy, args, cacheData)
+	in.SnapshotVersion = version
 	if err != nil {
 		log.Error("get all cache data error: %v", err)
-		return nil, err
+		return nil, in, nil, err
 	}
 
-	cmdn := CMDNPriority{}
-	res, err := cmdn.Score(args.Pod, nodeNames, s.netBwMap, cacheData)
+	res, reasons, err := ScoreSnapshot(in)
 	log.V(3).Info("score result of CMDAP is: %#v", res)
-	if err == nil && s.topsisMin {
-		for i := range res {
-			res[i].Score = model.MaxNodeScore - res[i].Score
+
+	return &res, in, reasons, err
+}
+
+// scoreInput 使用集群配置和指定的算法构造评分输入
+func (s *Service) scoreInput(algo string, args *extenderv1.ExtenderArgs, snapshot map[string](map[string]int64)) *ScoreInput {
+	return s.policyScoreInput(s.legacyPolicy(algo), args, snapshot)
+}
+
+// policyScoreInput 使用为Pod选择的策略构造评分输入
+func (s *Service) policyScoreInput(policy *model.PolicyResolution, args *extenderv1.ExtenderArgs, snapshot map[string](map[string]int64)) *ScoreInput {
+	in := &ScoreInput{
+		MissingPolicy: s.missingPolicy,
+		Pod:           s.podWithNetDemand(args.Pod),
+		NodeNames:     *args.NodeNames,
+		NetBwMap:      s.netBw(),
+		NetCapMap:     s.netCap(),
+		Snapshot:      snapshot,
+	}
+	// 只有last策略会用到最近一次已知的值，缺少数据时再逐个查询，避免每次评分都复制全部指标
+	if s.missingPolicy.Uses(model.MissingLast) {
+		in.LastKnownValue = s.dao.GetLastKnown
+	}
+	in.Stochastic = s.stochasticNet()
+	s.applyPolicy(in, policy)
+
+	return in
+}
+
+// applyPolicy 按策略设置评分输入中与策略相关的字段，其余字段和快照不变，影子评分用它在线上的输入上换成影子策略
+func (s *Service) applyPolicy(in *ScoreInput, policy *model.PolicyResolution) {
+	algo := policy.Algorithm
+	in.Algorithm = algo
+	in.Policy = policy
+	in.TopsisMin = policy.TopsisMin()
+	in.Balance, in.CMDNCache, in.CMDNWeights, in.Ensemble = model.BalanceConfig{}, nil, nil, nil
+	if algo == model.AlgoBNP || ensembleUses(policy.Ensemble, model.AlgoBNP) {
+		in.Balance = policy.Balance
+	}
+	if algo == model.AlgoCMDN || ensembleUses(policy.Ensemble, model.AlgoCMDN) {
+		in.CMDNCache = s.loadCMDNCache()
+		in.CMDNWeights = policy.CMDNWeights
+	}
+	if algo == model.AlgoEnsemble {
+		in.Ensemble = policy.Ensemble
+	}
+	if algo != model.AlgoBNP || in.Balance.Weights[model.ResourceDiskIOKey] > 0 {
+		if in.DiskCapMap == nil {
+			in.DiskCapMap = s.diskCapacity(in.NodeNames)
+		}
+	} else {
+		in.DiskCapMap = nil
+	}
+}
+
+// ScoreInput 一次评分需要的全部输入，在线评分、解释和录制回放共用
+type ScoreInput struct {
+	Algorithm     string                  // bnp/cmdn/ensemble
+	Policy        *model.PolicyResolution // 为Pod选择的调度策略，只用于审计记录，录制回放时为空
+	TopsisMin     bool                    // 为true时翻转cmdn得分
+	MissingPolicy model.MissingPolicy
+	Balance       model.BalanceConfig // bnp多资源均衡的配置，为零值或者只有网络负载时使用原来的bnp
+	Pod           *v1.Pod
+	NodeNames     []string
+	NetBwMap      map[string]int64
+	NetCapMap     map[string]model.NetCapacity  // 分方向的网卡带宽，为空或快照中没有分方向的负载时按照单一网络负载评分
+	DiskCapMap    map[string]model.DiskCapacity // 磁盘IO能力，为空时cmdn使用原始的磁盘IO
+	Snapshot      map[string](map[string]int64) // 评分使用的指标快照，bnp只使用网络负载和分方向的网络负载
+	LastKnown     map[string](map[string]int64) // 最近一次已知的指标值，用于last策略，回放时来自录制数据
+	// LastKnownValue 按需查询最近一次已知的指标值，不为空时优先于LastKnown
+	LastKnownValue  func(key, name string) (int64, bool)
+	CMDNCache       *CMDNCache            // 同步时预先计算的cmdn评分数据，可以为空
+	SnapshotVersion int64                 // Snapshot的版本号，与CMDNCache的版本号相同时使用预先计算的数据，为0表示不是同步得到的快照
+	Stochastic      *model.StochasticNet  // 有效带宽模型的输入，为空时按照确定的网络需求过滤和评分
+	Ensemble        *model.EnsembleConfig // 组合评分的配置，只在Algorithm为ensemble时使用
+	CMDNWeights     map[string]float64    // cmdn中TOPSIS各指标的权重，为空时都为1
+}
+
+// requiredKeys 算法需要的指标
+func requiredKeys(algo string) []string {
+	if algo == model.AlgoBNP {
+		return []string{model.ResourceNetIOKey}
+	}
+
+	return []string{model.ResourceCPUKey, model.ResourceMemKey, model.ResourceNetIOKey, model.ResourceDiskIOKey}
+}
+
+// ScoreSnapshot 使用给定的指标快照评分，返回每个候选节点的得分以及没有正常参与评分的节点的原因
+func ScoreSnapshot(in *ScoreInput) (extenderv1.HostPriorityList, map[string]string, error) {
+	explain, res, err := explainSnapshot(in)
+	if explain == nil {
+		return res, nil, err
+	}
+
+	reasons := make(map[string]string)
+	for _, hs := range explain.Scores {
+		if hs.Reason != "" {
+			reasons[hs.Host] = hs.Reason
+		}
+	}
+
+	return res, reasons, err
+}
+
+// explainSnapshot 先按照缺失数据策略处理快照，再使用算法评分，返回完整的计算过程
+// 所有候选节点都会出现在结果中，被排除或者过滤掉的节点得分为最低分
+func explainSnapshot(in *ScoreInput) (*model.Explanation, extenderv1.HostPriorityList, error) {
+	if in.Algorithm == model.AlgoEnsemble {
+		return explainEnsemble(in)
+	}
+	keys := scoreKeys(in.Algorithm, in.Balance)
+	switch in.Algorithm {
+	case model.AlgoBNP, model.AlgoCMDN:
+	default:
+		return nil, nil, fmt.Errorf("unknown algorithm %s", in.Algorithm)
+	}
+	if err := ValidateCacheData(keys, in.Snapshot); err != nil {
+		return nil, GetDefaultScore(in.NodeNames), err
+	}
+
+	validNames, snapshot, missing := resolveMissing(in, keys)
+	explain := &model.Explanation{Algorithm: in.Algorithm, Missing: missing}
+	reasons := make(map[string]string)
+	for _, mv := range missing {
+		// 同一个节点有多个缺失指标时，排除的原因优先
+		if _, ok := reasons[mv.Host]; !ok || mv.Excluded {
+			reasons[mv.Host] = mv.Reason
+		}
+	}
+
+	var (
+		res      extenderv1.HostPriorityList
+		rejected []model.NodeRejection
+		err      error
+	)
+	if in.Algorithm == model.AlgoBNP && in.Balance.MultiDim() {
+		brp := BalancedResourcePriority{Mode: in.Balance.Mode}
+		res, explain.Balance, err = brp.Explain(validNames, balanceDimensions(in, snapshot, validNames))
+		rejected = explain.Balance.Rejected
+	} else if in.Algorithm == model.AlgoBNP {
+		bnp := BalanceNetloadPriority{Stochastic: in.Stochastic}
+		if in.NetCapMap != nil && HasNetDirections(snapshot, validNames) {
+			res, explain.BNP, err = bnp.ExplainDuplex(in.Pod, validNames, snapshot[model.ResourceNetInKey], snapshot[model.ResourceNetOutKey], in.NetCapMap)
+		} else {
+			res, explain.BNP, err = bnp.Explain(in.Pod, validNames, snapshot[model.ResourceNetIOKey], in.NetBwMap)
+		}
+		rejected = explain.BNP.Rejected
+	} else {
+		cmdn := CMDNPriority{Cache: in.CMDNCache, Version: in.SnapshotVersion, Stochastic: in.Stochastic, Weights: in.CMDNWeights}
+		// 补全了缺失值的快照与同步得到的快照不同，不能使用预先计算的数据
+		if filledMissing(in, missing) {
+			cmdn.Version = 0
+		}
+		res, explain.CMDN, err = cmdn.Explain(in.Pod, validNames, in.NetBwMap, withCapacity(in, snapshot))
+		explain.CMDN.TopsisMin = in.TopsisMin
+		rejected = explain.CMDN.Rejected
+		if err == nil && in.TopsisMin {
+			for i := range res {
+				res[i].Score = model.MaxNodeScore - res[i].Score
+			}
+		}
+	}
+	// 被排除和被过滤掉的节点得分为最低分，翻转后也不能得到最高分
+	zero := make(map[string]bool)
+	for _, mv := range missing {
+		if mv.Excluded {
+			zero[mv.Host] = true
+		}
+	}
+	for _, r := range rejected {
+		reasons[r.Host] = r.Reason
+		zero[r.Host] = true
+	}
+
+	scoreMap := make(map[string]int64, len(res))
+	for _, hp := range res {
+		scoreMap[hp.Host] = hp.Score
+	}
+	full := make(extenderv1.HostPriorityList, len(in.NodeNames))
+	explain.Scores = make([]model.HostScore, len(in.NodeNames))
+	for i, name := range in.NodeNames {
+		score := scoreMap[name]
+		if zero[name] {
+			score = model.MinNodeScore
+		}
+		full[i] = extenderv1.HostPriority{Host: name, Score: score}
+		explain.Scores[i] = model.HostScore{Host: name, Score: score, Reason: reasons[name]}
+	}
+
+	return explain, full, err
+}
+
+// withCapacity 返回加入磁盘能力和分方向网卡带宽后的快照，供cmdn使用，snapshot本身不会被修改
+func withCapacity(in *ScoreInput, snapshot map[string](map[string]int64)) map[string](map[string]int64) {
+	res := make(map[string](map[string]int64), len(snapshot)+4)
+	for k, v := range snapshot {
+		res[k] = v
+	}
+	if in.DiskCapMap != nil {
+		capMap := make(map[string]int64, len(in.DiskCapMap))
+		iopsCapMap := make(map[string]int64, len(in.DiskCapMap))
+		for name, c := range in.DiskCapMap {
+			capMap[name] = c.Throughput
+			iopsCapMap[name] = c.IOPS
+		}
+		res[model.ResourceDiskCapKey] = capMap
+		res[model.ResourceDiskIOPSCapKey] = iopsCapMap
+	}
+	if in.NetCapMap != nil {
+		inCapMap := make(map[string]int64, len(in.NetCapMap))
+		outCapMap := make(map[string]int64, len(in.NetCapMap))
+		for name, c := range in.NetCapMap {
+			inCapMap[name] = c.In
+			outCapMap[name] = c.Out
 		}
+		res[model.ResourceNetInCapKey] = inCapMap
+		res[model.ResourceNetOutCapKey] = outCapMap
 	}
 
-	return &res, err
+	return res
 }
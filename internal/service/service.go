@@ -3,11 +3,8 @@ package service
 import (
 	"context"
 	"fmt"
-	"math/rand"
-	"reflect"
-	"runtime"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"liang/internal/dao"
@@ -27,22 +24,72 @@ type Service struct {
 	ac        *paladin.Map
 	dao       dao.Dao
 	cron      *cron3.Cron
-	netBwMap  map[string]int64 // 节点的网卡速度信息
+	nicCap    *nicCapacity // 节点的网卡速度信息
 	nodeNames []string
-	topsisMin bool // 为true则要将topsis得到的结果翻转，评分越大，翻转后越小
-	useBNP    bool // 是否使用bnp算法
-	dryrun    bool // 是否dryrun，用于测试，不会请求真实环境
+	topsisMin bool      // 为true则要将topsis得到的结果翻转，评分越大，翻转后越小
+	useBNP    bool      // 是否使用bnp算法
+	dryrun    bool      // 是否dryrun，用于测试，不会请求真实环境
+	scenario  *scenario // dryrun时生成指标数据
+
+	missingPolicy model.MissingPolicy // 节点缺少指标时的处理策略
+	balance       model.BalanceConfig // bnp多资源均衡的配置
+	forecastCfg   ForecastConfig      // 负载预测的配置
+	forecast      forecastState       // 最近一次同步后的预测值
+	netDemandCfg  NetDemandConfig     // 学习Pod网络需求的配置
+	netDemand     netDemandState      // 按控制器学习得到的Pod网络需求
+	stochasticCfg StochasticConfig    // bnp有效带宽模型的配置
+	stochastic    stochasticState     // 按快照版本缓存的节点网络负载标准差
+	policyCfg     PolicyConfig        // 按Pod选择调度策略的配置
+	policy        policyState         // 命名空间上指定的调度策略
+	autoCfg       AutoAlgorithmConfig // 按集群瓶颈自动选择算法的配置
+	auto          autoAlgorithmState  // 自动选择的算法和切换记录
+	shadowCfg     ShadowConfig        // 影子评分的配置
+	shadow        shadowState         // 影子策略与线上结果的差异汇总
+	experimentCfg ExperimentConfig    // A/B实验的配置
+	experiment    experimentState     // 参与实验的Pod和结果
+
+	diskCapConfig DiskCapacityConfig            // 配置的磁盘IO能力
+	diskBenchMu   sync.RWMutex                  // 保护diskBench
+	diskBench     map[string]model.DiskCapacity // 基准测试指标中的磁盘IO能力
+	nodeLabelsMu  sync.RWMutex                  // 保护nodeLabels
+	nodeLabels    map[string]map[string]string  // 从kube-state-metrics同步的节点标签
+
+	cmdnCacheMu sync.RWMutex // 保护cmdnCache
+	cmdnCache   *CMDNCache   // 最近一次同步后预先计算的cmdn评分数据
+
+	snapMu      sync.RWMutex // 同步写入指标和递增版本号时持有写锁，评分读取快照时持有读锁
+	snapVersion int64        // 本地缓存中指标快照的版本号，每次同步写入指标后递增
+	configHash  atomic.Value // 评分相关配置的摘要，写入决策审计记录，网卡带宽变化时更新
+
+	syncState       syncState
+	maxSnapshotAge  time.Duration // 就绪检查允许的指标快照最大时长
+	livenessTimeout time.Duration // 超过该时长没有尝试同步则存活检查失败
+	closeCh         chan struct{}
+	records         *recorder     // 后台写入审计记录和录制数据
+	shadowWorker    *recorder     // 后台执行影子评分，完成后再提交审计记录
+	initDone        chan struct{} // 首次同步成功或者服务关闭后close
 }
 
 // New new a service and return.
 func New(d dao.Dao) (s *Service, cf func(), err error) {
+	ac := &paladin.TOML{}
+	if err = paladin.Watch("application.toml", ac); err != nil {
+		return
+	}
+
+	return NewWithConfig(d, ac)
+}
+
+// NewWithConfig 使用给定的application.toml配置创建service，不监听配置文件
+func NewWithConfig(d dao.Dao, ac *paladin.Map) (s *Service, cf func(), err error) {
 	s = &Service{
-		ac:  &paladin.TOML{},
-		dao: d,
+		ac:           ac,
+		dao:          d,
+		records:      newRecorder("record", recordQueueSize, recordSubmitWait),
+		shadowWorker: newRecorder("shadow", shadowQueueSize, 0),
 	}
 	s.cron = cron3.New(cron3.WithSeconds())
 	cf = s.Close
-	err = paladin.Watch("application.toml", s.ac)
 
 	var topsisMin, useBNP, dryrun bool
 	topsisMin, err = s.ac.Get("topsisMin").Bool()
@@ -88,15 +135,201 @@ func New(d dao.Dao) (s *Service, cf func(), err error) {
 	netMap := make(map[string]int64)
 	for i := 0; i < keyLen; i++ {
 		// 内部计算单位统一为Kbit/s
-		netmp := int64(netCap[i] * model.KbitPS)
-		if netmp == 0 {
-			err = fmt.Errorf("netload of %s is %f, should not be zero", hosts[i], netCap[i])
+		netMap[hosts[i]] = int64(netCap[i] * model.KbitPS)
+	}
+	// 可选的分方向带宽，与netbwMapKeys一一对应，为0时使用netbwMapValues
+	netDirMap := make(map[string]model.NetCapacity)
+	for _, key := range []string{"netbwInMapValues", "netbwOutMapValues"} {
+		if !s.ac.Exist(key) {
+			continue
+		}
+		var values []float64
+		if err = s.ac.Get(key).Slice(&values); err != nil {
+			log.Error("get slice config %s error: %v", key, err)
+			return
+		}
+		if len(values) != keyLen {
+			err = fmt.Errorf("len of netbwMapKeys(%d) and %s(%d) not euqal", keyLen, key, len(values))
+			return
 		}
-		netMap[hosts[i]] = netmp
+		for i := 0; i < keyLen; i++ {
+			c := netDirMap[hosts[i]]
+			if key == "netbwInMapValues" {
+				c.In = int64(values[i] * model.KbitPS)
+			} else {
+				c.Out = int64(values[i] * model.KbitPS)
+			}
+			netDirMap[hosts[i]] = c
+		}
+	}
+	minMbps, maxMbps := float64(defaultNICCapacityMinMbps), float64(defaultNICCapacityMaxMbps)
+	if s.ac.Exist("nicCapacityMinMbps") {
+		if minMbps, err = s.ac.Get("nicCapacityMinMbps").Float64(); err != nil {
+			return
+		}
+	}
+	if s.ac.Exist("nicCapacityMaxMbps") {
+		if maxMbps, err = s.ac.Get("nicCapacityMaxMbps").Float64(); err != nil {
+			return
+		}
+	}
+	s.nicCap, err = newNICCapacity(hosts, netMap, netDirMap, int64(minMbps*model.KbitPS), int64(maxMbps*model.KbitPS))
+	if err != nil {
+		log.Error("invalid net capacity config: %v", err)
+		return
 	}
-	s.netBwMap = netMap
 	log.Info("netBwMap is %#v", netMap)
 
+	s.missingPolicy = model.DefaultMissingPolicy()
+	if s.ac.Exist("missingDataPolicy") {
+		if err = s.ac.Get("missingDataPolicy").UnmarshalTOML(&s.missingPolicy); err != nil {
+			log.Error("unmarshal config missingDataPolicy error: %v", err)
+			return
+		}
+		if err = ValidateMissingPolicy(s.missingPolicy); err != nil {
+			return
+		}
+	}
+	log.Info("missing data policy is %v", s.missingPolicy)
+
+	s.diskCapConfig = DefaultDiskCapacityConfig()
+	if s.ac.Exist("diskCapacity") {
+		if err = s.ac.Get("diskCapacity").UnmarshalTOML(&s.diskCapConfig); err != nil {
+			log.Error("unmarshal config diskCapacity error: %v", err)
+			return
+		}
+	}
+	if err = s.diskCapConfig.Validate(); err != nil {
+		return
+	}
+	log.V(5).Info("disk capacity config: %+v", s.diskCapConfig)
+
+	s.balance = model.DefaultBalanceConfig()
+	if s.ac.Exist("bnpBalance") {
+		var balance model.BalanceConfig
+		if err = s.ac.Get("bnpBalance").UnmarshalTOML(&balance); err != nil {
+			log.Error("unmarshal config bnpBalance error: %v", err)
+			return
+		}
+		if balance.Mode != "" {
+			s.balance.Mode = balance.Mode
+		}
+		if balance.Weights != nil {
+			s.balance.Weights = balance.Weights
+		}
+		if err = ValidateBalanceConfig(s.balance); err != nil {
+			return
+		}
+	}
+	log.Info("bnp balance config is %+v", s.balance)
+
+	s.forecastCfg = DefaultForecastConfig()
+	if s.ac.Exist("forecast") {
+		if err = s.ac.Get("forecast").UnmarshalTOML(&s.forecastCfg); err != nil {
+			log.Error("unmarshal config forecast error: %v", err)
+			return
+		}
+		if err = s.forecastCfg.Validate(); err != nil {
+			return
+		}
+	}
+	log.Info("forecast config is %+v", s.forecastCfg)
+
+	s.netDemandCfg = DefaultNetDemandConfig()
+	if s.ac.Exist("netDemand") {
+		if err = s.ac.Get("netDemand").UnmarshalTOML(&s.netDemandCfg); err != nil {
+			log.Error("unmarshal config netDemand error: %v", err)
+			return
+		}
+		if err = s.netDemandCfg.Validate(); err != nil {
+			return
+		}
+	}
+	log.Info("net demand config is %+v", s.netDemandCfg)
+
+	s.stochasticCfg = DefaultStochasticConfig()
+	if s.ac.Exist("stochasticNet") {
+		if err = s.ac.Get("stochasticNet").UnmarshalTOML(&s.stochasticCfg); err != nil {
+			log.Error("unmarshal config stochasticNet error: %v", err)
+			return
+		}
+		if err = s.stochasticCfg.Validate(); err != nil {
+			return
+		}
+	}
+	log.Info("stochastic net config is %+v", s.stochasticCfg)
+
+	s.policyCfg = DefaultPolicyConfig()
+	if s.ac.Exist("policy") {
+		if err = s.ac.Get("policy").UnmarshalTOML(&s.policyCfg); err != nil {
+			log.Error("unmarshal config policy error: %v", err)
+			return
+		}
+		s.policyCfg.normalize()
+		if err = s.policyCfg.Validate(); err != nil {
+			return
+		}
+	}
+	log.Info("policy config is %+v", s.policyCfg)
+
+	s.autoCfg = DefaultAutoAlgorithmConfig()
+	if s.ac.Exist("autoAlgorithm") {
+		if err = s.ac.Get("autoAlgorithm").UnmarshalTOML(&s.autoCfg); err != nil {
+			log.Error("unmarshal config autoAlgorithm error: %v", err)
+			return
+		}
+		if err = s.autoCfg.Validate(); err != nil {
+			return
+		}
+	}
+	// 从useBNP指定的算法开始自动选择
+	s.auto.algorithm = model.AlgoCMDN
+	if s.useBNP {
+		s.auto.algorithm = model.AlgoBNP
+	}
+	if s.autoCfg.Enabled {
+		setAutoAlgorithmMetric(s.auto.algorithm)
+	}
+	log.Info("auto algorithm config is %+v", s.autoCfg)
+
+	s.shadowCfg = DefaultShadowConfig()
+	if s.ac.Exist("shadow") {
+		if err = s.ac.Get("shadow").UnmarshalTOML(&s.shadowCfg); err != nil {
+			log.Error("unmarshal config shadow error: %v", err)
+			return
+		}
+		if err = s.shadowCfg.Validate(s.policyCfg); err != nil {
+			return
+		}
+	}
+	log.Info("shadow config is %+v", s.shadowCfg)
+
+	s.experimentCfg = DefaultExperimentConfig()
+	if s.ac.Exist("experiment") {
+		if err = s.ac.Get("experiment").UnmarshalTOML(&s.experimentCfg); err != nil {
+			log.Error("unmarshal config experiment error: %v", err)
+			return
+		}
+		if err = s.experimentCfg.Validate(s.policyCfg); err != nil {
+			return
+		}
+	}
+	log.Info("experiment config is %+v", s.experimentCfg)
+	s.refreshConfigHash()
+
+	if s.dryrun {
+		scenarioCfg := DefaultScenarioConfig()
+		if s.ac.Exist("dryrunScenario") {
+			if err = s.ac.Get("dryrunScenario").UnmarshalTOML(&scenarioCfg); err != nil {
+				log.Error("unmarshal config dryrunScenario error: %v", err)
+				return
+			}
+		}
+		diskCapMap := resolveDiskCapacity(s.diskCapConfig, nil, nil, s.nodeNames)
+		s.scenario = newScenario(scenarioCfg, s.nodeNames, s.netCap(), diskCapMap)
+		log.V(5).Info("dryrun scenario: %+v", scenarioCfg)
+	}
+
 	// 同步prom状态信息
 	var syncInterval string
 	syncInterval, err = s.ac.Get("syncStatusInterval").String()
@@ -104,19 +337,61 @@ func New(d dao.Dao) (s *Service, cf func(), err error) {
 		log.Error("get syncStatusInterval from application.toml error: %v", err)
 		return
 	}
-	// err = s.SyncNetIO()
-	err = s.ParallelSyncInfo()
-	if err != nil {
+	s.maxSnapshotAge = defaultMaxSnapshotAge
+	if s.ac.Exist("maxSnapshotAge") {
+		if s.maxSnapshotAge, err = s.ac.Get("maxSnapshotAge").Duration(); err != nil {
+			log.Error("get maxSnapshotAge from application.toml error: %v", err)
+			return
+		}
+	}
+	s.livenessTimeout = defaultLivenessTimeout
+	if s.ac.Exist("livenessTimeout") {
+		if s.livenessTimeout, err = s.ac.Get("livenessTimeout").Duration(); err != nil {
+			log.Error("get livenessTimeout from application.toml error: %v", err)
+			return
+		}
+	}
+	if s.maxSnapshotAge <= 0 || s.livenessTimeout <= 0 {
+		err = fmt.Errorf("maxSnapshotAge %s and livenessTimeout %s should be positive", s.maxSnapshotAge, s.livenessTimeout)
+		log.Error("%v", err)
 		return
 	}
-	// TODO: 做下判断，如果err次数过多，直接panic
+	log.Info("maxSnapshotAge is %s, livenessTimeout is %s", s.maxSnapshotAge, s.livenessTimeout)
+	nicInterval := defaultNICDiscoveryInterval
+	if s.ac.Exist("nicDiscoveryInterval") {
+		if nicInterval, err = s.ac.Get("nicDiscoveryInterval").String(); err != nil {
+			log.Error("get nicDiscoveryInterval from application.toml error: %v", err)
+			return
+		}
+	}
+
+	// 首次同步在后台进行，Prometheus不可用时不阻塞启动
+	s.closeCh = make(chan struct{})
+	s.initDone = make(chan struct{})
+	go s.initialSync()
+	// 等待informer首次同步可能较慢，不阻塞启动
+	go func() {
+		if err := s.watchExperimentPods(); err != nil {
+			log.Error("experiment disabled: %v", err)
+		}
+	}()
+
 	_, err = s.cron.AddFunc(syncInterval, func() {
+		// 首次同步完成之前由initialSync负责重试
+		select {
+		case <-s.initDone:
+		default:
+			return
+		}
+
 		var innerErr error
-		if s.useBNP {
+		if s.syncNetIOOnly() {
 			innerErr = s.SyncNetIO()
 		} else {
 			innerErr = s.ParallelSyncInfo()
 		}
+		s.markAttempt(innerErr)
+		s.FinishExperimentPods()
 
 		if innerErr != nil {
 			log.Error("%v", innerErr)
@@ -127,18 +402,64 @@ func New(d dao.Dao) (s *Service, cf func(), err error) {
 		log.Error("add sync prom status error: %v", err)
 		return
 	}
+	// 定期从Prometheus发现网卡带宽和节点标签，dryrun时只使用静态配置
+	if !s.dryrun {
+		_, err = s.cron.AddFunc(nicInterval, func() {
+			_ = s.SyncNICCapacity()
+			_ = s.SyncNodeLabels()
+		})
+		if err != nil {
+			log.Error("add nic discovery error: %v", err)
+			return
+		}
+	}
+	// 定期从Prometheus同步命名空间上指定的调度策略
+	if s.syncNamespacePolicyEnabled() {
+		_, err = s.cron.AddFunc(s.policyCfg.Interval, func() {
+			_ = s.SyncNamespacePolicies()
+		})
+		if err != nil {
+			log.Error("add namespace policy sync error: %v", err)
+			return
+		}
+	}
+	// 定期从Prometheus学习没有注解的Pod的网络需求
+	if !s.dryrun && s.netDemandCfg.Enabled {
+		_, err = s.cron.AddFunc(s.netDemandCfg.Interval, func() {
+			_ = s.SyncNetDemand()
+		})
+		if err != nil {
+			log.Error("add net demand learning error: %v", err)
+			return
+		}
+	}
 	s.cron.Start()
 
 	return
 }
 
 // Ping ping the resource.
+// 作为存活检查，只要定时同步任务还在运行就返回nil
 func (s *Service) Ping(ctx context.Context, e *empty.Empty) (*empty.Empty, error) {
-	return &empty.Empty{}, s.dao.Ping(ctx)
+	if report := s.Liveness(); !report.OK {
+		return &empty.Empty{}, fmt.Errorf("%s", report.Reason)
+	}
+
+	return &empty.Empty{}, nil
 }
 
 // Close close the resource.
 func (s *Service) Close() {
+	if s.cron != nil {
+		s.cron.Stop()
+	}
+	if s.closeCh != nil {
+		close(s.closeCh)
+	}
+	// 先执行完排队的影子评分，它们会再提交审计记录，然后写完全部审计记录，dao在service之后关闭
+	s.shadowWorker.close()
+	s.records.flush()
+	s.records.close()
 }
 
 // PromDemo demo of prometheus api
@@ -147,7 +468,14 @@ func (s *Service) PromDemo() {
 }
 
 func (s *Service) GetAllCache() (map[string](map[string]int64), error) {
-	return s.dao.GetAllInfo()
+	res, _, err := s.allSnapshot()
+	return res, err
+}
+
+// allSnapshot 读取全部指标和对应的快照版本号，开启负载预测时为预测值
+func (s *Service) allSnapshot() (map[string](map[string]int64), int64, error) {
+	res, version, err := s.readSnapshot(s.dao.GetAllInfo)
+	return s.withForecast(res, version), version, err
 }
 
 // RequestPromInfo 从本地缓存获取diskIO/netIO/CPU Usage/Mem Usage信息
@@ -240,13 +568,20 @@ func (s *Service) SyncNetIO() error {
 	if len(netMap) == 0 {
 		return nil
 	}
-
-	err = s.dao.SetNetIO(netMap)
-	if err != nil {
+	values := map[string](map[string]int64){model.ResourceNetIOKey: netMap}
+
+	// 上行负载用于分方向评分，获取失败时按照下行负载评分
+	if up, err := s.dao.RequestPromNetIO(model.NetIOTypeUp); err != nil {
+		log.Warn("sync upload netload error: %v", err)
+	} else {
+		values[model.ResourceNetInKey] = netMap
+		values[model.ResourceNetOutKey] = s.filterByNodeName(up)
+	}
+	if err = s.writeSnapshot(values); err != nil {
 		log.Error("SetNetIO error: %v", err)
 		return err
 	}
-
+	s.updateForecast(model.ResourceNetIOKey, model.ResourceNetInKey, model.ResourceNetOutKey)
 	costTime := time.Now().Sub(start).String()
 	log.V(7).Info("sync net info costs %s", costTime)
 
@@ -255,56 +590,23 @@ func (s *Service) SyncNetIO() error {
 
 // DryrunSyncInfo 模拟存储需要的数据
 func (s *Service) DryrunSyncInfo() error {
-	// 模拟DiskIO/NetIO/CPU/Mem 数据并存储到缓存中，格式为 map[string]int64类型
-	// map的key为node1 node2 node3等主机hostname，value为对应的值
-	nodeKeys := []string{"node1", "node2", "node3"}
-
-	// diskIO
-	diskMap := make(map[string]int64)
-	for _, v := range nodeKeys {
-		diskMap[v] = s.randSeed(10000)
-	}
-	err := s.dao.SetKV(model.ResourceDiskIOKey, diskMap)
-	if err != nil {
-		return err
+	// 按照dryrun场景生成DiskIO/NetIO/CPU/Mem数据并存储到缓存中，格式为 map[string]int64类型
+	// map的key为netbwMapKeys中的主机hostname，value为对应的值
+	data := s.scenario.Next()
+	keys := []string{model.ResourceDiskIOKey, model.ResourceDiskIOPSKey, model.ResourceNetIOKey,
+		model.ResourceNetInKey, model.ResourceNetOutKey, model.ResourceCPUKey, model.ResourceMemKey}
+	values := make(map[string](map[string]int64), len(keys))
+	for _, key := range keys {
+		values[key] = data[key]
 	}
-
-	// netIO
-	netMap := make(map[string]int64)
-	for _, v := range nodeKeys {
-		netMap[v] = s.randSeed(20000)
-	}
-	err = s.dao.SetKV(model.ResourceNetIOKey, netMap)
-	if err != nil {
-		return err
-	}
-
-	// CPU
-	cpuMap := make(map[string]int64)
-	for _, v := range nodeKeys {
-		cpuMap[v] = s.randCPU()
-	}
-	err = s.dao.SetKV(model.ResourceCPUKey, cpuMap)
-	if err != nil {
+	if err := s.writeSnapshot(values); err != nil {
 		return err
 	}
+	s.updateForecast(keys...)
+	s.selectAlgorithm()
+	s.refreshCMDNCache()
 
-	// Mem
-	memMap := make(map[string]int64)
-	for _, v := range nodeKeys {
-		memMap[v] = s.randCPU()
-	}
-	return s.dao.SetKV(model.ResourceMemKey, memMap)
-}
-
-func (s *Service) randCPU() int64 {
-	res := rand.Intn(100) + 1
-	return int64(res)
-}
-
-func (s *Service) randSeed(seed int) int64 {
-	res := rand.Intn(seed) + 1
-	return int64(res)
+	return nil
 }
 
 // ParallelSyncInfo 并发获取CPU/Mem/DiskIO/NetIO信息
@@ -315,54 +617,77 @@ func (s *Service) ParallelSyncInfo() error {
 	}
 
 	start := time.Now()
-	type innerFunc func() (map[string]int64, error)
-	funcArr := []innerFunc{s.dao.RequestPromMaxNetIO, s.dao.RequestPromMaxDiskIO, s.dao.RequestPromCPUUsage, s.dao.RequestPromMemUsage}
-
-	funcKeyMap := make(map[string]string)
-	var vkey string
-	for _, ff := range funcArr {
-		fName := runtime.FuncForPC(reflect.ValueOf(ff).Pointer()).Name()
-		if strings.Contains(fName, "RequestPromMaxNetIO") {
-			vkey = model.ResourceNetIOKey
-		} else if strings.Contains(fName, "RequestPromMaxDiskIO") {
-			vkey = model.ResourceDiskIOKey
-		} else if strings.Contains(fName, "RequestPromCPUUsage") {
-			vkey = model.ResourceCPUKey
-		} else {
-			vkey = model.ResourceMemKey
-		}
-
-		funcKeyMap[fName] = vkey
+	// optional为true的指标同步失败时不影响本次同步的结果
+	funcArr := []struct {
+		key      string
+		optional bool
+		ff       func() (map[string]int64, error)
+	}{
+		{key: model.ResourceNetIOKey, ff: s.dao.RequestPromMaxNetIO},
+		{key: model.ResourceNetInKey, optional: true, ff: func() (map[string]int64, error) {
+			return s.dao.RequestPromNetIO(model.NetIOTypeDown)
+		}},
+		{key: model.ResourceNetOutKey, optional: true, ff: func() (map[string]int64, error) {
+			return s.dao.RequestPromNetIO(model.NetIOTypeUp)
+		}},
+		{key: model.ResourceDiskIOKey, ff: s.dao.RequestPromMaxDiskIO},
+		{key: model.ResourceDiskIOPSKey, ff: s.dao.RequestPromMaxDiskIOPS},
+		{key: model.ResourceCPUKey, ff: s.dao.RequestPromCPUUsage},
+		{key: model.ResourceMemKey, ff: s.dao.RequestPromMemUsage},
 	}
 
+	// 全部查询完成后再一起写入本地缓存，评分不会读到只更新了一部分的快照
 	var wg sync.WaitGroup
-	var returnErr error
+	results := make([]map[string]int64, len(funcArr))
+	errs := make([]error, len(funcArr))
 	for i := range funcArr {
-		ff := funcArr[i]
+		i, item := i, funcArr[i]
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			if returnErr != nil {
+			resMap, err := item.ff()
+			if err != nil && item.optional {
+				log.Warn("[ParallelGetLoadInfo] get %s error: %v", item.key, err)
 				return
 			}
-			fName := runtime.FuncForPC(reflect.ValueOf(ff).Pointer()).Name()
-			resMap, err := ff()
 			if err != nil {
-				log.Error("[ParallelGetLoadInfo] %s error: %v", fName, err)
-				returnErr = paladin.ErrDifferentTypes
+				log.Error("[ParallelGetLoadInfo] get %s error: %v", item.key, err)
+				errs[i] = paladin.ErrDifferentTypes
 				return
 			}
-
-			filtered := s.filterByNodeName(resMap)
-			err = s.dao.SetKV(funcKeyMap[fName], filtered)
-			if err != nil {
-				returnErr = err
-				log.Error("[ParallelGetLoadInfo] SetKV error: %v", err)
-			}
+			results[i] = s.filterByNodeName(resMap)
 		}()
 	}
 
 	wg.Wait()
+	var returnErr error
+	values := make(map[string](map[string]int64), len(funcArr))
+	for i, item := range funcArr {
+		if errs[i] != nil && returnErr == nil {
+			returnErr = errs[i]
+		}
+		if results[i] != nil {
+			values[item.key] = results[i]
+		}
+	}
+	// 必需的指标查询失败时，已经得到的指标仍然写入
+	if err := s.writeSnapshot(values); err != nil && returnErr == nil {
+		returnErr = err
+	}
+	// 基准测试指标不是每个集群都有，同步失败不影响评分
+	_ = s.SyncDiskCapacity()
+	if returnErr == nil {
+		// 可选指标同步失败时缓存中是旧数据，不记录到历史中
+		keys := make([]string, 0, len(funcArr))
+		for _, item := range funcArr {
+			if t, ok := s.metricUpdated(item.key); ok && !t.Before(start) {
+				keys = append(keys, item.key)
+			}
+		}
+		s.updateForecast(keys...)
+		s.selectAlgorithm()
+		s.refreshCMDNCache()
+	}
 	costTime := time.Now().Sub(start).String()
 	log.V(7).Info("sync dynamic info costs %s", costTime)
 	return returnErr
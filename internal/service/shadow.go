@@ -0,0 +1,367 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/log"
+	xtime "github.com/go-kratos/kratos/pkg/time"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// shadowQueueSize 后台影子评分队列的长度，队列满时丢弃新的影子评分
+const shadowQueueSize = 64
+
+// ShadowConfig 影子评分的配置，对应application.toml中的[shadow]
+// 每次Prioritize在线上策略之外再用Policies中的策略评分，只把线上结果返回给调度器
+// 影子结果写入审计记录，与线上结果的差异按Bucket汇总，最多保留MaxBuckets个时间段
+type ShadowConfig struct {
+	Policies   []string       // 影子策略的名称，可以是[policy.policies]中的策略或者cluster
+	Bucket     xtime.Duration // 汇总差异的时间段长度
+	MaxBuckets int
+}
+
+// DefaultShadowConfig 没有配置[shadow]时不做影子评分，默认保留最近一天的汇总
+func DefaultShadowConfig() ShadowConfig {
+	return ShadowConfig{
+		Bucket:     xtime.Duration(10 * time.Minute),
+		MaxBuckets: 144,
+	}
+}
+
+// Validate 检查影子策略是否存在以及汇总的时间段
+func (cfg ShadowConfig) Validate(policyCfg PolicyConfig) error {
+	seen := make(map[string]bool, len(cfg.Policies))
+	for _, name := range cfg.Policies {
+		if name == "" || !policyCfg.exist(name) {
+			return fmt.Errorf("shadow policy %s does not exist", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("shadow policy %s is duplicated", name)
+		}
+		seen[name] = true
+	}
+	if cfg.Bucket <= 0 {
+		return fmt.Errorf("shadow bucket should be positive")
+	}
+	if cfg.MaxBuckets < 1 {
+		return fmt.Errorf("shadow max buckets %d should be positive", cfg.MaxBuckets)
+	}
+
+	return nil
+}
+
+// shadowAgg 一个时间段内的差异累计值
+type shadowAgg struct {
+	start   time.Time
+	count   int
+	errors  int
+	dropped int
+	agree   int
+	tauSum  float64
+	tauMin  float64
+}
+
+// add 累加一次比较结果，失败的比较只计数
+func (agg *shadowAgg) add(r *model.ShadowResult) {
+	if r.Error != "" {
+		agg.errors++
+		return
+	}
+	if agg.count == 0 || r.KendallTau < agg.tauMin {
+		agg.tauMin = r.KendallTau
+	}
+	agg.count++
+	agg.tauSum += r.KendallTau
+	if r.Top1Agree {
+		agg.agree++
+	}
+}
+
+// merge 合并另一个时间段的累计值
+func (agg *shadowAgg) merge(other *shadowAgg) {
+	if other.count > 0 && (agg.count == 0 || other.tauMin < agg.tauMin) {
+		agg.tauMin = other.tauMin
+	}
+	agg.count += other.count
+	agg.errors += other.errors
+	agg.dropped += other.dropped
+	agg.agree += other.agree
+	agg.tauSum += other.tauSum
+}
+
+func (agg *shadowAgg) bucket() model.ShadowBucket {
+	b := model.ShadowBucket{Start: agg.start, Count: agg.count, Errors: agg.errors, Dropped: agg.dropped}
+	if agg.count > 0 {
+		b.Top1Agreement = float64(agg.agree) / float64(agg.count)
+		b.MeanKendallTau = agg.tauSum / float64(agg.count)
+		b.MinKendallTau = agg.tauMin
+	}
+
+	return b
+}
+
+// shadowState 每个影子策略按时间段汇总的差异，时间段按时间从旧到新
+type shadowState struct {
+	mu      sync.Mutex
+	buckets map[string][]*shadowAgg
+}
+
+// topHost 得分最高的节点，得分相同时取候选节点中靠前的，与调度器的选择不一定相同
+func topHost(nodeNames []string, scores map[string]int64) string {
+	res := ""
+	for _, name := range nodeNames {
+		if res == "" || scores[name] > scores[res] {
+			res = name
+		}
+	}
+
+	return res
+}
+
+// kendallTau 两组得分在nodeNames上排序的Kendall tau-b，考虑得分相同的情况
+// 节点少于2个或者两组得分都完全相同时为1，只有一组完全相同时为0
+// 使用Knight的算法：按(a, b)排序后用归并排序统计b中的逆序对，复杂度为O(n log n)
+func kendallTau(nodeNames []string, a, b map[string]int64) float64 {
+	n := len(nodeNames)
+	pairs := make([][2]int64, n)
+	for i, name := range nodeNames {
+		pairs[i] = [2]int64{a[name], b[name]}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+
+	// a中相同的节点对，以及a和b都相同的节点对
+	var tiesA, tiesBoth float64
+	for i := 0; i < n; {
+		j := i
+		for j < n && pairs[j][0] == pairs[i][0] {
+			j++
+		}
+		tiesA += tiedPairs(j - i)
+		for k := i; k < j; {
+			l := k
+			for l < j && pairs[l][1] == pairs[k][1] {
+				l++
+			}
+			tiesBoth += tiedPairs(l - k)
+			k = l
+		}
+		i = j
+	}
+
+	values := make([]int64, n)
+	for i, p := range pairs {
+		values[i] = p[1]
+	}
+	discordant := float64(countInversions(values, make([]int64, n)))
+	// 归并排序后values按b有序
+	var tiesB float64
+	for i := 0; i < n; {
+		j := i
+		for j < n && values[j] == values[i] {
+			j++
+		}
+		tiesB += tiedPairs(j - i)
+		i = j
+	}
+
+	total := tiedPairs(n)
+	pairsA := total - tiesA // a中不相同的节点对
+	pairsB := total - tiesB
+	if pairsA == 0 && pairsB == 0 {
+		return 1
+	}
+	if pairsA == 0 || pairsB == 0 {
+		return 0
+	}
+	// 一致的节点对减去不一致的节点对
+	diff := total - tiesA - tiesB + tiesBoth - 2*discordant
+
+	return diff / math.Sqrt(pairsA*pairsB)
+}
+
+// tiedPairs n个节点两两组成的节点对数量
+func tiedPairs(n int) float64 {
+	return float64(n) * float64(n-1) / 2
+}
+
+// countInversions 归并排序values并返回严格逆序的对数，buf为与values等长的缓冲区
+func countInversions(values, buf []int64) int64 {
+	n := len(values)
+	if n < 2 {
+		return 0
+	}
+	mid := n / 2
+	res := countInversions(values[:mid], buf[:mid]) + countInversions(values[mid:], buf[mid:])
+	i, j, k := 0, mid, 0
+	for i < mid && j < n {
+		if values[j] < values[i] {
+			// 左半部分剩余的值都严格大于values[j]
+			res += int64(mid - i)
+			buf[k] = values[j]
+			j++
+		} else {
+			buf[k] = values[i]
+			i++
+		}
+		k++
+	}
+	k += copy(buf[k:], values[i:mid])
+	copy(buf[k:], values[j:])
+	copy(values, buf)
+
+	return res
+}
+
+// compareShadow 计算影子结果与线上结果的差异
+func compareShadow(nodeNames []string, live, shadow extenderv1.HostPriorityList) (bool, float64) {
+	liveMap := make(map[string]int64, len(live))
+	for _, hp := range live {
+		liveMap[hp.Host] = hp.Score
+	}
+	shadowMap := make(map[string]int64, len(shadow))
+	for _, hp := range shadow {
+		shadowMap[hp.Host] = hp.Score
+	}
+
+	return topHost(nodeNames, liveMap) == topHost(nodeNames, shadowMap), kendallTau(nodeNames, liveMap, shadowMap)
+}
+
+// shadowNeedsAllMetrics 是否有影子策略需要网络负载以外的指标，此时线上的bnp也使用全部指标的快照
+func (s *Service) shadowNeedsAllMetrics() bool {
+	for _, name := range s.shadowCfg.Policies {
+		if p := s.namedPolicy(name, model.PolicySourceShadow); p.Algorithm != model.AlgoBNP || p.Balance.MultiDim() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shadowScore 在线上评分的输入in上换成每个影子策略评分并与线上结果比较，使用与线上相同的快照
+// 在后台执行，t为Prioritize的时间，live为空或liveErr不为空表示线上评分失败，此时不再评分
+func (s *Service) shadowScore(t time.Time, args *extenderv1.ExtenderArgs, in *ScoreInput, live *extenderv1.HostPriorityList, liveErr error) []model.ShadowResult {
+	if len(s.shadowCfg.Policies) == 0 {
+		return nil
+	}
+
+	res := make([]model.ShadowResult, 0, len(s.shadowCfg.Policies))
+	for _, name := range s.shadowCfg.Policies {
+		policy := s.namedPolicy(name, model.PolicySourceShadow)
+		r := model.ShadowResult{Policy: name, Algorithm: policy.Algorithm}
+		var err error
+		if in == nil || live == nil || liveErr != nil {
+			err = fmt.Errorf("live scoring failed")
+		} else {
+			shadowIn := *in
+			s.applyPolicy(&shadowIn, policy)
+			explain, scores, scoreErr := explainSnapshot(&shadowIn)
+			if explain != nil {
+				r.Scores = explain.Scores
+			}
+			err = scoreErr
+			if err == nil {
+				r.Top1Agree, r.KendallTau = compareShadow(*args.NodeNames, *live, scores)
+			}
+		}
+		if err != nil {
+			r.Error = err.Error()
+			log.Warn("shadow policy %s error: %v", name, err)
+		}
+		res = append(res, r)
+		s.observeShadow(t, &r)
+	}
+
+	return res
+}
+
+// observeShadow 把一次比较结果累加到Prioritize的时间t所在的时间段
+func (s *Service) observeShadow(t time.Time, r *model.ShadowResult) {
+	s.shadow.mu.Lock()
+	defer s.shadow.mu.Unlock()
+	if agg := s.shadowBucket(r.Policy, t); agg != nil {
+		agg.add(r)
+	}
+}
+
+// dropShadow 后台队列满时，把每个影子策略的一次丢弃计入时间t所在的时间段
+func (s *Service) dropShadow(t time.Time) {
+	s.shadow.mu.Lock()
+	defer s.shadow.mu.Unlock()
+	for _, name := range s.shadowCfg.Policies {
+		if agg := s.shadowBucket(name, t); agg != nil {
+			agg.dropped++
+		}
+	}
+}
+
+// shadowBucket 返回policy在时刻t所在的时间段，不存在时按时间顺序插入，调用方需要持有锁
+// 后台评分可能在下一个时间段开始后才完成，t早于保留的全部时间段时返回nil
+func (s *Service) shadowBucket(policy string, t time.Time) *shadowAgg {
+	start := t.Truncate(time.Duration(s.shadowCfg.Bucket))
+	if s.shadow.buckets == nil {
+		s.shadow.buckets = make(map[string][]*shadowAgg)
+	}
+	buckets := s.shadow.buckets[policy]
+	i := len(buckets)
+	for i > 0 && buckets[i-1].start.After(start) {
+		i--
+	}
+	if i > 0 && buckets[i-1].start.Equal(start) {
+		return buckets[i-1]
+	}
+
+	agg := &shadowAgg{start: start}
+	buckets = append(buckets, nil)
+	copy(buckets[i+1:], buckets[i:])
+	buckets[i] = agg
+	if len(buckets) > s.shadowCfg.MaxBuckets {
+		buckets = buckets[len(buckets)-s.shadowCfg.MaxBuckets:]
+	}
+	s.shadow.buckets[policy] = buckets
+	if start.Before(buckets[0].start) {
+		return nil
+	}
+
+	return agg
+}
+
+// ShadowReport 每个影子策略与线上结果的差异，policy不为空时只返回该策略
+func (s *Service) ShadowReport(policy string) *model.ShadowReport {
+	report := &model.ShadowReport{
+		Bucket:   time.Duration(s.shadowCfg.Bucket).String(),
+		Dropped:  s.shadowWorker.droppedCount(),
+		Policies: make([]model.ShadowPolicyReport, 0, len(s.shadowCfg.Policies)),
+	}
+
+	s.shadow.mu.Lock()
+	defer s.shadow.mu.Unlock()
+	for _, name := range s.shadowCfg.Policies {
+		if policy != "" && policy != name {
+			continue
+		}
+		p := model.ShadowPolicyReport{Policy: name, Algorithm: s.namedPolicy(name, model.PolicySourceShadow).Algorithm, Buckets: []model.ShadowBucket{}}
+		var total shadowAgg
+		for _, agg := range s.shadow.buckets[name] {
+			if total.start.IsZero() {
+				total.start = agg.start
+			}
+			total.merge(agg)
+			p.Buckets = append(p.Buckets, agg.bucket())
+		}
+		p.Total = total.bucket()
+		report.Policies = append(report.Policies, p)
+	}
+
+	return report
+}
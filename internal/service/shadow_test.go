@@ -0,0 +1,275 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"liang/internal/fakeprom"
+	"liang/internal/model"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func TestShadowConfig_Validate(t *testing.T) {
+	policyCfg := DefaultPolicyConfig()
+	policyCfg.Policies = map[string]model.Policy{"pack": {Algorithm: model.AlgoCMDN, Mode: model.PolicyModeCompact}}
+	cases := []struct {
+		Name   string
+		Modify func(cfg *ShadowConfig)
+		Valid  bool
+	}{
+		{Name: "default", Modify: func(cfg *ShadowConfig) {}, Valid: true},
+		{Name: "policies", Modify: func(cfg *ShadowConfig) { cfg.Policies = []string{"pack", model.PolicyCluster} }, Valid: true},
+		{Name: "unknown policy", Modify: func(cfg *ShadowConfig) { cfg.Policies = []string{"spread"} }},
+		{Name: "duplicated policy", Modify: func(cfg *ShadowConfig) { cfg.Policies = []string{"pack", "pack"} }},
+		{Name: "zero bucket", Modify: func(cfg *ShadowConfig) { cfg.Bucket = 0 }},
+		{Name: "zero max buckets", Modify: func(cfg *ShadowConfig) { cfg.MaxBuckets = 0 }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			cfg := DefaultShadowConfig()
+			c.Modify(&cfg)
+			if err := cfg.Validate(policyCfg); (err == nil) != c.Valid {
+				t.Errorf("valid should be %v, but get %v", c.Valid, err)
+			}
+		})
+	}
+}
+
+func TestKendallTau(t *testing.T) {
+	nodeNames := []string{"node1", "node2", "node3", "node4"}
+	live := map[string]int64{"node1": 90, "node2": 60, "node3": 30, "node4": 0}
+	cases := []struct {
+		Name     string
+		Shadow   map[string]int64
+		Expected float64
+	}{
+		{Name: "same order", Shadow: map[string]int64{"node1": 50, "node2": 40, "node3": 20, "node4": 10}, Expected: 1},
+		{Name: "reversed", Shadow: map[string]int64{"node1": 0, "node2": 30, "node3": 60, "node4": 90}, Expected: -1},
+		// 6对中5对一致1对相反
+		{Name: "one swap", Shadow: map[string]int64{"node1": 90, "node2": 60, "node3": 0, "node4": 30}, Expected: 4.0 / 6},
+		// 一对相同：C=5 D=0，tau-b=5/sqrt(6*5)
+		{Name: "tie", Shadow: map[string]int64{"node1": 90, "node2": 60, "node3": 30, "node4": 30}, Expected: 5 / math.Sqrt(30)},
+		{Name: "all tied", Shadow: map[string]int64{"node1": 10, "node2": 10, "node3": 10, "node4": 10}, Expected: 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if tau := kendallTau(nodeNames, live, c.Shadow); math.Abs(tau-c.Expected) > 1e-9 {
+				t.Errorf("tau should be %v, but get %v", c.Expected, tau)
+			}
+		})
+	}
+	if tau := kendallTau(nodeNames[:1], live, live); tau != 1 {
+		t.Errorf("tau of single node should be 1, but get %v", tau)
+	}
+}
+
+// naiveKendallTau 逐对比较的Kendall tau-b，用于验证kendallTau
+func naiveKendallTau(nodeNames []string, a, b map[string]int64) float64 {
+	var concordant, discordant, tiesA, tiesB float64
+	for i := range nodeNames {
+		for j := i + 1; j < len(nodeNames); j++ {
+			da := a[nodeNames[i]] - a[nodeNames[j]]
+			db := b[nodeNames[i]] - b[nodeNames[j]]
+			switch {
+			case da == 0 && db == 0:
+			case da == 0:
+				tiesA++
+			case db == 0:
+				tiesB++
+			case (da > 0) == (db > 0):
+				concordant++
+			default:
+				discordant++
+			}
+		}
+	}
+	pairsA, pairsB := concordant+discordant+tiesB, concordant+discordant+tiesA
+	if pairsA == 0 && pairsB == 0 {
+		return 1
+	}
+	if pairsA == 0 || pairsB == 0 {
+		return 0
+	}
+
+	return (concordant - discordant) / math.Sqrt(pairsA*pairsB)
+}
+
+func TestKendallTau_Random(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		n := rnd.Intn(60)
+		// 得分范围较小时有大量相同的得分
+		scale := int64(rnd.Intn(100) + 1)
+		nodeNames := make([]string, n)
+		a, b := make(map[string]int64, n), make(map[string]int64, n)
+		for i := range nodeNames {
+			name := fmt.Sprintf("node%d", i)
+			nodeNames[i] = name
+			a[name], b[name] = rnd.Int63n(scale), rnd.Int63n(scale)
+		}
+		if tau, expected := kendallTau(nodeNames, a, b), naiveKendallTau(nodeNames, a, b); math.Abs(tau-expected) > 1e-9 {
+			t.Fatalf("tau of %d nodes should be %v, but get %v", n, expected, tau)
+		}
+	}
+}
+
+func TestService_Shadow(t *testing.T) {
+	prom := fakeprom.New()
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 100000, "node2": 600000, "node3": 1200000})
+	prom.SetConst(fakeprom.DiskWritten, map[string]float64{"node1": 1024, "node2": 2048, "node3": 4096})
+	prom.SetConst(fakeprom.CPUSeconds, map[string]float64{"node1": 0.2, "node2": 0.4, "node3": 0.6})
+	prom.SetConst(fakeprom.MemAvailable, map[string]float64{"node1": 0.3, "node2": 0.5, "node3": 0.7})
+	s := newFakePromService(t, prom, false, `
+[policy.policies.pack]
+algorithm = "cmdn"
+mode = "compact"
+
+[shadow]
+policies = ["pack", "cluster"]
+`)
+
+	nodeNames := []string{"node1", "node2", "node3"}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}
+	args := &extenderv1.ExtenderArgs{Pod: pod, NodeNames: &nodeNames}
+	live, err := s.Prioritize(args)
+	if err != nil {
+		t.Fatalf("prioritize error: %v", err)
+	}
+	// 线上为cluster策略，影子中的cluster与线上完全相同，pack不翻转得分，排序相反
+	if _, err = s.Prioritize(args); err != nil {
+		t.Fatalf("prioritize error: %v", err)
+	}
+	for _, hp := range *live {
+		if hp.Host == "node1" && hp.Score != model.MaxNodeScore {
+			t.Errorf("live result should not be affected by shadows, but get %+v", *live)
+		}
+	}
+
+	s.shadowWorker.flush()
+	s.records.flush()
+	decisions, err := s.QueryDecisions(&model.DecisionFilter{})
+	if err != nil || len(decisions) != 2 {
+		t.Fatalf("should record 2 decisions, but get %v %v", decisions, err)
+	}
+	shadows := decisions[0].Shadows
+	if len(shadows) != 2 || shadows[0].Policy != "pack" || len(shadows[0].Scores) != 3 {
+		t.Fatalf("decision should record both shadows, but get %+v", shadows)
+	}
+	if shadows[0].Top1Agree || shadows[0].KendallTau != -1 {
+		t.Errorf("pack should reverse the live ranking, but get %+v", shadows[0])
+	}
+	if !shadows[1].Top1Agree || shadows[1].KendallTau != 1 {
+		t.Errorf("cluster shadow should agree with live, but get %+v", shadows[1])
+	}
+
+	report := s.ShadowReport("")
+	if len(report.Policies) != 2 || report.Bucket != "10m0s" {
+		t.Fatalf("report should contain both shadows, but get %+v", report)
+	}
+	pack := report.Policies[0]
+	if pack.Total.Count != 2 || pack.Total.Top1Agreement != 0 || pack.Total.MeanKendallTau != -1 || len(pack.Buckets) != 1 {
+		t.Errorf("pack report is wrong: %+v", pack)
+	}
+	if r := s.ShadowReport("cluster"); len(r.Policies) != 1 || r.Policies[0].Total.Top1Agreement != 1 || r.Policies[0].Algorithm != model.AlgoCMDN {
+		t.Errorf("cluster report is wrong: %+v", r)
+	}
+
+	// 影子评分队列满时丢弃影子评分，线上结果和审计记录不受影响
+	s.shadowWorker.close()
+	s.shadowWorker = &recorder{name: "shadow", jobs: make(chan func()), stop: make(chan struct{})}
+	if _, err = s.Prioritize(args); err != nil {
+		t.Fatalf("prioritize error: %v", err)
+	}
+	s.records.flush()
+	decisions, err = s.QueryDecisions(&model.DecisionFilter{})
+	if err != nil || len(decisions) != 3 || len(decisions[0].Shadows) != 0 {
+		t.Fatalf("decision should be recorded without shadows, but get %v %v", decisions, err)
+	}
+	if r := s.ShadowReport(""); r.Dropped != 1 || r.Policies[0].Total.Count != 2 || r.Policies[0].Total.Dropped != 1 {
+		t.Errorf("dropped shadow should only be counted as dropped, but get %+v", r)
+	}
+}
+
+func TestService_ShadowLiveSnapshot(t *testing.T) {
+	prom := fakeprom.New()
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 100000, "node2": 600000, "node3": 1200000})
+	prom.SetConst(fakeprom.DiskWritten, map[string]float64{"node1": 1024, "node2": 2048, "node3": 4096})
+	prom.SetConst(fakeprom.CPUSeconds, map[string]float64{"node1": 0.2, "node2": 0.4, "node3": 0.6})
+	prom.SetConst(fakeprom.MemAvailable, map[string]float64{"node1": 0.3, "node2": 0.5, "node3": 0.7})
+	s := newFakePromService(t, prom, true, `
+[policy.policies.pack]
+algorithm = "cmdn"
+mode = "compact"
+
+[shadow]
+policies = ["pack"]
+`)
+	if !s.shadowNeedsAllMetrics() {
+		t.Fatalf("cmdn shadow should need all metrics")
+	}
+
+	// 线上为bnp，有cmdn影子策略时使用全部指标的快照，影子评分在同一份快照上进行
+	nodeNames := []string{"node1", "node2", "node3"}
+	args := &extenderv1.ExtenderArgs{Pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}, NodeNames: &nodeNames}
+	live, in, _, err := s.bnpScore(args, s.ResolvePolicy(args.Pod))
+	if err != nil {
+		t.Fatalf("bnp score error: %v", err)
+	}
+	if _, ok := in.Snapshot[model.ResourceCPUKey]; !ok {
+		t.Fatalf("live snapshot should contain all metrics, but get %v", in.Snapshot)
+	}
+	shadows := s.shadowScore(time.Now(), args, in, live, nil)
+	if len(shadows) != 1 || shadows[0].Error != "" || len(shadows[0].Scores) != 3 {
+		t.Fatalf("cmdn shadow should score on the live snapshot, but get %+v", shadows)
+	}
+	if in.Algorithm != model.AlgoBNP || in.CMDNCache != nil {
+		t.Errorf("live input should not be modified by shadows, but get %+v", in)
+	}
+	if shadows = s.shadowScore(time.Now(), args, in, nil, fmt.Errorf("no node")); shadows[0].Error != "live scoring failed" {
+		t.Errorf("shadow should not score when live scoring fails, but get %+v", shadows[0])
+	}
+}
+
+func TestService_ObserveShadowBuckets(t *testing.T) {
+	s := &Service{shadowCfg: DefaultShadowConfig(), policyCfg: DefaultPolicyConfig()}
+	s.policyCfg.Policies = map[string]model.Policy{"pack": {Algorithm: model.AlgoCMDN}}
+	s.shadowCfg.Policies = []string{"pack"}
+	s.shadowCfg.MaxBuckets = 2
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, tau := range []float64{1, 0.5, -0.5} {
+		s.observeShadow(start.Add(time.Duration(i)*11*time.Minute), &model.ShadowResult{Policy: "pack", KendallTau: tau, Top1Agree: tau > 0})
+	}
+	s.observeShadow(start.Add(22*time.Minute), &model.ShadowResult{Policy: "pack", Error: "live scoring failed"})
+
+	report := s.ShadowReport("pack").Policies[0]
+	if len(report.Buckets) != 2 || !report.Buckets[0].Start.Equal(start.Add(10*time.Minute)) {
+		t.Fatalf("should keep the last 2 buckets, but get %+v", report.Buckets)
+	}
+	if total := report.Total; total.Count != 2 || total.Errors != 1 || total.MinKendallTau != -0.5 || total.Top1Agreement != 0.5 || total.MeanKendallTau != 0 {
+		t.Errorf("total is wrong: %+v", total)
+	}
+
+	// 后台完成较晚的比较和丢弃的比较按Prioritize的时间计入之前的时间段，早于保留的时间段时不计入
+	s.observeShadow(start.Add(33*time.Minute), &model.ShadowResult{Policy: "pack", KendallTau: 1, Top1Agree: true})
+	s.observeShadow(start.Add(25*time.Minute), &model.ShadowResult{Policy: "pack", KendallTau: 1, Top1Agree: true})
+	s.dropShadow(start.Add(21 * time.Minute))
+	s.dropShadow(start.Add(5 * time.Minute))
+	report = s.ShadowReport("pack").Policies[0]
+	if len(report.Buckets) != 2 || !report.Buckets[0].Start.Equal(start.Add(20*time.Minute)) || !report.Buckets[1].Start.Equal(start.Add(30*time.Minute)) {
+		t.Fatalf("should keep buckets at 20m and 30m, but get %+v", report.Buckets)
+	}
+	if b := report.Buckets[0]; b.Count != 2 || b.Dropped != 1 || b.Errors != 1 {
+		t.Errorf("bucket at 20m is wrong: %+v", b)
+	}
+	if report.Total.Dropped != 1 || report.Buckets[1].Count != 1 {
+		t.Errorf("total is wrong: %+v", report.Total)
+	}
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kratos/kratos/pkg/log"
+)
+
+// syncState 记录指标同步的状态，用于判断本地缓存中的数据是否新鲜
+type syncState struct {
+	mu          sync.RWMutex
+	updated     map[string]time.Time // 每个指标最近一次同步成功的时间
+	lastAttempt time.Time            // 最近一次尝试同步的时间，不论成功与否
+	lastErr     error
+}
+
+// bumpSnapshot 每次同步写入指标后递增快照版本号
+func (s *Service) bumpSnapshot() int64 {
+	return atomic.AddInt64(&s.snapVersion, 1)
+}
+
+// writeSnapshot 在写锁内把一次同步得到的指标写入本地缓存，写入了指标时递增快照版本号，返回第一个写入错误
+// 同一个版本号总是对应同一份指标，预先计算的cmdn评分数据和负载预测按版本号判断是否可用
+func (s *Service) writeSnapshot(values map[string](map[string]int64)) error {
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+
+	var res error
+	written := make([]string, 0, len(values))
+	for key, v := range values {
+		if err := s.dao.SetKV(key, v); err != nil {
+			log.Error("[writeSnapshot] SetKV %s error: %v", key, err)
+			if res == nil {
+				res = err
+			}
+			continue
+		}
+		written = append(written, key)
+	}
+	if len(written) > 0 {
+		s.markSynced(written...)
+		s.bumpSnapshot()
+	}
+
+	return res
+}
+
+// readSnapshot 在读锁内读取本地缓存中的指标和对应的快照版本号，不会读到同步写了一半的数据
+func (s *Service) readSnapshot(read func() (map[string](map[string]int64), error)) (map[string](map[string]int64), int64, error) {
+	s.snapMu.RLock()
+	defer s.snapMu.RUnlock()
+	res, err := read()
+
+	return res, s.snapshotVersion(), err
+}
+
+// snapshotVersion 当前本地缓存中指标快照的版本号
+func (s *Service) snapshotVersion() int64 {
+	return atomic.LoadInt64(&s.snapVersion)
+}
+
+// markSynced 记录指标key同步成功的时间
+func (s *Service) markSynced(keys ...string) {
+	now := time.Now()
+	s.syncState.mu.Lock()
+	defer s.syncState.mu.Unlock()
+	if s.syncState.updated == nil {
+		s.syncState.updated = make(map[string]time.Time)
+	}
+	for _, key := range keys {
+		s.syncState.updated[key] = now
+	}
+}
+
+// markAttempt 记录一次同步尝试及其结果
+func (s *Service) markAttempt(err error) {
+	s.syncState.mu.Lock()
+	defer s.syncState.mu.Unlock()
+	s.syncState.lastAttempt = time.Now()
+	s.syncState.lastErr = err
+}
+
+// lastSyncAttempt 返回最近一次同步尝试的时间和错误
+func (s *Service) lastSyncAttempt() (time.Time, error) {
+	s.syncState.mu.RLock()
+	defer s.syncState.mu.RUnlock()
+
+	return s.syncState.lastAttempt, s.syncState.lastErr
+}
+
+// metricUpdated 返回指标key最近一次同步成功的时间
+func (s *Service) metricUpdated(key string) (time.Time, bool) {
+	s.syncState.mu.RLock()
+	defer s.syncState.mu.RUnlock()
+	t, ok := s.syncState.updated[key]
+
+	return t, ok
+}
+
+// requiredMetrics 评分依赖的指标，配置了多个策略时为所有策略依赖的指标的并集
+func (s *Service) requiredMetrics() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, p := range s.allPolicies() {
+		for _, key := range scoreKeys(p.Algorithm, p.Balance) {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys
+}
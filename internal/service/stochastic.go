@@ -0,0 +1,186 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"liang/internal/model"
+
+	"github.com/go-kratos/kratos/pkg/log"
+	xtime "github.com/go-kratos/kratos/pkg/time"
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/gonum/stat/distuv"
+	v1 "k8s.io/api/core/v1"
+)
+
+// stochasticKeys 有效带宽模型需要标准差的网络负载指标
+var stochasticKeys = []string{model.ResourceNetIOKey, model.ResourceNetInKey, model.ResourceNetOutKey}
+
+// StochasticConfig bnp有效带宽模型的配置，对应application.toml中的[stochasticNet]
+// 节点和Pod的网络负载都视为独立的正态分布，调度后均值之和加上k倍合并标准差不超过网卡带宽时节点才可用
+// k为标准正态分布的1-OverloadProbability分位数，节点的均值和标准差来自最近Window内的历史数据
+// bnp按有效带宽过滤和评分，cmdn按有效带宽过滤节点
+type StochasticConfig struct {
+	Enabled             bool
+	OverloadProbability float64        // 允许的超载概率，越小越保守
+	Window              xtime.Duration // 计算节点网络负载均值和标准差的时间范围
+}
+
+// DefaultStochasticConfig 没有配置[stochasticNet]时按照确定的网络需求过滤和评分
+func DefaultStochasticConfig() StochasticConfig {
+	return StochasticConfig{
+		OverloadProbability: 0.05,
+		Window:              xtime.Duration(10 * time.Minute),
+	}
+}
+
+// Validate 检查超载概率和时间范围，超载概率大于0.5时k为负数，没有意义
+func (cfg StochasticConfig) Validate() error {
+	if cfg.OverloadProbability <= 0 || cfg.OverloadProbability > 0.5 {
+		return fmt.Errorf("overload probability %v should be in (0, 0.5]", cfg.OverloadProbability)
+	}
+	if cfg.Window <= 0 {
+		return fmt.Errorf("stochastic net window should be positive")
+	}
+
+	return nil
+}
+
+// K 超载概率对应的标准正态分布分位数，如0.05对应1.645
+func (cfg StochasticConfig) K() float64 {
+	return distuv.UnitNormal.Quantile(1 - cfg.OverloadProbability)
+}
+
+// stochasticState 节点网络负载的均值和标准差只在同步后变化，按快照版本缓存，避免每次评分都遍历历史数据
+type stochasticState struct {
+	mu      sync.Mutex
+	version int64
+	net     *model.StochasticNet
+}
+
+// stochasticNet 开启有效带宽模型时返回评分使用的k和节点网络负载的均值、标准差，否则返回nil
+func (s *Service) stochasticNet() *model.StochasticNet {
+	if !s.stochasticCfg.Enabled {
+		return nil
+	}
+
+	version := s.snapshotVersion()
+	s.stochastic.mu.Lock()
+	defer s.stochastic.mu.Unlock()
+	if s.stochastic.net != nil && s.stochastic.version == version {
+		return s.stochastic.net
+	}
+
+	since := time.Now().Add(-time.Duration(s.stochasticCfg.Window))
+	net := &model.StochasticNet{
+		K:      s.stochasticCfg.K(),
+		Mean:   make(map[string](map[string]float64), len(stochasticKeys)),
+		Stddev: make(map[string](map[string]float64), len(stochasticKeys)),
+	}
+	for _, key := range stochasticKeys {
+		history := s.dao.GetHistory(key, since)
+		mean := make(map[string]float64, len(history))
+		stddev := make(map[string]float64, len(history))
+		for name, samples := range history {
+			if len(samples) == 0 {
+				continue
+			}
+			values := make([]float64, len(samples))
+			for i, sample := range samples {
+				values[i] = float64(sample.Value)
+			}
+			if len(values) < 2 {
+				mean[name] = values[0]
+				continue
+			}
+			mean[name], stddev[name] = stat.MeanStdDev(values, nil)
+		}
+		net.Mean[key], net.Stddev[key] = mean, stddev
+	}
+	s.stochastic.version, s.stochastic.net = version, net
+	log.V(5).Info("stochastic net of snapshot %d is %+v", version, net)
+
+	return net
+}
+
+// GetPodNetStddev 从Pod注解中拿到网络需求的标准差，单位Kbit/s，LiangNetIO为需求的均值
+// LiangNetIOStddev直接声明标准差；只声明LiangNetIOPeak时峰值视为超载概率对应的分位数，标准差为(峰值-均值)/k
+func GetPodNetStddev(pod *v1.Pod, k float64) float64 {
+	parse := func(key string) (float64, bool) {
+		v, ok := pod.Annotations[key]
+		if !ok {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			log.Error("parse %s of %s to float64 error:%v", v, key, err)
+			return 0, false
+		}
+		if f < 0 {
+			log.Error("%s of %s should not be negative", v, key)
+			return 0, false
+		}
+		return f * model.KbitPS, true
+	}
+
+	if std, ok := parse(model.ResourceNetIOStddevKey); ok {
+		return std
+	}
+	if peak, ok := parse(model.ResourceNetIOPeakKey); ok && k > 0 {
+		return math.Max(peak-float64(GetPodNetIONeed(pod)), 0) / k
+	}
+
+	return 0
+}
+
+// podDirectionStddev 按两个方向的需求占LiangNetIO的比例分配标准差，没有LiangNetIO时两个方向的标准差相同
+func podDirectionStddev(std float64, demand model.NetDemand, netIO int64) (in, out float64) {
+	if netIO <= 0 {
+		return std, std
+	}
+
+	return std * float64(demand.In) / float64(netIO), std * float64(demand.Out) / float64(netIO)
+}
+
+// nodeNetMean 节点网络负载key的均值，有历史数据时使用Window内的均值，否则使用当前负载
+func nodeNetMean(net *model.StochasticNet, key, name string, cur float64) float64 {
+	if v, ok := net.Mean[key][name]; ok {
+		return v
+	}
+
+	return cur
+}
+
+// effectiveBandwidth 返回节点的有效负载nodeMean+k·nodeStd，以及Pod调度到节点后有效负载的增量
+// 两者独立时合并标准差为sqrt(nodeStd²+std²)，增量为mean+k·(sqrt(nodeStd²+std²)-nodeStd)
+func effectiveBandwidth(nodeMean, nodeStd, mean, std, k float64) (eff, delta float64) {
+	return nodeMean + k*nodeStd, mean + k*(math.Hypot(nodeStd, std)-nodeStd)
+}
+
+// FilterNodeByEffectiveNet 按网络负载key的有效带宽过滤节点，返回可用节点的有效负载、有效负载的增量和网卡带宽
+// 指标或带宽不存在的节点与FilterNodeByNetWithReason相同，没有历史数据的节点使用当前负载，标准差为0
+func FilterNodeByEffectiveNet(nodeNames []string, mean int64, std float64, curMap, capMap map[string]int64,
+	net *model.StochasticNet, key string) (valideNames []string, effArr, deltaArr, capArr []float64, rejected []model.NodeRejection) {
+	names, curArr, caps, rejected := FilterNodeByNetWithReason(nodeNames, 0, curMap, capMap)
+	for i, name := range names {
+		eff, delta := effectiveBandwidth(nodeNetMean(net, key, name, curArr[i]), net.Stddev[key][name], float64(mean), std, net.K)
+		if eff+delta > caps[i] {
+			log.V(5).Info("effective net %.0f plus request %.0f overflow net cap %.0f, skip", eff, delta, caps[i])
+			rejected = append(rejected, model.NodeRejection{
+				Host:   name,
+				Reason: fmt.Sprintf("effective net %.0f plus request %.0f overflow net cap %.0f", eff, delta, caps[i]),
+			})
+			continue
+		}
+
+		valideNames = append(valideNames, name)
+		effArr = append(effArr, eff)
+		deltaArr = append(deltaArr, delta)
+		capArr = append(capArr, caps[i])
+	}
+
+	return
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"liang/internal/fakeprom"
+	"liang/internal/model"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func TestStochasticConfig(t *testing.T) {
+	cfg := DefaultStochasticConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("default config should be valid, but get %v", err)
+	}
+	if k := cfg.K(); math.Abs(k-1.6449) > 1e-4 {
+		t.Errorf("k of overload probability 0.05 should be 1.6449, but get %v", k)
+	}
+
+	for _, p := range []float64{0, 0.6, -0.1} {
+		cfg.OverloadProbability = p
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("overload probability %v should be invalid", p)
+		}
+	}
+	cfg = DefaultStochasticConfig()
+	cfg.Window = 0
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("zero window should be invalid")
+	}
+}
+
+func TestGetPodNetStddev(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Annotations map[string]string
+		Expected    float64
+	}{
+		{Name: "stddev", Annotations: map[string]string{model.ResourceNetIOKey: "10", model.ResourceNetIOStddevKey: "2.5"}, Expected: 2500},
+		{Name: "stddev first", Annotations: map[string]string{model.ResourceNetIOStddevKey: "1", model.ResourceNetIOPeakKey: "100"}, Expected: 1000},
+		// (30-10)/2
+		{Name: "peak", Annotations: map[string]string{model.ResourceNetIOKey: "10", model.ResourceNetIOPeakKey: "30"}, Expected: 10000},
+		{Name: "peak below mean", Annotations: map[string]string{model.ResourceNetIOKey: "10", model.ResourceNetIOPeakKey: "5"}},
+		{Name: "invalid", Annotations: map[string]string{model.ResourceNetIOKey: "10", model.ResourceNetIOStddevKey: "-1"}},
+		{Name: "fixed", Annotations: map[string]string{model.ResourceNetIOKey: "10"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: c.Annotations}}
+			if std := GetPodNetStddev(pod, 2); std != c.Expected {
+				t.Errorf("stddev should be %v, but get %v", c.Expected, std)
+			}
+		})
+	}
+}
+
+func TestBalanceNetloadPriority_Stochastic(t *testing.T) {
+	nodeNames := []string{"node1", "node2", "node3"}
+	curMap := map[string]int64{"node1": 40 * model.KbitPS, "node2": 50 * model.KbitPS, "node3": 60 * model.KbitPS}
+	capMap := map[string]int64{"node1": 100 * model.KbitPS, "node2": 100 * model.KbitPS, "node3": 100 * model.KbitPS}
+	// node1当前负载最低，但是波动很大
+	stochastic := &model.StochasticNet{
+		K:      2,
+		Stddev: map[string](map[string]float64){model.ResourceNetIOKey: {"node1": 20 * model.KbitPS}},
+	}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{model.ResourceNetIOKey: "10"}}}
+
+	bnp := BalanceNetloadPriority{}
+	res, _ := bnp.Score(pod, nodeNames, curMap, capMap)
+	if res[0].Score != model.MaxNodeScore {
+		t.Errorf("deterministic score should prefer node1, but get %v", res)
+	}
+
+	// 有效负载为80、50、60
+	bnp = BalanceNetloadPriority{Stochastic: stochastic}
+	res, explain, err := bnp.Explain(pod, nodeNames, curMap, capMap)
+	if err != nil {
+		t.Fatalf("explain error: %v", err)
+	}
+	if res[1].Score != model.MaxNodeScore || res[0].Score != model.MinNodeScore {
+		t.Errorf("stochastic score should prefer node2 and avoid node1, but get %v", res)
+	}
+	if explain.K != 2 || len(explain.Nodes) != 3 || explain.Nodes[0].Current != 80*model.KbitPS || explain.Nodes[0].Stddev != 20*model.KbitPS {
+		t.Errorf("explanation should contain effective load, but get %+v", explain)
+	}
+
+	// Pod自身标准差为20Mbps，node1增量为10+2*(28.28-20)，node2增量为50，node3超出带宽
+	pod.Annotations[model.ResourceNetIOStddevKey] = "20"
+	res, explain, _ = bnp.Explain(pod, nodeNames, curMap, capMap)
+	expected := extenderv1.HostPriorityList{
+		{Host: "node1", Score: model.MinNodeScore},
+		{Host: "node2", Score: model.MaxNodeScore},
+		{Host: "node3", Score: model.MinNodeScore},
+	}
+	if !reflect.DeepEqual(res, expected) || len(explain.Rejected) != 2 || explain.NetStddev != 20*model.KbitPS {
+		t.Errorf("only node2 should be admitted, but get %v, explanation %+v", res, explain)
+	}
+
+	// 没有波动时与确定的需求相同
+	pod.Annotations = map[string]string{model.ResourceNetIOKey: "10"}
+	bnp.Stochastic = &model.StochasticNet{K: 2}
+	stochasticRes, _ := bnp.Score(pod, nodeNames, curMap, capMap)
+	deterministicRes, _ := (&BalanceNetloadPriority{}).Score(pod, nodeNames, curMap, capMap)
+	if !reflect.DeepEqual(stochasticRes, deterministicRes) {
+		t.Errorf("score without variance should be %v, but get %v", deterministicRes, stochasticRes)
+	}
+}
+
+func TestBalanceNetloadPriority_StochasticDuplex(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				model.ResourceNetIOKey:       "10",
+				model.ResourceNetOutKey:      "2",
+				model.ResourceNetIOStddevKey: "10",
+			},
+		},
+	}
+	nodeNames := []string{"node1", "node2", "node3"}
+	curIn := map[string]int64{"node1": 20 * model.KbitPS, "node2": 20 * model.KbitPS, "node3": 20 * model.KbitPS}
+	curOut := map[string]int64{"node1": 95 * model.KbitPS, "node2": 20 * model.KbitPS, "node3": 20 * model.KbitPS}
+	capMap := map[string]model.NetCapacity{
+		"node1": {In: 100 * model.KbitPS, Out: 100 * model.KbitPS},
+		"node2": {In: 100 * model.KbitPS, Out: 100 * model.KbitPS},
+		"node3": {In: 100 * model.KbitPS, Out: 100 * model.KbitPS},
+	}
+	bnp := BalanceNetloadPriority{Stochastic: &model.StochasticNet{
+		K:      2,
+		Stddev: map[string](map[string]float64){model.ResourceNetOutKey: {"node2": 30 * model.KbitPS}},
+	}}
+
+	// 上行标准差按需求比例为2Mbps，node1上行超出带宽，node2上行有效负载为80Mbps
+	res, explain, err := bnp.ExplainDuplex(pod, nodeNames, curIn, curOut, capMap)
+	if err != nil {
+		t.Fatalf("explain duplex error: %v", err)
+	}
+	expected := extenderv1.HostPriorityList{
+		{Host: "node1", Score: model.MinNodeScore},
+		{Host: "node2", Score: model.MinNodeScore},
+		{Host: "node3", Score: model.MaxNodeScore},
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("stochastic duplex score should be %v, but get %v", expected, res)
+	}
+	if len(explain.Rejected) != 1 || !strings.HasPrefix(explain.Rejected[0].Reason, "out: effective net") {
+		t.Errorf("node1 should be rejected by upload, but get %+v", explain.Rejected)
+	}
+	if len(explain.Nodes) != 2 || explain.Nodes[0].OutStddev != 30*model.KbitPS || explain.Nodes[0].OutCurrent != 80*model.KbitPS {
+		t.Errorf("explanation should contain effective upload load, but get %+v", explain.Nodes)
+	}
+}
+
+func TestBalanceNetloadPriority_StochasticMean(t *testing.T) {
+	nodeNames := []string{"node1", "node2"}
+	curMap := map[string]int64{"node1": 10 * model.KbitPS, "node2": 40 * model.KbitPS}
+	capMap := map[string]int64{"node1": 100 * model.KbitPS, "node2": 100 * model.KbitPS}
+	// node1此刻负载很低，但是Window内的均值为90Mbps
+	net := &model.StochasticNet{
+		K:      2,
+		Mean:   map[string](map[string]float64){model.ResourceNetIOKey: {"node1": 90 * model.KbitPS}},
+		Stddev: map[string](map[string]float64){model.ResourceNetIOKey: {"node1": 5 * model.KbitPS}},
+	}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{model.ResourceNetIOKey: "5"}}}
+
+	bnp := BalanceNetloadPriority{Stochastic: net}
+	_, explain, err := bnp.Explain(pod, nodeNames, curMap, capMap)
+	if err != nil {
+		t.Fatalf("explain error: %v", err)
+	}
+	// 有效负载为90+2*5，加上需求后超出带宽；node2没有历史数据，使用当前负载
+	if len(explain.Rejected) != 1 || explain.Rejected[0].Host != "node1" {
+		t.Errorf("node1 should be rejected by its mean load, but get %+v", explain.Rejected)
+	}
+	if len(explain.Nodes) != 1 || explain.Nodes[0].Current != 40*model.KbitPS {
+		t.Errorf("node2 should use its current load, but get %+v", explain.Nodes)
+	}
+
+	// cmdn同样按有效带宽过滤节点
+	cacheData := map[string](map[string]int64){
+		model.ResourceCPUKey:    {"node1": 10, "node2": 10},
+		model.ResourceMemKey:    {"node1": 10, "node2": 10},
+		model.ResourceNetIOKey:  curMap,
+		model.ResourceDiskIOKey: {"node1": 10, "node2": 10},
+	}
+	_, cmdnExplain, err := (&CMDNPriority{Stochastic: net}).Explain(pod, nodeNames, capMap, cacheData)
+	if err != nil {
+		t.Fatalf("cmdn explain error: %v", err)
+	}
+	if len(cmdnExplain.Rejected) != 1 || cmdnExplain.Rejected[0].Host != "node1" || !reflect.DeepEqual(cmdnExplain.Nodes, []string{"node2"}) {
+		t.Errorf("cmdn should reject node1 by effective bandwidth, but get %+v", cmdnExplain.Rejected)
+	}
+}
+
+func TestService_StochasticNet(t *testing.T) {
+	prom := fakeprom.New()
+	prom.SetConst(fakeprom.NetReceive, map[string]float64{"node1": 100000, "node2": 600000, "node3": 1200000})
+	s := newFakePromService(t, prom, true, `
+[stochasticNet]
+enabled = true
+overloadProbability = 0.01
+window = "10m"
+`)
+
+	net := s.stochasticNet()
+	if net == nil || math.Abs(net.K-2.3263) > 1e-4 {
+		t.Fatalf("k of overload probability 0.01 should be 2.3263, but get %+v", net)
+	}
+	if s.stochasticNet() != net {
+		t.Errorf("stddev should be cached until the next sync")
+	}
+	if v := net.Mean[model.ResourceNetIOKey]["node1"]; v <= 0 {
+		t.Errorf("mean net load of node1 should come from history, but get %v", v)
+	}
+	s.bumpSnapshot()
+	if s.stochasticNet() == net {
+		t.Errorf("stddev should be recalculated after sync")
+	}
+
+	nodeNames := []string{"node1", "node2", "node3"}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{model.ResourceNetIOKey: "10", model.ResourceNetIOPeakKey: "20"}}}
+	explain, err := s.Explain(&extenderv1.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+	if err != nil {
+		t.Fatalf("explain error: %v", err)
+	}
+	if explain.BNP == nil || explain.BNP.K != net.K || explain.BNP.NetStddev == 0 {
+		t.Errorf("bnp explanation should use the effective bandwidth model, but get %+v", explain.BNP)
+	}
+}
@@ -0,0 +1,196 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"liang/internal/model"
+	"liang/internal/service"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// 模拟器支持的调度算法
+const (
+	AlgoBNP            = "bnp"
+	AlgoBNPBalanced    = "bnp-balanced"
+	AlgoCMDNBalance    = "cmdn-balance"
+	AlgoCMDNCompact    = "cmdn-compact"
+	AlgoLeastAllocated = "least-allocated"
+	// AlgoTrace 按照trace中记录的原始节点放置，作为生产调度器的对照组
+	AlgoTrace = "trace"
+)
+
+// Algorithms 返回全部算法名称，顺序即报告中的顺序
+func Algorithms() []string {
+	return []string{AlgoBNP, AlgoBNPBalanced, AlgoCMDNBalance, AlgoCMDNCompact, AlgoLeastAllocated}
+}
+
+// scoreFunc 对候选节点评分，candidates中的节点都已经满足资源限制
+type scoreFunc func(pod *v1.Pod, candidates []*nodeState) (extenderv1.HostPriorityList, error)
+
+func getScoreFunc(algo string) (scoreFunc, error) {
+	switch algo {
+	case AlgoBNP:
+		return scoreBNP, nil
+	case AlgoBNPBalanced:
+		return scoreBNPBalanced, nil
+	case AlgoCMDNBalance:
+		return func(pod *v1.Pod, candidates []*nodeState) (extenderv1.HostPriorityList, error) {
+			return scoreCMDN(pod, candidates, true)
+		}, nil
+	case AlgoCMDNCompact:
+		return func(pod *v1.Pod, candidates []*nodeState) (extenderv1.HostPriorityList, error) {
+			return scoreCMDN(pod, candidates, false)
+		}, nil
+	case AlgoLeastAllocated:
+		return scoreLeastAllocated, nil
+	case AlgoTrace:
+		// 不评分，由simulator直接使用原始节点
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("unknown algorithm %s, should be one of %v", algo, Algorithms())
+}
+
+// newPod 构造带有Liang注解的Pod，网络需求向上取整到Mbps，磁盘需求单位MB/s
+func newPod(event *PodEvent) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: event.Name,
+			Annotations: map[string]string{
+				model.ResourceNetIOKey:  strconv.FormatInt(podNetNeed(event), 10),
+				model.ResourceDiskIOKey: strconv.FormatFloat(event.DiskIO/model.MByte, 'f', -1, 64),
+				model.ResourceCPUKey:    strconv.FormatFloat(event.CPU, 'f', -1, 64),
+				model.ResourceMemKey:    strconv.FormatFloat(event.Mem, 'f', -1, 64),
+			},
+		},
+	}
+}
+
+// podNetNeed Pod的网络需求，单位Mbps，和注解保持一致
+func podNetNeed(event *PodEvent) int64 {
+	return int64(math.Ceil(event.NetIO))
+}
+
+func candidateNames(candidates []*nodeState) []string {
+	names := make([]string, len(candidates))
+	for i, node := range candidates {
+		names[i] = node.spec.Name
+	}
+
+	return names
+}
+
+// netMaps 返回节点当前网络负载和网卡带宽，单位Kbit/s
+func netMaps(candidates []*nodeState) (curMap, capMap map[string]int64) {
+	curMap = make(map[string]int64, len(candidates))
+	capMap = make(map[string]int64, len(candidates))
+	for _, node := range candidates {
+		curMap[node.spec.Name] = int64(math.Round(node.netIO * model.KbitPS))
+		capMap[node.spec.Name] = int64(math.Round(node.spec.NetCap * model.KbitPS))
+	}
+
+	return
+}
+
+func scoreBNP(pod *v1.Pod, candidates []*nodeState) (extenderv1.HostPriorityList, error) {
+	curMap, capMap := netMaps(candidates)
+	bnp := service.BalanceNetloadPriority{}
+
+	return bnp.Score(pod, candidateNames(candidates), curMap, capMap)
+}
+
+// scoreBNPBalanced 网络、磁盘、CPU和内存等权重的多资源均衡
+// 没有磁盘能力的节点使用默认的磁盘吞吐能力
+func scoreBNPBalanced(pod *v1.Pod, candidates []*nodeState) (extenderv1.HostPriorityList, error) {
+	curMap, capMap := netMaps(candidates)
+	cur := map[string](map[string]int64){
+		model.ResourceDiskIOKey: make(map[string]int64, len(candidates)),
+		model.ResourceCPUKey:    make(map[string]int64, len(candidates)),
+		model.ResourceMemKey:    make(map[string]int64, len(candidates)),
+	}
+	capacity := map[string](map[string]int64){
+		model.ResourceDiskIOKey: make(map[string]int64, len(candidates)),
+		model.ResourceCPUKey:    make(map[string]int64, len(candidates)),
+		model.ResourceMemKey:    make(map[string]int64, len(candidates)),
+	}
+	defaultDiskCap := service.DefaultDiskCapacityConfig().Default.Throughput * model.MByte
+	for _, node := range candidates {
+		name := node.spec.Name
+		cur[model.ResourceDiskIOKey][name] = int64(math.Round(node.diskIO))
+		cur[model.ResourceCPUKey][name] = int64(math.Round(node.cpu))
+		cur[model.ResourceMemKey][name] = int64(math.Round(node.mem))
+		diskCap := node.spec.DiskCap
+		if diskCap <= 0 {
+			diskCap = defaultDiskCap
+		}
+		capacity[model.ResourceDiskIOKey][name] = int64(math.Round(diskCap))
+		capacity[model.ResourceCPUKey][name] = 100
+		capacity[model.ResourceMemKey][name] = 100
+	}
+
+	demand := service.GetPodBalanceDemand(pod)
+	dims := []service.BalanceDimension{{Key: model.ResourceNetIOKey, Weight: 1, Need: demand[model.ResourceNetIOKey], Cur: curMap, Cap: capMap}}
+	for _, key := range []string{model.ResourceDiskIOKey, model.ResourceCPUKey, model.ResourceMemKey} {
+		dims = append(dims, service.BalanceDimension{Key: key, Weight: 1, Need: demand[key], Cur: cur[key], Cap: capacity[key]})
+	}
+	brp := service.BalancedResourcePriority{Mode: model.BalanceWeighted}
+
+	return brp.Score(candidateNames(candidates), dims)
+}
+
+// scoreCMDN balance为true时翻转TOPSIS得分，与配置topsisMin=true一致
+func scoreCMDN(pod *v1.Pod, candidates []*nodeState, balance bool) (extenderv1.HostPriorityList, error) {
+	curMap, capMap := netMaps(candidates)
+	cpuMap := make(map[string]int64, len(candidates))
+	memMap := make(map[string]int64, len(candidates))
+	diskMap := make(map[string]int64, len(candidates))
+	for _, node := range candidates {
+		cpuMap[node.spec.Name] = int64(math.Round(node.cpu))
+		memMap[node.spec.Name] = int64(math.Round(node.mem))
+		diskMap[node.spec.Name] = int64(math.Round(node.diskIO))
+	}
+	cacheData := map[string](map[string]int64){
+		model.ResourceNetIOKey:  curMap,
+		model.ResourceCPUKey:    cpuMap,
+		model.ResourceMemKey:    memMap,
+		model.ResourceDiskIOKey: diskMap,
+	}
+	diskCapMap := make(map[string]int64, len(candidates))
+	for _, node := range candidates {
+		if node.spec.DiskCap > 0 {
+			diskCapMap[node.spec.Name] = int64(math.Round(node.spec.DiskCap))
+		}
+	}
+	if len(diskCapMap) > 0 {
+		cacheData[model.ResourceDiskCapKey] = diskCapMap
+	}
+
+	cmdn := service.CMDNPriority{}
+	res, err := cmdn.Score(pod, candidateNames(candidates), capMap, cacheData)
+	if err == nil && balance {
+		for i := range res {
+			res[i].Score = model.MaxNodeScore - res[i].Score
+		}
+	}
+
+	return res, err
+}
+
+// scoreLeastAllocated 对照组，与默认调度器的LeastAllocated相同，CPU和内存剩余越多得分越高
+func scoreLeastAllocated(pod *v1.Pod, candidates []*nodeState) (extenderv1.HostPriorityList, error) {
+	res := make(extenderv1.HostPriorityList, len(candidates))
+	for i, node := range candidates {
+		free := (100-node.cpu)/100 + (100-node.mem)/100
+		res[i] = extenderv1.HostPriority{
+			Host:  node.spec.Name,
+			Score: int64(free * model.MaxNodeScore / 2),
+		}
+	}
+
+	return res, nil
+}
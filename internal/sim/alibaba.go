@@ -0,0 +1,157 @@
+package sim
+
+import (
+	"fmt"
+)
+
+// Alibaba cluster-trace-v2018 machine_meta/container_meta/container_usage中用到的列
+const (
+	aliMachineIDCol  = 0
+	aliMachineCPUCol = 4
+	aliMachineMemCol = 5
+
+	aliMetaIDCol         = 0
+	aliMetaCPURequestCol = 5
+	aliMetaMemCol        = 7
+
+	aliUsageIDCol      = 0
+	aliUsageMachineCol = 1
+	aliUsageTimeCol    = 2
+	aliUsageCPUCol     = 3
+	aliUsageMemCol     = 4
+	aliUsageNetInCol   = 8
+	aliUsageNetOutCol  = 9
+	aliUsageDiskIOCol  = 10
+
+	// container_usage的采样间隔，单位秒
+	aliUsageInterval = 10
+)
+
+type aliContainer struct {
+	name    string
+	machine string
+	start   float64
+	end     float64
+	cpu     float64
+	mem     float64
+	netIO   float64
+	diskIO  float64
+	samples float64
+}
+
+// LoadAlibabaTrace 导入Alibaba cluster-trace-v2018格式的machine_meta、container_meta和container_usage
+// cpu_request单位为1/100核，换算为占所在机器cpu_num的百分比；mem_size在trace中统一归一化，
+// 按容器与机器mem_size的比例换算；net_in/net_out和disk_io_percent是0-100的归一化值，
+// 分别按NICCap和DiskBandwidth换算，网络需求取上下行中的较大值
+func LoadAlibabaTrace(machineFile, metaFile, usageFile string, mapping TraceMapping) (*Cluster, *Trace, error) {
+	machineRecords, err := readCSV(machineFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	type machineCap struct{ cpu, mem float64 }
+	caps := make(map[string]machineCap)
+	cluster := &Cluster{}
+	for i, record := range machineRecords {
+		if len(record) <= aliMachineMemCol {
+			return nil, nil, fmt.Errorf("%s line %d: expect at least %d columns", machineFile, i+1, aliMachineMemCol+1)
+		}
+		id := record[aliMachineIDCol]
+		if _, ok := caps[id]; ok {
+			continue
+		}
+		cpu, err1 := parseFloat(record[aliMachineCPUCol])
+		mem, err2 := parseFloat(record[aliMachineMemCol])
+		if err1 != nil || err2 != nil || cpu <= 0 || mem <= 0 {
+			continue
+		}
+		caps[id] = machineCap{cpu: cpu, mem: mem}
+		cluster.Nodes = append(cluster.Nodes, NodeSpec{Name: id, NetCap: mapping.NICCap, DiskCap: mapping.DiskBandwidth})
+	}
+
+	metaRecords, err := readCSV(metaFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	type containerReq struct{ cpu, mem float64 }
+	reqs := make(map[string]containerReq)
+	for i, record := range metaRecords {
+		if len(record) <= aliMetaMemCol {
+			return nil, nil, fmt.Errorf("%s line %d: expect at least %d columns", metaFile, i+1, aliMetaMemCol+1)
+		}
+		cpu, err1 := parseFloat(record[aliMetaCPURequestCol])
+		mem, err2 := parseFloat(record[aliMetaMemCol])
+		if err := firstErr(err1, err2); err != nil {
+			return nil, nil, fmt.Errorf("%s line %d: %v", metaFile, i+1, err)
+		}
+		reqs[record[aliMetaIDCol]] = containerReq{cpu: cpu / 100, mem: mem}
+	}
+
+	usageRecords, err := readCSV(usageFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	containers := make(map[string]*aliContainer)
+	order := make([]string, 0)
+	for i, record := range usageRecords {
+		if len(record) <= aliUsageDiskIOCol {
+			return nil, nil, fmt.Errorf("%s line %d: expect at least %d columns", usageFile, i+1, aliUsageDiskIOCol+1)
+		}
+		id, machine := record[aliUsageIDCol], record[aliUsageMachineCol]
+		mc, ok := caps[machine]
+		if !ok {
+			continue
+		}
+		req, ok := reqs[id]
+		if !ok {
+			continue
+		}
+		ts, err1 := parseFloat(record[aliUsageTimeCol])
+		cpu, err2 := parseFloat(record[aliUsageCPUCol])
+		mem, err3 := parseFloat(record[aliUsageMemCol])
+		netIn, err4 := parseFloat(record[aliUsageNetInCol])
+		netOut, err5 := parseFloat(record[aliUsageNetOutCol])
+		disk, err6 := parseFloat(record[aliUsageDiskIOCol])
+		if err := firstErr(err1, err2, err3, err4, err5, err6); err != nil {
+			return nil, nil, fmt.Errorf("%s line %d: %v", usageFile, i+1, err)
+		}
+
+		c, ok := containers[id]
+		if !ok {
+			c = &aliContainer{name: id, machine: machine, start: ts, end: ts}
+			containers[id] = c
+			order = append(order, id)
+		}
+		if ts < c.start {
+			c.start = ts
+		}
+		if ts > c.end {
+			c.end = ts
+		}
+		c.cpu += cpu / 100 * req.cpu / mc.cpu * 100
+		c.mem += mem / 100 * req.mem / mc.mem * 100
+		netMax := netIn
+		if netOut > netMax {
+			netMax = netOut
+		}
+		c.netIO += netMax / 100 * mapping.NICCap
+		c.diskIO += disk / 100 * mapping.DiskBandwidth
+		c.samples++
+	}
+
+	events := make([]PodEvent, 0, len(order))
+	for _, id := range order {
+		c := containers[id]
+		events = append(events, PodEvent{
+			Name:     c.name,
+			Node:     c.machine,
+			Arrival:  c.start,
+			Duration: c.end - c.start + aliUsageInterval,
+			NetIO:    c.netIO / c.samples,
+			CPU:      c.cpu / c.samples,
+			Mem:      c.mem / c.samples,
+			DiskIO:   c.diskIO / c.samples,
+		})
+	}
+
+	return cluster, newImportedTrace(events, mapping), nil
+}
@@ -0,0 +1,213 @@
+package sim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Google ClusterData 2011 machine_events和task_usage中用到的列
+const (
+	borgMachineTimeCol = 0
+	borgMachineIDCol   = 1
+	borgMachineTypeCol = 2
+	borgMachineCPUCol  = 4
+	borgMachineMemCol  = 5
+
+	borgUsageStartCol  = 0
+	borgUsageEndCol    = 1
+	borgUsageJobCol    = 2
+	borgUsageTaskCol   = 3
+	borgUsageMachine   = 4
+	borgUsageCPUCol    = 5
+	borgUsageMemCol    = 6
+	borgUsageDiskIOCol = 11
+
+	borgMachineAdd = "0"
+	// borg trace的时间戳单位是微秒
+	borgTimeUnit = 1e6
+)
+
+// TraceMapping 把公开trace中归一化的使用率映射到Liang的指标单位
+type TraceMapping struct {
+	NICCap        float64 // 节点网卡带宽，单位Mbps，trace中没有网卡信息
+	DiskBandwidth float64 // 磁盘IO占满时的吞吐，单位B/s
+	MaxTasks      int     // 最多导入的任务数，0表示不限制
+}
+
+// DefaultTraceMapping 10Gbps网卡，200MB/s磁盘
+func DefaultTraceMapping() TraceMapping {
+	return TraceMapping{
+		NICCap:        10000,
+		DiskBandwidth: 200 * 1024 * 1024,
+	}
+}
+
+// borgTask 按job ID和task index聚合task_usage的多个采样窗口
+type borgTask struct {
+	name    string
+	machine string
+	start   float64
+	end     float64
+	cpu     float64
+	mem     float64
+	diskIO  float64
+	samples float64
+}
+
+// LoadBorgTrace 导入Google ClusterData 2011格式的machine_events和task_usage
+// CPU和内存在trace中按最大机器归一化，这里换算为占所在机器的百分比；
+// mean disk I/O time按DiskBandwidth换算为吞吐；trace中没有网络数据，网络需求为0
+func LoadBorgTrace(machineFile, usageFile string, mapping TraceMapping) (*Cluster, *Trace, error) {
+	machineRecords, err := readCSV(machineFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	type machineCap struct{ cpu, mem float64 }
+	caps := make(map[string]machineCap)
+	cluster := &Cluster{}
+	for i, record := range machineRecords {
+		if len(record) <= borgMachineMemCol {
+			return nil, nil, fmt.Errorf("%s line %d: expect at least %d columns", machineFile, i+1, borgMachineMemCol+1)
+		}
+		if record[borgMachineTypeCol] != borgMachineAdd {
+			continue
+		}
+		id := record[borgMachineIDCol]
+		if _, ok := caps[id]; ok {
+			continue
+		}
+		cpu, err1 := parseFloat(record[borgMachineCPUCol])
+		mem, err2 := parseFloat(record[borgMachineMemCol])
+		if err1 != nil || err2 != nil || cpu <= 0 || mem <= 0 {
+			// 部分机器缺少容量信息，无法换算使用率
+			continue
+		}
+		caps[id] = machineCap{cpu: cpu, mem: mem}
+		cluster.Nodes = append(cluster.Nodes, NodeSpec{Name: id, NetCap: mapping.NICCap, DiskCap: mapping.DiskBandwidth})
+	}
+
+	usageRecords, err := readCSV(usageFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	tasks := make(map[string]*borgTask)
+	order := make([]string, 0)
+	for i, record := range usageRecords {
+		if len(record) <= borgUsageDiskIOCol {
+			return nil, nil, fmt.Errorf("%s line %d: expect at least %d columns", usageFile, i+1, borgUsageDiskIOCol+1)
+		}
+		machine := record[borgUsageMachine]
+		mc, ok := caps[machine]
+		if !ok {
+			continue
+		}
+		start, err1 := parseFloat(record[borgUsageStartCol])
+		end, err2 := parseFloat(record[borgUsageEndCol])
+		cpu, err3 := parseFloat(record[borgUsageCPUCol])
+		mem, err4 := parseFloat(record[borgUsageMemCol])
+		disk, err5 := parseFloat(record[borgUsageDiskIOCol])
+		if err := firstErr(err1, err2, err3, err4, err5); err != nil {
+			return nil, nil, fmt.Errorf("%s line %d: %v", usageFile, i+1, err)
+		}
+
+		key := record[borgUsageJobCol] + "-" + record[borgUsageTaskCol]
+		task, ok := tasks[key]
+		if !ok {
+			task = &borgTask{name: key, machine: machine, start: start, end: end}
+			tasks[key] = task
+			order = append(order, key)
+		}
+		if start < task.start {
+			task.start = start
+		}
+		if end > task.end {
+			task.end = end
+		}
+		task.cpu += cpu / mc.cpu * 100
+		task.mem += mem / mc.mem * 100
+		task.diskIO += disk * mapping.DiskBandwidth
+		task.samples++
+	}
+
+	events := make([]PodEvent, 0, len(order))
+	for _, key := range order {
+		task := tasks[key]
+		events = append(events, PodEvent{
+			Name:     task.name,
+			Node:     task.machine,
+			Arrival:  task.start / borgTimeUnit,
+			Duration: (task.end - task.start) / borgTimeUnit,
+			CPU:      task.cpu / task.samples,
+			Mem:      task.mem / task.samples,
+			DiskIO:   task.diskIO / task.samples,
+		})
+	}
+
+	return cluster, newImportedTrace(events, mapping), nil
+}
+
+// newImportedTrace 按到达时间排序，时间平移到从0开始，并截取前MaxTasks个任务
+func newImportedTrace(events []PodEvent, mapping TraceMapping) *Trace {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Arrival < events[j].Arrival
+	})
+	if mapping.MaxTasks > 0 && len(events) > mapping.MaxTasks {
+		events = events[:mapping.MaxTasks]
+	}
+	if len(events) > 0 {
+		base := events[0].Arrival
+		for i := range events {
+			events[i].Arrival -= base
+		}
+	}
+
+	return &Trace{Pods: events}
+}
+
+func readCSV(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	// trace中有很多空列，列数也不完全一致
+	r.FieldsPerRecord = -1
+	records := make([][]string, 0)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s error: %v", path, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// parseFloat 空字段按0处理
+func parseFloat(v string) (float64, error) {
+	if v == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseFloat(v, 64)
+}
+
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
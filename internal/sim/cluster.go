@@ -0,0 +1,83 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+)
+
+// NodeSpec 模拟集群中的节点，负载为不属于模拟Pod的基础负载
+// NetCap/NetIO单位Mbps，CPU/Mem为使用率百分比，DiskIO/DiskCap单位B/s，DiskCap为0时按原始磁盘IO评分
+type NodeSpec struct {
+	Name    string  `json:"name"`
+	NetCap  float64 `json:"netCap"`
+	NetIO   float64 `json:"netIO"`
+	CPU     float64 `json:"cpu"`
+	Mem     float64 `json:"mem"`
+	DiskIO  float64 `json:"diskIO"`
+	DiskCap float64 `json:"diskCap,omitempty"`
+}
+
+// Cluster 模拟集群
+type Cluster struct {
+	Nodes []NodeSpec `json:"nodes"`
+}
+
+// LoadCluster 从JSON文件读取集群描述
+func LoadCluster(path string) (*Cluster, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Cluster)
+	if err = json.Unmarshal(bs, c); err != nil {
+		return nil, fmt.Errorf("parse cluster file %s error: %v", path, err)
+	}
+
+	return c, c.Validate()
+}
+
+// Validate 检查节点名称和网卡带宽
+func (c *Cluster) Validate() error {
+	if len(c.Nodes) == 0 {
+		return fmt.Errorf("cluster has no nodes")
+	}
+	names := make(map[string]bool)
+	for _, node := range c.Nodes {
+		if node.Name == "" {
+			return fmt.Errorf("node name should not be empty")
+		}
+		if names[node.Name] {
+			return fmt.Errorf("duplicated node %s", node.Name)
+		}
+		names[node.Name] = true
+		if node.NetCap <= 0 {
+			return fmt.Errorf("net cap of node %s is %f, should be positive", node.Name, node.NetCap)
+		}
+	}
+
+	return nil
+}
+
+// SyntheticCluster 生成包含num个节点的集群，网卡带宽在1G/2.5G/10G中选择，磁盘为150MB/s的HDD或500MB/s的SSD
+func SyntheticCluster(num int, seed int64) *Cluster {
+	r := rand.New(rand.NewSource(seed))
+	caps := []float64{1000, 2500, 10000}
+	diskCaps := []float64{150 * 1024 * 1024, 500 * 1024 * 1024}
+	c := &Cluster{Nodes: make([]NodeSpec, num)}
+	for i := 0; i < num; i++ {
+		netCap := caps[r.Intn(len(caps))]
+		c.Nodes[i] = NodeSpec{
+			Name:    fmt.Sprintf("node-%d", i),
+			NetCap:  netCap,
+			NetIO:   netCap * r.Float64() * 0.3,
+			CPU:     10 + r.Float64()*40,
+			Mem:     20 + r.Float64()*40,
+			DiskIO:  r.Float64() * 50 * 1024 * 1024,
+			DiskCap: diskCaps[r.Intn(len(diskCaps))],
+		}
+	}
+
+	return c
+}
@@ -0,0 +1,95 @@
+package sim
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLoadBorgTrace(t *testing.T) {
+	cluster, trace, err := LoadBorgTrace("testdata/borg_machine_events.csv", "testdata/borg_task_usage.csv", DefaultTraceMapping())
+	if err != nil {
+		t.Fatalf("load borg trace error: %v", err)
+	}
+
+	// 机器13缺少容量信息，机器6的UPDATE事件不会重复添加
+	if len(cluster.Nodes) != 4 {
+		t.Errorf("num of machines should be 4, but get %d", len(cluster.Nodes))
+	}
+	// 12个任务，运行在机器13上的任务被跳过
+	if len(trace.Pods) != 12 {
+		t.Fatalf("num of tasks should be 12, but get %d", len(trace.Pods))
+	}
+
+	first := trace.Pods[0]
+	if first.Name != "3418309-0" || first.Node != "7" || first.Arrival != 0 {
+		t.Errorf("first task is %+v", first)
+	}
+	// 机器7的CPU容量为1，使用率0.03461换算为3.461%
+	if math.Abs(first.CPU-3.461) > 1e-9 {
+		t.Errorf("cpu of first task should be 3.461, but get %f", first.CPU)
+	}
+	if first.NetIO != 0 {
+		t.Errorf("borg trace has no network data, but get %f", first.NetIO)
+	}
+	for i := 1; i < len(trace.Pods); i++ {
+		if trace.Pods[i].Arrival < trace.Pods[i-1].Arrival {
+			t.Errorf("tasks should be ordered by arrival")
+		}
+		if trace.Pods[i].Duration <= 0 {
+			t.Errorf("duration of task %s should be positive", trace.Pods[i].Name)
+		}
+	}
+}
+
+func TestLoadAlibabaTrace(t *testing.T) {
+	mapping := DefaultTraceMapping()
+	mapping.MaxTasks = 8
+	cluster, trace, err := LoadAlibabaTrace("testdata/alibaba_machine_meta.csv", "testdata/alibaba_container_meta.csv",
+		"testdata/alibaba_container_usage.csv", mapping)
+	if err != nil {
+		t.Fatalf("load alibaba trace error: %v", err)
+	}
+
+	if len(cluster.Nodes) != 4 {
+		t.Errorf("num of machines should be 4, but get %d", len(cluster.Nodes))
+	}
+	if len(trace.Pods) != mapping.MaxTasks {
+		t.Fatalf("num of containers should be %d, but get %d", mapping.MaxTasks, len(trace.Pods))
+	}
+
+	// c_1: cpu_request 4核，cpu_util 6%和27%，机器96核
+	first := trace.Pods[0]
+	if first.Name != "c_1" || first.Node != "m_1" {
+		t.Errorf("first container is %+v", first)
+	}
+	expectedCPU := (6.0 + 27.0) / 2 / 100 * 4 / 96 * 100
+	if math.Abs(first.CPU-expectedCPU) > 1e-9 {
+		t.Errorf("cpu of c_1 should be %f, but get %f", expectedCPU, first.CPU)
+	}
+	// 网络取上下行较大值：9.44和16.17
+	expectedNet := (9.44 + 16.17) / 2 / 100 * mapping.NICCap
+	if math.Abs(first.NetIO-expectedNet) > 1e-9 {
+		t.Errorf("net io of c_1 should be %f, but get %f", expectedNet, first.NetIO)
+	}
+	if first.Duration != 20 {
+		t.Errorf("duration of c_1 should be 20, but get %f", first.Duration)
+	}
+}
+
+func TestRun_ImportedTrace(t *testing.T) {
+	cluster, trace, err := LoadAlibabaTrace("testdata/alibaba_machine_meta.csv", "testdata/alibaba_container_meta.csv",
+		"testdata/alibaba_container_usage.csv", DefaultTraceMapping())
+	if err != nil {
+		t.Fatalf("load alibaba trace error: %v", err)
+	}
+
+	for _, algo := range append(Algorithms(), AlgoTrace) {
+		res, err := Run(cluster, trace, algo, Options{Interval: 10, HotspotThreshold: 80})
+		if err != nil {
+			t.Fatalf("run %s error: %v", algo, err)
+		}
+		if res.Summary.Placed != len(trace.Pods) {
+			t.Errorf("%s should place all %d containers, but get %d", algo, len(trace.Pods), res.Summary.Placed)
+		}
+	}
+}
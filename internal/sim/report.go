@@ -0,0 +1,71 @@
+package sim
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+// WriteReport 按format输出模拟结果，支持table/csv/json
+// table只输出汇总，csv输出每个采样点，json输出全部内容
+func WriteReport(w io.Writer, format string, results []*Result) error {
+	switch format {
+	case "table":
+		return writeTable(w, results)
+	case "csv":
+		return writeCSV(w, results)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	return fmt.Errorf("unknown report format %s, should be table/csv/json", format)
+}
+
+func writeTable(w io.Writer, results []*Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ALGORITHM\tPLACED\tREJECTED\tREJECT_RATE\tNET_VAR\tCPU_VAR\tMEM_VAR\tHOTSPOTS(MEAN)\tHOTSPOTS(MAX)")
+	for _, r := range results {
+		s := r.Summary
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.4f\t%.6f\t%.6f\t%.6f\t%.2f\t%d\n",
+			r.Algorithm, s.Placed, s.Rejected, s.RejectionRate,
+			s.MeanNetVariance, s.MeanCPUVariance, s.MeanMemVariance, s.MeanHotspots, s.MaxHotspots)
+	}
+
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, results []*Result) error {
+	cw := csv.NewWriter(w)
+	header := []string{"algorithm", "time", "running", "placed", "rejected", "rejection_rate",
+		"net_variance", "cpu_variance", "mem_variance", "hotspots"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range results {
+		for _, s := range r.Samples {
+			record := []string{
+				r.Algorithm,
+				strconv.FormatFloat(s.Time, 'f', -1, 64),
+				strconv.Itoa(s.Running),
+				strconv.Itoa(s.Placed),
+				strconv.Itoa(s.Rejected),
+				strconv.FormatFloat(s.RejectionRate, 'f', 6, 64),
+				strconv.FormatFloat(s.NetVariance, 'g', 8, 64),
+				strconv.FormatFloat(s.CPUVariance, 'g', 8, 64),
+				strconv.FormatFloat(s.MemVariance, 'g', 8, 64),
+				strconv.Itoa(s.Hotspots),
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
@@ -0,0 +1,279 @@
+package sim
+
+import (
+	"container/heap"
+	"fmt"
+
+	"liang/internal/model"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// Options 模拟参数
+type Options struct {
+	Interval         float64 // 采样间隔，单位秒
+	HotspotThreshold float64 // 节点任一资源使用率超过该百分比视为热点
+}
+
+// DefaultOptions 每分钟采样一次，使用率超过80%视为热点
+func DefaultOptions() Options {
+	return Options{
+		Interval:         60,
+		HotspotThreshold: model.UsageUpperLimit,
+	}
+}
+
+// Sample 某一时刻集群的均衡情况，方差按节点使用率比例计算
+type Sample struct {
+	Time          float64 `json:"time"`
+	Running       int     `json:"running"`
+	Placed        int     `json:"placed"`
+	Rejected      int     `json:"rejected"`
+	RejectionRate float64 `json:"rejectionRate"`
+	NetVariance   float64 `json:"netVariance"`
+	CPUVariance   float64 `json:"cpuVariance"`
+	MemVariance   float64 `json:"memVariance"`
+	Hotspots      int     `json:"hotspots"`
+}
+
+// Summary 整个模拟过程的汇总
+type Summary struct {
+	Placed          int     `json:"placed"`
+	Rejected        int     `json:"rejected"`
+	RejectionRate   float64 `json:"rejectionRate"`
+	MeanNetVariance float64 `json:"meanNetVariance"`
+	MeanCPUVariance float64 `json:"meanCPUVariance"`
+	MeanMemVariance float64 `json:"meanMemVariance"`
+	MeanHotspots    float64 `json:"meanHotspots"`
+	MaxHotspots     int     `json:"maxHotspots"`
+}
+
+// Result 一个算法的模拟结果
+type Result struct {
+	Algorithm string   `json:"algorithm"`
+	Samples   []Sample `json:"samples"`
+	Summary   Summary  `json:"summary"`
+}
+
+// nodeState 节点当前负载，包括基础负载和已经调度上来的Pod
+type nodeState struct {
+	spec   NodeSpec
+	netIO  float64
+	cpu    float64
+	mem    float64
+	diskIO float64
+}
+
+func (n *nodeState) fits(event *PodEvent) bool {
+	return n.netIO+float64(podNetNeed(event)) <= n.spec.NetCap &&
+		n.cpu+event.CPU <= 100 &&
+		n.mem+event.Mem <= 100
+}
+
+func (n *nodeState) apply(event *PodEvent, sign float64) {
+	n.netIO += sign * float64(podNetNeed(event))
+	n.cpu += sign * event.CPU
+	n.mem += sign * event.Mem
+	n.diskIO += sign * event.DiskIO
+}
+
+// running 正在运行的Pod，按结束时间组成小顶堆
+type running struct {
+	end   float64
+	node  *nodeState
+	event *PodEvent
+}
+
+type runningHeap []running
+
+func (h runningHeap) Len() int            { return len(h) }
+func (h runningHeap) Less(i, j int) bool  { return h[i].end < h[j].end }
+func (h runningHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runningHeap) Push(x interface{}) { *h = append(*h, x.(running)) }
+func (h *runningHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// simulator 单次模拟的状态
+type simulator struct {
+	opts     Options
+	nodes    []*nodeState
+	running  runningHeap
+	placed   int
+	rejected int
+	samples  []Sample
+}
+
+// Run 按到达顺序使用algo放置trace中的Pod，定期采样集群的均衡情况
+// 每个Pod先过滤掉资源不足的节点，再选择得分最高的节点，得分相同时选择靠前的节点
+func Run(c *Cluster, t *Trace, algo string, opts Options) (*Result, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	score, err := getScoreFunc(algo)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Interval <= 0 {
+		return nil, fmt.Errorf("sample interval should be positive")
+	}
+
+	sim := &simulator{opts: opts}
+	for _, spec := range c.Nodes {
+		sim.nodes = append(sim.nodes, &nodeState{
+			spec:   spec,
+			netIO:  spec.NetIO,
+			cpu:    spec.CPU,
+			mem:    spec.Mem,
+			diskIO: spec.DiskIO,
+		})
+	}
+
+	nextSample := 0.0
+	for i := range t.Pods {
+		event := &t.Pods[i]
+		for nextSample <= event.Arrival {
+			sim.release(nextSample)
+			sim.sample(nextSample)
+			nextSample += opts.Interval
+		}
+		sim.release(event.Arrival)
+
+		if err = sim.place(event, score); err != nil {
+			return nil, fmt.Errorf("algorithm %s place pod %s error: %v", algo, event.Name, err)
+		}
+	}
+	sim.release(nextSample)
+	sim.sample(nextSample)
+
+	return &Result{
+		Algorithm: algo,
+		Samples:   sim.samples,
+		Summary:   sim.summary(),
+	}, nil
+}
+
+// release 释放在now之前结束的Pod
+func (sim *simulator) release(now float64) {
+	for sim.running.Len() > 0 && sim.running[0].end <= now {
+		r := heap.Pop(&sim.running).(running)
+		r.node.apply(r.event, -1)
+	}
+}
+
+func (sim *simulator) place(event *PodEvent, score scoreFunc) error {
+	if score == nil {
+		return sim.placeOriginal(event)
+	}
+
+	candidates := make([]*nodeState, 0, len(sim.nodes))
+	for _, node := range sim.nodes {
+		if node.fits(event) {
+			candidates = append(candidates, node)
+		}
+	}
+	if len(candidates) == 0 {
+		sim.rejected++
+		return nil
+	}
+
+	res, err := score(newPod(event), candidates)
+	if err != nil {
+		return err
+	}
+	best := -1
+	for i := range res {
+		if best < 0 || res[i].Score > res[best].Score {
+			best = i
+		}
+	}
+	var target *nodeState
+	for _, node := range candidates {
+		if node.spec.Name == res[best].Host {
+			target = node
+			break
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("algorithm returns unknown node %s", res[best].Host)
+	}
+
+	target.apply(event, 1)
+	heap.Push(&sim.running, running{end: event.Arrival + event.Duration, node: target, event: event})
+	sim.placed++
+
+	return nil
+}
+
+// placeOriginal 放置到trace记录的原始节点，即使按模拟的容量已经放不下
+func (sim *simulator) placeOriginal(event *PodEvent) error {
+	for _, node := range sim.nodes {
+		if node.spec.Name == event.Node {
+			node.apply(event, 1)
+			heap.Push(&sim.running, running{end: event.Arrival + event.Duration, node: node, event: event})
+			sim.placed++
+			return nil
+		}
+	}
+	sim.rejected++
+
+	return nil
+}
+
+func (sim *simulator) sample(now float64) {
+	num := len(sim.nodes)
+	netArr := make([]float64, num)
+	cpuArr := make([]float64, num)
+	memArr := make([]float64, num)
+	hotspots := 0
+	for i, node := range sim.nodes {
+		netArr[i] = node.netIO / node.spec.NetCap
+		cpuArr[i] = node.cpu / 100
+		memArr[i] = node.mem / 100
+		limit := sim.opts.HotspotThreshold / 100
+		if netArr[i] > limit || cpuArr[i] > limit || memArr[i] > limit {
+			hotspots++
+		}
+	}
+
+	s := Sample{
+		Time:        now,
+		Running:     sim.running.Len(),
+		Placed:      sim.placed,
+		Rejected:    sim.rejected,
+		NetVariance: stat.PopVariance(netArr, nil),
+		CPUVariance: stat.PopVariance(cpuArr, nil),
+		MemVariance: stat.PopVariance(memArr, nil),
+		Hotspots:    hotspots,
+	}
+	if total := sim.placed + sim.rejected; total > 0 {
+		s.RejectionRate = float64(sim.rejected) / float64(total)
+	}
+	sim.samples = append(sim.samples, s)
+}
+
+func (sim *simulator) summary() Summary {
+	sum := Summary{
+		Placed:   sim.placed,
+		Rejected: sim.rejected,
+	}
+	if total := sim.placed + sim.rejected; total > 0 {
+		sum.RejectionRate = float64(sim.rejected) / float64(total)
+	}
+	num := float64(len(sim.samples))
+	for _, s := range sim.samples {
+		sum.MeanNetVariance += s.NetVariance / num
+		sum.MeanCPUVariance += s.CPUVariance / num
+		sum.MeanMemVariance += s.MemVariance / num
+		sum.MeanHotspots += float64(s.Hotspots) / num
+		if s.Hotspots > sum.MaxHotspots {
+			sum.MaxHotspots = s.Hotspots
+		}
+	}
+
+	return sum
+}
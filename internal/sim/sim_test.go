@@ -0,0 +1,106 @@
+package sim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRun_Placement(t *testing.T) {
+	cluster := &Cluster{
+		Nodes: []NodeSpec{
+			{Name: "node1", NetCap: 1000, NetIO: 500, CPU: 10, Mem: 10},
+			{Name: "node2", NetCap: 1000, NetIO: 100, CPU: 10, Mem: 10},
+			{Name: "node3", NetCap: 2500, NetIO: 2400, CPU: 10, Mem: 10},
+		},
+	}
+	trace := &Trace{
+		Pods: []PodEvent{
+			{Name: "pod-0", Arrival: 1, Duration: 1000, NetIO: 100, CPU: 1, Mem: 1},
+			{Name: "pod-1", Arrival: 2, Duration: 1000, NetIO: 800, CPU: 1, Mem: 1},
+			{Name: "pod-2", Arrival: 3, Duration: 1000, NetIO: 1000, CPU: 1, Mem: 1},
+		},
+	}
+
+	res, err := Run(cluster, trace, AlgoBNP, DefaultOptions())
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	// pod-0放到负载最低的node2，pod-1只有node2还能放下，pod-2没有节点可以放下
+	if res.Summary.Placed != 2 || res.Summary.Rejected != 1 {
+		t.Errorf("placed %d rejected %d, should be 2 and 1", res.Summary.Placed, res.Summary.Rejected)
+	}
+	last := res.Samples[len(res.Samples)-1]
+	if last.Running != 2 {
+		t.Errorf("running pods should be 2, but get %d", last.Running)
+	}
+}
+
+func TestRun_Release(t *testing.T) {
+	cluster := &Cluster{
+		Nodes: []NodeSpec{
+			{Name: "node1", NetCap: 1000},
+		},
+	}
+	trace := &Trace{
+		Pods: []PodEvent{
+			{Name: "pod-0", Arrival: 0, Duration: 100, NetIO: 1000},
+			{Name: "pod-1", Arrival: 50, Duration: 100, NetIO: 1000},
+			{Name: "pod-2", Arrival: 120, Duration: 100, NetIO: 1000},
+		},
+	}
+
+	res, err := Run(cluster, trace, AlgoLeastAllocated, Options{Interval: 60, HotspotThreshold: 80})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	// pod-1到达时网卡已被pod-0占满，pod-2到达时pod-0已经结束
+	if res.Summary.Placed != 2 || res.Summary.Rejected != 1 {
+		t.Errorf("placed %d rejected %d, should be 2 and 1", res.Summary.Placed, res.Summary.Rejected)
+	}
+	if res.Summary.MaxHotspots != 1 {
+		t.Errorf("max hotspots should be 1, but get %d", res.Summary.MaxHotspots)
+	}
+}
+
+func TestRun_AllAlgorithms(t *testing.T) {
+	cluster := SyntheticCluster(10, 1)
+	trace := SyntheticTrace(200, 10, 1)
+
+	results := make([]*Result, 0)
+	for _, algo := range Algorithms() {
+		res, err := Run(cluster, trace, algo, DefaultOptions())
+		if err != nil {
+			t.Fatalf("run %s error: %v", algo, err)
+		}
+		if res.Summary.Placed+res.Summary.Rejected != len(trace.Pods) {
+			t.Errorf("%s: placed %d plus rejected %d should be %d", algo, res.Summary.Placed, res.Summary.Rejected, len(trace.Pods))
+		}
+		if len(res.Samples) == 0 {
+			t.Errorf("%s: samples should not be empty", algo)
+		}
+		results = append(results, res)
+	}
+
+	// 相同的输入结果应该是确定的
+	again, _ := Run(cluster, trace, AlgoBNP, DefaultOptions())
+	if again.Summary != results[0].Summary {
+		t.Errorf("result should be deterministic, get %v and %v", again.Summary, results[0].Summary)
+	}
+
+	for _, format := range []string{"table", "csv", "json"} {
+		var buf bytes.Buffer
+		if err := WriteReport(&buf, format, results); err != nil {
+			t.Errorf("write %s report error: %v", format, err)
+		}
+		if !strings.Contains(buf.String(), AlgoCMDNCompact) {
+			t.Errorf("%s report should contain %s", format, AlgoCMDNCompact)
+		}
+	}
+}
+
+func TestRun_UnknownAlgorithm(t *testing.T) {
+	if _, err := Run(SyntheticCluster(3, 1), SyntheticTrace(3, 1, 1), "unknown", DefaultOptions()); err == nil {
+		t.Errorf("unknown algorithm should return error")
+	}
+}
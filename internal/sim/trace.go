@@ -0,0 +1,72 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+)
+
+// PodEvent 模拟到达的Pod，Arrival/Duration单位秒
+// NetIO单位Mbps，对应Pod注解LiangNetIO；CPU/Mem为占节点的百分比，DiskIO单位B/s
+// Node为公开trace中记录的原始调度节点，可以为空
+type PodEvent struct {
+	Name     string  `json:"name"`
+	Node     string  `json:"node,omitempty"`
+	Arrival  float64 `json:"arrival"`
+	Duration float64 `json:"duration"`
+	NetIO    float64 `json:"netIO"`
+	CPU      float64 `json:"cpu"`
+	Mem      float64 `json:"mem"`
+	DiskIO   float64 `json:"diskIO"`
+}
+
+// Trace Pod到达序列，按Arrival升序排列
+type Trace struct {
+	Pods []PodEvent `json:"pods"`
+}
+
+// LoadTrace 从JSON文件读取Pod到达序列
+func LoadTrace(path string) (*Trace, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := new(Trace)
+	if err = json.Unmarshal(bs, t); err != nil {
+		return nil, fmt.Errorf("parse trace file %s error: %v", path, err)
+	}
+	t.Sort()
+
+	return t, nil
+}
+
+// Sort 按到达时间排序，到达时间相同的保持原有顺序
+func (t *Trace) Sort() {
+	sort.SliceStable(t.Pods, func(i, j int) bool {
+		return t.Pods[i].Arrival < t.Pods[j].Arrival
+	})
+}
+
+// SyntheticTrace 生成num个Pod，到达间隔服从均值为interval秒的指数分布
+func SyntheticTrace(num int, interval float64, seed int64) *Trace {
+	r := rand.New(rand.NewSource(seed))
+	netNeeds := []float64{10, 50, 100, 200}
+	t := &Trace{Pods: make([]PodEvent, num)}
+	now := 0.0
+	for i := 0; i < num; i++ {
+		now += r.ExpFloat64() * interval
+		t.Pods[i] = PodEvent{
+			Name:     fmt.Sprintf("pod-%d", i),
+			Arrival:  now,
+			Duration: 300 + r.ExpFloat64()*1200,
+			NetIO:    netNeeds[r.Intn(len(netNeeds))],
+			CPU:      1 + r.Float64()*9,
+			Mem:      1 + r.Float64()*9,
+			DiskIO:   r.Float64() * 5 * 1024 * 1024,
+		}
+	}
+
+	return t
+}
@@ -47,6 +47,17 @@ func GoNumLearn() {
 	fmt.Println(names)
 }
 
+// TOPSISDetail TOPSIS计算过程中的中间结果
+type TOPSISDetail struct {
+	Normalized *mat.Dense // 正规化并乘以权重后的矩阵
+	Weights    []float64  // 各指标权重
+	Ideal      []float64  // 正理想解，每列的最大值
+	AntiIdeal  []float64  // 负理想解，每列的最小值
+	DPlus      []float64  // 每行到正理想解的距离
+	DMinus     []float64  // 每行到负理想解的距离
+	Closeness  []float64  // 相对贴近度，即最终得分
+}
+
 // CalcTOPSIS 计算TOPSIS值，输入的数组默认已经同向化
 // cpu: 	[1,2,3]
 // mem:		[2,3,4]
@@ -55,14 +66,46 @@ func GoNumLearn() {
 // filtered: [0.0, 0.0, 0.0, 0.0]
 // 如果存在列全部为0，则默认填充1
 func CalcTOPSIS(matrix *mat.Dense) ([]float64, error) {
+	detail, err := CalcTOPSISDetail(matrix)
+	if err != nil {
+		return nil, err
+	}
+
+	return detail.Closeness, nil
+}
+
+// CalcTOPSISDetail 计算TOPSIS值并返回中间结果，matrix会被原地正规化，每列的权重都为1
+func CalcTOPSISDetail(matrix *mat.Dense) (*TOPSISDetail, error) {
+	return CalcWeightedTOPSISDetail(matrix, nil)
+}
+
+// CalcWeightedTOPSISDetail 按每列的权重计算TOPSIS值并返回中间结果，matrix会被原地正规化并乘以权重
+// weights为空时每列的权重都为1，否则长度需要与列数相同，权重不能为负数且不能全部为0
+func CalcWeightedTOPSISDetail(matrix *mat.Dense, weights []float64) (*TOPSISDetail, error) {
 	// 矩阵是否规范检查
 	if IsMatrixEmpty(matrix) {
 		return nil, fmt.Errorf("empty matrix")
 	}
 	row := matrix.RawMatrix().Rows
 	col := matrix.RawMatrix().Cols
+	if weights == nil {
+		weights = make([]float64, col)
+		for i := range weights {
+			weights[i] = 1.0
+		}
+	}
+	if len(weights) != col {
+		return nil, fmt.Errorf("length of weights %d does not match columns %d", len(weights), col)
+	}
+	if floats.Min(weights) < 0 || floats.Max(weights) == 0 {
+		return nil, fmt.Errorf("weights %v should be non-negative and not all zero", weights)
+	}
 	if row == 1 {
-		return []float64{1.0}, nil
+		return &TOPSISDetail{
+			Normalized: matrix,
+			Weights:    weights,
+			Closeness:  []float64{1.0},
+		}, nil
 	}
 
 	// 检查是否存在负数
@@ -72,38 +115,44 @@ func CalcTOPSIS(matrix *mat.Dense) ([]float64, error) {
 	// 如果某列为全部为0，则填充1
 	ResetZeroCol(matrix, 1.0)
 
-	// 1. 按照矩阵列正规化
-	maxMinArr := make([][]float64, col)
-	maxMinMatrix := mat.NewDense(col, 2, nil)
+	// 1. 按照矩阵列正规化，再乘以该列的权重
+	detail := &TOPSISDetail{
+		Normalized: matrix,
+		Weights:    weights,
+		Ideal:      make([]float64, col),
+		AntiIdeal:  make([]float64, col),
+		DPlus:      make([]float64, row),
+		DMinus:     make([]float64, row),
+		Closeness:  make([]float64, row),
+	}
 	for i := 0; i < col; i++ {
 		colArr := GetDenseCol(matrix, i)
 		normArr := NormArray(colArr)
+		floats.Scale(weights[i], normArr)
 		// 得到max/min
-		maxMin := make([]float64, 2)
-		maxMin[0] = floats.Max(normArr)
-		maxMin[1] = floats.Min(normArr)
-		maxMinArr[i] = maxMin
-		maxMinMatrix.SetRow(i, maxMin)
+		detail.Ideal[i] = floats.Max(normArr)
+		detail.AntiIdeal[i] = floats.Min(normArr)
 		matrix.SetCol(i, normArr)
 	}
 
 	// 2. 计算每个维度的距离
 	// 要考虑某一个维度是不是全部是0
-	resMax := make([]float64, row)
 	for i := 0; i < row; i++ {
 		rowArr := matrix.RawRowView(i)
 		maxSum, minSum := 0.0, 0.0
 		for j := 0; j < col; j++ {
-			maxSum += math.Pow(rowArr[j]-maxMinArr[j][0], 2)
-			minSum += math.Pow(rowArr[j]-maxMinArr[j][1], 2)
+			maxSum += math.Pow(rowArr[j]-detail.Ideal[j], 2)
+			minSum += math.Pow(rowArr[j]-detail.AntiIdeal[j], 2)
 		}
 		dmax := math.Sqrt(maxSum)
 		dmin := math.Sqrt(minSum)
 
-		resMax[i] = dmin / (dmin + dmax)
+		detail.DPlus[i] = dmax
+		detail.DMinus[i] = dmin
+		detail.Closeness[i] = dmin / (dmin + dmax)
 	}
 
-	return resMax, nil
+	return detail, nil
 }
 
 // ResetZeroCol 如果某列全部为0，填充为给定值
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"math"
 	"testing"
 
 	"liang/internal/model"
@@ -119,6 +120,43 @@ func TestCalcTOPSIS(t *testing.T) {
 
 }
 
+func TestCalcWeightedTOPSISDetail(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Weights  []float64
+		Expected []float64
+	}{
+		{Name: "equal weights", Weights: nil, Expected: []float64{0.5, 0.5}},
+		{Name: "first column weighted", Weights: []float64{3, 1}, Expected: []float64{0.25, 0.75}},
+		{Name: "second column ignored", Weights: []float64{1, 0}, Expected: []float64{0, 1}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			matrix := mat.NewDense(2, 2, []float64{
+				1, 4,
+				4, 1,
+			})
+			detail, err := CalcWeightedTOPSISDetail(matrix, tc.Weights)
+			if err != nil {
+				t.Fatalf("calc weighted topsis error: %v", err)
+			}
+			for i := range tc.Expected {
+				if math.Abs(detail.Closeness[i]-tc.Expected[i]) > 0.000001 {
+					t.Errorf("%dth closeness should be %f, but get %f", i, tc.Expected[i], detail.Closeness[i])
+				}
+			}
+		})
+	}
+
+	for _, weights := range [][]float64{{1}, {-1, 1}, {0, 0}} {
+		matrix := mat.NewDense(2, 2, []float64{1, 4, 4, 1})
+		if _, err := CalcWeightedTOPSISDetail(matrix, weights); err == nil {
+			t.Errorf("weights %v should be invalid", weights)
+		}
+	}
+}
+
 func TestNormArray(t *testing.T) {
 	cases := []struct {
 		Name     string